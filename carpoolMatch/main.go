@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/database"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/carpoolmatch"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"github.com/aws/aws-lambda-go/lambda"
+	"log/slog"
+	"os"
+)
+
+func main() {
+	logging.Setup()
+	db, err := store.Open(context.Background(), database.ConfigFromEnv())
+	if err != nil {
+		slog.Error("error opening store", "error", err)
+		os.Exit(1)
+	}
+	sink, err := metrics.FromEnv()
+	if err != nil {
+		slog.Error("error configuring metrics", "error", err)
+		os.Exit(1)
+	}
+	routesClient := google.NewClientFromEnv()
+	routesClient.OnAttempt = google.Hooks(budget.Recorder(db.Usage, clock.System), metrics.ProviderHook(sink))
+
+	handler := &carpoolmatch.Handler{Store: db, Routes: routesClient, Metrics: sink}
+	lambda.Start(metrics.Wrap(sink, handler.HandleRequest))
+}
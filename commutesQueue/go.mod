@@ -3,12 +3,15 @@ module github.com/Cole-T-Harris/OptimizeRouteApp
 go 1.22.5
 
 require (
+	github.com/Cole-T-Harris/OptimizeRouteApp/shared v0.0.0
 	github.com/aws/aws-lambda-go v1.47.0
 	github.com/aws/aws-sdk-go v1.55.3
-	github.com/lib/pq v1.10.9
 )
 
 require (
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/lib/pq v1.10.9 // indirect
 	github.com/stretchr/testify v1.8.4 // indirect
 )
+
+replace github.com/Cole-T-Harris/OptimizeRouteApp/shared => ../shared
@@ -0,0 +1,15 @@
+module github.com/Cole-T-Harris/OptimizeRouteApp
+
+go 1.22.5
+
+require (
+	github.com/Cole-T-Harris/OptimizeRouteApp/shared v0.0.0
+	github.com/aws/aws-lambda-go v1.47.0
+)
+
+require (
+	github.com/lib/pq v1.10.9 // indirect
+	github.com/stretchr/testify v1.8.4 // indirect
+)
+
+replace github.com/Cole-T-Harris/OptimizeRouteApp/shared => ../shared
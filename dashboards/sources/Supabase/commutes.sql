@@ -1 +1 @@
-SELECT * FROM commutes
\ No newline at end of file
+SELECT * FROM commutes WHERE ad_hoc = false
\ No newline at end of file
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/database"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/exportpaths"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"github.com/aws/aws-lambda-go/lambda"
+	"log/slog"
+	"os"
+)
+
+func main() {
+	logging.Setup()
+	db, err := store.Open(context.Background(), database.ConfigFromEnv())
+	if err != nil {
+		slog.Error("error opening store", "error", err)
+		os.Exit(1)
+	}
+	sink, err := metrics.FromEnv()
+	if err != nil {
+		slog.Error("error configuring metrics", "error", err)
+		os.Exit(1)
+	}
+
+	handler := &exportpaths.Handler{Store: db, Metrics: sink}
+	lambda.Start(metrics.Wrap(sink, handler.HandleRequest))
+}
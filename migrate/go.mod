@@ -0,0 +1,9 @@
+module github.com/Cole-T-Harris/OptimizeRouteApp/migrate
+
+go 1.22.5
+
+require github.com/Cole-T-Harris/OptimizeRouteApp/shared v0.0.0
+
+require github.com/lib/pq v1.10.9 // indirect
+
+replace github.com/Cole-T-Harris/OptimizeRouteApp/shared => ../shared
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/database"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/migrations"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: migrate [flags] <up|down|version|check>")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  up       - Apply all pending migrations")
+	fmt.Fprintln(os.Stderr, "  down     - Roll back the latest migrations (see -steps)")
+	fmt.Fprintln(os.Stderr, "  version  - Print the current schema version")
+	fmt.Fprintln(os.Stderr, "  check    - Exit non-zero unless the schema is at the expected version")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+}
+
+func run(ctx context.Context, command string, steps int) error {
+	databaseClient, err := database.Open(ctx, database.ConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	defer databaseClient.Close()
+
+	migrator, err := migrations.New(databaseClient)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+	case "down":
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Current version: %d, expected version: %d\n", version, migrations.Expected())
+	case "check":
+		if err := migrator.Check(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Schema is at expected version %d\n", migrations.Expected())
+	default:
+		usage()
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	return nil
+}
+
+func main() {
+	steps := flag.Int("steps", 1, "number of migrations to roll back with down")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := run(context.Background(), flag.Arg(0), *steps); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
@@ -1,17 +1,15 @@
 module github.com/Cole-T-Harris/OptimizeRouteApp
 
-go 1.21.1
+go 1.22.5
 
 require (
+	github.com/Cole-T-Harris/OptimizeRouteApp/shared v0.0.0
 	github.com/aws/aws-lambda-go v1.47.0
-	github.com/supabase-community/supabase-go v0.0.4
 )
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/supabase-community/functions-go v0.0.0-20220927045802-22373e6cb51d // indirect
-	github.com/supabase-community/gotrue-go v1.2.0 // indirect
-	github.com/supabase-community/postgrest-go v0.0.11 // indirect
-	github.com/supabase-community/storage-go v0.7.0 // indirect
-	github.com/tomnomnom/linkheader v0.0.0-20180905144013-02ca5825eb80 // indirect
+	github.com/lib/pq v1.10.9 // indirect
+	github.com/stretchr/testify v1.8.4 // indirect
 )
+
+replace github.com/Cole-T-Harris/OptimizeRouteApp/shared => ../shared
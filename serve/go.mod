@@ -0,0 +1,20 @@
+module github.com/Cole-T-Harris/OptimizeRouteApp/serve
+
+go 1.22.5
+
+require github.com/Cole-T-Harris/OptimizeRouteApp/shared v0.0.0
+
+require (
+	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
+	github.com/go-playground/locales v0.14.1 // indirect
+	github.com/go-playground/universal-translator v0.18.1 // indirect
+	github.com/go-playground/validator/v10 v10.22.1 // indirect
+	github.com/leodido/go-urn v1.4.0 // indirect
+	github.com/lib/pq v1.10.9 // indirect
+	golang.org/x/crypto v0.19.0 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
+
+replace github.com/Cole-T-Harris/OptimizeRouteApp/shared => ../shared
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/anomaly"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/database"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google/fakegoogle"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/adduserroute"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/carpoolmatch"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/checkcommute"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/commutesqueue"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/comparelocations"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/exportpaths"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/healthcheck"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/notifyusers"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/optimizeroute"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/orderstops"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/plandeparture"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/routepaths"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/weeklyreport"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/notify"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/report"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+)
+
+// errorResponse mirrors the body Lambda returns when a handler fails.
+type errorResponse struct {
+	ErrorMessage string `json:"errorMessage"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("error writing response", "error", err)
+	}
+}
+
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, google.ErrQuota):
+		return http.StatusTooManyRequests
+	case errors.Is(err, google.ErrAuth), errors.Is(err, google.ErrTransient), errors.Is(err, google.ErrInvalidRequest):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// endpoint adapts a Lambda HandleRequest to HTTP. An empty body decodes to
+// the zero request, like an empty Lambda test event.
+func endpoint[Req, Resp any](handle func(context.Context, Req) (Resp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Req
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("invalid JSON body: %v", err)})
+			return
+		}
+		response, err := handle(r.Context(), request)
+		if err != nil {
+			writeJSON(w, statusCode(err), errorResponse{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// newServer mounts every handler, plus GET /metrics when sink can serve a
+// Prometheus scrape.
+func newServer(db *store.Store, googleClient *google.Client, channels notify.Channels, sink metrics.Sink) http.Handler {
+	anomalies := &anomaly.Monitor{
+		Store:   db,
+		Alerter: &anomaly.Notifier{Notifications: db.Notifications, Channels: channels},
+		Metrics: sink,
+	}
+	optimizeRoute := &optimizeroute.Handler{Store: db, Routes: googleClient, Metrics: sink, Anomalies: anomalies}
+	commutesQueue := &commutesqueue.Handler{
+		Store:   db,
+		Metrics: sink,
+		// Dispatch in process instead of invoking the optimizeRoute Lambda.
+		Dispatcher: commutesqueue.DispatcherFunc(func(ctx context.Context, request domain.OptimizeRouteRequest) error {
+			_, err := optimizeRoute.HandleRequest(ctx, request)
+			return err
+		}),
+	}
+	addUserRoute := &adduserroute.Handler{Store: db, Geocoder: googleClient}
+	checkCommute := &checkcommute.Handler{Store: db, Routes: googleClient, Metrics: sink}
+	healthCheck := &healthcheck.Handler{Store: db, Metrics: sink}
+	notifyUsers := &notifyusers.Handler{Store: db, Channels: channels, Metrics: sink}
+	planDeparture := &plandeparture.Handler{Store: db, Routes: googleClient, Metrics: sink}
+	routePaths := &routepaths.Handler{Store: db, Metrics: sink}
+	exportPaths := &exportpaths.Handler{Store: db, Metrics: sink}
+	carpoolMatch := &carpoolmatch.Handler{Store: db, Routes: googleClient, Metrics: sink}
+	orderStops := &orderstops.Handler{Store: db, Routes: googleClient, Metrics: sink}
+	compareLocations := &comparelocations.Handler{Store: db, Routes: googleClient, Metrics: sink}
+	weeklyReport := &weeklyreport.Handler{Store: db, Sender: &report.Subscribers{Notifications: db.Notifications, Channels: channels}, Metrics: sink}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /optimizeRoute", endpoint(metrics.Wrap(sink, optimizeRoute.HandleRequest)))
+	mux.Handle("POST /commutesQueue", endpoint(metrics.Wrap(sink, commutesQueue.HandleRequest)))
+	mux.Handle("POST /addUserRoute", endpoint(metrics.Wrap(sink, addUserRoute.HandleRequest)))
+	mux.Handle("POST /checkCommute", endpoint(metrics.Wrap(sink, checkCommute.HandleRequest)))
+	mux.Handle("POST /healthCheck", endpoint(metrics.Wrap(sink, healthCheck.HandleRequest)))
+	mux.Handle("POST /notifyUsers", endpoint(metrics.Wrap(sink, notifyUsers.HandleRequest)))
+	mux.Handle("POST /planDeparture", endpoint(metrics.Wrap(sink, planDeparture.HandleRequest)))
+	mux.Handle("POST /weeklyReport", endpoint(metrics.Wrap(sink, weeklyReport.HandleRequest)))
+	mux.Handle("POST /routePaths", endpoint(metrics.Wrap(sink, routePaths.HandleRequest)))
+	mux.Handle("POST /exportPaths", endpoint(metrics.Wrap(sink, exportPaths.HandleRequest)))
+	mux.Handle("POST /carpoolMatch", endpoint(metrics.Wrap(sink, carpoolMatch.HandleRequest)))
+	mux.Handle("POST /orderStops", endpoint(metrics.Wrap(sink, orderStops.HandleRequest)))
+	mux.Handle("POST /compareLocations", endpoint(metrics.Wrap(sink, compareLocations.HandleRequest)))
+	if scrape, ok := sink.(http.Handler); ok {
+		mux.Handle("GET /metrics", scrape)
+	}
+	return mux
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	memory := flag.Bool("memory", false, "use an in-memory store instead of Postgres")
+	fakeGoogle := flag.Bool("fake-google", false, "answer Google API calls from the fakegoogle fixtures")
+	flag.Parse()
+	logging.Setup()
+
+	// Metrics default to Prometheus here, served on /metrics.
+	if os.Getenv("METRICS_SINK") == "" {
+		os.Setenv("METRICS_SINK", "prometheus")
+	}
+	sink, err := metrics.FromEnv()
+	if err != nil {
+		slog.Error("error configuring metrics", "error", err)
+		os.Exit(1)
+	}
+
+	var db *store.Store
+	if *memory {
+		db, _ = store.NewMemory()
+	} else {
+		db, err = store.Open(context.Background(), database.ConfigFromEnv())
+		if err != nil {
+			slog.Error("error opening store", "error", err)
+			os.Exit(1)
+		}
+	}
+	defer db.Close()
+
+	googleClient := google.NewClientFromEnv()
+	if *fakeGoogle {
+		fake := httptest.NewServer(fakegoogle.NewDefault())
+		defer fake.Close()
+		googleClient.SetBaseURL(fake.URL)
+		if googleClient.APIKey == "" {
+			googleClient.APIKey = "fake"
+		}
+		slog.Info("serving fake Google APIs", "url", fake.URL)
+	}
+	channels, err := notify.ChannelsFromEnv()
+	if err != nil {
+		slog.Error("error configuring notification channels", "error", err)
+		os.Exit(1)
+	}
+	googleClient.OnAttempt = google.Hooks(budget.Recorder(db.Usage, clock.System), metrics.ProviderHook(sink))
+
+	slog.Info("serving optimizeRoute, commutesQueue, addUserRoute, checkCommute, healthCheck, notifyUsers, planDeparture, weeklyReport, routePaths, exportPaths, carpoolMatch, orderStops and compareLocations", "url", "http://"+*addr)
+	if err := http.ListenAndServe(*addr, newServer(db, googleClient, channels, sink)); err != nil {
+		slog.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google/fakegoogle"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/notify"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *store.Memory) {
+	t.Helper()
+	fake := httptest.NewServer(fakegoogle.NewDefault())
+	t.Cleanup(fake.Close)
+	client := google.NewClient("test-key")
+	client.SetBaseURL(fake.URL)
+	client.Retry = google.RetryPolicy{MaxAttempts: 1}
+
+	db, memory := store.NewMemory()
+	server := httptest.NewServer(newServer(db, client, notify.Channels{}, metrics.NewPrometheus()))
+	t.Cleanup(server.Close)
+	return server, memory
+}
+
+func post(t *testing.T, server *httptest.Server, path, body string) (int, map[string]any) {
+	t.Helper()
+	resp, err := http.Post(server.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var decoded map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatalf("decoding %s response: %v", path, err)
+	}
+	return resp.StatusCode, decoded
+}
+
+func TestAddRouteThenQueueCommutes(t *testing.T) {
+	server, memory := newTestServer(t)
+
+	status, body := post(t, server, "/addUserRoute", `{
+		"user_id": 3,
+		"origin_address": "1777 Broadway, Boulder, CO",
+		"destination_address": "1437 Bannock St, Denver, CO",
+		"timezone": "America/Denver",
+		"morning_start_time": "00:00:00",
+		"morning_end_time": "23:59:59",
+		"afternoon_start_time": "00:00:00",
+		"afternoon_end_time": "23:59:59",
+		"monday": true, "tuesday": true, "wednesday": true, "thursday": true,
+		"friday": true, "saturday": true, "sunday": true
+	}`)
+	if status != http.StatusOK {
+		t.Fatalf("addUserRoute returned %d: %v", status, body)
+	}
+
+	status, body = post(t, server, "/commutesQueue", "")
+	if status != http.StatusOK {
+		t.Fatalf("commutesQueue returned %d: %v", status, body)
+	}
+	data := body["data"].(map[string]any)
+	if data["routes_suceeded"] != float64(2) {
+		t.Errorf("unexpected data: %v", data)
+	}
+	if len(memory.AllCommutes()) != 2 {
+		t.Errorf("recorded %d commutes, want 2", len(memory.AllCommutes()))
+	}
+
+	status, body = post(t, server, "/healthCheck", "")
+	if status != http.StatusOK {
+		t.Fatalf("healthCheck returned %d: %v", status, body)
+	}
+	if data := body["data"].(map[string]any); data["routes_checked"] != float64(1) {
+		t.Errorf("unexpected health check data: %v", data)
+	}
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	scrape, _ := io.ReadAll(resp.Body)
+	for _, line := range []string{
+		"optimize_route_routes_succeeded_total 2",
+		`optimize_route_commutes_inserted_total{ad_hoc="false"} 2`,
+	} {
+		if !strings.Contains(string(scrape), line+"\n") {
+			t.Errorf("missing %q in /metrics:\n%s", line, scrape)
+		}
+	}
+}
+
+func TestStatusCodes(t *testing.T) {
+	server, memory := newTestServer(t)
+	memory.Routes[1] = memoryRoute(1, 1.1, 1.1, 1.2, 1.2)
+	memory.Routes[2] = memoryRoute(2, 3.1, 3.1, 3.2, 3.2)
+	memory.Routes[3] = memoryRoute(3, 40.01499, -105.27055, 39.73915, -104.9847)
+	memory.Schedules[3] = domain.Schedule{
+		RouteID:            3,
+		MorningStartTime:   "07:00:00",
+		MorningEndTime:     "08:30:00",
+		AfternoonStartTime: "16:30:00",
+		AfternoonEndTime:   "18:00:00",
+		Monday:             true, Tuesday: true, Wednesday: true, Thursday: true, Friday: true, Saturday: true, Sunday: true,
+	}
+
+	tests := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/optimizeRoute", `{not json`, http.StatusBadRequest},
+		{"/optimizeRoute", `{}`, http.StatusBadRequest},
+		{"/optimizeRoute", `{"route": 1, "to_work": true}`, http.StatusTooManyRequests},
+		{"/optimizeRoute", `{"route": 2, "to_work": true}`, http.StatusBadGateway},
+		{"/optimizeRoute", `{"route": 3, "to_work": true}`, http.StatusOK},
+		{"/optimizeRoute", `{"route": 3, "to_work": true, "user_id": 4}`, http.StatusBadRequest},
+		{"/optimizeRoute", `{"route": 99, "to_work": true}`, http.StatusNotFound},
+		{"/addUserRoute", `{"user_id": 3}`, http.StatusBadRequest},
+		{"/checkCommute", `{}`, http.StatusBadRequest},
+		{"/checkCommute", `{"route_id": 99}`, http.StatusNotFound},
+		{"/planDeparture", `{"route_id": 3, "arrive_by": "09:00"}`, http.StatusOK},
+		{"/planDeparture", `{"route_id": 3, "arrive_by": "09:00", "confidence": 1}`, http.StatusBadRequest},
+		{"/planDeparture", `{"route_id": 99, "arrive_by": "09:00"}`, http.StatusNotFound},
+		{"/weeklyReport", `{"format": "markdown"}`, http.StatusOK},
+		{"/weeklyReport", `{"format": "pdf"}`, http.StatusBadRequest},
+		{"/weeklyReport", `{"user_id": 99}`, http.StatusNotFound},
+		{"/routePaths", `{"route_id": 3, "backfill": true}`, http.StatusOK},
+		{"/routePaths", `{"route_id": 3, "days": 0}`, http.StatusBadRequest},
+		{"/routePaths", `{"route_id": 99}`, http.StatusNotFound},
+		{"/exportPaths", `{"route_id": 3, "format": "gpx", "fastest": 5}`, http.StatusOK},
+		{"/exportPaths", `{"route_id": 3, "format": "shp"}`, http.StatusBadRequest},
+		{"/exportPaths", `{"route_id": 99}`, http.StatusNotFound},
+		{"/carpoolMatch", `{"to_work": true}`, http.StatusOK},
+		{"/carpoolMatch", `{"limit": 51}`, http.StatusBadRequest},
+		{"/carpoolMatch", `{"user_id": 99}`, http.StatusNotFound},
+		{"/orderStops", `{"route_id": 3, "stops": [{"latitude": 39.9, "longitude": -105.1}, {"latitude": 39.8, "longitude": -105.0}]}`, http.StatusOK},
+		{"/orderStops", `{"route_id": 3}`, http.StatusBadRequest},
+		{"/orderStops", `{"route_id": 99}`, http.StatusNotFound},
+		{"/compareLocations", `{"route_id": 3, "origin_addresses": ["3901 W 112th Ave, Westminster, CO"]}`, http.StatusOK},
+		{"/compareLocations", `{"route_id": 3, "origin_addresses": ["Springfield"]}`, http.StatusBadRequest},
+		{"/compareLocations", `{"route_id": 99, "origin_addresses": ["3901 W 112th Ave, Westminster, CO"]}`, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		status, body := post(t, server, test.path, test.body)
+		if status != test.status {
+			t.Errorf("%s %s: got %d (%v), want %d", test.path, test.body, status, body, test.status)
+		}
+		if status != http.StatusOK && body["errorMessage"] == "" {
+			t.Errorf("%s %s: missing errorMessage", test.path, test.body)
+		}
+	}
+}
+
+func memoryRoute(id int, originLat, originLng, destinationLat, destinationLng float64) domain.Route {
+	return domain.Route{
+		ID:             id,
+		UserID:         3,
+		StartLatitude:  domain.FormatCoordinate(originLat),
+		StartLongitude: domain.FormatCoordinate(originLng),
+		EndLatitude:    domain.FormatCoordinate(destinationLat),
+		EndLongitude:   domain.FormatCoordinate(destinationLng),
+		Active:         true,
+		TimeZone:       "America/Denver",
+	}
+}
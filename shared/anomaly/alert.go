@@ -0,0 +1,52 @@
+package anomaly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/notify"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"time"
+)
+
+// Notifier alerts the route's user through their notification
+// subscriptions, with times in the commute's QueryTime location. A user
+// without subscriptions is not alerted.
+type Notifier struct {
+	Notifications store.NotificationRepository
+	Channels      notify.Channels
+}
+
+// Message writes the alert for anomaly.
+func Message(anomaly Anomaly, loc *time.Location) notify.Message {
+	commute := anomaly.Commute
+	destination := "home"
+	if commute.ToWork {
+		destination = "work"
+	}
+	minutes := func(seconds int) int { return (seconds + 30) / 60 }
+	return notify.Message{
+		Title: fmt.Sprintf("Your drive to %s is %d min slower than usual", destination, minutes(commute.Duration-anomaly.Score.Median)),
+		Body: fmt.Sprintf("Route %d takes %d min right now; it usually takes %d min around %s. It will be checked every minute until %s.",
+			commute.Route, minutes(commute.Duration), minutes(anomaly.Score.Median), commute.QueryTime.In(loc).Format("15:04"),
+			anomaly.BoostedUntil.In(loc).Format("15:04")),
+		UserID:  commute.UserID,
+		RouteID: commute.Route,
+		ToWork:  &commute.ToWork,
+	}
+}
+
+func (n *Notifier) Alert(ctx context.Context, anomaly Anomaly) error {
+	subscriptions, err := n.Notifications.Subscriptions(ctx, anomaly.Commute.UserID)
+	if err != nil {
+		return fmt.Errorf("error loading subscriptions: %v", err)
+	}
+	message := Message(anomaly, anomaly.Commute.QueryTime.Location())
+	var errs []error
+	for _, subscription := range subscriptions {
+		if err := n.Channels.Send(ctx, subscription, message); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", subscription.Channel, err))
+		}
+	}
+	return errors.Join(errs...)
+}
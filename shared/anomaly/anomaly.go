@@ -0,0 +1,187 @@
+// Package anomaly scores recorded commutes against the route's usual
+// duration around the same time of day, using the median and median absolute
+// deviation (MAD) of past commutes, and reacts to ones far slower than usual
+// by boosting the route's sampling and alerting its user.
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+)
+
+// Detector scores a commute with the modified z-score
+// 0.6745 * (duration - median) / MAD over the commutes of the previous
+// History recorded within Tolerance of its time of day on other days.
+type Detector struct {
+	History   time.Duration
+	Tolerance time.Duration
+	// MinSamples is the fewest past commutes a score needs.
+	MinSamples int
+	// Threshold is the score at and above which a commute is anomalous.
+	// Faster than usual is never anomalous.
+	Threshold float64
+	// MinMAD, in seconds, keeps a very steady route from turning a few
+	// seconds of difference into a huge score.
+	MinMAD float64
+}
+
+var DefaultDetector = Detector{
+	History:    28 * 24 * time.Hour,
+	Tolerance:  15 * time.Minute,
+	MinSamples: 10,
+	Threshold:  3.5,
+	MinMAD:     30,
+}
+
+// Score is a commute's score and the baseline it was scored against, in
+// seconds.
+type Score struct {
+	Score     float64 `json:"score"`
+	Median    int     `json:"median"`
+	MAD       float64 `json:"mad"`
+	Samples   int     `json:"samples"`
+	Anomalous bool    `json:"anomalous"`
+}
+
+// Score scores commute against history in loc. It returns false when there
+// are fewer than MinSamples past commutes to compare with.
+func (d Detector) Score(commute domain.Commute, history []domain.Commute, loc *time.Location) (Score, bool) {
+	local := commute.QueryTime.In(loc)
+	date := local.Format(time.DateOnly)
+	var durations []float64
+	for _, past := range history {
+		pastLocal := past.QueryTime.In(loc)
+		if past.ID == commute.ID || pastLocal.Format(time.DateOnly) == date || !past.QueryTime.Before(commute.QueryTime) {
+			continue
+		}
+		if (timeOfDay(pastLocal) - timeOfDay(local)).Abs() <= d.Tolerance {
+			durations = append(durations, float64(past.Duration))
+		}
+	}
+	if len(durations) < d.MinSamples {
+		return Score{}, false
+	}
+
+	median := medianOf(durations)
+	deviations := make([]float64, len(durations))
+	for i, duration := range durations {
+		deviations[i] = math.Abs(duration - median)
+	}
+	mad := medianOf(deviations)
+	score := 0.6745 * (float64(commute.Duration) - median) / max(mad, d.MinMAD)
+	return Score{
+		Score:     math.Round(score*100) / 100,
+		Median:    int(math.Round(median)),
+		MAD:       mad,
+		Samples:   len(durations),
+		Anomalous: score >= d.Threshold,
+	}, true
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// Anomaly is a commute found far slower than usual.
+type Anomaly struct {
+	Commute      domain.Commute `json:"commute"`
+	Score        Score          `json:"score"`
+	BoostedUntil time.Time      `json:"boosted_until"`
+}
+
+// Alerter is told about the first anomaly of each incident.
+type Alerter interface {
+	Alert(ctx context.Context, anomaly Anomaly) error
+}
+
+type AlerterFunc func(ctx context.Context, anomaly Anomaly) error
+
+func (f AlerterFunc) Alert(ctx context.Context, anomaly Anomaly) error {
+	return f(ctx, anomaly)
+}
+
+// Monitor scores newly recorded commutes and stores the score. An anomalous
+// commute boosts the route so commutesQueue keeps checking it for BoostFor,
+// even past its schedule window, and the first one of an incident goes to
+// Alerter.
+type Monitor struct {
+	Store *store.Store
+	// Detector defaults to DefaultDetector when its Threshold is zero.
+	Detector Detector
+	// BoostFor defaults to an hour.
+	BoostFor time.Duration
+	// Alerter is optional.
+	Alerter Alerter
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+// Inspect scores commute, a scheduled or boosted commute just recorded for a
+// route in loc. The score is nil when there is not enough history yet.
+func (m *Monitor) Inspect(ctx context.Context, commute domain.Commute, loc *time.Location) (*Score, error) {
+	detector := m.Detector
+	if detector.Threshold == 0 {
+		detector = DefaultDetector
+	}
+	boostFor := m.BoostFor
+	if boostFor <= 0 {
+		boostFor = time.Hour
+	}
+	sink := metrics.Or(m.Metrics)
+
+	history, err := m.Store.Commutes.Recent(ctx, commute.Route, commute.ToWork, commute.QueryTime.Add(-detector.History))
+	if err != nil {
+		return nil, fmt.Errorf("error loading recent commutes: %v", err)
+	}
+	score, ok := detector.Score(commute, history, loc)
+	if !ok {
+		return nil, nil
+	}
+	if err := m.Store.Commutes.SetAnomalyScore(ctx, commute.ID, score.Score); err != nil {
+		return nil, fmt.Errorf("error storing anomaly score: %v", err)
+	}
+	sink.Count("commutes_scored", 1)
+	if !score.Anomalous {
+		return &score, nil
+	}
+
+	sink.Count("commute_anomalies", 1)
+	until := commute.QueryTime.Add(boostFor)
+	started, err := m.Store.Boosts.Extend(ctx, domain.Boost{
+		RouteID: commute.Route,
+		ToWork:  commute.ToWork,
+		Until:   until,
+		Reason:  fmt.Sprintf("commute %d scored %.2f", commute.ID, score.Score),
+	}, commute.QueryTime)
+	if err != nil {
+		return &score, fmt.Errorf("error boosting route %d: %v", commute.Route, err)
+	}
+	slog.WarnContext(ctx, "anomalous commute", "commute_id", commute.ID, "route_id", commute.Route, "to_work", commute.ToWork,
+		"duration_s", commute.Duration, "median_s", score.Median, "score", score.Score, "boosted_until", until, "new_incident", started)
+	if !started || m.Alerter == nil {
+		return &score, nil
+	}
+	if err := m.Alerter.Alert(ctx, Anomaly{Commute: commute, Score: score, BoostedUntil: until}); err != nil {
+		sink.Count("anomaly_alerts_failed", 1)
+		return &score, fmt.Errorf("error alerting about commute %d: %v", commute.ID, err)
+	}
+	sink.Count("anomaly_alerts_sent", 1)
+	return &score, nil
+}
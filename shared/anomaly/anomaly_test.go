@@ -0,0 +1,134 @@
+package anomaly
+
+import (
+	"context"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/notify"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 9, day, hour, minute, 0, 0, denver)
+}
+
+// history is ten weekdays of route 7 to work at 08:00, taking 29 to 31
+// minutes, and a slow commute at 10:00 that is too far away to count.
+func history() []domain.Commute {
+	var commutes []domain.Commute
+	for i, day := range []int{2, 3, 4, 5, 6, 9, 10, 11, 12, 13} {
+		commutes = append(commutes, domain.Commute{ID: 101 + i, Route: 7, UserID: 3, ToWork: true, QueryTime: at(day, 8, 0), Duration: 1740 + 12*i})
+	}
+	return append(commutes, domain.Commute{ID: 111, Route: 7, UserID: 3, ToWork: true, QueryTime: at(13, 10, 0), Duration: 4000})
+}
+
+func TestScore(t *testing.T) {
+	detector := DefaultDetector
+
+	score, ok := detector.Score(domain.Commute{ID: 20, QueryTime: at(16, 8, 5), Duration: 1800}, history(), denver)
+	if !ok || score.Samples != 10 || score.Median != 1794 || score.MAD != 30 || score.Anomalous {
+		t.Errorf("unexpected score for a usual commute: %+v", score)
+	}
+
+	score, _ = detector.Score(domain.Commute{ID: 20, QueryTime: at(16, 8, 5), Duration: 2700}, history(), denver)
+	if !score.Anomalous || score.Score < 20 {
+		t.Errorf("unexpected score for a slow commute: %+v", score)
+	}
+
+	// Faster than usual is not an incident.
+	score, _ = detector.Score(domain.Commute{ID: 20, QueryTime: at(16, 8, 5), Duration: 900}, history(), denver)
+	if score.Anomalous || score.Score > -20 {
+		t.Errorf("unexpected score for a fast commute: %+v", score)
+	}
+
+	// Commutes from the same day do not count toward the baseline.
+	if _, ok := detector.Score(domain.Commute{ID: 20, QueryTime: at(13, 8, 5), Duration: 1800}, history(), denver); ok {
+		t.Error("expected too few samples on 2024-09-13")
+	}
+	if _, ok := detector.Score(domain.Commute{ID: 20, QueryTime: at(16, 9, 0), Duration: 1800}, history(), denver); ok {
+		t.Error("expected too few samples at 09:00")
+	}
+}
+
+func TestMonitorBoostsAndAlertsOncePerIncident(t *testing.T) {
+	db, memory := store.NewMemory()
+	memory.Routes[7] = domain.Route{ID: 7, UserID: 3, Active: true, TimeZone: "America/Denver",
+		StartLatitude: "40.01499", StartLongitude: "-105.27055", EndLatitude: "39.73915", EndLongitude: "-104.9847"}
+	memory.Commutes = history()
+	var alerts []Anomaly
+	monitor := &Monitor{Store: db, Alerter: AlerterFunc(func(ctx context.Context, anomaly Anomaly) error {
+		alerts = append(alerts, anomaly)
+		return nil
+	})}
+
+	record := func(when time.Time, duration int) (*Score, error) {
+		t.Helper()
+		commute, err := db.Commutes.Insert(context.Background(), domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: when, Duration: duration})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return monitor.Inspect(context.Background(), commute, denver)
+	}
+
+	if score, err := record(at(16, 8, 0), 1790); err != nil || score == nil || score.Anomalous {
+		t.Fatalf("score = %+v, %v", score, err)
+	}
+	for _, minute := range []int{1, 2} {
+		if score, err := record(at(16, 8, minute), 2700); err != nil || !score.Anomalous {
+			t.Fatalf("score = %+v, %v", score, err)
+		}
+	}
+	if len(alerts) != 1 || alerts[0].Commute.Duration != 2700 || !alerts[0].BoostedUntil.Equal(at(16, 9, 1)) {
+		t.Errorf("alerts = %+v", alerts)
+	}
+
+	boosted, err := db.Boosts.Active(context.Background(), at(16, 9, 1))
+	if err != nil || len(boosted) != 1 || boosted[0].ID != 7 || !boosted[0].ToWork || !boosted[0].BoostedUntil.Equal(at(16, 9, 2)) {
+		t.Errorf("boosted = %+v, %v", boosted, err)
+	}
+	for _, commute := range memory.AllCommutes()[11:] {
+		if commute.AnomalyScore == nil {
+			t.Errorf("commute %d was not scored", commute.ID)
+		}
+	}
+
+	// Once the boost has lapsed, the next anomaly is a new incident.
+	if _, err := record(at(16, 17, 0), 2700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := record(at(17, 8, 0), 2700); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 {
+		t.Errorf("alerts = %+v, want a second incident", alerts)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	db, memory := store.NewMemory()
+	memory.Subscriptions = []domain.Subscription{{UserID: 3, Channel: "capture", Target: "phone", Active: true}}
+	capture := &notify.Capture{}
+	notifier := &Notifier{Notifications: db.Notifications, Channels: notify.Channels{"capture": capture}}
+
+	anomaly := Anomaly{
+		Commute:      domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: at(16, 8, 1), Duration: 2700},
+		Score:        Score{Median: 1794},
+		BoostedUntil: at(16, 9, 1),
+	}
+	if err := notifier.Alert(context.Background(), anomaly); err != nil {
+		t.Fatal(err)
+	}
+	sent := capture.Sent()
+	if len(sent) != 1 || sent[0].Message.Title != "Your drive to work is 15 min slower than usual" ||
+		sent[0].Message.Body != "Route 7 takes 45 min right now; it usually takes 30 min around 08:01. It will be checked every minute until 09:01." {
+		t.Errorf("captured %+v", sent)
+	}
+
+	memory.Subscriptions = append(memory.Subscriptions, domain.Subscription{UserID: 3, Channel: "pager", Target: "x", Active: true})
+	if err := notifier.Alert(context.Background(), anomaly); err == nil {
+		t.Errorf("err = %v, want an unknown channel error", err)
+	}
+}
@@ -0,0 +1,226 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"time"
+)
+
+const (
+	Closed   = "closed"
+	Open     = "open"
+	HalfOpen = "half_open"
+)
+
+// Config controls the circuit breaker. The breaker opens when at least
+// MinRequests calls were made in the last Window and FailureRate of them
+// failed, whatever each call was billed as.
+// After Cooldown it lets HalfOpenRequests through as a probe: a clean probe
+// closes it again, a failed one reopens it.
+type Config struct {
+	Window           time.Duration
+	MinRequests      int
+	FailureRate      float64
+	Cooldown         time.Duration
+	HalfOpenRequests int
+}
+
+var DefaultConfig = Config{
+	Window:           10 * time.Minute,
+	MinRequests:      10,
+	FailureRate:      0.5,
+	Cooldown:         15 * time.Minute,
+	HalfOpenRequests: 1,
+}
+
+// Status is reported in commutesQueue's response. Limits and remaining
+// counts are in billed units and nil when the provider has no limit for that
+// period.
+type Status struct {
+	Provider         string `json:"provider"`
+	Circuit          string `json:"circuit"`
+	DailyLimit       *int   `json:"daily_limit"`
+	DailyRemaining   *int   `json:"daily_remaining"`
+	MonthlyLimit     *int   `json:"monthly_limit"`
+	MonthlyRemaining *int   `json:"monthly_remaining"`
+}
+
+// Guard decides how many requests a provider may be sent right now.
+type Guard struct {
+	Provider string
+	Config   Config
+	Clock    clock.Clock
+	usage    store.UsageRepository
+}
+
+func NewGuard(usage store.UsageRepository, provider string) *Guard {
+	return &Guard{
+		Provider: provider,
+		Config:   DefaultConfig,
+		Clock:    clock.System,
+		usage:    usage,
+	}
+}
+
+// Allow returns how many of wanted requests may be dispatched, and the
+// provider's status after accounting for them. Each dispatch is counted as a
+// single request; retries are metered as they happen but not reserved here.
+func (g *Guard) Allow(ctx context.Context, wanted int) (int, Status, error) {
+	return g.allow(ctx, wanted, 1, true)
+}
+
+// AllowCalls is Allow for calls billed as size requests each, such as route
+// matrices billed per element or a group of requests that only make sense
+// together. The circuit counts calls and the limits count billed requests, so
+// a half-open circuit lets a whole call through as its probe.
+func (g *Guard) AllowCalls(ctx context.Context, calls, size int) (int, Status, error) {
+	return g.allow(ctx, calls, max(size, 1), true)
+}
+
+// Require allows all of calls of size requests each, or returns an error
+// wrapping google.ErrQuota when the budget or circuit refuses any of them.
+func (g *Guard) Require(ctx context.Context, calls, size int) (Status, error) {
+	allowed, status, err := g.AllowCalls(ctx, calls, size)
+	if err != nil {
+		return status, fmt.Errorf("error checking %s budget: %v", g.Provider, err)
+	}
+	if allowed < calls {
+		return status, fmt.Errorf("%w: %s budget allows %d of %d calls of %d requests (circuit %s)", google.ErrQuota, g.Provider, allowed, calls, max(size, 1), status.Circuit)
+	}
+	return status, nil
+}
+
+// Peek answers like Allow but leaves the circuit state as it found it, for
+// dry runs.
+func (g *Guard) Peek(ctx context.Context, wanted int) (int, Status, error) {
+	return g.allow(ctx, wanted, 1, false)
+}
+
+func (g *Guard) allow(ctx context.Context, wanted, size int, save bool) (int, Status, error) {
+	now := clock.Now(g.Clock).UTC()
+	status := Status{Provider: g.Provider}
+
+	circuit, allowed, err := g.circuit(ctx, now, wanted, save)
+	if err != nil {
+		return 0, status, err
+	}
+	status.Circuit = circuit
+
+	budget, err := g.usage.Budget(ctx, g.Provider)
+	if err != nil {
+		return 0, status, err
+	}
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var dailyRemaining, monthlyRemaining *int
+	if budget.DailyLimit != nil {
+		if dailyRemaining, err = g.remaining(ctx, *budget.DailyLimit, dayStart); err != nil {
+			return 0, status, err
+		}
+		allowed = min(allowed, *dailyRemaining/size)
+	}
+	if budget.MonthlyLimit != nil {
+		if monthlyRemaining, err = g.remaining(ctx, *budget.MonthlyLimit, monthStart); err != nil {
+			return 0, status, err
+		}
+		allowed = min(allowed, *monthlyRemaining/size)
+	}
+
+	status.DailyLimit = budget.DailyLimit
+	status.MonthlyLimit = budget.MonthlyLimit
+	if dailyRemaining != nil {
+		left := *dailyRemaining - allowed*size
+		status.DailyRemaining = &left
+	}
+	if monthlyRemaining != nil {
+		left := *monthlyRemaining - allowed*size
+		status.MonthlyRemaining = &left
+	}
+	return allowed, status, nil
+}
+
+func (g *Guard) remaining(ctx context.Context, limit int, since time.Time) (*int, error) {
+	used, err := g.usage.Since(ctx, g.Provider, since)
+	if err != nil {
+		return nil, err
+	}
+	left := max(limit-used.Units, 0)
+	return &left, nil
+}
+
+// circuit advances the breaker and returns its state and how many of wanted
+// requests it lets through. State changes are only stored when save is set.
+func (g *Guard) circuit(ctx context.Context, now time.Time, wanted int, save bool) (string, int, error) {
+	openedAt, err := g.usage.CircuitOpenedAt(ctx, g.Provider)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if openedAt == nil {
+		recent, err := g.usage.Since(ctx, g.Provider, now.Add(-g.Config.Window))
+		if err != nil {
+			return "", 0, err
+		}
+		if !g.tripped(recent) {
+			return Closed, wanted, nil
+		}
+		slog.WarnContext(ctx, "opening circuit", "provider", g.Provider, "failures", recent.Failures, "requests", recent.Requests)
+		return Open, 0, g.setOpenedAt(ctx, save, &now)
+	}
+
+	probeStart := openedAt.Add(g.Config.Cooldown)
+	if now.Before(probeStart) {
+		return Open, 0, nil
+	}
+
+	probe, err := g.usage.Since(ctx, g.Provider, probeStart)
+	if err != nil {
+		return "", 0, err
+	}
+	switch {
+	case probe.Failures > 0:
+		slog.WarnContext(ctx, "reopening circuit after failed probe", "provider", g.Provider)
+		return Open, 0, g.setOpenedAt(ctx, save, &now)
+	case probe.Requests > 0:
+		slog.InfoContext(ctx, "closing circuit after successful probe", "provider", g.Provider)
+		return Closed, wanted, g.setOpenedAt(ctx, save, nil)
+	}
+	return HalfOpen, min(wanted, g.Config.HalfOpenRequests), nil
+}
+
+func (g *Guard) setOpenedAt(ctx context.Context, save bool, openedAt *time.Time) error {
+	if !save {
+		return nil
+	}
+	return g.usage.SetCircuitOpenedAt(ctx, g.Provider, openedAt)
+}
+
+func (g *Guard) tripped(usage domain.Usage) bool {
+	if usage.Requests == 0 || usage.Requests < g.Config.MinRequests {
+		return false
+	}
+	return float64(usage.Failures)/float64(usage.Requests) >= g.Config.FailureRate
+}
+
+// Recorder returns a google.Client OnAttempt hook that meters every attempt
+// as one call, for the breaker, billed as its units, for the limits. Invalid
+// requests count against the budget but not the breaker, since they point at
+// our input rather than the provider.
+func Recorder(usage store.UsageRepository, c clock.Clock) google.AttemptHook {
+	return func(ctx context.Context, attempt google.Attempt) {
+		used := domain.Usage{Requests: 1, Units: max(attempt.Units, 1)}
+		if attempt.Err != nil && !errors.Is(attempt.Err, google.ErrInvalidRequest) {
+			used.Failures = 1
+		}
+		if err := usage.Record(ctx, attempt.Provider, clock.Now(c), used); err != nil {
+			slog.ErrorContext(ctx, "error recording usage", "provider", attempt.Provider, "error", err)
+		}
+	}
+}
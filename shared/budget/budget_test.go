@@ -0,0 +1,184 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"testing"
+	"time"
+)
+
+const provider = "google_routes"
+
+func newGuard(t *testing.T, now time.Time) (*Guard, *store.Store, *store.Memory) {
+	t.Helper()
+	db, memory := store.NewMemory()
+	guard := NewGuard(db.Usage, provider)
+	guard.Clock = clock.Fixed(now)
+	return guard, db, memory
+}
+
+func record(t *testing.T, db *store.Store, at time.Time, requests, failures int) {
+	t.Helper()
+	if err := db.Usage.Record(context.Background(), provider, at, domain.Usage{Requests: requests, Failures: failures, Units: requests}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllowWithoutBudget(t *testing.T) {
+	guard, _, _ := newGuard(t, time.Date(2024, 9, 2, 14, 0, 0, 0, time.UTC))
+
+	allowed, status, err := guard.Allow(context.Background(), 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed != 40 || status.Circuit != Closed || status.DailyRemaining != nil || status.MonthlyRemaining != nil {
+		t.Errorf("allowed %d, status %+v", allowed, status)
+	}
+}
+
+func TestAllowEnforcesDailyAndMonthlyLimits(t *testing.T) {
+	now := time.Date(2024, 9, 2, 14, 0, 0, 0, time.UTC)
+	guard, db, memory := newGuard(t, now)
+	daily, monthly := 100, 150
+	memory.Budgets[provider] = domain.Budget{Provider: provider, DailyLimit: &daily, MonthlyLimit: &monthly}
+
+	record(t, db, now.Add(-time.Hour), 90, 0)                           // today
+	record(t, db, now.AddDate(0, 0, -1), 50, 0)                         // earlier this month
+	record(t, db, time.Date(2024, 8, 31, 0, 0, 0, 0, time.UTC), 500, 0) // last month
+
+	allowed, status, err := guard.Allow(context.Background(), 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed != 10 {
+		t.Errorf("allowed %d, want 10", allowed)
+	}
+	if *status.DailyRemaining != 0 || *status.MonthlyRemaining != 0 {
+		t.Errorf("remaining daily %d, monthly %d; want 0 and 0", *status.DailyRemaining, *status.MonthlyRemaining)
+	}
+}
+
+func TestCircuitLifecycle(t *testing.T) {
+	now := time.Date(2024, 9, 2, 14, 0, 0, 0, time.UTC)
+	guard, db, memory := newGuard(t, now)
+
+	// A few failures below MinRequests do not trip the breaker.
+	record(t, db, now.Add(-time.Minute), 4, 4)
+	if allowed, status, _ := guard.Allow(context.Background(), 5); allowed != 5 || status.Circuit != Closed {
+		t.Fatalf("allowed %d, circuit %s; want 5 closed", allowed, status.Circuit)
+	}
+
+	record(t, db, now.Add(-time.Minute), 6, 2)
+	allowed, status, err := guard.Allow(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed != 0 || status.Circuit != Open {
+		t.Fatalf("allowed %d, circuit %s; want 0 open", allowed, status.Circuit)
+	}
+	if _, ok := memory.Circuits[provider]; !ok {
+		t.Fatal("circuit state was not saved")
+	}
+
+	// Still open during the cooldown.
+	guard.Clock = clock.Fixed(now.Add(10 * time.Minute))
+	if allowed, status, _ := guard.Allow(context.Background(), 5); allowed != 0 || status.Circuit != Open {
+		t.Fatalf("allowed %d, circuit %s; want 0 open", allowed, status.Circuit)
+	}
+
+	// After the cooldown a single probe is let through.
+	probeAt := now.Add(guard.Config.Cooldown)
+	guard.Clock = clock.Fixed(probeAt)
+	if allowed, status, _ := guard.Allow(context.Background(), 5); allowed != 1 || status.Circuit != HalfOpen {
+		t.Fatalf("allowed %d, circuit %s; want 1 half_open", allowed, status.Circuit)
+	}
+
+	// A failed probe reopens the circuit.
+	record(t, db, probeAt, 1, 1)
+	guard.Clock = clock.Fixed(probeAt.Add(time.Minute))
+	if allowed, status, _ := guard.Allow(context.Background(), 5); allowed != 0 || status.Circuit != Open {
+		t.Fatalf("allowed %d, circuit %s; want 0 open", allowed, status.Circuit)
+	}
+
+	// A successful probe after the next cooldown closes it.
+	probeAt = probeAt.Add(time.Minute + guard.Config.Cooldown)
+	record(t, db, probeAt, 1, 0)
+	guard.Clock = clock.Fixed(probeAt.Add(time.Minute))
+	if allowed, status, _ := guard.Allow(context.Background(), 5); allowed != 5 || status.Circuit != Closed {
+		t.Fatalf("allowed %d, circuit %s; want 5 closed", allowed, status.Circuit)
+	}
+	if _, ok := memory.Circuits[provider]; ok {
+		t.Error("closed circuit is still saved as open")
+	}
+}
+
+func TestAllowCallsBySize(t *testing.T) {
+	now := time.Date(2024, 9, 2, 14, 0, 0, 0, time.UTC)
+	guard, db, memory := newGuard(t, now)
+	daily := 100
+	memory.Budgets[provider] = domain.Budget{Provider: provider, DailyLimit: &daily}
+	record(t, db, now.Add(-time.Hour), 30, 0)
+
+	allowed, status, err := guard.AllowCalls(context.Background(), 4, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed != 2 || *status.DailyRemaining != 20 {
+		t.Errorf("allowed %d with %d remaining, want 2 with 20", allowed, *status.DailyRemaining)
+	}
+	if _, err := guard.Require(context.Background(), 3, 25); !errors.Is(err, google.ErrQuota) {
+		t.Errorf("got %v, want ErrQuota", err)
+	}
+	if _, err := guard.Require(context.Background(), 1, 70); err != nil {
+		t.Errorf("got %v for an affordable call", err)
+	}
+
+	// A half-open circuit lets one whole call through as its probe.
+	opened := now.Add(-guard.Config.Cooldown - time.Minute)
+	memory.Circuits[provider] = opened
+	if allowed, status, _ := guard.AllowCalls(context.Background(), 3, 20); allowed != 1 || status.Circuit != HalfOpen {
+		t.Errorf("allowed %d, circuit %s; want 1 half_open", allowed, status.Circuit)
+	}
+}
+
+func TestRecorderCountsUnits(t *testing.T) {
+	now := time.Date(2024, 9, 2, 14, 0, 0, 0, time.UTC)
+	_, db, _ := newGuard(t, now)
+	hook := Recorder(db.Usage, clock.Fixed(now))
+	hook(context.Background(), google.Attempt{Provider: provider})
+	hook(context.Background(), google.Attempt{Provider: provider, Units: 16})
+	hook(context.Background(), google.Attempt{Provider: provider, Units: 9, Err: google.ErrTransient})
+
+	used, err := db.Usage.Since(context.Background(), provider, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used.Requests != 3 || used.Failures != 1 || used.Units != 26 {
+		t.Errorf("recorded %+v, want 3 calls, 1 failure and 26 units", used)
+	}
+}
+
+func TestFailedMatrixDoesNotTripCircuit(t *testing.T) {
+	now := time.Date(2024, 9, 2, 14, 0, 0, 0, time.UTC)
+	guard, db, memory := newGuard(t, now)
+	limit := 1000
+	memory.Budgets[provider] = domain.Budget{Provider: provider, DailyLimit: &limit}
+	hook := Recorder(db.Usage, clock.Fixed(now))
+	for range 9 {
+		hook(context.Background(), google.Attempt{Provider: provider})
+	}
+	// A 24 by 24 matrix is one failed call, though billed as 576 elements.
+	hook(context.Background(), google.Attempt{Provider: provider, Units: 576, Err: google.ErrTransient})
+
+	allowed, status, err := guard.Allow(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed != 10 || status.Circuit != Closed || *status.DailyRemaining != 1000-585-10 {
+		t.Errorf("allowed %d, status %+v", allowed, status)
+	}
+}
@@ -0,0 +1,293 @@
+// Package carpool finds pairs of users who could share a car: their homes
+// and workplaces are close together, their schedule windows overlap, and
+// picking the passenger up and dropping them off costs the driver little
+// extra driving.
+package carpool
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/planner"
+	"math"
+	"sort"
+	"time"
+)
+
+// CallsPerCandidate is how many drives Evaluate predicts for one candidate:
+// both solo trips and the four legs to and from the other party.
+const CallsPerCandidate = 6
+
+type Matcher struct {
+	Predict planner.Predictor
+	// MaxOriginDistance and MaxDestinationDistance are how far apart in
+	// meters the two trips may start and end.
+	MaxOriginDistance      float64
+	MaxDestinationDistance float64
+	// MinOverlap is how long both schedule windows must be open together.
+	MinOverlap time.Duration
+	// MaxDetour is the most extra driving a suggestion may cost the driver.
+	MaxDetour time.Duration
+}
+
+// New returns a Matcher with the default limits.
+func New(predict planner.Predictor) *Matcher {
+	return &Matcher{
+		Predict:                predict,
+		MaxOriginDistance:      5000,
+		MaxDestinationDistance: 3000,
+		MinOverlap:             15 * time.Minute,
+		MaxDetour:              20 * time.Minute,
+	}
+}
+
+// Candidate is a pair of routes of different users that are close enough and
+// share enough of a schedule window to be worth asking the provider about.
+// WindowStart and WindowEnd are the overlap, "HH:MM:SS" in the shared time
+// zone, and Days are the weekdays both commute.
+type Candidate struct {
+	A, B                domain.ScheduledRoute
+	ToWork              bool
+	Days                []time.Weekday
+	WindowStart         string
+	WindowEnd           string
+	OriginDistance      float64
+	DestinationDistance float64
+}
+
+// Party is one side of a suggestion.
+type Party struct {
+	UserID  int `json:"user_id"`
+	RouteID int `json:"route_id"`
+	// Solo is the predicted drive in seconds on their own.
+	Solo int `json:"solo"`
+}
+
+// Suggestion is a carpool for one direction. Shared is the driver's trip in
+// seconds with the pickup and drop-off, Detour how much longer that is than
+// driving alone, and Saved the drive time the pair saves together.
+type Suggestion struct {
+	Driver              Party     `json:"driver"`
+	Passenger           Party     `json:"passenger"`
+	ToWork              bool      `json:"to_work"`
+	Days                []string  `json:"days"`
+	WindowStart         string    `json:"window_start"`
+	WindowEnd           string    `json:"window_end"`
+	Departure           time.Time `json:"departure"`
+	OriginDistance      int       `json:"origin_distance"`
+	DestinationDistance int       `json:"destination_distance"`
+	Shared              int       `json:"shared"`
+	Detour              int       `json:"detour"`
+	Saved               int       `json:"saved"`
+}
+
+// window returns the route's schedule window in a direction.
+func window(route domain.ScheduledRoute, toWork bool) (time.Time, time.Time, error) {
+	start, end := route.Schedule.AfternoonStartTime, route.Schedule.AfternoonEndTime
+	if toWork {
+		start, end = route.Schedule.MorningStartTime, route.Schedule.MorningEndTime
+	}
+	from, err := time.Parse(time.TimeOnly, start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("route %d window start %q: %v", route.Route.ID, start, err)
+	}
+	to, err := time.Parse(time.TimeOnly, end)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("route %d window end %q: %v", route.Route.ID, end, err)
+	}
+	return from, to, nil
+}
+
+// Candidates pairs every two routes of different users in the same time zone
+// that commute on a common weekday, start and end within the distance limits
+// and whose windows in the direction overlap by at least MinOverlap. Routes
+// with unreadable coordinates or windows are left out. The closest pairs come
+// first.
+func (m *Matcher) Candidates(routes []domain.ScheduledRoute, toWork bool) []Candidate {
+	var candidates []Candidate
+	for i, a := range routes {
+		for _, b := range routes[i+1:] {
+			if a.Route.UserID == b.Route.UserID || a.Route.TimeZone != b.Route.TimeZone {
+				continue
+			}
+			var days []time.Weekday
+			for day := time.Sunday; day <= time.Saturday; day++ {
+				if a.Schedule.ActiveOn(day) && b.Schedule.ActiveOn(day) {
+					days = append(days, day)
+				}
+			}
+			if len(days) == 0 {
+				continue
+			}
+			tripA, errA := a.Route.Direction(toWork)
+			tripB, errB := b.Route.Direction(toWork)
+			if errA != nil || errB != nil {
+				continue
+			}
+			originDistance := Distance(tripA.Origin, tripB.Origin)
+			destinationDistance := Distance(tripA.Destination, tripB.Destination)
+			if originDistance > m.MaxOriginDistance || destinationDistance > m.MaxDestinationDistance {
+				continue
+			}
+			startA, endA, errA := window(a, toWork)
+			startB, endB, errB := window(b, toWork)
+			if errA != nil || errB != nil {
+				continue
+			}
+			start, end := startA, endA
+			if startB.After(start) {
+				start = startB
+			}
+			if endB.Before(end) {
+				end = endB
+			}
+			if end.Sub(start) < m.MinOverlap {
+				continue
+			}
+			candidates = append(candidates, Candidate{
+				A:                   a,
+				B:                   b,
+				ToWork:              toWork,
+				Days:                days,
+				WindowStart:         start.Format(time.TimeOnly),
+				WindowEnd:           end.Format(time.TimeOnly),
+				OriginDistance:      originDistance,
+				DestinationDistance: destinationDistance,
+			})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].OriginDistance+candidates[i].DestinationDistance < candidates[j].OriginDistance+candidates[j].DestinationDistance
+	})
+	return candidates
+}
+
+// NextDeparture is the start of the candidate's shared window on the next
+// day both commute, after now.
+func NextDeparture(candidate Candidate, now time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(candidate.A.Route.TimeZone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("route %d time zone %q: %v", candidate.A.Route.ID, candidate.A.Route.TimeZone, err)
+	}
+	start, err := time.Parse(time.TimeOnly, candidate.WindowStart)
+	if err != nil {
+		return time.Time{}, err
+	}
+	local := now.In(loc)
+	for offset := 0; offset <= 7; offset++ {
+		day := local.AddDate(0, 0, offset)
+		departure := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), start.Second(), 0, loc)
+		if departure.After(now) && candidate.A.Schedule.ActiveOn(departure.Weekday()) && candidate.B.Schedule.ActiveOn(departure.Weekday()) {
+			return departure, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("routes %d and %d share no weekday", candidate.A.Route.ID, candidate.B.Route.ID)
+}
+
+// Evaluate predicts both solo drives and both ways of sharing them at the
+// departure, and suggests whichever driver has the shorter detour. The driver
+// picks the passenger up at their origin and drops them off at their
+// destination on the way.
+func (m *Matcher) Evaluate(ctx context.Context, candidate Candidate, departure time.Time) (Suggestion, error) {
+	tripA, err := candidate.A.Route.Direction(candidate.ToWork)
+	if err != nil {
+		return Suggestion{}, err
+	}
+	tripB, err := candidate.B.Route.Direction(candidate.ToWork)
+	if err != nil {
+		return Suggestion{}, err
+	}
+	drive := func(from, to domain.LatLng) (int, error) {
+		leg := tripA
+		leg.Origin, leg.Destination = from, to
+		return m.Predict(ctx, leg, departure)
+	}
+	var legs [CallsPerCandidate]int
+	for i, leg := range [CallsPerCandidate][2]domain.LatLng{
+		{tripA.Origin, tripA.Destination},
+		{tripB.Origin, tripB.Destination},
+		{tripA.Origin, tripB.Origin},
+		{tripB.Destination, tripA.Destination},
+		{tripB.Origin, tripA.Origin},
+		{tripA.Destination, tripB.Destination},
+	} {
+		if legs[i], err = drive(leg[0], leg[1]); err != nil {
+			return Suggestion{}, err
+		}
+	}
+	soloA, soloB := legs[0], legs[1]
+	driverA := legs[2] + soloB + legs[3]
+	driverB := legs[4] + soloA + legs[5]
+
+	a := Party{UserID: candidate.A.Route.UserID, RouteID: candidate.A.Route.ID, Solo: soloA}
+	b := Party{UserID: candidate.B.Route.UserID, RouteID: candidate.B.Route.ID, Solo: soloB}
+	suggestion := Suggestion{
+		Driver:              a,
+		Passenger:           b,
+		ToWork:              candidate.ToWork,
+		WindowStart:         candidate.WindowStart,
+		WindowEnd:           candidate.WindowEnd,
+		Departure:           departure,
+		OriginDistance:      int(math.Round(candidate.OriginDistance)),
+		DestinationDistance: int(math.Round(candidate.DestinationDistance)),
+		Shared:              driverA,
+	}
+	if driverB-soloB < driverA-soloA {
+		suggestion.Driver, suggestion.Passenger, suggestion.Shared = b, a, driverB
+	}
+	suggestion.Detour = suggestion.Shared - suggestion.Driver.Solo
+	suggestion.Saved = soloA + soloB - suggestion.Shared
+	for _, day := range candidate.Days {
+		suggestion.Days = append(suggestion.Days, day.String())
+	}
+	return suggestion, nil
+}
+
+// Rank drops the suggestions whose detour is over MaxDetour and orders the
+// rest by detour, then by the most time saved.
+func (m *Matcher) Rank(suggestions []Suggestion) []Suggestion {
+	ranked := []Suggestion{}
+	for _, suggestion := range suggestions {
+		if time.Duration(suggestion.Detour)*time.Second <= m.MaxDetour {
+			ranked = append(ranked, suggestion)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Detour != ranked[j].Detour {
+			return ranked[i].Detour < ranked[j].Detour
+		}
+		return ranked[i].Saved > ranked[j].Saved
+	})
+	return ranked
+}
+
+// Cached remembers predict's answers by origin, destination and departure, so
+// a route paired with several others is only asked about once per leg.
+func Cached(predict planner.Predictor) planner.Predictor {
+	type key struct {
+		origin, destination domain.LatLng
+		departure           time.Time
+	}
+	answers := map[key]int{}
+	return func(ctx context.Context, trip domain.DueRoute, departure time.Time) (int, error) {
+		k := key{trip.Origin, trip.Destination, departure.UTC()}
+		if seconds, ok := answers[k]; ok {
+			return seconds, nil
+		}
+		seconds, err := predict(ctx, trip, departure)
+		if err == nil {
+			answers[k] = seconds
+		}
+		return seconds, err
+	}
+}
+
+// Distance is the great-circle distance between two points in meters.
+func Distance(a, b domain.LatLng) float64 {
+	const earthRadius = 6371000.0
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,147 @@
+package carpool
+
+import (
+	"context"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+// straightLine predicts a drive at 15 m/s along the straight line.
+func straightLine(calls *int) func(ctx context.Context, trip domain.DueRoute, departure time.Time) (int, error) {
+	return func(ctx context.Context, trip domain.DueRoute, departure time.Time) (int, error) {
+		*calls++
+		return int(Distance(trip.Origin, trip.Destination) / 15), nil
+	}
+}
+
+func scheduled(id, userID int, home, work domain.LatLng, morningStart, morningEnd string) domain.ScheduledRoute {
+	return domain.ScheduledRoute{
+		Route: domain.Route{
+			ID:             id,
+			UserID:         userID,
+			StartLatitude:  domain.FormatCoordinate(home.Latitude),
+			StartLongitude: domain.FormatCoordinate(home.Longitude),
+			EndLatitude:    domain.FormatCoordinate(work.Latitude),
+			EndLongitude:   domain.FormatCoordinate(work.Longitude),
+			Active:         true,
+			TimeZone:       "America/Denver",
+		},
+		Schedule: domain.Schedule{
+			RouteID:            id,
+			MorningStartTime:   morningStart,
+			MorningEndTime:     morningEnd,
+			AfternoonStartTime: "16:30:00",
+			AfternoonEndTime:   "18:00:00",
+			Monday:             true, Tuesday: true, Wednesday: true, Thursday: true, Friday: true,
+		},
+	}
+}
+
+var (
+	boulder      = domain.LatLng{Latitude: 40.01499, Longitude: -105.27055}
+	nearBoulder  = domain.LatLng{Latitude: 40.02, Longitude: -105.255}
+	golden       = domain.LatLng{Latitude: 39.7555, Longitude: -105.2211}
+	downtown     = domain.LatLng{Latitude: 39.73915, Longitude: -104.9847}
+	nearDowntown = domain.LatLng{Latitude: 39.7425, Longitude: -104.99}
+	routes       = []domain.ScheduledRoute{
+		scheduled(1, 10, boulder, downtown, "07:00:00", "08:30:00"),
+		// Close to route 1 with an overlapping window.
+		scheduled(2, 20, nearBoulder, nearDowntown, "07:45:00", "09:00:00"),
+		// Route 1's user again, a commuter from Golden, too far away, and one
+		// who leaves after route 1's window closes and shares only ten
+		// minutes of route 2's.
+		scheduled(3, 10, nearBoulder, downtown, "07:00:00", "08:30:00"),
+		scheduled(4, 40, golden, downtown, "07:00:00", "08:30:00"),
+		scheduled(5, 50, boulder, downtown, "08:50:00", "10:00:00"),
+	}
+)
+
+func TestCandidates(t *testing.T) {
+	matcher := New(nil)
+	candidates := matcher.Candidates(routes, true)
+	if len(candidates) != 2 {
+		t.Fatalf("got %d candidates: %+v", len(candidates), candidates)
+	}
+	// Routes 2 and 3 start at the same place, so they come before 1 and 2.
+	best := candidates[0]
+	if best.A.Route.ID != 2 || best.B.Route.ID != 3 || best.WindowStart != "07:45:00" || best.WindowEnd != "08:30:00" || len(best.Days) != 5 {
+		t.Errorf("unexpected best candidate: A=%d B=%d %+v", best.A.Route.ID, best.B.Route.ID, best)
+	}
+	for _, candidate := range candidates {
+		if candidate.A.Route.UserID == candidate.B.Route.UserID || candidate.A.Route.ID == 4 || candidate.B.Route.ID == 4 || candidate.B.Route.ID == 5 {
+			t.Errorf("unexpected candidate %d and %d", candidate.A.Route.ID, candidate.B.Route.ID)
+		}
+	}
+
+	// Going home, everyone's 16:30-18:00 window overlaps, so route 5 pairs too.
+	if home := matcher.Candidates(routes, false); len(home) != 5 {
+		t.Errorf("got %d candidates from work", len(home))
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	calls := 0
+	matcher := New(straightLine(&calls))
+	candidate := matcher.Candidates(routes[:2], true)[0]
+	departure, err := NextDeparture(candidate, time.Date(2024, 9, 6, 18, 0, 0, 0, denver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Friday evening's next shared window is Monday morning.
+	if want := time.Date(2024, 9, 9, 7, 45, 0, 0, denver); !departure.Equal(want) {
+		t.Errorf("departure = %v, want %v", departure, want)
+	}
+
+	suggestion, err := matcher.Evaluate(context.Background(), candidate, departure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != CallsPerCandidate {
+		t.Errorf("made %d calls", calls)
+	}
+	solo1 := int(Distance(boulder, downtown) / 15)
+	solo2 := int(Distance(nearBoulder, nearDowntown) / 15)
+	// Route 1 starts further out, so driving from there costs the least.
+	shared := int(Distance(boulder, nearBoulder)/15) + solo2 + int(Distance(nearDowntown, downtown)/15)
+	if suggestion.Driver != (Party{UserID: 10, RouteID: 1, Solo: solo1}) || suggestion.Passenger != (Party{UserID: 20, RouteID: 2, Solo: solo2}) {
+		t.Errorf("driver %+v, passenger %+v", suggestion.Driver, suggestion.Passenger)
+	}
+	if suggestion.Shared != shared || suggestion.Detour != shared-solo1 || suggestion.Saved != solo1+solo2-shared || suggestion.Detour <= 0 {
+		t.Errorf("unexpected suggestion: %+v", suggestion)
+	}
+	if len(suggestion.Days) != 5 || suggestion.Days[0] != "Monday" {
+		t.Errorf("days = %v", suggestion.Days)
+	}
+}
+
+func TestRank(t *testing.T) {
+	matcher := New(nil)
+	ranked := matcher.Rank([]Suggestion{
+		{Detour: 300, Saved: 1000},
+		{Detour: 1500, Saved: 3000},
+		{Detour: 120, Saved: 500},
+		{Detour: 300, Saved: 2000},
+	})
+	if len(ranked) != 3 || ranked[0].Detour != 120 || ranked[1].Saved != 2000 || ranked[2].Saved != 1000 {
+		t.Errorf("ranked = %+v", ranked)
+	}
+}
+
+func TestCached(t *testing.T) {
+	calls := 0
+	predict := Cached(straightLine(&calls))
+	at := time.Date(2024, 9, 9, 7, 45, 0, 0, denver)
+	trip := domain.DueRoute{Origin: boulder, Destination: downtown}
+	for i := 0; i < 3; i++ {
+		if _, err := predict(context.Background(), trip, at.In(time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	predict(context.Background(), trip, at.Add(time.Hour))
+	if calls != 2 {
+		t.Errorf("made %d calls, want 2", calls)
+	}
+}
@@ -0,0 +1,33 @@
+// Package clock lets handlers and queries take the current time from the
+// caller, so schedules can be tested and past days replayed.
+package clock
+
+import "time"
+
+type Clock interface {
+	Now() time.Time
+}
+
+// Func adapts a function to a Clock.
+type Func func() time.Time
+
+func (f Func) Now() time.Time {
+	return f()
+}
+
+// System reads the machine's clock.
+var System Clock = Func(time.Now)
+
+// Fixed always returns t.
+func Fixed(t time.Time) Clock {
+	return Func(func() time.Time { return t })
+}
+
+// Now returns c.Now(), or the system time if c is nil, so a zero value
+// handler uses the real clock.
+func Now(c Clock) time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config describes how to reach the Postgres database. Lambdas build it
+// from the environment with ConfigFromEnv.
+type Config struct {
+	Username string
+	Password string
+	Host     string
+	Port     string
+	Database string
+	// SSLMode is passed through to lib/pq, e.g. "require", "verify-full" or
+	// "disable" for a local database.
+	SSLMode string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
+	ConnMaxLifetime time.Duration
+}
+
+// Defaults keep each Lambda container to a handful of connections, since
+// commutesQueue can fan out to many optimizeRoute containers at once.
+const (
+	defaultSSLMode         = "require"
+	defaultMaxOpenConns    = 2
+	defaultMaxIdleConns    = 2
+	defaultConnMaxIdleTime = 5 * time.Minute
+	defaultConnMaxLifetime = 30 * time.Minute
+)
+
+// ConfigFromEnv reads SUPABASE_USERNAME, SUPABASE_PASSWORD, SUPABASE_HOST,
+// SUPABASE_PORT, SUPABASE_DATABASE and the optional SUPABASE_SSLMODE and
+// DATABASE_MAX_OPEN_CONNS.
+func ConfigFromEnv() Config {
+	config := Config{
+		Username:        os.Getenv("SUPABASE_USERNAME"),
+		Password:        os.Getenv("SUPABASE_PASSWORD"),
+		Host:            os.Getenv("SUPABASE_HOST"),
+		Port:            os.Getenv("SUPABASE_PORT"),
+		Database:        os.Getenv("SUPABASE_DATABASE"),
+		SSLMode:         os.Getenv("SUPABASE_SSLMODE"),
+		MaxOpenConns:    defaultMaxOpenConns,
+		MaxIdleConns:    defaultMaxIdleConns,
+		ConnMaxIdleTime: defaultConnMaxIdleTime,
+		ConnMaxLifetime: defaultConnMaxLifetime,
+	}
+	if maxOpenConns, err := strconv.Atoi(os.Getenv("DATABASE_MAX_OPEN_CONNS")); err == nil && maxOpenConns > 0 {
+		config.MaxOpenConns = maxOpenConns
+		if config.MaxIdleConns > maxOpenConns {
+			config.MaxIdleConns = maxOpenConns
+		}
+	}
+	return config
+}
+
+// URL builds the connection string. Credentials are escaped, so passwords
+// containing characters such as '@' or '/' are safe.
+func (c Config) URL() (string, error) {
+	missing := ""
+	switch {
+	case c.Username == "":
+		missing = "SUPABASE_USERNAME"
+	case c.Host == "":
+		missing = "SUPABASE_HOST"
+	case c.Database == "":
+		missing = "SUPABASE_DATABASE"
+	}
+	if missing != "" {
+		return "", fmt.Errorf("invalid database config. Missing %s", missing)
+	}
+
+	host := c.Host
+	if c.Port != "" {
+		host = net.JoinHostPort(c.Host, c.Port)
+	}
+	sslMode := c.SSLMode
+	if sslMode == "" {
+		sslMode = defaultSSLMode
+	}
+	connURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Username, c.Password),
+		Host:     host,
+		Path:     "/" + c.Database,
+		RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+	}
+	return connURL.String(), nil
+}
+
+// Open creates a connection pool and pings it, so a misconfigured Lambda
+// fails at startup instead of on its first query. Call it once per
+// container and reuse the pool across invocations.
+func Open(ctx context.Context, config Config) (*sql.DB, error) {
+	connStr, err := config.URL()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initalize database client: %w", err)
+	}
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping the database: %w", err)
+	}
+	return db, nil
+}
@@ -0,0 +1,347 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidRequest wraps errors caused by a bad handler request rather than
+// a failure while serving it.
+var ErrInvalidRequest = errors.New("invalid request")
+
+type LatLng struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type User struct {
+	ID         int     `json:"id,omitempty"`
+	Username   string  `json:"username"`
+	DateJoined string  `json:"date_joined"`
+	Name       *string `json:"name"`
+}
+
+// Route is a row of the routes table. Coordinates are stored as text.
+type Route struct {
+	ID             int     `json:"id,omitempty"`
+	UserID         int     `json:"user_id"`
+	StartAddress   string  `json:"start_address"`
+	EndAddress     string  `json:"end_address"`
+	StartLatitude  string  `json:"start_latitude"`
+	StartLongitude string  `json:"start_longitude"`
+	EndLatitude    string  `json:"end_latitude"`
+	EndLongitude   string  `json:"end_longitude"`
+	Active         bool    `json:"active"`
+	StartDate      string  `json:"start_date"`
+	EndDate        *string `json:"end_date"`
+	TimeZone       string  `json:"time_zone"`
+}
+
+func (r Route) Start() (LatLng, error) {
+	return parseLatLng(r.StartLatitude, r.StartLongitude)
+}
+
+func (r Route) End() (LatLng, error) {
+	return parseLatLng(r.EndLatitude, r.EndLongitude)
+}
+
+// Direction returns the route as driven to work, or home when toWork is
+// false.
+func (r Route) Direction(toWork bool) (DueRoute, error) {
+	start, err := r.Start()
+	if err != nil {
+		return DueRoute{}, fmt.Errorf("route %d origin: %w", r.ID, err)
+	}
+	end, err := r.End()
+	if err != nil {
+		return DueRoute{}, fmt.Errorf("route %d destination: %w", r.ID, err)
+	}
+	trip := DueRoute{
+		ID:          r.ID,
+		UserID:      r.UserID,
+		Origin:      start,
+		Destination: end,
+		TimeZone:    r.TimeZone,
+		ToWork:      toWork,
+	}
+	if !toWork {
+		trip.Origin, trip.Destination = end, start
+	}
+	return trip, nil
+}
+
+// Waypoint is a row of the route_waypoints table: a stop such as daycare or
+// the gym between a route's start and end. Position orders the stops on the
+// way to work, from 1; driving home visits them in reverse. Coordinates are
+// stored as text, like the route's.
+type Waypoint struct {
+	ID        int    `json:"id,omitempty"`
+	RouteID   int    `json:"route_id"`
+	Position  int    `json:"position"`
+	Address   string `json:"address"`
+	Latitude  string `json:"latitude"`
+	Longitude string `json:"longitude"`
+}
+
+func (w Waypoint) LatLng() (LatLng, error) {
+	return parseLatLng(w.Latitude, w.Longitude)
+}
+
+// Via returns the trip stopping at waypoints, which are in the order they
+// are visited on the way to work.
+func (d DueRoute) Via(waypoints []Waypoint) (DueRoute, error) {
+	stops := make([]LatLng, len(waypoints))
+	for i, waypoint := range waypoints {
+		stop, err := waypoint.LatLng()
+		if err != nil {
+			return DueRoute{}, fmt.Errorf("route %d waypoint %d: %w", d.ID, waypoint.Position, err)
+		}
+		if d.ToWork {
+			stops[i] = stop
+		} else {
+			stops[len(waypoints)-1-i] = stop
+		}
+	}
+	d.Waypoints = stops
+	return d, nil
+}
+
+// Schedule is a row of the route_schedule table. Times are "HH:mm:ss" in the
+// route's time zone.
+type Schedule struct {
+	ID                 int    `json:"id,omitempty"`
+	RouteID            int    `json:"route_id"`
+	MorningStartTime   string `json:"morning_start_time" validate:"required,validTimeFormat"`
+	MorningEndTime     string `json:"morning_end_time" validate:"required,validTimeFormat"`
+	AfternoonStartTime string `json:"afternoon_start_time" validate:"required,validTimeFormat"`
+	AfternoonEndTime   string `json:"afternoon_end_time" validate:"required,validTimeFormat"`
+	Monday             bool   `json:"monday"`
+	Tuesday            bool   `json:"tuesday"`
+	Wednesday          bool   `json:"wednesday"`
+	Thursday           bool   `json:"thursday"`
+	Friday             bool   `json:"friday"`
+	Saturday           bool   `json:"saturday"`
+	Sunday             bool   `json:"sunday"`
+	// MorningArriveBy and AfternoonArriveBy are optional target arrival
+	// times at work and at home, "" when unset.
+	MorningArriveBy   string `json:"morning_arrive_by,omitempty" validate:"omitempty,validTimeFormat"`
+	AfternoonArriveBy string `json:"afternoon_arrive_by,omitempty" validate:"omitempty,validTimeFormat"`
+}
+
+// ArriveBy returns the target arrival time for a direction, or "".
+func (s Schedule) ArriveBy(toWork bool) string {
+	if toWork {
+		return s.MorningArriveBy
+	}
+	return s.AfternoonArriveBy
+}
+
+func (s Schedule) ActiveOn(day time.Weekday) bool {
+	switch day {
+	case time.Sunday:
+		return s.Sunday
+	case time.Monday:
+		return s.Monday
+	case time.Tuesday:
+		return s.Tuesday
+	case time.Wednesday:
+		return s.Wednesday
+	case time.Thursday:
+		return s.Thursday
+	case time.Friday:
+		return s.Friday
+	case time.Saturday:
+		return s.Saturday
+	}
+	return false
+}
+
+// ScheduledRoute is an active route and its schedule.
+type ScheduledRoute struct {
+	Route    Route
+	Schedule Schedule
+}
+
+// DailyCount is the number of scheduled commutes a route recorded in one
+// direction on one date, "2006-01-02" in the route's time zone.
+type DailyCount struct {
+	RouteID int
+	ToWork  bool
+	Date    string
+	Count   int
+}
+
+// Commute is a row of the commutes table. AdjustedQueryTime is filled in by
+// the update_commute_timezone trigger.
+type Commute struct {
+	ID                int        `json:"id,omitempty"`
+	UserID            int        `json:"user_id"`
+	QueryTime         time.Time  `json:"query_time"`
+	Duration          int        `json:"duration"`
+	Distance          int        `json:"distance"`
+	Route             int        `json:"route"`
+	RouteHash         string     `json:"route_hash"`
+	ToWork            bool       `json:"to_work"`
+	DayOfWeek         string     `json:"day_of_week"`
+	AdjustedQueryTime *time.Time `json:"adjusted_query_time,omitempty"`
+	// AdHoc marks a commute checked on demand rather than by the schedule.
+	AdHoc bool `json:"ad_hoc"`
+	// Boosted marks a commute sampled outside the schedule window while the
+	// route was boosted after an anomaly.
+	Boosted bool `json:"boosted"`
+	// AnomalyScore is how far the duration is from the route's usual one
+	// around that time of day, nil until it has been scored.
+	AnomalyScore *float64 `json:"anomaly_score,omitempty"`
+	// PathID is the route_paths row of the path Google gave, nil for commutes
+	// recorded before paths were tracked.
+	PathID *int `json:"path_id,omitempty"`
+	// Legs are the drives between stops in driving order, only for routes
+	// with waypoints.
+	Legs []Leg `json:"legs,omitempty"`
+}
+
+// Scheduled reports whether the commute was taken by the schedule, so it
+// belongs in counts and baselines.
+func (c Commute) Scheduled() bool {
+	return !c.AdHoc && !c.Boosted
+}
+
+// Leg is the drive from one stop of a commute to the next. Duration is in
+// seconds and Distance in meters.
+type Leg struct {
+	Duration int `json:"duration"`
+	Distance int `json:"distance"`
+}
+
+// Boost is a row of the sampling_boosts table: commutesQueue keeps checking
+// the route in this direction until Until, even outside its schedule window.
+type Boost struct {
+	RouteID int       `json:"route_id"`
+	ToWork  bool      `json:"to_work"`
+	Until   time.Time `json:"until"`
+	Reason  string    `json:"reason"`
+}
+
+// RoutePath is a row of the route_paths table: one distinct path Google has
+// given for a route in one direction. Label names it for people, "path 1"
+// being the first seen. Polyline and Distance are from its first commute.
+type RoutePath struct {
+	ID          int       `json:"id"`
+	RouteID     int       `json:"route_id"`
+	ToWork      bool      `json:"to_work"`
+	Fingerprint string    `json:"fingerprint"`
+	Label       string    `json:"label"`
+	Polyline    string    `json:"polyline"`
+	Distance    int       `json:"distance"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// PathStats summarizes the scheduled commutes that took one path. Durations
+// are in seconds and zero when there are no commutes.
+type PathStats struct {
+	RoutePath
+	Commutes        int `json:"commutes"`
+	AverageDuration int `json:"average_duration"`
+	MinDuration     int `json:"min_duration"`
+	MaxDuration     int `json:"max_duration"`
+}
+
+// Subscription is a row of the notification_subscriptions table: one channel
+// a user's leave-now messages go to. Target is an email address for "email"
+// and a URL for the other channels.
+type Subscription struct {
+	ID      int    `json:"id,omitempty"`
+	UserID  int    `json:"user_id"`
+	Channel string `json:"channel"`
+	Target  string `json:"target"`
+	Active  bool   `json:"active"`
+}
+
+// Notification is a row of the notifications table, one per leave-now
+// message sent for a route and direction.
+type Notification struct {
+	ID       int       `json:"id,omitempty"`
+	UserID   int       `json:"user_id"`
+	Route    int       `json:"route"`
+	ToWork   bool      `json:"to_work"`
+	Decision string    `json:"decision"`
+	Message  string    `json:"message"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// DueRoute is a route whose schedule window is open, or whose sampling is
+// boosted, with Origin and Destination already swapped to the direction of
+// travel.
+type DueRoute struct {
+	ID          int
+	UserID      int
+	Origin      LatLng
+	Destination LatLng
+	TimeZone    string
+	ToWork      bool
+	// Waypoints are the stops between Origin and Destination in the order
+	// they are driven.
+	Waypoints []LatLng
+	// WindowStart and WindowEnd are the open schedule window, "HH:MM:SS" in
+	// the route's time zone.
+	WindowStart string
+	WindowEnd   string
+	// BoostedUntil is set instead of the window for a route sampled after an
+	// anomaly.
+	BoostedUntil *time.Time
+}
+
+// OptimizeRouteRequest is the payload commutesQueue sends to optimizeRoute.
+// optimizeRoute loads the coordinates and time zone from the routes row;
+// UserID is optional and, when set, must match the row. RunID is the
+// commutesQueue run that dispatched the request, for tracing logs. Fields are
+// pointers so a missing value can be told apart from a zero value.
+type OptimizeRouteRequest struct {
+	Route  *int   `json:"route"`
+	ToWork *bool  `json:"to_work"`
+	UserID *int   `json:"user_id,omitempty"`
+	RunID  string `json:"run_id,omitempty"`
+}
+
+func NewOptimizeRouteRequest(route DueRoute) OptimizeRouteRequest {
+	return OptimizeRouteRequest{
+		Route:  &route.ID,
+		ToWork: &route.ToWork,
+		UserID: &route.UserID,
+	}
+}
+
+func parseLatLng(latitude, longitude string) (LatLng, error) {
+	lat, err := strconv.ParseFloat(latitude, 64)
+	if err != nil {
+		return LatLng{}, fmt.Errorf("error converting latitude to float: %w", err)
+	}
+	lng, err := strconv.ParseFloat(longitude, 64)
+	if err != nil {
+		return LatLng{}, fmt.Errorf("error converting longitude to float: %w", err)
+	}
+	return LatLng{Latitude: lat, Longitude: lng}, nil
+}
+
+func FormatCoordinate(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// Usage is the provider calls made over some period, and how many of them
+// failed. Units is what they were billed as, such as one per element of a
+// route matrix.
+type Usage struct {
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+	Units    int `json:"units"`
+}
+
+// Budget is a row of the provider_budgets table. A nil limit is unlimited.
+type Budget struct {
+	Provider     string `json:"provider"`
+	DailyLimit   *int   `json:"daily_limit"`
+	MonthlyLimit *int   `json:"monthly_limit"`
+}
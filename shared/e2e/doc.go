@@ -0,0 +1,4 @@
+// Package e2e runs the handlers end to end against a real Postgres and the
+// fake Google server. The tests skip when no Postgres is available; see
+// internal/pgtest.
+package e2e
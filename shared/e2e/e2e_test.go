@@ -0,0 +1,581 @@
+package e2e
+
+import (
+	"context"
+	"database/sql"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google/fakegoogle"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/checkcommute"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/commutesqueue"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/healthcheck"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/optimizeroute"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/plandeparture"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/internal/pgtest"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	pgtest.Stop()
+	os.Exit(code)
+}
+
+type harness struct {
+	db       *sql.DB
+	store    *store.Store
+	fake     *fakegoogle.Server
+	queue    *commutesqueue.Handler
+	optimize *optimizeroute.Handler
+	userID   int
+}
+
+// newHarness wires commutesQueue to dispatch straight into optimizeRoute,
+// which calls the fake Google server and writes to a fresh database.
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	db := pgtest.New(t)
+	s := store.NewPostgres(db)
+	if err := s.CheckSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := fakegoogle.NewDefault()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := google.NewClient("test-key")
+	client.SetBaseURL(server.URL)
+	client.Retry = google.RetryPolicy{MaxAttempts: 1}
+	client.OnAttempt = budget.Recorder(s.Usage, clock.System)
+
+	h := &harness{db: db, store: s, fake: fake}
+	h.optimize = &optimizeroute.Handler{Store: s, Routes: client}
+	h.queue = &commutesqueue.Handler{
+		Store: s,
+		Dispatcher: commutesqueue.DispatcherFunc(func(ctx context.Context, request domain.OptimizeRouteRequest) error {
+			_, err := h.optimize.HandleRequest(ctx, request)
+			return err
+		}),
+	}
+
+	err := db.QueryRow(`INSERT INTO public.users (username, date_joined, name)
+VALUES ('commuter', '2024-01-01', 'Test Commuter') RETURNING id`).Scan(&h.userID)
+	if err != nil {
+		t.Fatalf("seeding user: %v", err)
+	}
+	return h
+}
+
+// addRoute seeds a route between the endpoints of a fakegoogle fixture.
+func (h *harness) addRoute(t *testing.T, fixture string, active bool, schedule domain.Schedule) domain.Route {
+	t.Helper()
+	route, ok := h.fake.Fixtures.Route(fixture)
+	if !ok {
+		t.Fatalf("no route fixture %q", fixture)
+	}
+	created, err := h.store.Routes.Create(context.Background(), domain.Route{
+		UserID:         h.userID,
+		StartAddress:   fixture + " start",
+		EndAddress:     fixture + " end",
+		StartLatitude:  domain.FormatCoordinate(route.Origin.Latitude),
+		StartLongitude: domain.FormatCoordinate(route.Origin.Longitude),
+		EndLatitude:    domain.FormatCoordinate(route.Destination.Latitude),
+		EndLongitude:   domain.FormatCoordinate(route.Destination.Longitude),
+		Active:         active,
+		StartDate:      "2024-01-01",
+		TimeZone:       "America/Denver",
+	})
+	if err != nil {
+		t.Fatalf("seeding route: %v", err)
+	}
+	schedule.RouteID = created.ID
+	if _, err := h.store.Schedules.Create(context.Background(), schedule); err != nil {
+		t.Fatalf("seeding schedule: %v", err)
+	}
+	return created
+}
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+// weekdays is the schedule every seeded route uses: Monday to Friday, to work
+// from 07:00 to 09:00 and home from 16:00 to 18:00 Denver time.
+var weekdays = domain.Schedule{
+	MorningStartTime:   "07:00:00",
+	MorningEndTime:     "09:00:00",
+	AfternoonStartTime: "16:00:00",
+	AfternoonEndTime:   "18:00:00",
+	Monday:             true,
+	Tuesday:            true,
+	Wednesday:          true,
+	Thursday:           true,
+	Friday:             true,
+}
+
+func (h *harness) commutes(t *testing.T) []domain.Commute {
+	t.Helper()
+	rows, err := h.db.Query(`SELECT user_id, route, query_time, duration, distance, route_hash,
+  to_work, day_of_week, adjusted_query_time FROM public.commutes ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var commutes []domain.Commute
+	for rows.Next() {
+		var commute domain.Commute
+		if err := rows.Scan(&commute.UserID, &commute.Route, &commute.QueryTime, &commute.Duration,
+			&commute.Distance, &commute.RouteHash, &commute.ToWork, &commute.DayOfWeek,
+			&commute.AdjustedQueryTime); err != nil {
+			t.Fatal(err)
+		}
+		commutes = append(commutes, commute)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return commutes
+}
+
+func TestCommutesQueueRecordsDueRoutes(t *testing.T) {
+	tests := []struct {
+		name     string
+		asOf     time.Time
+		toWork   bool
+		duration int
+		distance int
+		due      bool
+	}{
+		{"tuesday morning", time.Date(2024, 9, 3, 7, 45, 0, 0, denver), true, 2104, 45872, true},
+		{"tuesday afternoon", time.Date(2024, 9, 3, 17, 15, 0, 0, denver), false, 2388, 46120, true},
+		{"window edge", time.Date(2024, 9, 3, 9, 0, 0, 0, denver), true, 2104, 45872, true},
+		{"tuesday midday", time.Date(2024, 9, 3, 12, 0, 0, 0, denver), false, 0, 0, false},
+		{"saturday morning", time.Date(2024, 9, 7, 7, 45, 0, 0, denver), false, 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			due := h.addRoute(t, "boulder_to_denver", true, weekdays)
+			h.addRoute(t, "boulder_to_denver", false, weekdays)
+
+			// Both handlers run on the same fixed clock, so query_time is
+			// exactly a minute after as_of.
+			h.queue.Clock = clock.Fixed(test.asOf)
+			h.optimize.Clock = clock.Fixed(test.asOf)
+
+			response, err := h.queue.HandleRequest(context.Background(), commutesqueue.Request{})
+			if !test.due {
+				if err == nil {
+					t.Fatalf("expected no due routes, got %+v", response.Data)
+				}
+				if commutes := h.commutes(t); len(commutes) != 0 {
+					t.Errorf("recorded %d commutes, want 0", len(commutes))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("commutesQueue: %v", err)
+			}
+			if response.Data.Processed != 1 || response.Data.Suceeded != 1 || response.Data.Failed != 0 {
+				t.Fatalf("unexpected counts: %+v", response.Data)
+			}
+
+			commutes := h.commutes(t)
+			if len(commutes) != 1 {
+				t.Fatalf("recorded %d commutes, want 1", len(commutes))
+			}
+			commute := commutes[0]
+			if commute.Route != due.ID || commute.UserID != h.userID || commute.ToWork != test.toWork {
+				t.Errorf("unexpected commute: %+v", commute)
+			}
+			if commute.Duration != test.duration || commute.Distance != test.distance {
+				t.Errorf("got %d s and %d m, want %d s and %d m", commute.Duration, commute.Distance, test.duration, test.distance)
+			}
+			queryTime := test.asOf.Add(time.Minute)
+			if !commute.QueryTime.Equal(queryTime) || commute.DayOfWeek != "Tuesday" {
+				t.Errorf("query_time %v on %s, want %v on Tuesday", commute.QueryTime, commute.DayOfWeek, queryTime)
+			}
+
+			// The trigger stores the wall clock time in the route's time zone.
+			local := queryTime.In(denver)
+			want := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, time.UTC)
+			if commute.AdjustedQueryTime == nil || !commute.AdjustedQueryTime.Equal(want) {
+				t.Errorf("adjusted_query_time = %v, want %v", commute.AdjustedQueryTime, want)
+			}
+		})
+	}
+}
+
+func TestCommutesQueueAsOf(t *testing.T) {
+	h := newHarness(t)
+	h.addRoute(t, "boulder_to_denver", true, weekdays)
+	h.queue.Clock = clock.Fixed(time.Date(2024, 9, 7, 12, 0, 0, 0, denver))
+
+	// The handler clock says Saturday, but as_of replays Tuesday morning.
+	asOf := time.Date(2024, 9, 3, 7, 45, 0, 0, denver)
+	response, err := h.queue.HandleRequest(context.Background(), commutesqueue.Request{AsOf: &asOf})
+	if err != nil {
+		t.Fatalf("commutesQueue: %v", err)
+	}
+	if response.Data.Processed != 1 {
+		t.Errorf("processed %d routes, want 1", response.Data.Processed)
+	}
+
+	usage, err := h.store.Usage.Since(context.Background(), google.RoutesProvider, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Requests != 1 || usage.Failures != 0 || usage.Units != 1 {
+		t.Errorf("recorded usage %+v, want 1 request", usage)
+	}
+}
+
+func TestCommutesQueueDryRun(t *testing.T) {
+	h := newHarness(t)
+	route := h.addRoute(t, "boulder_to_denver", true, weekdays)
+	h.queue.Clock = clock.Fixed(time.Date(2024, 9, 3, 17, 15, 0, 0, denver))
+
+	response, err := h.queue.HandleRequest(context.Background(), commutesqueue.Request{DryRun: true})
+	if err != nil {
+		t.Fatalf("commutesQueue: %v", err)
+	}
+	planned := response.Data.Routes
+	if len(planned) != 1 || planned[0].RouteID != route.ID || planned[0].Direction != "from_work" ||
+		planned[0].WindowStart != "16:00:00" || planned[0].WindowEnd != "18:00:00" || !planned[0].Dispatch {
+		t.Errorf("unexpected plan: %+v", planned)
+	}
+	if commutes := h.commutes(t); len(commutes) != 0 {
+		t.Errorf("recorded %d commutes, want 0", len(commutes))
+	}
+	if requests := len(h.fake.RouteRequests()); requests != 0 {
+		t.Errorf("sent %d route requests to Google, want 0", requests)
+	}
+}
+
+func TestCheckCommuteRecordsAdHoc(t *testing.T) {
+	h := newHarness(t)
+	route := h.addRoute(t, "boulder_to_denver", true, weekdays)
+	check := &checkcommute.Handler{Store: h.store, Routes: h.optimize.Routes,
+		Clock: clock.Fixed(time.Date(2024, 9, 7, 9, 0, 0, 0, denver))}
+
+	if _, err := check.HandleRequest(context.Background(), checkcommute.Request{RouteID: &route.ID, Record: true}); err != nil {
+		t.Fatalf("checkCommute: %v", err)
+	}
+	var adHoc, toWork bool
+	var duration int
+	if err := h.db.QueryRow("SELECT ad_hoc, to_work, duration FROM public.commutes WHERE route = $1", route.ID).Scan(&adHoc, &toWork, &duration); err != nil {
+		t.Fatal(err)
+	}
+	if !adHoc || !toWork || duration != 2104 {
+		t.Errorf("got ad_hoc=%v to_work=%v duration=%d, want an ad hoc 2104 s commute to work", adHoc, toWork, duration)
+	}
+}
+
+func TestHealthCheckFindsGaps(t *testing.T) {
+	h := newHarness(t)
+	route := h.addRoute(t, "boulder_to_denver", true, weekdays)
+	h.addRoute(t, "boulder_to_denver", false, weekdays)
+	tuesdayMorning := time.Date(2024, 9, 3, 7, 45, 0, 0, denver)
+	h.queue.Clock = clock.Fixed(tuesdayMorning)
+	h.optimize.Clock = clock.Fixed(tuesdayMorning)
+	if _, err := h.queue.HandleRequest(context.Background(), commutesqueue.Request{}); err != nil {
+		t.Fatalf("commutesQueue: %v", err)
+	}
+
+	// One commute is enough for Tuesday morning, but Monday afternoon has
+	// none.
+	check := &healthcheck.Handler{Store: h.store, Clock: clock.Fixed(time.Date(2024, 9, 3, 12, 0, 0, 0, denver))}
+	days, coverage := 1, 0.005
+	response, err := check.HandleRequest(context.Background(), healthcheck.Request{Days: &days, MinCoverage: &coverage})
+	if err != nil {
+		t.Fatalf("healthCheck: %v", err)
+	}
+	routes := response.Data.Routes
+	if len(routes) != 1 || routes[0].RouteID != route.ID || routes[0].Expected != 242 || routes[0].Recorded != 1 {
+		t.Fatalf("unexpected routes: %+v", routes)
+	}
+	if gaps := routes[0].Gaps; len(gaps) != 1 || gaps[0].Date != "2024-09-02" || gaps[0].Direction != "from_work" {
+		t.Errorf("unexpected gaps: %+v", gaps)
+	}
+}
+
+func TestDailyCountsSkipRoutesWithoutTimeZone(t *testing.T) {
+	h := newHarness(t)
+	var ids []int
+	for range 2 {
+		route := h.addRoute(t, "boulder_to_denver", true, weekdays)
+		_, err := h.store.Commutes.Insert(context.Background(), domain.Commute{
+			UserID: h.userID, QueryTime: time.Date(2024, 9, 2, 14, 30, 0, 0, time.UTC), Duration: 2104, Distance: 45872,
+			Route: route.ID, ToWork: true, DayOfWeek: "Monday",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, route.ID)
+	}
+	if _, err := h.db.Exec("UPDATE public.routes SET time_zone = NULL WHERE id = $1", ids[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := h.store.Commutes.DailyCounts(context.Background(), ids, time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("DailyCounts: %v", err)
+	}
+	if len(counts) != 1 || counts[0].RouteID != ids[0] || counts[0].Date != "2024-09-02" {
+		t.Errorf("counts = %+v, want route %d only", counts, ids[0])
+	}
+
+	check := &healthcheck.Handler{Store: h.store, Clock: clock.Fixed(time.Date(2024, 9, 3, 12, 0, 0, 0, denver))}
+	response, err := check.HandleRequest(context.Background(), healthcheck.Request{})
+	if err != nil {
+		t.Fatalf("healthCheck: %v", err)
+	}
+	if routes := response.Data.Routes; len(routes) != 2 || routes[1].RouteID != ids[1] || routes[1].Problem != "missing time zone" {
+		t.Errorf("unexpected routes: %+v", routes)
+	}
+}
+
+func TestNotificationsRoundTrip(t *testing.T) {
+	h := newHarness(t)
+	route := h.addRoute(t, "boulder_to_denver", true, weekdays)
+	if _, err := h.db.Exec(`INSERT INTO public.notification_subscriptions (user_id, channel, target, active)
+VALUES ($1, 'ntfy', 'https://ntfy.sh/commute', true), ($1, 'email', 'old@example.com', false)`, h.userID); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	subscriptions, err := h.store.Notifications.Subscriptions(ctx, h.userID)
+	if err != nil || len(subscriptions) != 1 || subscriptions[0].Channel != "ntfy" {
+		t.Fatalf("subscriptions = %+v, %v", subscriptions, err)
+	}
+
+	sentAt := time.Date(2024, 9, 5, 7, 45, 0, 0, denver)
+	for _, decision := range []string{"wait", "leave_now"} {
+		_, err := h.store.Notifications.Insert(ctx, domain.Notification{
+			UserID: h.userID, Route: route.ID, ToWork: true, Decision: decision, Message: decision, SentAt: sentAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sentAt = sentAt.Add(15 * time.Minute)
+	}
+	last, err := h.store.Notifications.Last(ctx, route.ID, true, time.Date(2024, 9, 5, 7, 0, 0, 0, denver))
+	if err != nil || last == nil || last.Decision != "leave_now" {
+		t.Errorf("last = %+v, %v", last, err)
+	}
+	if last, err := h.store.Notifications.Last(ctx, route.ID, false, time.Time{}); err != nil || last != nil {
+		t.Errorf("last from_work = %+v, %v", last, err)
+	}
+}
+
+func TestPlanDepartureReadsArriveBy(t *testing.T) {
+	h := newHarness(t)
+	schedule := weekdays
+	schedule.MorningArriveBy = "09:00:00"
+	route := h.addRoute(t, "boulder_to_denver", true, schedule)
+
+	stored, err := h.store.Schedules.GetByRoute(context.Background(), route.ID)
+	if err != nil || stored.MorningArriveBy != "09:00:00" || stored.AfternoonArriveBy != "" {
+		t.Fatalf("schedule = %+v, %v", stored, err)
+	}
+	plan := &plandeparture.Handler{Store: h.store, Routes: h.optimize.Routes,
+		Clock: clock.Fixed(time.Date(2024, 9, 5, 6, 0, 0, 0, denver))}
+	response, err := plan.HandleRequest(context.Background(), plandeparture.Request{RouteID: &route.ID})
+	if err != nil {
+		t.Fatalf("planDeparture: %v", err)
+	}
+	if want := time.Date(2024, 9, 5, 8, 24, 0, 0, denver); !response.Data.Departure.Equal(want) {
+		t.Errorf("departure = %v, want %v", response.Data.Departure, want)
+	}
+}
+
+func TestBoostsAndAnomalyScores(t *testing.T) {
+	h := newHarness(t)
+	route := h.addRoute(t, "boulder_to_denver", true, weekdays)
+	ctx := context.Background()
+	now := time.Date(2024, 9, 3, 8, 0, 0, 0, denver)
+
+	started, err := h.store.Boosts.Extend(ctx, domain.Boost{RouteID: route.ID, ToWork: true, Until: now.Add(time.Hour), Reason: "test"}, now)
+	if err != nil || !started {
+		t.Fatalf("first boost started=%v, %v", started, err)
+	}
+	// A shorter boost keeps the later end and does not start a new incident.
+	started, err = h.store.Boosts.Extend(ctx, domain.Boost{RouteID: route.ID, ToWork: true, Until: now.Add(30 * time.Minute), Reason: "test"}, now.Add(time.Minute))
+	if err != nil || started {
+		t.Fatalf("second boost started=%v, %v", started, err)
+	}
+	boosted, err := h.store.Boosts.Active(ctx, now.Add(45*time.Minute))
+	if err != nil || len(boosted) != 1 || !boosted[0].BoostedUntil.Equal(now.Add(time.Hour)) {
+		t.Errorf("boosted = %+v, %v", boosted, err)
+	}
+	if started, err := h.store.Boosts.Extend(ctx, domain.Boost{RouteID: route.ID, ToWork: true, Until: now.Add(3 * time.Hour), Reason: "test"}, now.Add(2*time.Hour)); err != nil || !started {
+		t.Errorf("boost after lapse started=%v, %v", started, err)
+	}
+
+	commute, err := h.store.Commutes.Insert(ctx, domain.Commute{UserID: h.userID, Route: route.ID, ToWork: true, QueryTime: now, Duration: 2104})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.store.Commutes.SetAnomalyScore(ctx, commute.ID, 4.2); err != nil {
+		t.Fatal(err)
+	}
+	recent, err := h.store.Commutes.Recent(ctx, route.ID, true, now.Add(-time.Hour))
+	if err != nil || len(recent) != 1 || recent[0].AnomalyScore == nil || *recent[0].AnomalyScore != 4.2 {
+		t.Errorf("recent = %+v, %v", recent, err)
+	}
+}
+
+func TestRoutePathsRoundTrip(t *testing.T) {
+	h := newHarness(t)
+	route := h.addRoute(t, "boulder_to_denver", true, weekdays)
+	ctx := context.Background()
+	now := time.Date(2024, 9, 3, 8, 0, 0, 0, denver)
+	record := func(fingerprint string, at time.Time) (domain.RoutePath, bool) {
+		t.Helper()
+		path, added, err := h.store.Paths.Record(ctx, domain.RoutePath{
+			RouteID: route.ID, ToWork: true, Fingerprint: fingerprint, Polyline: "ulfsF|soaS~zt@qyv@", Distance: 45872, FirstSeen: at, LastSeen: at,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path, added
+	}
+
+	usual, added := record("aaaa", now)
+	if !added || usual.Label != "path 1" {
+		t.Errorf("first path added=%v: %+v", added, usual)
+	}
+	// Seeing it again earlier and later widens the row instead of adding one.
+	record("aaaa", now.Add(-time.Hour))
+	again, added := record("aaaa", now.Add(time.Hour))
+	if added || again.ID != usual.ID || !again.FirstSeen.Equal(now.Add(-time.Hour)) || !again.LastSeen.Equal(now.Add(time.Hour)) {
+		t.Errorf("repeat path added=%v: %+v", added, again)
+	}
+	diverted, added := record("bbbb", now)
+	if !added || diverted.Label != "path 2" {
+		t.Errorf("second path added=%v: %+v", added, diverted)
+	}
+
+	if _, err := h.store.Commutes.Insert(ctx, domain.Commute{UserID: h.userID, Route: route.ID, ToWork: true, QueryTime: now, Duration: 1800, PathID: &usual.ID}); err != nil {
+		t.Fatal(err)
+	}
+	later, err := h.store.Commutes.Insert(ctx, domain.Commute{UserID: h.userID, Route: route.ID, ToWork: true, QueryTime: now.Add(time.Minute), Duration: 2400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.store.Commutes.SetPath(ctx, later.ID, diverted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := h.store.Paths.Last(ctx, route.ID, true)
+	if err != nil || last == nil || last.ID != diverted.ID {
+		t.Errorf("last path = %+v, %v", last, err)
+	}
+	if last, err := h.store.Paths.Last(ctx, route.ID, false); err != nil || last != nil {
+		t.Errorf("last path from work = %+v, %v", last, err)
+	}
+	recent, err := h.store.Commutes.Recent(ctx, route.ID, true, now.Add(-time.Hour))
+	if err != nil || len(recent) != 2 || recent[1].PathID == nil || *recent[1].PathID != diverted.ID {
+		t.Errorf("recent = %+v, %v", recent, err)
+	}
+	stats, err := h.store.Paths.Stats(ctx, route.ID, true, now.Add(-time.Hour))
+	if err != nil || len(stats) != 2 {
+		t.Fatalf("stats = %+v, %v", stats, err)
+	}
+	if stats[0].ID != usual.ID || stats[0].Commutes != 1 || stats[0].AverageDuration != 1800 || stats[1].MaxDuration != 2400 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestWaypointLegsRoundTrip(t *testing.T) {
+	h := newHarness(t)
+	route := h.addRoute(t, "boulder_to_denver", true, weekdays)
+	ctx := context.Background()
+	created, err := h.store.Waypoints.Create(ctx, route.ID, []domain.Waypoint{
+		{Address: "Daycare", Latitude: "39.90083", Longitude: "-105.03561"},
+		{Address: "Gym", Latitude: "39.7508", Longitude: "-105"},
+	})
+	if err != nil || len(created) != 2 || created[0].Position != 1 || created[1].Address != "Gym" || created[1].Position != 2 {
+		t.Fatalf("created waypoints %+v, %v", created, err)
+	}
+	if listed, err := h.store.Waypoints.List(ctx, route.ID); err != nil || len(listed) != 2 || listed[0].ID != created[0].ID {
+		t.Errorf("listed waypoints %+v, %v", listed, err)
+	}
+
+	toWork := true
+	if _, err := h.optimize.HandleRequest(ctx, domain.OptimizeRouteRequest{Route: &route.ID, ToWork: &toWork}); err != nil {
+		t.Fatalf("optimizeRoute: %v", err)
+	}
+	recent, err := h.store.Commutes.Recent(ctx, route.ID, true, time.Time{})
+	if err != nil || len(recent) != 1 || len(recent[0].Legs) != 3 {
+		t.Fatalf("recent = %+v, %v", recent, err)
+	}
+	total := 0
+	for _, leg := range recent[0].Legs {
+		total += leg.Duration
+	}
+	if total != recent[0].Duration {
+		t.Errorf("legs %+v do not add up to %d s", recent[0].Legs, recent[0].Duration)
+	}
+}
+
+func TestCreateRouteRollsBack(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	route := domain.Route{
+		UserID: h.userID, StartAddress: "home", EndAddress: "work", StartLatitude: "40.01499", StartLongitude: "-105.27055",
+		EndLatitude: "39.73915", EndLongitude: "-104.9847", Active: true, StartDate: "2024-01-01", TimeZone: "America/Denver",
+	}
+	waypoints := []domain.Waypoint{{Address: "Daycare", Latitude: "39.90083", Longitude: "-105.03561"}}
+
+	broken := weekdays
+	broken.MorningStartTime = "25:00:00"
+	if _, _, _, err := h.store.CreateRoute(ctx, route, waypoints, broken); err == nil {
+		t.Fatal("saved a schedule with an invalid time")
+	}
+	var routes int
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM public.routes").Scan(&routes); err != nil || routes != 0 {
+		t.Errorf("left %d routes behind: %v", routes, err)
+	}
+
+	created, stops, schedule, err := h.store.CreateRoute(ctx, route, waypoints, weekdays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stops) != 1 || stops[0].RouteID != created.ID || schedule.RouteID != created.ID {
+		t.Errorf("created %+v with %+v and %+v", created, stops, schedule)
+	}
+}
+
+func TestRouteTimeZoneChangeUpdatesCommutes(t *testing.T) {
+	h := newHarness(t)
+	route := h.addRoute(t, "boulder_to_denver", true, weekdays)
+
+	queryTime := time.Date(2024, 9, 2, 14, 30, 0, 0, time.UTC)
+	_, err := h.store.Commutes.Insert(context.Background(), domain.Commute{
+		UserID:    h.userID,
+		QueryTime: queryTime,
+		Duration:  2104,
+		Distance:  45872,
+		Route:     route.ID,
+		ToWork:    true,
+		DayOfWeek: "Monday",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.db.Exec("UPDATE public.routes SET time_zone = 'America/New_York' WHERE id = $1", route.ID); err != nil {
+		t.Fatal(err)
+	}
+	var adjusted time.Time
+	if err := h.db.QueryRow("SELECT adjusted_query_time FROM public.commutes WHERE route = $1", route.ID).Scan(&adjusted); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 9, 2, 10, 30, 0, 0, time.UTC); !adjusted.Equal(want) {
+		t.Errorf("adjusted_query_time = %v, want %v", adjusted, want)
+	}
+}
@@ -0,0 +1,244 @@
+// Package geoexport writes recorded commutes as GeoJSON, GPX or KML so the
+// path each one took can be opened in a map.
+package geoexport
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/report"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats lists the formats Render accepts.
+var Formats = []string{"geojson", "gpx", "kml"}
+
+// Track is one recorded commute along its decoded polyline. Start is the
+// departure in the route's time zone and Duration is in seconds.
+type Track struct {
+	CommuteID int
+	RouteID   int
+	ToWork    bool
+	Path      string
+	Start     time.Time
+	Duration  int
+	Distance  int
+	Points    []domain.LatLng
+}
+
+func (t Track) End() time.Time {
+	return t.Start.Add(time.Duration(t.Duration) * time.Second)
+}
+
+func (t Track) Direction() string {
+	if t.ToWork {
+		return "to_work"
+	}
+	return "from_work"
+}
+
+// Name is e.g. "Route 7 to work, Mon 2 Sep 07:45".
+func (t Track) Name() string {
+	direction := "to work"
+	if !t.ToWork {
+		direction = "from work"
+	}
+	return fmt.Sprintf("Route %d %s, %s", t.RouteID, direction, t.Start.Format("Mon 2 Jan 15:04"))
+}
+
+// Description is e.g. "35 min, 45.9 km on path 2".
+func (t Track) Description() string {
+	description := fmt.Sprintf("%s, %.1f km", report.Minutes(t.Duration), float64(t.Distance)/1000)
+	if t.Path != "" {
+		description += " on " + t.Path
+	}
+	return description
+}
+
+// Render renders tracks as "geojson", "gpx" or "kml". name titles the
+// document where the format has one.
+func Render(name string, tracks []Track, format string) (string, error) {
+	switch format {
+	case "geojson":
+		return GeoJSON(tracks)
+	case "gpx":
+		return GPX(name, tracks)
+	case "kml":
+		return KML(name, tracks)
+	}
+	return "", fmt.Errorf("unknown export format %q", format)
+}
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string     `json:"type"`
+	Geometry   lineString `json:"geometry"`
+	Properties properties `json:"properties"`
+}
+
+type lineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+type properties struct {
+	CommuteID int    `json:"commute_id"`
+	RouteID   int    `json:"route_id"`
+	Direction string `json:"direction"`
+	Path      string `json:"path,omitempty"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	DayOfWeek string `json:"day_of_week"`
+	LocalTime string `json:"local_time"`
+	Duration  int    `json:"duration"`
+	Distance  int    `json:"distance"`
+}
+
+// GeoJSON renders tracks as a FeatureCollection of LineStrings.
+func GeoJSON(tracks []Track) (string, error) {
+	collection := featureCollection{Type: "FeatureCollection", Features: []feature{}}
+	for _, track := range tracks {
+		coordinates := make([][2]float64, len(track.Points))
+		for i, point := range track.Points {
+			coordinates[i] = [2]float64{point.Longitude, point.Latitude}
+		}
+		collection.Features = append(collection.Features, feature{
+			Type:     "Feature",
+			Geometry: lineString{Type: "LineString", Coordinates: coordinates},
+			Properties: properties{
+				CommuteID: track.CommuteID,
+				RouteID:   track.RouteID,
+				Direction: track.Direction(),
+				Path:      track.Path,
+				Start:     track.Start.Format(time.RFC3339),
+				End:       track.End().Format(time.RFC3339),
+				DayOfWeek: track.Start.Weekday().String(),
+				LocalTime: track.Start.Format("15:04"),
+				Duration:  track.Duration,
+				Distance:  track.Distance,
+			},
+		})
+	}
+	body, err := json.MarshalIndent(collection, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error encoding geojson: %v", err)
+	}
+	return string(body), nil
+}
+
+type gpx struct {
+	XMLName xml.Name   `xml:"gpx"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Version string     `xml:"version,attr"`
+	Creator string     `xml:"creator,attr"`
+	Name    string     `xml:"metadata>name"`
+	Tracks  []gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name   string     `xml:"name"`
+	Desc   string     `xml:"desc"`
+	Number int        `xml:"number"`
+	Type   string     `xml:"type"`
+	Points []gpxPoint `xml:"trkseg>trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time,omitempty"`
+}
+
+// GPX renders each track as a GPX 1.1 trk numbered by its commute ID. Only
+// the first and last points have a time, the departure and the arrival, as
+// the polyline does not say when the points in between were passed.
+func GPX(name string, tracks []Track) (string, error) {
+	document := gpx{Xmlns: "http://www.topografix.com/GPX/1/1", Version: "1.1", Creator: "OptimizeRouteApp", Name: name}
+	for _, track := range tracks {
+		points := make([]gpxPoint, len(track.Points))
+		for i, point := range track.Points {
+			points[i] = gpxPoint{Lat: point.Latitude, Lon: point.Longitude}
+		}
+		if len(points) > 0 {
+			points[0].Time = track.Start.UTC().Format(time.RFC3339)
+			points[len(points)-1].Time = track.End().UTC().Format(time.RFC3339)
+		}
+		document.Tracks = append(document.Tracks, gpxTrack{
+			Name:   track.Name(),
+			Desc:   track.Description(),
+			Number: track.CommuteID,
+			Type:   track.Direction(),
+			Points: points,
+		})
+	}
+	return encodeXML(document)
+}
+
+type kml struct {
+	XMLName    xml.Name       `xml:"kml"`
+	Xmlns      string         `xml:"xmlns,attr"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name        string    `xml:"name"`
+	Description string    `xml:"description"`
+	Begin       string    `xml:"TimeSpan>begin"`
+	End         string    `xml:"TimeSpan>end"`
+	Data        []kmlData `xml:"ExtendedData>Data"`
+	Tessellate  int       `xml:"LineString>tessellate"`
+	Coordinates string    `xml:"LineString>coordinates"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+// KML renders each track as a Placemark with a LineString, a TimeSpan from
+// departure to arrival and the commute's attributes as ExtendedData.
+func KML(name string, tracks []Track) (string, error) {
+	document := kml{Xmlns: "http://www.opengis.net/kml/2.2", Name: name}
+	for _, track := range tracks {
+		coordinates := make([]string, len(track.Points))
+		for i, point := range track.Points {
+			coordinates[i] = strconv.FormatFloat(point.Longitude, 'f', -1, 64) + "," + strconv.FormatFloat(point.Latitude, 'f', -1, 64)
+		}
+		data := []kmlData{
+			{"commute_id", strconv.Itoa(track.CommuteID)},
+			{"route_id", strconv.Itoa(track.RouteID)},
+			{"direction", track.Direction()},
+			{"duration", strconv.Itoa(track.Duration)},
+			{"distance", strconv.Itoa(track.Distance)},
+		}
+		if track.Path != "" {
+			data = append(data, kmlData{"path", track.Path})
+		}
+		document.Placemarks = append(document.Placemarks, kmlPlacemark{
+			Name:        track.Name(),
+			Description: track.Description(),
+			Begin:       track.Start.Format(time.RFC3339),
+			End:         track.End().Format(time.RFC3339),
+			Data:        data,
+			Tessellate:  1,
+			Coordinates: strings.Join(coordinates, " "),
+		})
+	}
+	return encodeXML(document)
+}
+
+func encodeXML(document any) (string, error) {
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error encoding xml: %v", err)
+	}
+	return xml.Header + string(body) + "\n", nil
+}
@@ -0,0 +1,122 @@
+package geoexport
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"strings"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+var tracks = []Track{
+	{
+		CommuteID: 41,
+		RouteID:   7,
+		ToWork:    true,
+		Path:      "path 2",
+		Start:     time.Date(2024, 9, 2, 7, 45, 0, 0, denver),
+		Duration:  2104,
+		Distance:  45872,
+		Points:    []domain.LatLng{{Latitude: 40.01499, Longitude: -105.27055}, {Latitude: 39.9, Longitude: -105.1}, {Latitude: 39.73915, Longitude: -104.9847}},
+	},
+	{
+		CommuteID: 42,
+		RouteID:   7,
+		Start:     time.Date(2024, 9, 2, 17, 0, 0, 0, denver),
+		Duration:  1800,
+		Distance:  45000,
+		Points:    []domain.LatLng{{Latitude: 39.73915, Longitude: -104.9847}, {Latitude: 40.01499, Longitude: -105.27055}},
+	},
+}
+
+func TestGeoJSON(t *testing.T) {
+	content, err := Render("Route 7", tracks, "geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var collection struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates [][2]float64
+			}
+			Properties map[string]any
+		}
+	}
+	if err := json.Unmarshal([]byte(content), &collection); err != nil {
+		t.Fatal(err)
+	}
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 2 {
+		t.Fatalf("unexpected collection: %s", content)
+	}
+	first := collection.Features[0]
+	if first.Geometry.Type != "LineString" || len(first.Geometry.Coordinates) != 3 || first.Geometry.Coordinates[0] != [2]float64{-105.27055, 40.01499} {
+		t.Errorf("unexpected geometry: %+v", first.Geometry)
+	}
+	want := map[string]any{
+		"commute_id": 41.0, "route_id": 7.0, "direction": "to_work", "path": "path 2", "start": "2024-09-02T07:45:00-06:00",
+		"end": "2024-09-02T08:20:04-06:00", "day_of_week": "Monday", "local_time": "07:45", "duration": 2104.0, "distance": 45872.0,
+	}
+	for key, value := range want {
+		if first.Properties[key] != value {
+			t.Errorf("property %s = %v, want %v", key, first.Properties[key], value)
+		}
+	}
+	if _, ok := collection.Features[1].Properties["path"]; ok {
+		t.Error("a commute without a path has a path property")
+	}
+}
+
+func TestGPX(t *testing.T) {
+	content, err := Render("Route 7", tracks, "gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document gpx
+	if err := xml.Unmarshal([]byte(content), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.Name != "Route 7" || len(document.Tracks) != 2 {
+		t.Fatalf("unexpected gpx:\n%s", content)
+	}
+	track := document.Tracks[0]
+	if track.Name != "Route 7 to work, Mon 2 Sep 07:45" || track.Desc != "35 min, 45.9 km on path 2" || track.Number != 41 || track.Type != "to_work" {
+		t.Errorf("unexpected track: %+v", track)
+	}
+	times := [3]string{track.Points[0].Time, track.Points[1].Time, track.Points[2].Time}
+	if times != [3]string{"2024-09-02T13:45:00Z", "", "2024-09-02T14:20:04Z"} {
+		t.Errorf("point times = %v", times)
+	}
+}
+
+func TestKML(t *testing.T) {
+	content, err := Render("Route 7 & 8", tracks, "kml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content, "<name>Route 7 &amp; 8</name>") {
+		t.Errorf("name was not escaped:\n%s", content)
+	}
+	var document kml
+	if err := xml.Unmarshal([]byte(content), &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Placemarks) != 2 {
+		t.Fatalf("unexpected kml:\n%s", content)
+	}
+	placemark := document.Placemarks[1]
+	if placemark.Name != "Route 7 from work, Mon 2 Sep 17:00" || placemark.Begin != "2024-09-02T17:00:00-06:00" || placemark.End != "2024-09-02T17:30:00-06:00" {
+		t.Errorf("unexpected placemark: %+v", placemark)
+	}
+	if placemark.Coordinates != "-104.9847,39.73915 -105.27055,40.01499" || len(placemark.Data) != 5 || placemark.Data[3] != (kmlData{"duration", "1800"}) {
+		t.Errorf("unexpected placemark: %+v", placemark)
+	}
+
+	if _, err := Render("Route 7", tracks, "shp"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,119 @@
+package google
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Error classes. Every error returned by Client wraps exactly one of them,
+// so callers can branch with errors.Is.
+var (
+	ErrQuota          = errors.New("quota exceeded")
+	ErrAuth           = errors.New("authentication failed")
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrTransient      = errors.New("transient error")
+)
+
+// APIError is a failed response from a Google API.
+type APIError struct {
+	Class      error
+	StatusCode int
+	Status     string
+	Message    string
+
+	retryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%v: status %d %s", e.Class, e.StatusCode, e.Status)
+	}
+	return fmt.Sprintf("%v: status %d %s: %s", e.Class, e.StatusCode, e.Status, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Class
+}
+
+// Class returns the class err belongs to, or nil if it is not a Google API
+// error.
+func Class(err error) error {
+	for _, class := range []error{ErrQuota, ErrAuth, ErrInvalidRequest, ErrTransient} {
+		if errors.Is(err, class) {
+			return class
+		}
+	}
+	return nil
+}
+
+// ClassName is a short label for err's class, used in logs and responses.
+func ClassName(err error) string {
+	switch Class(err) {
+	case ErrQuota:
+		return "quota"
+	case ErrAuth:
+		return "auth"
+	case ErrInvalidRequest:
+		return "invalid_request"
+	case ErrTransient:
+		return "transient"
+	}
+	return "unknown"
+}
+
+type errorBody struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// classifyResponse turns a non-2xx response into an APIError. The body is
+// the standard Google {"error": {...}} envelope when present.
+func classifyResponse(statusCode int, body []byte) *APIError {
+	var parsed errorBody
+	json.Unmarshal(body, &parsed)
+	apiErr := &APIError{
+		StatusCode: statusCode,
+		Status:     parsed.Error.Status,
+		Message:    parsed.Error.Message,
+	}
+	if apiErr.Status == "" {
+		apiErr.Status = http.StatusText(statusCode)
+	}
+
+	switch {
+	case parsed.Error.Status == "RESOURCE_EXHAUSTED" || statusCode == http.StatusTooManyRequests:
+		apiErr.Class = ErrQuota
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		apiErr.Class = ErrAuth
+	case statusCode == http.StatusRequestTimeout || statusCode >= 500:
+		apiErr.Class = ErrTransient
+	case statusCode >= 400:
+		apiErr.Class = ErrInvalidRequest
+	default:
+		apiErr.Class = ErrTransient
+	}
+	return apiErr
+}
+
+// classifyGeocodeStatus maps the status field of a Geocoding API response,
+// which reports failures with a 200 status code.
+func classifyGeocodeStatus(status, message string) *APIError {
+	apiErr := &APIError{StatusCode: http.StatusOK, Status: status, Message: message}
+	switch status {
+	case "OVER_QUERY_LIMIT", "OVER_DAILY_LIMIT":
+		apiErr.Class = ErrQuota
+	case "REQUEST_DENIED":
+		apiErr.Class = ErrAuth
+	case "INVALID_REQUEST":
+		apiErr.Class = ErrInvalidRequest
+	default:
+		apiErr.Class = ErrTransient
+	}
+	return apiErr
+}
@@ -0,0 +1,315 @@
+// Package fakegoogle is a stand-in for the Google Routes and Geocoding APIs.
+// It answers from fixture files so tests and local runs need no API key.
+package fakegoogle
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/polyline"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"sync"
+)
+
+//go:embed fixtures/*.json
+var defaultFixtures embed.FS
+
+// Response is one canned HTTP response.
+type Response struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// RouteFixture answers computeRoutes calls between Origin and Destination.
+// Responses are served in order and the last one repeats, so a transient
+// error followed by a success exercises retries.
+type RouteFixture struct {
+	Name        string        `json:"name"`
+	Origin      domain.LatLng `json:"origin"`
+	Destination domain.LatLng `json:"destination"`
+	Responses   []Response    `json:"responses"`
+}
+
+type Fixtures struct {
+	Routes []RouteFixture `json:"routes"`
+	// Geocode is keyed by the exact address string.
+	Geocode map[string][]Response `json:"geocode"`
+}
+
+// Route returns the route fixture with the given name.
+func (f Fixtures) Route(name string) (RouteFixture, bool) {
+	for _, route := range f.Routes {
+		if route.Name == name {
+			return route, true
+		}
+	}
+	return RouteFixture{}, false
+}
+
+// LoadFixtures reads routes.json and geocode.json from fsys. Either file may
+// be missing.
+func LoadFixtures(fsys fs.FS) (Fixtures, error) {
+	var fixtures Fixtures
+	for _, name := range []string{"routes.json", "geocode.json"} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return Fixtures{}, fmt.Errorf("failed to read fixture %s: %w", name, err)
+		}
+		if err := json.Unmarshal(data, &fixtures); err != nil {
+			return Fixtures{}, fmt.Errorf("failed to parse fixture %s: %w", name, err)
+		}
+	}
+	return fixtures, nil
+}
+
+// DefaultFixtures returns the fixtures shipped in this package.
+func DefaultFixtures() Fixtures {
+	sub, err := fs.Sub(defaultFixtures, "fixtures")
+	if err != nil {
+		panic(err)
+	}
+	fixtures, err := LoadFixtures(sub)
+	if err != nil {
+		panic(err)
+	}
+	return fixtures
+}
+
+// Server is an http.Handler serving both APIs at Google's paths. Point a
+// client at it with google.Client.SetBaseURL.
+type Server struct {
+	Fixtures Fixtures
+	// APIKey, if set, must match the key sent by the client.
+	APIKey string
+
+	mu             sync.Mutex
+	served         map[string]int
+	routeRequests  []google.RouteRequest
+	matrixRequests []google.RouteMatrixRequest
+	geocodeCalls   int
+}
+
+func New(fixtures Fixtures) *Server {
+	return &Server{Fixtures: fixtures, served: map[string]int{}}
+}
+
+// NewDefault returns a Server using DefaultFixtures.
+func NewDefault() *Server {
+	return New(DefaultFixtures())
+}
+
+// RouteRequests returns every computeRoutes request received, in order.
+func (s *Server) RouteRequests() []google.RouteRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]google.RouteRequest(nil), s.routeRequests...)
+}
+
+// MatrixRequests returns every computeRouteMatrix request received, in
+// order.
+func (s *Server) MatrixRequests() []google.RouteMatrixRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]google.RouteMatrixRequest(nil), s.matrixRequests...)
+}
+
+func (s *Server) GeocodeCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.geocodeCalls
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case google.RoutesPath:
+		s.computeRoutes(w, r)
+	case google.MatrixPath:
+		s.computeRouteMatrix(w, r)
+	case google.GeocodePath:
+		s.geocode(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// decodePost reads a Routes API request into request, writing the error
+// response and returning false when it is not acceptable.
+func (s *Server) decodePost(w http.ResponseWriter, r *http.Request, method string, request any) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "INVALID_ARGUMENT", method+" requires POST")
+		return false
+	}
+	if s.APIKey != "" && r.Header.Get("X-Goog-Api-Key") != s.APIKey {
+		writeError(w, http.StatusForbidden, "PERMISSION_DENIED", "The provided API key is invalid.")
+		return false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return false
+	}
+	if err := json.Unmarshal(body, request); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) computeRoutes(w http.ResponseWriter, r *http.Request) {
+	var request google.RouteRequest
+	if !s.decodePost(w, r, "computeRoutes", &request) {
+		return
+	}
+
+	s.mu.Lock()
+	s.routeRequests = append(s.routeRequests, request)
+	s.mu.Unlock()
+
+	origin := request.Origin.Location.LatLng
+	destination := request.Destination.Location.LatLng
+	for _, fixture := range s.Fixtures.Routes {
+		if fixture.Origin == origin && fixture.Destination == destination && len(request.Intermediates) == 0 {
+			s.serve(w, "route:"+fixture.Name, fixture.Responses)
+			return
+		}
+	}
+
+	// Unknown trips get straight lines between the stops driven at 50 km/h,
+	// so any coordinates produce a stable answer.
+	points := []domain.LatLng{origin}
+	for _, stop := range request.Intermediates {
+		points = append(points, stop.Location.LatLng)
+	}
+	points = append(points, destination)
+	route := google.Route{Polyline: google.Polyline{EncodedPolyline: EncodePolyline(points)}}
+	seconds := 0
+	for i := 1; i < len(points); i++ {
+		meters, legSeconds := straightLine(points[i-1], points[i])
+		route.Legs = append(route.Legs, google.Leg{DistanceMeters: meters, Duration: fmt.Sprintf("%ds", legSeconds)})
+		route.DistanceMeters += meters
+		seconds += legSeconds
+	}
+	route.Duration = fmt.Sprintf("%ds", seconds)
+	writeJSON(w, http.StatusOK, google.RoutesResponse{Routes: []google.Route{route}})
+}
+
+// computeRouteMatrix answers every pair with a straight line at 50 km/h.
+func (s *Server) computeRouteMatrix(w http.ResponseWriter, r *http.Request) {
+	var request google.RouteMatrixRequest
+	if !s.decodePost(w, r, "computeRouteMatrix", &request) {
+		return
+	}
+	if elements := len(request.Origins) * len(request.Destinations); elements > google.MaxMatrixElements {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", fmt.Sprintf("Too many elements: %d", elements))
+		return
+	}
+
+	s.mu.Lock()
+	s.matrixRequests = append(s.matrixRequests, request)
+	s.mu.Unlock()
+
+	elements := []google.RouteMatrixElement{}
+	for i, origin := range request.Origins {
+		for j, destination := range request.Destinations {
+			meters, seconds := straightLine(origin.Waypoint.Location.LatLng, destination.Waypoint.Location.LatLng)
+			elements = append(elements, google.RouteMatrixElement{
+				OriginIndex:      i,
+				DestinationIndex: j,
+				DistanceMeters:   meters,
+				Duration:         fmt.Sprintf("%ds", seconds),
+				Condition:        "ROUTE_EXISTS",
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, elements)
+}
+
+// straightLine is the fake drive between two points in meters and seconds.
+func straightLine(a, b domain.LatLng) (int, int) {
+	meters := haversine(a, b)
+	return int(math.Round(meters)), int(math.Round(meters / (50 / 3.6)))
+}
+
+func (s *Server) geocode(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.geocodeCalls++
+	s.mu.Unlock()
+
+	query := r.URL.Query()
+	if s.APIKey != "" && query.Get("key") != s.APIKey {
+		writeJSON(w, http.StatusOK, google.GeocodeResponse{Status: "REQUEST_DENIED", ErrorMessage: "The provided API key is invalid."})
+		return
+	}
+	address := query.Get("address")
+	if address == "" {
+		writeJSON(w, http.StatusOK, google.GeocodeResponse{Status: "INVALID_REQUEST", ErrorMessage: "Invalid request. Missing the 'address' parameter."})
+		return
+	}
+	responses, ok := s.Fixtures.Geocode[address]
+	if !ok {
+		writeJSON(w, http.StatusOK, google.GeocodeResponse{Status: "ZERO_RESULTS", Results: []google.GeocodeResult{}})
+		return
+	}
+	s.serve(w, "geocode:"+address, responses)
+}
+
+// serve writes the next response in a fixture's sequence.
+func (s *Server) serve(w http.ResponseWriter, key string, responses []Response) {
+	if len(responses) == 0 {
+		writeError(w, http.StatusInternalServerError, "INTERNAL", "fixture "+key+" has no responses")
+		return
+	}
+	s.mu.Lock()
+	index := min(s.served[key], len(responses)-1)
+	s.served[key]++
+	s.mu.Unlock()
+
+	response := responses[index]
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	status := response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(response.Body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{"code": status, "message": message, "status": code},
+	})
+}
+
+func haversine(a, b domain.LatLng) float64 {
+	const earthRadius = 6371000.0
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// EncodePolyline encodes points with Google's polyline algorithm at 1e5
+// precision.
+func EncodePolyline(points []domain.LatLng) string {
+	return polyline.Encode(points)
+}
@@ -0,0 +1,30 @@
+package fakegoogle
+
+import (
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"testing"
+)
+
+func TestEncodePolyline(t *testing.T) {
+	// The example from Google's polyline algorithm documentation.
+	points := []domain.LatLng{
+		{Latitude: 38.5, Longitude: -120.2},
+		{Latitude: 40.7, Longitude: -120.95},
+		{Latitude: 43.252, Longitude: -126.453},
+	}
+	if got, want := EncodePolyline(points), "_p~iF~ps|U_ulLnnqC_mqNvxq`@"; got != want {
+		t.Errorf("EncodePolyline = %q, want %q", got, want)
+	}
+}
+
+func TestDefaultFixtures(t *testing.T) {
+	fixtures := DefaultFixtures()
+	for _, name := range []string{"boulder_to_denver", "no_route", "quota_exceeded", "flaky"} {
+		if _, ok := fixtures.Route(name); !ok {
+			t.Errorf("missing route fixture %q", name)
+		}
+	}
+	if _, ok := fixtures.Geocode["Springfield"]; !ok {
+		t.Error("missing multi-result geocode fixture")
+	}
+}
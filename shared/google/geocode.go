@@ -0,0 +1,57 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+type GeocodeLocation struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+type Geometry struct {
+	Location GeocodeLocation `json:"location"`
+}
+
+type GeocodeResult struct {
+	Geometry         Geometry `json:"geometry"`
+	FormattedAddress string   `json:"formatted_address"`
+}
+
+type GeocodeResponse struct {
+	Results      []GeocodeResult `json:"results"`
+	Status       string          `json:"status"`
+	ErrorMessage string          `json:"error_message"`
+}
+
+// Geocode looks up address with the Geocoding API. ZERO_RESULTS is not an
+// error; it returns an empty slice.
+func (c *Client) Geocode(ctx context.Context, address string) ([]GeocodeResult, error) {
+	query := url.Values{}
+	query.Set("address", address)
+	query.Set("key", c.APIKey)
+	getURL := c.GeocodeURL + "?" + query.Encode()
+
+	var response GeocodeResponse
+	_, err := c.do(ctx, GeocodeProvider, 1, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, getURL, nil)
+	}, func(body []byte) error {
+		response = GeocodeResponse{}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return fmt.Errorf("%w: error unmarshalling JSON: %v", ErrTransient, err)
+		}
+		switch response.Status {
+		case "OK", "ZERO_RESULTS", "":
+			return nil
+		}
+		return classifyGeocodeStatus(response.Status, response.ErrorMessage)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
@@ -0,0 +1,232 @@
+package google
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RoutesPath  = "/directions/v2:computeRoutes"
+	MatrixPath  = "/distanceMatrix/v2:computeRouteMatrix"
+	GeocodePath = "/maps/api/geocode/json"
+
+	DefaultRoutesURL  = "https://routes.googleapis.com" + RoutesPath
+	DefaultMatrixURL  = "https://routes.googleapis.com" + MatrixPath
+	DefaultGeocodeURL = "https://maps.googleapis.com" + GeocodePath
+)
+
+// Provider names used to track spend in the provider_usage table.
+const (
+	RoutesProvider  = "google_routes"
+	GeocodeProvider = "google_geocode"
+)
+
+// RetryPolicy controls how transient failures are retried. Delays use full
+// jitter: each wait is random between zero and the capped exponential delay.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// Client calls the Google Routes and Geocoding APIs.
+type Client struct {
+	APIKey     string
+	RoutesURL  string
+	MatrixURL  string
+	GeocodeURL string
+	HTTPClient *http.Client
+	// RequestTimeout bounds each attempt. The caller's ctx deadline still
+	// applies across all attempts.
+	RequestTimeout time.Duration
+	Retry          RetryPolicy
+	// OnAttempt, if set, is called after every HTTP attempt including
+	// retries. It is used to meter spend and latency.
+	OnAttempt AttemptHook
+
+	// sleep is swapped out in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Attempt describes one HTTP request to a provider. Err is nil on success.
+// Units is what the request is billed as: one for a route or a geocode, one
+// per element for a route matrix.
+type Attempt struct {
+	Provider string
+	Units    int
+	Elapsed  time.Duration
+	Err      error
+}
+
+type AttemptHook func(ctx context.Context, attempt Attempt)
+
+// Hooks combines several OnAttempt hooks into one, skipping nil ones.
+func Hooks(hooks ...AttemptHook) AttemptHook {
+	return func(ctx context.Context, attempt Attempt) {
+		for _, hook := range hooks {
+			if hook != nil {
+				hook(ctx, attempt)
+			}
+		}
+	}
+}
+
+func NewClient(apiKey string) *Client {
+	return &Client{
+		APIKey:         apiKey,
+		RoutesURL:      DefaultRoutesURL,
+		MatrixURL:      DefaultMatrixURL,
+		GeocodeURL:     DefaultGeocodeURL,
+		HTTPClient:     &http.Client{},
+		RequestTimeout: 5 * time.Second,
+		Retry:          DefaultRetryPolicy,
+		sleep:          sleepContext,
+	}
+}
+
+// NewClientFromEnv builds a Client from GOOGLE_API_KEY. If
+// GOOGLE_API_BASE_URL is set, both APIs are sent there instead of Google,
+// e.g. to the fake server in google/fakegoogle.
+func NewClientFromEnv() *Client {
+	c := NewClient(os.Getenv("GOOGLE_API_KEY"))
+	if baseURL := os.Getenv("GOOGLE_API_BASE_URL"); baseURL != "" {
+		c.SetBaseURL(baseURL)
+	}
+	return c
+}
+
+// SetBaseURL points both APIs at baseURL, keeping Google's paths.
+func (c *Client) SetBaseURL(baseURL string) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	c.RoutesURL = baseURL + RoutesPath
+	c.MatrixURL = baseURL + MatrixPath
+	c.GeocodeURL = baseURL + GeocodePath
+}
+
+// do sends a request billed as units, built by newRequest, retrying
+// transient failures. It returns the body of the first 2xx response that
+// check accepts; check may be nil.
+func (c *Client) do(ctx context.Context, provider string, units int, newRequest func(ctx context.Context) (*http.Request, error), check func(body []byte) error) ([]byte, error) {
+	attempts := c.Retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			delay := c.backoff(attempt)
+			var apiErr *APIError
+			if errors.As(lastErr, &apiErr) && apiErr.retryAfter > delay {
+				delay = apiErr.retryAfter
+			}
+			sleep := c.sleep
+			if sleep == nil {
+				sleep = sleepContext
+			}
+			if err := sleep(ctx, delay); err != nil {
+				return nil, fmt.Errorf("%w (after %d attempts: %v)", lastErr, attempt, err)
+			}
+		}
+
+		started := time.Now()
+		body, err := c.attempt(ctx, newRequest)
+		if err == nil && check != nil {
+			err = check(body)
+		}
+		if c.OnAttempt != nil {
+			c.OnAttempt(ctx, Attempt{Provider: provider, Units: units, Elapsed: time.Since(started), Err: err})
+		}
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+		if !errors.Is(err, ErrTransient) || ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *Client) attempt(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) ([]byte, error) {
+	if c.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.RequestTimeout)
+		defer cancel()
+	}
+
+	req, err := newRequest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error creating request: %v", ErrInvalidRequest, err)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error sending request: %v", ErrTransient, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error reading response body: %v", ErrTransient, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := classifyResponse(resp.StatusCode, body)
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, apiErr
+	}
+	return body, nil
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.Retry.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > c.Retry.MaxDelay {
+		delay = c.Retry.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func jsonRequest(ctx context.Context, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
@@ -0,0 +1,256 @@
+package google_test
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google/fakegoogle"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newClient(t *testing.T) (*google.Client, *fakegoogle.Server) {
+	t.Helper()
+	fake := fakegoogle.NewDefault()
+	fake.APIKey = "test-key"
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := google.NewClient("test-key")
+	client.SetBaseURL(server.URL)
+	client.Retry = google.RetryPolicy{MaxAttempts: 3}
+	return client, fake
+}
+
+func routeRequest(t *testing.T, fake *fakegoogle.Server, name string) google.RouteRequest {
+	t.Helper()
+	fixture, ok := fake.Fixtures.Route(name)
+	if !ok {
+		t.Fatalf("no route fixture %q", name)
+	}
+	return google.NewRouteRequest(fixture.Origin, fixture.Destination, time.Date(2024, 9, 2, 8, 0, 0, 0, time.UTC))
+}
+
+func TestComputeRoutes(t *testing.T) {
+	client, fake := newClient(t)
+
+	response, err := client.ComputeRoutes(context.Background(), routeRequest(t, fake, "boulder_to_denver"))
+	if err != nil {
+		t.Fatalf("ComputeRoutes: %v", err)
+	}
+	if len(response.Routes) != 1 {
+		t.Fatalf("got %d routes, want 1", len(response.Routes))
+	}
+	route := response.Routes[0]
+	seconds, err := route.DurationSeconds()
+	if err != nil {
+		t.Fatalf("DurationSeconds: %v", err)
+	}
+	if route.DistanceMeters != 45872 || seconds != 2104 {
+		t.Errorf("got %d m in %d s, want 45872 m in 2104 s", route.DistanceMeters, seconds)
+	}
+	if route.Polyline.EncodedPolyline == "" {
+		t.Error("missing polyline")
+	}
+
+	requests := fake.RouteRequests()
+	if len(requests) != 1 || requests[0].TravelMode != "DRIVE" || requests[0].RoutingPreference != "TRAFFIC_AWARE" {
+		t.Errorf("unexpected requests sent: %+v", requests)
+	}
+}
+
+func TestComputeRoutesNoRoute(t *testing.T) {
+	client, fake := newClient(t)
+
+	response, err := client.ComputeRoutes(context.Background(), routeRequest(t, fake, "no_route"))
+	if err != nil {
+		t.Fatalf("ComputeRoutes: %v", err)
+	}
+	if len(response.Routes) != 0 {
+		t.Errorf("got %d routes, want 0", len(response.Routes))
+	}
+}
+
+func TestComputeRoutesUnknownPairIsDeterministic(t *testing.T) {
+	client, _ := newClient(t)
+	request := google.NewRouteRequest(domain.LatLng{Latitude: 10, Longitude: 10}, domain.LatLng{Latitude: 10.1, Longitude: 10.1}, time.Now())
+
+	first, err := client.ComputeRoutes(context.Background(), request)
+	if err != nil {
+		t.Fatalf("ComputeRoutes: %v", err)
+	}
+	second, err := client.ComputeRoutes(context.Background(), request)
+	if err != nil {
+		t.Fatalf("ComputeRoutes: %v", err)
+	}
+	if len(first.Routes) != 1 || !reflect.DeepEqual(first.Routes[0], second.Routes[0]) {
+		t.Errorf("responses differ: %+v and %+v", first, second)
+	}
+}
+
+func TestComputeRoutesWithIntermediates(t *testing.T) {
+	client, fake := newClient(t)
+	// The Boulder fixture only answers trips without stops.
+	origin := domain.LatLng{Latitude: 40.01499, Longitude: -105.27055}
+	daycare := domain.LatLng{Latitude: 39.9205, Longitude: -105.0867}
+	destination := domain.LatLng{Latitude: 39.73915, Longitude: -104.9847}
+	response, err := client.ComputeRoutes(context.Background(), google.NewRouteRequest(origin, destination, time.Now(), daycare))
+	if err != nil {
+		t.Fatalf("ComputeRoutes: %v", err)
+	}
+	if len(response.Routes) != 1 || len(response.Routes[0].Legs) != 2 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	route := response.Routes[0]
+	total, distance := 0, 0
+	for _, leg := range route.Legs {
+		seconds, err := leg.DurationSeconds()
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += seconds
+		distance += leg.DistanceMeters
+	}
+	if seconds, _ := route.DurationSeconds(); seconds != total || route.DistanceMeters != distance || route.Duration == "2104s" {
+		t.Errorf("legs %+v do not add up to %+v", route.Legs, route)
+	}
+	requests := fake.RouteRequests()
+	if stops := requests[len(requests)-1].Intermediates; len(stops) != 1 || stops[0].Location.LatLng != daycare {
+		t.Errorf("intermediates = %+v", stops)
+	}
+}
+
+func TestComputeRouteMatrix(t *testing.T) {
+	client, fake := newClient(t)
+	units := 0
+	client.OnAttempt = func(ctx context.Context, attempt google.Attempt) { units += attempt.Units }
+	points := []domain.LatLng{{Latitude: 40.01499, Longitude: -105.27055}, {Latitude: 39.90083, Longitude: -105.03561}, {Latitude: 39.73915, Longitude: -104.9847}}
+	elements, err := client.ComputeRouteMatrix(context.Background(), google.NewRouteMatrixRequest(points[:2], points[1:], time.Now()))
+	if err != nil {
+		t.Fatalf("ComputeRouteMatrix: %v", err)
+	}
+	if len(elements) != 4 || len(fake.MatrixRequests()) != 1 {
+		t.Fatalf("got %d elements: %+v", len(elements), elements)
+	}
+	if units != 4 {
+		t.Errorf("billed %d units, want one per element", units)
+	}
+	for _, element := range elements {
+		seconds, err := element.DurationSeconds()
+		if err != nil || !element.Exists() {
+			t.Fatalf("unexpected element %+v: %v", element, err)
+		}
+		// Origin 1 and destination 0 are the same point.
+		if (element.OriginIndex == 1 && element.DestinationIndex == 0) != (seconds == 0) {
+			t.Errorf("unexpected element %+v", element)
+		}
+	}
+
+	tooMany := make([]domain.LatLng, 26)
+	if _, err := client.ComputeRouteMatrix(context.Background(), google.NewRouteMatrixRequest(tooMany, tooMany, time.Now())); !errors.Is(err, google.ErrInvalidRequest) {
+		t.Errorf("got %v for 676 elements", err)
+	}
+}
+
+func TestComputeRoutesErrors(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		class    error
+		attempts int
+	}{
+		{"quota_exceeded", google.ErrQuota, 1},
+		{"invalid_argument", google.ErrInvalidRequest, 1},
+		{"unavailable", google.ErrTransient, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			client, fake := newClient(t)
+			attempts := 0
+			client.OnAttempt = func(ctx context.Context, attempt google.Attempt) {
+				if attempt.Provider != google.RoutesProvider || attempt.Err == nil || attempt.Elapsed <= 0 {
+					t.Errorf("unexpected attempt: %+v", attempt)
+				}
+				attempts++
+			}
+
+			_, err := client.ComputeRoutes(context.Background(), routeRequest(t, fake, test.fixture))
+			if !errors.Is(err, test.class) {
+				t.Fatalf("got %v, want %v", err, test.class)
+			}
+			if attempts != test.attempts {
+				t.Errorf("made %d attempts, want %d", attempts, test.attempts)
+			}
+		})
+	}
+}
+
+func TestComputeRoutesRetriesTransientFailure(t *testing.T) {
+	client, fake := newClient(t)
+
+	response, err := client.ComputeRoutes(context.Background(), routeRequest(t, fake, "flaky"))
+	if err != nil {
+		t.Fatalf("ComputeRoutes: %v", err)
+	}
+	if len(response.Routes) != 1 {
+		t.Errorf("got %d routes, want 1", len(response.Routes))
+	}
+	if len(fake.RouteRequests()) != 2 {
+		t.Errorf("sent %d requests, want 2", len(fake.RouteRequests()))
+	}
+}
+
+func TestComputeRoutesBadKey(t *testing.T) {
+	client, fake := newClient(t)
+	client.APIKey = "wrong"
+
+	_, err := client.ComputeRoutes(context.Background(), routeRequest(t, fake, "boulder_to_denver"))
+	if !errors.Is(err, google.ErrAuth) {
+		t.Fatalf("got %v, want ErrAuth", err)
+	}
+}
+
+func TestGeocode(t *testing.T) {
+	tests := []struct {
+		address string
+		results int
+		class   error
+	}{
+		{"1777 Broadway, Boulder, CO", 1, nil},
+		{"Springfield", 3, nil},
+		{"Atlantis", 0, nil},
+		{"Over Quota", 0, google.ErrQuota},
+		{"Server Error", 0, google.ErrTransient},
+	}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			client, _ := newClient(t)
+
+			results, err := client.Geocode(context.Background(), test.address)
+			if test.class != nil {
+				if !errors.Is(err, test.class) {
+					t.Fatalf("got %v, want %v", err, test.class)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Geocode: %v", err)
+			}
+			if len(results) != test.results {
+				t.Errorf("got %d results, want %d", len(results), test.results)
+			}
+		})
+	}
+}
+
+func TestGeocodeBadKey(t *testing.T) {
+	client, _ := newClient(t)
+	client.APIKey = "wrong"
+
+	_, err := client.Geocode(context.Background(), "1777 Broadway, Boulder, CO")
+	if !errors.Is(err, google.ErrAuth) {
+		t.Fatalf("got %v, want ErrAuth", err)
+	}
+}
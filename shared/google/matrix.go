@@ -0,0 +1,102 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"net/http"
+	"time"
+)
+
+// MaxMatrixElements is the most origin and destination pairs one
+// computeRouteMatrix call may ask for with traffic aware routing.
+const MaxMatrixElements = 625
+
+type MatrixWaypoint struct {
+	Waypoint OriginDestination `json:"waypoint"`
+}
+
+type RouteMatrixRequest struct {
+	Origins           []MatrixWaypoint `json:"origins"`
+	Destinations      []MatrixWaypoint `json:"destinations"`
+	TravelMode        string           `json:"travelMode"`
+	RoutingPreference string           `json:"routingPreference"`
+	DepartureTime     time.Time        `json:"departureTime"`
+}
+
+// NewRouteMatrixRequest asks for a traffic aware drive from every origin to
+// every destination.
+func NewRouteMatrixRequest(origins, destinations []domain.LatLng, departureTime time.Time) RouteMatrixRequest {
+	waypoints := func(points []domain.LatLng) []MatrixWaypoint {
+		converted := make([]MatrixWaypoint, len(points))
+		for i, point := range points {
+			converted[i] = MatrixWaypoint{Waypoint: OriginDestination{Location: Location{LatLng: point}}}
+		}
+		return converted
+	}
+	return RouteMatrixRequest{
+		Origins:           waypoints(origins),
+		Destinations:      waypoints(destinations),
+		TravelMode:        "DRIVE",
+		RoutingPreference: "TRAFFIC_AWARE",
+		DepartureTime:     departureTime,
+	}
+}
+
+// RouteMatrixElement is the drive from Origins[OriginIndex] to
+// Destinations[DestinationIndex]. Condition is "ROUTE_EXISTS" when there is
+// one; otherwise Duration and DistanceMeters are unset.
+type RouteMatrixElement struct {
+	OriginIndex      int    `json:"originIndex"`
+	DestinationIndex int    `json:"destinationIndex"`
+	DistanceMeters   int    `json:"distanceMeters"`
+	Duration         string `json:"duration"`
+	Condition        string `json:"condition"`
+}
+
+func (e RouteMatrixElement) Exists() bool {
+	return e.Condition == "ROUTE_EXISTS"
+}
+
+func (e RouteMatrixElement) DurationSeconds() (int, error) {
+	return durationSeconds(e.Duration)
+}
+
+// Elements is the number of origin and destination pairs the request is
+// billed for.
+func (r RouteMatrixRequest) Elements() int {
+	return len(r.Origins) * len(r.Destinations)
+}
+
+// ComputeRouteMatrix calls the Routes API computeRouteMatrix method. Elements
+// come back in no particular order.
+func (c *Client) ComputeRouteMatrix(ctx context.Context, request RouteMatrixRequest) ([]RouteMatrixElement, error) {
+	elements := request.Elements()
+	if elements == 0 || elements > MaxMatrixElements {
+		return nil, fmt.Errorf("%w: route matrix of %d elements, want 1 to %d", ErrInvalidRequest, elements, MaxMatrixElements)
+	}
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling JSON: %w", err)
+	}
+
+	body, err := c.do(ctx, RoutesProvider, elements, func(ctx context.Context) (*http.Request, error) {
+		req, err := jsonRequest(ctx, c.MatrixURL, jsonData)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Goog-Api-Key", c.APIKey)
+		req.Header.Set("X-Goog-FieldMask", "originIndex,destinationIndex,duration,distanceMeters,condition")
+		return req, nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response []RouteMatrixElement
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("%w: error decoding response: %v", ErrTransient, err)
+	}
+	return response, nil
+}
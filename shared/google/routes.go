@@ -0,0 +1,132 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Location struct {
+	LatLng domain.LatLng `json:"latLng"`
+}
+
+type RouteModifiers struct {
+	AvoidTolls    bool `json:"avoidTolls"`
+	AvoidHighways bool `json:"avoidHighways"`
+	AvoidFerries  bool `json:"avoidFerries"`
+}
+
+type OriginDestination struct {
+	Location Location `json:"location"`
+}
+
+type RouteRequest struct {
+	Origin                   OriginDestination   `json:"origin"`
+	Destination              OriginDestination   `json:"destination"`
+	Intermediates            []OriginDestination `json:"intermediates,omitempty"`
+	TravelMode               string              `json:"travelMode"`
+	RoutingPreference        string              `json:"routingPreference"`
+	DepartureTime            time.Time           `json:"departureTime"`
+	ComputeAlternativeRoutes bool                `json:"computeAlternativeRoutes"`
+	RouteModifiers           RouteModifiers      `json:"routeModifiers"`
+	LanguageCode             string              `json:"languageCode"`
+	Units                    string              `json:"units"`
+}
+
+// NewRouteRequest is the traffic aware driving request used for every
+// commute sample. The route stops at intermediates in order on the way.
+func NewRouteRequest(origin, destination domain.LatLng, departureTime time.Time, intermediates ...domain.LatLng) RouteRequest {
+	var stops []OriginDestination
+	for _, stop := range intermediates {
+		stops = append(stops, OriginDestination{Location: Location{LatLng: stop}})
+	}
+	return RouteRequest{
+		Origin:                   OriginDestination{Location: Location{LatLng: origin}},
+		Destination:              OriginDestination{Location: Location{LatLng: destination}},
+		Intermediates:            stops,
+		TravelMode:               "DRIVE",
+		RoutingPreference:        "TRAFFIC_AWARE",
+		DepartureTime:            departureTime,
+		ComputeAlternativeRoutes: false,
+		RouteModifiers: RouteModifiers{
+			AvoidTolls:    false,
+			AvoidHighways: false,
+			AvoidFerries:  false,
+		},
+		LanguageCode: "en-US",
+		Units:        "IMPERIAL",
+	}
+}
+
+type Route struct {
+	DistanceMeters int      `json:"distanceMeters"`
+	Duration       string   `json:"duration"`
+	Polyline       Polyline `json:"polyline"`
+	// Legs has one entry per stretch between the origin, intermediates and
+	// destination.
+	Legs []Leg `json:"legs,omitempty"`
+}
+
+type Leg struct {
+	DistanceMeters int    `json:"distanceMeters"`
+	Duration       string `json:"duration"`
+}
+
+type Polyline struct {
+	EncodedPolyline string `json:"encodedPolyline"`
+}
+
+// DurationSeconds parses Duration, which the API formats as e.g. "1234s".
+func (r Route) DurationSeconds() (int, error) {
+	return durationSeconds(r.Duration)
+}
+
+func (l Leg) DurationSeconds() (int, error) {
+	return durationSeconds(l.Duration)
+}
+
+func durationSeconds(duration string) (int, error) {
+	numericPart := strings.TrimSuffix(duration, "s")
+	durationInt, err := strconv.Atoi(numericPart)
+	if err != nil {
+		return 0, fmt.Errorf("error converting duration: %w", err)
+	}
+	return durationInt, nil
+}
+
+type RoutesResponse struct {
+	Routes []Route `json:"routes"`
+}
+
+// ComputeRoutes calls the Routes API computeRoutes method. An empty Routes
+// slice means the API found no route; failures are returned as errors.
+func (c *Client) ComputeRoutes(ctx context.Context, request RouteRequest) (RoutesResponse, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return RoutesResponse{}, fmt.Errorf("error marshaling JSON: %w", err)
+	}
+
+	body, err := c.do(ctx, RoutesProvider, 1, func(ctx context.Context) (*http.Request, error) {
+		req, err := jsonRequest(ctx, c.RoutesURL, jsonData)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Goog-Api-Key", c.APIKey)
+		req.Header.Set("X-Goog-FieldMask", "routes.duration,routes.distanceMeters,routes.polyline.encodedPolyline,routes.legs.duration,routes.legs.distanceMeters")
+		return req, nil
+	}, nil)
+	if err != nil {
+		return RoutesResponse{}, err
+	}
+
+	var response RoutesResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return RoutesResponse{}, fmt.Errorf("%w: error decoding response: %v", ErrTransient, err)
+	}
+	return response, nil
+}
@@ -0,0 +1,179 @@
+package adduserroute
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"github.com/go-playground/validator/v10"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Request adds a route from Origin to Destination. Waypoints are addresses
+// of stops on the way to work, such as daycare, in the order they are
+// visited; the drive home visits them in reverse.
+type Request struct {
+	UserID      *int     `json:"user_id" validate:"required"`
+	Origin      *string  `json:"origin_address" validate:"required"`
+	Destination *string  `json:"destination_address" validate:"required"`
+	Waypoints   []string `json:"waypoint_addresses" validate:"max=10,dive,required"`
+	Timezone    *string  `json:"timezone" validate:"required"`
+	domain.Schedule
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+type Data struct {
+	AddedPlaces AddedPlaces `json:"added_routes"`
+}
+
+type Place struct {
+	LatLng  Coordinates `json:"coordinates"`
+	Address string      `json:"address"`
+}
+
+type AddedPlaces struct {
+	Origin      Place   `json:"origin"`
+	Destination Place   `json:"destination"`
+	Waypoints   []Place `json:"waypoints,omitempty"`
+}
+
+type Coordinates struct {
+	Latitude  string `json:"latitude"`
+	Longitude string `json:"longitude"`
+}
+
+var endDateBuffer = 30 //30 days from now route will become inactive
+
+// Handler geocodes a user's origin, destination and stops and saves them as
+// a new route with its schedule.
+type Handler struct {
+	Store    *store.Store
+	Geocoder *google.Client
+	// Clock sets the route's start and end dates and defaults to the system
+	// clock.
+	Clock clock.Clock
+}
+
+func validTimeFormat(fl validator.FieldLevel) bool {
+	// Parse the time in the format "HH:mm:ss"
+	_, err := time.Parse("15:04:05", fl.Field().String())
+	return err == nil
+}
+
+func (h *Handler) getCoordinates(ctx context.Context, address *string) (Place, error) {
+	if address == nil {
+		return Place{}, fmt.Errorf("%w: invalid address, address not included or nil", domain.ErrInvalidRequest)
+	}
+	geocodeResults, err := h.Geocoder.Geocode(ctx, *address)
+	if err != nil {
+		slog.ErrorContext(ctx, "error geocoding address", "error_class", google.ClassName(err), "error", err)
+		return Place{}, fmt.Errorf("error geocoding address (%s): %w", google.ClassName(err), err)
+	}
+
+	// Verify length of results
+	if len(geocodeResults) != 1 {
+		slog.WarnContext(ctx, "expected 1 geocode result", "results", len(geocodeResults))
+		return Place{}, fmt.Errorf("%w: expected 1 result, got: %d", domain.ErrInvalidRequest, len(geocodeResults))
+	}
+	// Check if geometry.location.lat and geometry.location.lng are present
+	firstResult := geocodeResults[0]
+	if firstResult.Geometry.Location.Lat == 0 || firstResult.Geometry.Location.Lng == 0 {
+		slog.WarnContext(ctx, "latitude or longitude is missing or zero")
+		return Place{}, fmt.Errorf("latitude or Longitude is missing or zero")
+	}
+	resultingPlace := Place{
+		LatLng: Coordinates{
+			Latitude:  strconv.FormatFloat(firstResult.Geometry.Location.Lat, 'f', -1, 64),
+			Longitude: strconv.FormatFloat(firstResult.Geometry.Location.Lng, 'f', -1, 64),
+		},
+		Address: firstResult.FormattedAddress,
+	}
+	return resultingPlace, nil
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	if h.Geocoder.APIKey == "" {
+		return Response{}, fmt.Errorf("error loading google maps API key from environment variables")
+	}
+	validate := validator.New()
+	validate.RegisterValidation("validTimeFormat", validTimeFormat)
+
+	if err := validate.Struct(request); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			slog.WarnContext(ctx, "validation failed", "field", err.Field(), "tag", err.Tag())
+			return Response{}, fmt.Errorf("%w: validation failed for field '%s': %s", domain.ErrInvalidRequest, err.Field(), err.Tag())
+		}
+	}
+	// Validate timezone string
+	userLocation, err := time.LoadLocation(*request.Timezone)
+	if err != nil {
+		return Response{}, fmt.Errorf("%w: invalid timezone: %s", domain.ErrInvalidRequest, *request.Timezone)
+	}
+	originPlace, err := h.getCoordinates(ctx, request.Origin)
+	if err != nil {
+		slog.ErrorContext(ctx, "error obtaining origin location coordinates", "error", err)
+		return Response{}, fmt.Errorf("error obtaining location coordinates: %w", err)
+	}
+	destinationPlace, err := h.getCoordinates(ctx, request.Destination)
+	if err != nil {
+		slog.ErrorContext(ctx, "error obtaining destination location coordinates", "error", err)
+		return Response{}, fmt.Errorf("error obtaining location coordinates: %w", err)
+	}
+	var waypointPlaces []Place
+	for i := range request.Waypoints {
+		place, err := h.getCoordinates(ctx, &request.Waypoints[i])
+		if err != nil {
+			slog.ErrorContext(ctx, "error obtaining waypoint location coordinates", "position", i+1, "error", err)
+			return Response{}, fmt.Errorf("error obtaining location coordinates: %w", err)
+		}
+		waypointPlaces = append(waypointPlaces, place)
+	}
+	now := clock.Now(h.Clock).In(userLocation)
+	endDate := now.AddDate(0, 0, endDateBuffer).Format("2006-01-02")
+	newRoute := domain.Route{
+		UserID:         *request.UserID,
+		StartAddress:   originPlace.Address,
+		StartLatitude:  originPlace.LatLng.Latitude,
+		StartLongitude: originPlace.LatLng.Longitude,
+		EndAddress:     destinationPlace.Address,
+		EndLatitude:    destinationPlace.LatLng.Latitude,
+		EndLongitude:   destinationPlace.LatLng.Longitude,
+		TimeZone:       *request.Timezone,
+		Active:         true,
+		StartDate:      now.Format("2006-01-02"),
+		EndDate:        &endDate,
+	}
+	waypoints := make([]domain.Waypoint, len(waypointPlaces))
+	for i, place := range waypointPlaces {
+		waypoints[i] = domain.Waypoint{Address: place.Address, Latitude: place.LatLng.Latitude, Longitude: place.LatLng.Longitude}
+	}
+	// One transaction, so a failure never leaves a route without its stops
+	// or schedule.
+	insertedRoute, waypoints, insertedSchedule, err := h.Store.CreateRoute(ctx, newRoute, waypoints, request.Schedule)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to insert route", "error", err)
+		return Response{}, fmt.Errorf("failed to insert data: %v", err)
+	}
+	slog.InfoContext(ctx, "inserted route", "route_id", insertedRoute.ID, "user_id", insertedRoute.UserID,
+		"waypoints", len(waypoints), "schedule_id", insertedSchedule.ID)
+
+	addUserRouteResponse := Response{
+		Message: "Success",
+		Data: Data{
+			AddedPlaces: AddedPlaces{
+				Origin:      originPlace,
+				Destination: destinationPlace,
+				Waypoints:   waypointPlaces,
+			},
+		},
+	}
+	return addUserRouteResponse, nil
+}
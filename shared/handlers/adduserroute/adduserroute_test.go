@@ -0,0 +1,148 @@
+package adduserroute
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google/fakegoogle"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"net/http/httptest"
+	"testing"
+)
+
+func newHandler(t *testing.T) (*Handler, *store.Memory) {
+	t.Helper()
+	server := httptest.NewServer(fakegoogle.NewDefault())
+	t.Cleanup(server.Close)
+	client := google.NewClient("test-key")
+	client.SetBaseURL(server.URL)
+	client.Retry = google.RetryPolicy{MaxAttempts: 1}
+
+	db, memory := store.NewMemory()
+	return &Handler{Store: db, Geocoder: client}, memory
+}
+
+func newRequest(origin, destination string) Request {
+	userID := 3
+	timezone := "America/Denver"
+	return Request{
+		UserID:      &userID,
+		Origin:      &origin,
+		Destination: &destination,
+		Timezone:    &timezone,
+		Schedule: domain.Schedule{
+			MorningStartTime:   "07:00:00",
+			MorningEndTime:     "09:00:00",
+			AfternoonStartTime: "16:00:00",
+			AfternoonEndTime:   "18:00:00",
+			Monday:             true,
+			Friday:             true,
+		},
+	}
+}
+
+func TestHandleRequestCreatesRouteAndSchedule(t *testing.T) {
+	handler, memory := newHandler(t)
+
+	response, err := handler.HandleRequest(context.Background(), newRequest("1777 Broadway, Boulder, CO", "1437 Bannock St, Denver, CO"))
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if got := response.Data.AddedPlaces.Origin.Address; got != "1777 Broadway, Boulder, CO 80302, USA" {
+		t.Errorf("origin address = %q", got)
+	}
+
+	if len(memory.Routes) != 1 {
+		t.Fatalf("created %d routes, want 1", len(memory.Routes))
+	}
+	for _, route := range memory.Routes {
+		if route.StartLatitude != "40.01499" || route.EndLongitude != "-104.9847" {
+			t.Errorf("unexpected coordinates: %+v", route)
+		}
+		if !route.Active || route.EndDate == nil || route.TimeZone != "America/Denver" {
+			t.Errorf("unexpected route: %+v", route)
+		}
+		schedule, ok := memory.Schedules[route.ID]
+		if !ok {
+			t.Fatalf("no schedule for route %d", route.ID)
+		}
+		if !schedule.Monday || schedule.Tuesday || schedule.MorningStartTime != "07:00:00" {
+			t.Errorf("unexpected schedule: %+v", schedule)
+		}
+	}
+}
+
+func TestHandleRequestSavesWaypoints(t *testing.T) {
+	handler, memory := newHandler(t)
+	request := newRequest("1777 Broadway, Boulder, CO", "1437 Bannock St, Denver, CO")
+	request.Waypoints = []string{"3901 W 112th Ave, Westminster, CO"}
+
+	response, err := handler.HandleRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if added := response.Data.AddedPlaces.Waypoints; len(added) != 1 || added[0].Address != "3901 W 112th Ave, Westminster, CO 80031, USA" {
+		t.Errorf("added waypoints %+v", added)
+	}
+	for routeID := range memory.Routes {
+		waypoints := memory.Waypoints[routeID]
+		if len(waypoints) != 1 || waypoints[0].Position != 1 || waypoints[0].Latitude != "39.90083" || waypoints[0].Longitude != "-105.03561" {
+			t.Errorf("saved waypoints %+v", waypoints)
+		}
+	}
+
+	// An unknown stop fails before the route is saved.
+	handler, memory = newHandler(t)
+	request.Waypoints = []string{"Atlantis"}
+	if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, domain.ErrInvalidRequest) || len(memory.Routes) != 0 {
+		t.Errorf("unknown waypoint: got %v with %d routes", err, len(memory.Routes))
+	}
+}
+
+func TestHandleRequestRejectsAmbiguousAndUnknownAddresses(t *testing.T) {
+	for _, address := range []string{"Springfield", "Atlantis"} {
+		t.Run(address, func(t *testing.T) {
+			handler, memory := newHandler(t)
+
+			_, err := handler.HandleRequest(context.Background(), newRequest(address, "1437 Bannock St, Denver, CO"))
+			if !errors.Is(err, domain.ErrInvalidRequest) {
+				t.Fatalf("got %v, want ErrInvalidRequest", err)
+			}
+			if len(memory.Routes) != 0 {
+				t.Error("created a route for an unusable address")
+			}
+		})
+	}
+}
+
+func TestHandleRequestProviderError(t *testing.T) {
+	handler, _ := newHandler(t)
+
+	_, err := handler.HandleRequest(context.Background(), newRequest("Over Quota", "1437 Bannock St, Denver, CO"))
+	if !errors.Is(err, google.ErrQuota) {
+		t.Fatalf("got %v, want ErrQuota", err)
+	}
+}
+
+func TestHandleRequestValidation(t *testing.T) {
+	handler, _ := newHandler(t)
+
+	request := newRequest("1777 Broadway, Boulder, CO", "1437 Bannock St, Denver, CO")
+	request.MorningEndTime = "9am"
+	if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("bad time: got %v, want ErrInvalidRequest", err)
+	}
+
+	request = newRequest("1777 Broadway, Boulder, CO", "1437 Bannock St, Denver, CO")
+	request.UserID = nil
+	if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("missing user: got %v, want ErrInvalidRequest", err)
+	}
+
+	request = newRequest("1777 Broadway, Boulder, CO", "1437 Bannock St, Denver, CO")
+	request.Waypoints = []string{"3901 W 112th Ave, Westminster, CO", ""}
+	if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("empty waypoint: got %v, want ErrInvalidRequest", err)
+	}
+}
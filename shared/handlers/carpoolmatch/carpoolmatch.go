@@ -0,0 +1,189 @@
+package carpoolmatch
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/carpool"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/planner"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+const (
+	defaultLimit = 10
+	maxLimit     = 50
+	// evaluationsPerSuggestion caps the candidates evaluated at a few times
+	// the suggestions asked for, as some are ranked out for their detour.
+	evaluationsPerSuggestion = 3
+	// deadlineMargin is the time left to the invocation's deadline at which
+	// no more candidates are evaluated, so the response still gets out.
+	deadlineMargin = 3 * time.Second
+)
+
+// Request suggests carpools in one direction or, when ToWork is unset, both,
+// between routes without waypoints. UserID limits them to the ones that user
+// is part of. Limit caps how many
+// suggestions are returned, 10 by default.
+type Request struct {
+	UserID *int  `json:"user_id"`
+	ToWork *bool `json:"to_work"`
+	Limit  int   `json:"limit"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+// Data counts the candidate pairs found, how many were evaluated with the
+// routing provider, and how many failed or were skipped: past a few times
+// Limit, for lack of budget or for lack of time.
+type Data struct {
+	Candidates  int                  `json:"candidates"`
+	Evaluated   int                  `json:"evaluated"`
+	Skipped     int                  `json:"skipped"`
+	Failed      int                  `json:"failed"`
+	Budget      budget.Status        `json:"budget"`
+	RunID       string               `json:"run_id"`
+	Suggestions []carpool.Suggestion `json:"suggestions"`
+}
+
+// Handler ranks carpools between users whose routes run close together.
+type Handler struct {
+	Store  *store.Store
+	Routes *google.Client
+	// Predict defaults to asking Routes when nil.
+	Predict planner.Predictor
+	// Clock defaults to the system clock when nil.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+// evaluate prices a candidate on the next day both routes commute.
+func evaluate(ctx context.Context, matcher *carpool.Matcher, candidate carpool.Candidate, now time.Time) (carpool.Suggestion, error) {
+	departure, err := carpool.NextDeparture(candidate, now)
+	if err != nil {
+		return carpool.Suggestion{}, err
+	}
+	return matcher.Evaluate(ctx, candidate, departure)
+}
+
+// withoutStops leaves out the routes with waypoints, as carpools are priced
+// between homes and workplaces only.
+func (h *Handler) withoutStops(ctx context.Context, routes []domain.ScheduledRoute) ([]domain.ScheduledRoute, error) {
+	var direct []domain.ScheduledRoute
+	for _, route := range routes {
+		waypoints, err := h.Store.Waypoints.List(ctx, route.Route.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "error loading waypoints", "route_id", route.Route.ID, "error", err)
+			return nil, fmt.Errorf("error loading waypoints: %v", err)
+		}
+		if len(waypoints) == 0 {
+			direct = append(direct, route)
+		}
+	}
+	return direct, nil
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	runID := logging.NewRunID()
+	ctx = logging.WithRunID(ctx, runID)
+	limit := defaultLimit
+	if request.Limit != 0 {
+		limit = request.Limit
+	}
+	if limit < 1 || limit > maxLimit {
+		return Response{}, fmt.Errorf("%w: limit must be between 1 and %d, got %d", domain.ErrInvalidRequest, maxLimit, limit)
+	}
+	if request.UserID != nil {
+		if _, err := h.Store.Users.Get(ctx, *request.UserID); err != nil {
+			slog.ErrorContext(ctx, "error loading user", "user_id", *request.UserID, "error", err)
+			return Response{}, fmt.Errorf("error loading user: %w", err)
+		}
+	}
+
+	predict := h.Predict
+	if predict == nil {
+		predict = planner.GooglePredictor(h.Routes)
+	}
+	matcher := carpool.New(carpool.Cached(predict))
+
+	routes, err := h.Store.Schedules.Active(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading scheduled routes", "error", err)
+		return Response{}, fmt.Errorf("error loading scheduled routes: %v", err)
+	}
+	if routes, err = h.withoutStops(ctx, routes); err != nil {
+		return Response{}, err
+	}
+	directions := []bool{true, false}
+	if request.ToWork != nil {
+		directions = []bool{*request.ToWork}
+	}
+	var candidates []carpool.Candidate
+	for _, toWork := range directions {
+		for _, candidate := range matcher.Candidates(routes, toWork) {
+			if request.UserID == nil || candidate.A.Route.UserID == *request.UserID || candidate.B.Route.UserID == *request.UserID {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].OriginDistance+candidates[i].DestinationDistance < candidates[j].OriginDistance+candidates[j].DestinationDistance
+	})
+
+	// Each candidate costs several provider requests, so only the closest few
+	// and only as many as the budget allows are evaluated.
+	wanted := min(len(candidates), evaluationsPerSuggestion*limit)
+	guard := budget.NewGuard(h.Store.Usage, google.RoutesProvider)
+	guard.Clock = h.Clock
+	affordable, status, err := guard.AllowCalls(ctx, wanted, carpool.CallsPerCandidate)
+	if err != nil {
+		slog.ErrorContext(ctx, "error checking routing budget", "error", err)
+		return Response{}, fmt.Errorf("error checking routing budget: %v", err)
+	}
+	data := Data{Candidates: len(candidates), Budget: status, RunID: runID}
+	if affordable < wanted {
+		slog.WarnContext(ctx, "skipping carpool candidates over the budget", "skipped", wanted-affordable, "candidates", len(candidates), "circuit", status.Circuit)
+	}
+
+	sink := metrics.Or(h.Metrics)
+	now := clock.Now(h.Clock)
+	deadline, hasDeadline := ctx.Deadline()
+	var suggestions []carpool.Suggestion
+	for i, candidate := range candidates[:affordable] {
+		if hasDeadline && time.Until(deadline) < deadlineMargin {
+			slog.WarnContext(ctx, "stopping carpool evaluation before the deadline", "evaluated", i, "remaining", affordable-i)
+			break
+		}
+		suggestion, err := evaluate(ctx, matcher, candidate, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "error evaluating carpool", "route_id", candidate.A.Route.ID, "other_route_id", candidate.B.Route.ID, "to_work", candidate.ToWork, "error", err)
+			sink.Count("carpools_failed", 1, "error_class", metrics.ErrorClass(err))
+			data.Failed++
+			continue
+		}
+		suggestions = append(suggestions, suggestion)
+		data.Evaluated++
+	}
+	data.Skipped = len(candidates) - data.Evaluated - data.Failed
+
+	data.Suggestions = matcher.Rank(suggestions)
+	if len(data.Suggestions) > limit {
+		data.Suggestions = data.Suggestions[:limit]
+	}
+	sink.Count("carpools_evaluated", float64(data.Evaluated))
+	sink.Count("carpools_suggested", float64(len(data.Suggestions)))
+	slog.InfoContext(ctx, "matched carpools", "candidates", data.Candidates, "evaluated", data.Evaluated, "skipped", data.Skipped,
+		"failed", data.Failed, "suggested", len(data.Suggestions))
+	return Response{fmt.Sprintf("Found %d carpools among %d candidate pairs.", len(data.Suggestions), data.Candidates), data}, nil
+}
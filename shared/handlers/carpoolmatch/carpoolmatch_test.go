@@ -0,0 +1,184 @@
+package carpoolmatch
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/carpool"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+// tuesdayNight is after every window, so the next shared one is Wednesday's.
+var tuesdayNight = time.Date(2024, 9, 3, 21, 0, 0, 0, denver)
+
+func weekdays(routeID int) domain.Schedule {
+	return domain.Schedule{
+		RouteID:            routeID,
+		MorningStartTime:   "07:00:00",
+		MorningEndTime:     "08:30:00",
+		AfternoonStartTime: "16:30:00",
+		AfternoonEndTime:   "18:00:00",
+		Monday:             true, Tuesday: true, Wednesday: true, Thursday: true, Friday: true,
+	}
+}
+
+// newHandler seeds three neighbours who work downtown, the third of them a
+// little further out, and one commuter from Golden.
+func newHandler(t *testing.T) (*Handler, *store.Memory, *int) {
+	t.Helper()
+	db, memory := store.NewMemory()
+	for i, home := range [][2]string{{"40.01499", "-105.27055"}, {"40.02", "-105.255"}, {"40.03", "-105.24"}, {"39.7555", "-105.2211"}} {
+		id, userID := i+1, 10*(i+1)
+		memory.Users[userID] = domain.User{ID: userID, Username: "commuter"}
+		memory.Routes[id] = domain.Route{
+			ID: id, UserID: userID, Active: true, TimeZone: "America/Denver",
+			StartLatitude: home[0], StartLongitude: home[1], EndLatitude: "39.73915", EndLongitude: "-104.9847",
+		}
+		memory.Schedules[id] = weekdays(id)
+	}
+	calls := 0
+	predict := func(ctx context.Context, trip domain.DueRoute, departure time.Time) (int, error) {
+		calls++
+		if want := time.Date(2024, 9, 4, 7, 0, 0, 0, denver); !departure.Equal(want) && !departure.Equal(want.Add(570*time.Minute)) {
+			t.Errorf("departure = %v", departure)
+		}
+		return int(carpool.Distance(trip.Origin, trip.Destination) / 15), nil
+	}
+	return &Handler{Store: db, Predict: predict, Clock: clock.Fixed(tuesdayNight)}, memory, &calls
+}
+
+func TestHandleRequestRanksCarpools(t *testing.T) {
+	handler, _, calls := newHandler(t)
+	toWork := true
+
+	response, err := handler.HandleRequest(context.Background(), Request{ToWork: &toWork})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if data.Candidates != 3 || data.Evaluated != 3 || data.Skipped != 0 || data.Failed != 0 || len(data.Suggestions) != 3 {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+	// Shared legs are only asked about once.
+	if *calls >= 3*carpool.CallsPerCandidate {
+		t.Errorf("made %d calls", *calls)
+	}
+	for i, suggestion := range data.Suggestions {
+		if suggestion.Driver.UserID == 40 || suggestion.Passenger.UserID == 40 || suggestion.Detour > 20*60 {
+			t.Errorf("unexpected suggestion: %+v", suggestion)
+		}
+		if i > 0 && suggestion.Detour < data.Suggestions[i-1].Detour {
+			t.Errorf("suggestions are not ranked by detour: %+v", data.Suggestions)
+		}
+	}
+	if response.Message != "Found 3 carpools among 3 candidate pairs." {
+		t.Errorf("message = %q", response.Message)
+	}
+}
+
+func TestHandleRequestForOneUser(t *testing.T) {
+	handler, _, _ := newHandler(t)
+	userID := 10
+
+	response, err := handler.HandleRequest(context.Background(), Request{UserID: &userID, Limit: 1})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	// Routes 1-2 both ways and 1-3 only to work: going home, their homes are
+	// further apart than a drop-off may be.
+	if data.Candidates != 3 || data.Evaluated != 3 || len(data.Suggestions) != 1 {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+	if suggestion := data.Suggestions[0]; suggestion.Driver.UserID != 10 && suggestion.Passenger.UserID != 10 {
+		t.Errorf("suggestion without user 10: %+v", suggestion)
+	}
+}
+
+func TestHandleRequestLeavesOutRoutesWithStops(t *testing.T) {
+	handler, memory, _ := newHandler(t)
+	memory.Waypoints[2] = []domain.Waypoint{{RouteID: 2, Position: 1, Address: "Daycare", Latitude: "39.9", Longitude: "-105.1"}}
+	toWork := true
+
+	response, err := handler.HandleRequest(context.Background(), Request{ToWork: &toWork})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if data.Candidates != 1 || len(data.Suggestions) != 1 {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+	if suggestion := data.Suggestions[0]; suggestion.Driver.RouteID == 2 || suggestion.Passenger.RouteID == 2 {
+		t.Errorf("suggested route 2 despite its stop: %+v", suggestion)
+	}
+}
+
+func TestHandleRequestRespectsBudget(t *testing.T) {
+	handler, memory, calls := newHandler(t)
+	limit := 2*carpool.CallsPerCandidate + 1
+	memory.Budgets[google.RoutesProvider] = domain.Budget{Provider: google.RoutesProvider, DailyLimit: &limit}
+	toWork := true
+
+	response, err := handler.HandleRequest(context.Background(), Request{ToWork: &toWork})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if data := response.Data; data.Evaluated != 2 || data.Skipped != 1 || *calls > 2*carpool.CallsPerCandidate {
+		t.Errorf("unexpected data after %d calls: %+v", *calls, data)
+	}
+}
+
+func TestHandleRequestCapsEvaluations(t *testing.T) {
+	handler, _, _ := newHandler(t)
+
+	response, err := handler.HandleRequest(context.Background(), Request{Limit: 1})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if data := response.Data; data.Candidates <= 3 || data.Evaluated != 3 || data.Skipped != data.Candidates-3 {
+		t.Errorf("unexpected data: %+v", data)
+	}
+
+	// Close to the deadline nothing more is evaluated.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	response, err = handler.HandleRequest(ctx, Request{})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if data := response.Data; data.Evaluated != 0 || data.Skipped != data.Candidates {
+		t.Errorf("unexpected data near the deadline: %+v", data)
+	}
+}
+
+func TestHandleRequestProbesHalfOpenCircuit(t *testing.T) {
+	handler, memory, calls := newHandler(t)
+	memory.Circuits[google.RoutesProvider] = tuesdayNight.Add(-time.Hour)
+	toWork := true
+
+	response, err := handler.HandleRequest(context.Background(), Request{ToWork: &toWork})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if data := response.Data; data.Budget.Circuit != budget.HalfOpen || data.Evaluated != 1 || data.Skipped != 2 || *calls > carpool.CallsPerCandidate {
+		t.Errorf("unexpected data after %d calls: %+v", *calls, data)
+	}
+}
+
+func TestHandleRequestValidation(t *testing.T) {
+	handler, _, _ := newHandler(t)
+	missingUser := 99
+	if _, err := handler.HandleRequest(context.Background(), Request{Limit: 51}); !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("limit: got %v", err)
+	}
+	if _, err := handler.HandleRequest(context.Background(), Request{UserID: &missingUser}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("unknown user: got %v", err)
+	}
+}
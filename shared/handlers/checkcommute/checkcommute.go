@@ -0,0 +1,125 @@
+package checkcommute
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/optimizeroute"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"time"
+)
+
+// Request checks one route on demand. ToWork defaults to the morning
+// direction before noon in the route's time zone and the afternoon direction
+// after. DepartureTime defaults to a minute from now. The result is only
+// saved when Record is set, and is then flagged as ad hoc.
+type Request struct {
+	RouteID       *int       `json:"route_id"`
+	ToWork        *bool      `json:"to_work"`
+	DepartureTime *time.Time `json:"departure_time"`
+	Record        bool       `json:"record"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+type Data struct {
+	RouteID       int             `json:"route_id"`
+	ToWork        bool            `json:"to_work"`
+	DepartureTime time.Time       `json:"departure_time"`
+	Commute       *domain.Commute `json:"commute,omitempty"`
+	Recorded      bool            `json:"recorded"`
+	Routes        []google.Route  `json:"routes"`
+}
+
+// Handler answers "how long is my commute right now" for a single route,
+// using the coordinates and time zone stored on the route.
+type Handler struct {
+	Store  *store.Store
+	Routes *google.Client
+	// Clock defaults to the system clock when nil.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	ctx = logging.WithRunID(ctx, logging.NewRunID())
+	if request.RouteID == nil {
+		return Response{}, fmt.Errorf("%w. Missing route ID", domain.ErrInvalidRequest)
+	}
+	route, err := h.Store.Routes.Get(ctx, *request.RouteID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading route", "route_id", *request.RouteID, "error", err)
+		return Response{}, fmt.Errorf("error loading route: %w", err)
+	}
+	if !route.Active {
+		return Response{}, fmt.Errorf("%w: route %d is inactive", domain.ErrInvalidRequest, route.ID)
+	}
+	loc, err := time.LoadLocation(route.TimeZone)
+	if err != nil {
+		return Response{}, fmt.Errorf("route %d has an invalid time zone %q: %v", route.ID, route.TimeZone, err)
+	}
+
+	now := clock.Now(h.Clock)
+	departureTime := now.Add(1 * time.Minute)
+	if request.DepartureTime != nil {
+		if request.DepartureTime.Before(now) {
+			return Response{}, fmt.Errorf("%w: departure_time %s is in the past", domain.ErrInvalidRequest, request.DepartureTime.Format(time.RFC3339))
+		}
+		departureTime = *request.DepartureTime
+	}
+	departureTime = departureTime.In(loc)
+
+	toWork := departureTime.Hour() < 12
+	if request.ToWork != nil {
+		toWork = *request.ToWork
+	}
+
+	direction, err := h.Store.Direction(ctx, route, toWork)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading route direction", "route_id", route.ID, "error", err)
+		return Response{}, err
+	}
+	trip := optimizeroute.Trip{
+		Route:     direction,
+		Departure: departureTime,
+		Record:    request.Record,
+		AdHoc:     true,
+	}
+
+	// Ad hoc checks spend the same budget as scheduled ones, so they stop
+	// when commutesQueue does.
+	guard := budget.NewGuard(h.Store.Usage, google.RoutesProvider)
+	guard.Clock = h.Clock
+	if status, err := guard.Require(ctx, 1, 1); err != nil {
+		slog.WarnContext(ctx, "refusing to check commute", "route_id", route.ID, "circuit", status.Circuit, "error", err)
+		return Response{}, err
+	}
+
+	optimizer := &optimizeroute.Handler{Store: h.Store, Routes: h.Routes, Clock: h.Clock, Metrics: h.Metrics}
+	routes, commute, err := optimizer.Check(ctx, trip)
+	if err != nil {
+		return Response{}, err
+	}
+	data := Data{
+		RouteID:       route.ID,
+		ToWork:        toWork,
+		DepartureTime: departureTime,
+		Commute:       commute,
+		Recorded:      commute != nil && request.Record,
+		Routes:        routes.Routes,
+	}
+	if commute == nil {
+		return Response{Message: "No routes found in the response", Data: data}, nil
+	}
+	return Response{Message: "Request successful", Data: data}, nil
+}
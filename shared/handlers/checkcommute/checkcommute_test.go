@@ -0,0 +1,142 @@
+package checkcommute
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google/fakegoogle"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+// saturdayNoon is outside any commute window, which is the point.
+var saturdayNoon = time.Date(2024, 9, 7, 12, 0, 0, 0, denver)
+
+func newHandler(t *testing.T) (*Handler, *store.Memory, *fakegoogle.Server) {
+	t.Helper()
+	fake := fakegoogle.NewDefault()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := google.NewClient("test-key")
+	client.SetBaseURL(server.URL)
+	client.Retry = google.RetryPolicy{MaxAttempts: 1}
+
+	db, memory := store.NewMemory()
+	memory.Routes[7] = domain.Route{
+		ID:             7,
+		UserID:         3,
+		StartLatitude:  "40.01499",
+		StartLongitude: "-105.27055",
+		EndLatitude:    "39.73915",
+		EndLongitude:   "-104.9847",
+		TimeZone:       "America/Denver",
+		Active:         true,
+	}
+	return &Handler{Store: db, Routes: client, Clock: clock.Fixed(saturdayNoon)}, memory, fake
+}
+
+func TestHandleRequestChecksWithoutRecording(t *testing.T) {
+	handler, memory, _ := newHandler(t)
+	routeID, toWork := 7, true
+
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, ToWork: &toWork})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if data.Recorded || data.Commute == nil || data.Commute.Duration != 2104 || data.Commute.Distance != 45872 {
+		t.Errorf("unexpected data: %+v", data)
+	}
+	if !data.DepartureTime.Equal(saturdayNoon.Add(time.Minute)) {
+		t.Errorf("departure_time = %v, want a minute from now", data.DepartureTime)
+	}
+	if commutes := memory.AllCommutes(); len(commutes) != 0 {
+		t.Errorf("recorded %d commutes, want 0", len(commutes))
+	}
+}
+
+func TestHandleRequestRecordsAdHoc(t *testing.T) {
+	handler, memory, _ := newHandler(t)
+	routeID := 7
+
+	// After noon with no direction given, the route is checked home from work.
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, Record: true})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if !response.Data.Recorded || response.Data.ToWork {
+		t.Errorf("unexpected data: %+v", response.Data)
+	}
+	commutes := memory.AllCommutes()
+	if len(commutes) != 1 {
+		t.Fatalf("recorded %d commutes, want 1", len(commutes))
+	}
+	commute := commutes[0]
+	if !commute.AdHoc || commute.ToWork || commute.Duration != 2388 || commute.DayOfWeek != "Saturday" {
+		t.Errorf("unexpected commute: %+v", commute)
+	}
+}
+
+func TestHandleRequestDepartureTime(t *testing.T) {
+	handler, _, fake := newHandler(t)
+	routeID := 7
+	departure := time.Date(2024, 9, 10, 7, 30, 0, 0, denver)
+
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, DepartureTime: &departure})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if !response.Data.ToWork {
+		t.Error("expected a morning departure to default to to_work")
+	}
+	requests := fake.RouteRequests()
+	if len(requests) != 1 || !requests[0].DepartureTime.Equal(departure) {
+		t.Errorf("unexpected route requests: %+v", requests)
+	}
+
+	past := saturdayNoon.Add(-time.Hour)
+	_, err = handler.HandleRequest(context.Background(), Request{RouteID: &routeID, DepartureTime: &past})
+	if !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("err = %v, want ErrInvalidRequest for a past departure", err)
+	}
+}
+
+func TestHandleRequestRefusals(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	routeID := 7
+
+	limit := 0
+	memory.Budgets[google.RoutesProvider] = domain.Budget{Provider: google.RoutesProvider, DailyLimit: &limit}
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID}); !errors.Is(err, google.ErrQuota) {
+		t.Errorf("err = %v, want ErrQuota without budget", err)
+	}
+
+	route := memory.Routes[7]
+	route.Active = false
+	memory.Routes[7] = route
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID}); !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("err = %v, want ErrInvalidRequest for an inactive route", err)
+	}
+	if requests := fake.RouteRequests(); len(requests) != 0 {
+		t.Errorf("sent %d route requests", len(requests))
+	}
+}
+
+func TestHandleRequestUnknownRoute(t *testing.T) {
+	handler, _, _ := newHandler(t)
+	routeID := 99
+
+	_, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+	if _, err := handler.HandleRequest(context.Background(), Request{}); !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("err = %v, want ErrInvalidRequest without a route ID", err)
+	}
+}
@@ -0,0 +1,320 @@
+package commutesqueue
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Request optionally overrides the time used to pick due routes, e.g. to
+// check what would run at 07:45 on a Tuesday. It does not change the
+// departure time optimizeRoute asks Google about. DryRun lists the routes
+// that would be dispatched without dispatching them.
+type Request struct {
+	AsOf   *time.Time `json:"as_of"`
+	DryRun bool       `json:"dry_run"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+type Data struct {
+	Processed int            `json:"routes_processed"`
+	Suceeded  int            `json:"routes_suceeded"`
+	Failed    int            `json:"routes_failed"`
+	Skipped   int            `json:"routes_skipped"`
+	Budget    budget.Status  `json:"budget"`
+	RunID     string         `json:"run_id"`
+	DryRun    bool           `json:"dry_run,omitempty"`
+	Routes    []PlannedRoute `json:"routes,omitempty"`
+}
+
+// PlannedRoute is one due route in a dry run and whether it would have been
+// dispatched.
+type PlannedRoute struct {
+	RouteID     int    `json:"route_id"`
+	UserID      int    `json:"user_id"`
+	Direction   string `json:"direction"`
+	TimeZone    string `json:"timezone"`
+	LocalTime   string `json:"local_time"`
+	WindowStart string `json:"window_start"`
+	WindowEnd   string `json:"window_end"`
+	Dispatch    bool   `json:"dispatch"`
+	Reason      string `json:"reason"`
+}
+
+// Dispatcher hands one due route to optimizeRoute. The Lambda invokes the
+// optimizeRoute function; the local server calls its handler directly.
+type Dispatcher interface {
+	Dispatch(ctx context.Context, request domain.OptimizeRouteRequest) error
+}
+
+type DispatcherFunc func(ctx context.Context, request domain.OptimizeRouteRequest) error
+
+func (f DispatcherFunc) Dispatch(ctx context.Context, request domain.OptimizeRouteRequest) error {
+	return f(ctx, request)
+}
+
+// Handler finds every route whose schedule window is open and dispatches it.
+type Handler struct {
+	Store      *store.Store
+	Dispatcher Dispatcher
+	// Clock defaults to the system clock when nil.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+func processRoute(ctx context.Context, route domain.DueRoute, dispatcher Dispatcher, sink metrics.Sink, resultChan chan<- error, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	request := domain.NewOptimizeRouteRequest(route)
+	request.RunID = logging.RunID(ctx)
+	sink.Count("routes_dispatched", 1)
+	if err := dispatcher.Dispatch(ctx, request); err != nil {
+		slog.ErrorContext(ctx, "error dispatching route", "route_id", route.ID, "to_work", route.ToWork, "error", err)
+		sink.Count("routes_failed", 1, "error_class", metrics.ErrorClass(err))
+		resultChan <- err
+		return
+	}
+
+	slog.InfoContext(ctx, "dispatched route", "route_id", route.ID, "to_work", route.ToWork)
+	sink.Count("routes_succeeded", 1)
+	resultChan <- nil
+}
+
+func directionName(toWork bool) string {
+	if toWork {
+		return "to_work"
+	}
+	return "from_work"
+}
+
+// recordFreshness observes how long ago each due route last recorded a
+// scheduled commute.
+func (h *Handler) recordFreshness(ctx context.Context, sink metrics.Sink, routes []domain.DueRoute, asOf time.Time) {
+	ids := make([]int, len(routes))
+	for i, route := range routes {
+		ids[i] = route.ID
+	}
+	last, err := h.Store.Commutes.LastRecorded(ctx, ids)
+	if err != nil {
+		slog.WarnContext(ctx, "error loading last recorded commutes", "error", err)
+		return
+	}
+	for _, route := range routes {
+		recorded, ok := last[route.ID]
+		if !ok {
+			sink.Count("routes_never_recorded", 1)
+			continue
+		}
+		sink.Observe("commute_age", asOf.Sub(recorded).Seconds(), metrics.Seconds)
+	}
+}
+
+func fetchRoutes(ctx context.Context, toWork bool, at time.Time, schedules store.ScheduleRepository, wg *sync.WaitGroup, results chan<- domain.DueRoute, errors chan<- error) {
+	defer wg.Done()
+
+	routes, err := schedules.Due(ctx, toWork, at)
+	if err != nil {
+		errors <- err
+		return
+	}
+	for _, route := range routes {
+		results <- route
+	}
+}
+
+// planRoutes explains why each due route would or would not be dispatched.
+// Routes are skipped in order, so the first allowed of them go out.
+func planRoutes(routes []domain.DueRoute, allowed int, status budget.Status, asOf time.Time) []PlannedRoute {
+	planned := make([]PlannedRoute, 0, len(routes))
+	for i, route := range routes {
+		direction := directionName(route.ToWork)
+		localTime := asOf.Format("Monday 15:04:05")
+		if loc, err := time.LoadLocation(route.TimeZone); err == nil {
+			localTime = asOf.In(loc).Format("Monday 15:04:05")
+		}
+		reason := fmt.Sprintf("%s is inside the %s window %s-%s", localTime, direction, route.WindowStart, route.WindowEnd)
+		if route.BoostedUntil != nil {
+			reason = fmt.Sprintf("%s is boosted after an anomaly until %s", direction, route.BoostedUntil.Format(time.RFC3339))
+		}
+		switch {
+		case i >= allowed && status.Circuit != budget.Closed:
+			reason = fmt.Sprintf("skipped: %s circuit is %s", status.Provider, status.Circuit)
+		case i >= allowed:
+			reason = fmt.Sprintf("skipped: %s budget exhausted", status.Provider)
+		}
+		planned = append(planned, PlannedRoute{
+			RouteID:     route.ID,
+			UserID:      route.UserID,
+			Direction:   direction,
+			TimeZone:    route.TimeZone,
+			LocalTime:   localTime,
+			WindowStart: route.WindowStart,
+			WindowEnd:   route.WindowEnd,
+			Dispatch:    i < allowed,
+			Reason:      reason,
+		})
+	}
+	return planned
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	// Every optimizeRoute invocation from this run logs the same run ID.
+	runID := logging.NewRunID()
+	ctx = logging.WithRunID(ctx, runID)
+
+	asOf := clock.Now(h.Clock)
+	if request.AsOf != nil {
+		asOf = *request.AsOf
+	}
+	slog.InfoContext(ctx, "selecting due routes", "as_of", asOf, "dry_run", request.DryRun)
+
+	var wg sync.WaitGroup
+
+	results := make(chan domain.DueRoute)
+	errors := make(chan error)
+
+	wg.Add(2)
+	go func() {
+		go fetchRoutes(ctx, true, asOf, h.Store.Schedules, &wg, results, errors)
+		go fetchRoutes(ctx, false, asOf, h.Store.Schedules, &wg, results, errors)
+		wg.Wait()
+		close(results)
+		close(errors)
+	}()
+
+	var routes []domain.DueRoute
+	for {
+		select {
+		case route, ok := <-results:
+			if !ok {
+				results = nil
+			} else {
+				routes = append(routes, route)
+			}
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+			} else {
+				slog.ErrorContext(ctx, "error selecting due routes", "error", err)
+			}
+		}
+		if results == nil && errors == nil {
+			break
+		}
+	}
+
+	// Routes boosted after an anomaly are checked even outside their window.
+	boosted, err := h.Store.Boosts.Active(ctx, asOf)
+	if err != nil {
+		slog.ErrorContext(ctx, "error selecting boosted routes", "error", err)
+	}
+	for _, route := range boosted {
+		if !slices.ContainsFunc(routes, func(due domain.DueRoute) bool { return due.ID == route.ID && due.ToWork == route.ToWork }) {
+			routes = append(routes, route)
+		}
+	}
+
+	sink := metrics.Or(h.Metrics)
+	if !request.DryRun {
+		due := map[bool]float64{true: 0, false: 0}
+		for _, route := range routes {
+			due[route.ToWork]++
+		}
+		for toWork, count := range due {
+			sink.Count("routes_due", count, "direction", directionName(toWork))
+		}
+	}
+
+	if len(routes) <= 0 && !request.DryRun {
+		slog.InfoContext(ctx, "no routes due")
+		return Response{}, fmt.Errorf("no rows returned: %d", len(routes))
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].ToWork != routes[j].ToWork {
+			return routes[i].ToWork
+		}
+		return routes[i].ID < routes[j].ID
+	})
+
+	// Routes past the budget, or all of them while the circuit is open, are
+	// skipped until a later run.
+	guard := budget.NewGuard(h.Store.Usage, google.RoutesProvider)
+	guard.Clock = h.Clock
+	allow := guard.Allow
+	if request.DryRun {
+		allow = guard.Peek
+	}
+	allowed, status, err := allow(ctx, len(routes))
+	if err != nil {
+		slog.ErrorContext(ctx, "error checking routing budget", "error", err)
+		return Response{}, fmt.Errorf("error checking routing budget: %v", err)
+	}
+	skipped := len(routes) - allowed
+	if skipped > 0 {
+		slog.WarnContext(ctx, "skipping routes", "skipped", skipped, "due", len(routes), "circuit", status.Circuit)
+	}
+
+	if request.DryRun {
+		data := Data{
+			Processed: allowed,
+			Skipped:   skipped,
+			Budget:    status,
+			DryRun:    true,
+			RunID:     runID,
+			Routes:    planRoutes(routes, allowed, status, asOf),
+		}
+		return Response{"Dry run complete. No routes were dispatched.", data}, nil
+	}
+	if skipped > 0 {
+		sink.Count("routes_skipped", float64(skipped), "circuit", status.Circuit)
+	}
+	h.recordFreshness(ctx, sink, routes, asOf)
+	routes = routes[:allowed]
+
+	resultChan := make(chan error, len(routes))
+	succeeded := 0
+	failed := 0
+
+	wg.Add(len(routes))
+	for _, route := range routes {
+		go processRoute(ctx, route, h.Dispatcher, sink, resultChan, &wg)
+	}
+
+	wg.Wait()
+	close(resultChan)
+
+	for err := range resultChan {
+		if err != nil {
+			failed++
+		} else {
+			succeeded++
+		}
+	}
+
+	lambdaResponse := Data{
+		Processed: len(routes),
+		Suceeded:  succeeded,
+		Failed:    failed,
+		Skipped:   skipped,
+		Budget:    status,
+		RunID:     runID,
+	}
+	slog.InfoContext(ctx, "commutes requests complete", "due", len(routes)+skipped, "succeeded", succeeded, "failed", failed, "skipped", skipped)
+	return Response{"Commutes Requests Complete.", lambdaResponse}, nil
+}
@@ -0,0 +1,309 @@
+package commutesqueue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+// tuesdayMorning falls inside the morning window of every seeded route.
+var tuesdayMorning = time.Date(2024, 9, 3, 7, 45, 0, 0, denver)
+
+type recordingDispatcher struct {
+	mu       sync.Mutex
+	requests []domain.OptimizeRouteRequest
+	err      error
+}
+
+func (d *recordingDispatcher) Dispatch(ctx context.Context, request domain.OptimizeRouteRequest) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests = append(d.requests, request)
+	return d.err
+}
+
+// newHandler seeds routes commuting Monday to Friday, to work from 07:00 to
+// 09:00 and home from 16:00 to 18:00, with the clock on Tuesday morning.
+func newHandler(t *testing.T, routes int) (*Handler, *store.Memory, *recordingDispatcher) {
+	t.Helper()
+	db, memory := store.NewMemory()
+	for id := 1; id <= routes; id++ {
+		memory.Routes[id] = domain.Route{
+			ID:             id,
+			UserID:         3,
+			StartLatitude:  "40.01499",
+			StartLongitude: "-105.27055",
+			EndLatitude:    "39.73915",
+			EndLongitude:   "-104.9847",
+			Active:         true,
+			TimeZone:       "America/Denver",
+		}
+		memory.Schedules[id] = domain.Schedule{
+			RouteID:            id,
+			MorningStartTime:   "07:00:00",
+			MorningEndTime:     "09:00:00",
+			AfternoonStartTime: "16:00:00",
+			AfternoonEndTime:   "18:00:00",
+			Monday:             true,
+			Tuesday:            true,
+			Wednesday:          true,
+			Thursday:           true,
+			Friday:             true,
+		}
+	}
+	dispatcher := &recordingDispatcher{}
+	handler := &Handler{Store: db, Dispatcher: dispatcher, Clock: clock.Fixed(tuesdayMorning)}
+	return handler, memory, dispatcher
+}
+
+func TestHandleRequestDispatchesInDirectionOfTravel(t *testing.T) {
+	tests := []struct {
+		name   string
+		at     time.Time
+		toWork bool
+	}{
+		{"morning", tuesdayMorning, true},
+		{"afternoon", time.Date(2024, 9, 3, 17, 15, 0, 0, denver), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, _, dispatcher := newHandler(t, 1)
+			handler.Clock = clock.Fixed(test.at)
+
+			response, err := handler.HandleRequest(context.Background(), Request{})
+			if err != nil {
+				t.Fatalf("HandleRequest: %v", err)
+			}
+			if response.Data.Processed != 1 || response.Data.Suceeded != 1 || response.Data.Failed != 0 {
+				t.Errorf("unexpected counts: %+v", response.Data)
+			}
+			if response.Data.Budget.Circuit != budget.Closed || response.Data.Budget.DailyRemaining != nil {
+				t.Errorf("unexpected budget: %+v", response.Data.Budget)
+			}
+			request := dispatcher.requests[0]
+			if request.RunID == "" || request.RunID != response.Data.RunID {
+				t.Errorf("dispatched run_id %q, response run_id %q", request.RunID, response.Data.RunID)
+			}
+			if *request.Route != 1 || *request.UserID != 3 || *request.ToWork != test.toWork {
+				t.Errorf("dispatched route %d for user %d with to_work=%v, want route 1 for user 3 with to_work=%v",
+					*request.Route, *request.UserID, *request.ToWork, test.toWork)
+			}
+		})
+	}
+}
+
+func TestHandleRequestAsOf(t *testing.T) {
+	handler, _, dispatcher := newHandler(t, 1)
+	handler.Clock = clock.Fixed(time.Date(2024, 9, 7, 12, 0, 0, 0, denver))
+
+	if _, err := handler.HandleRequest(context.Background(), Request{}); err == nil {
+		t.Fatal("expected no routes due on Saturday")
+	}
+
+	asOf := tuesdayMorning
+	if _, err := handler.HandleRequest(context.Background(), Request{AsOf: &asOf}); err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if len(dispatcher.requests) != 1 {
+		t.Errorf("dispatched %d routes, want 1", len(dispatcher.requests))
+	}
+}
+
+func TestHandleRequestDispatchesBoostedRoutes(t *testing.T) {
+	handler, memory, dispatcher := newHandler(t, 2)
+	// Route 1 is boosted past its morning window, and route 2 inside it.
+	schedule := memory.Schedules[1]
+	schedule.MorningEndTime = "08:00:00"
+	memory.Schedules[1] = schedule
+	memory.Boosts = []domain.Boost{
+		{RouteID: 1, ToWork: true, Until: tuesdayMorning.Add(2 * time.Hour)},
+		{RouteID: 2, ToWork: true, Until: tuesdayMorning.Add(2 * time.Hour)},
+		{RouteID: 2, ToWork: false, Until: tuesdayMorning.Add(-time.Hour)},
+	}
+	handler.Clock = clock.Fixed(tuesdayMorning.Add(time.Hour))
+
+	response, err := handler.HandleRequest(context.Background(), Request{DryRun: true})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	routes := response.Data.Routes
+	if len(routes) != 2 || routes[0].RouteID != 1 || !strings.HasPrefix(routes[0].Reason, "to_work is boosted after an anomaly") ||
+		routes[1].RouteID != 2 || !strings.Contains(routes[1].Reason, "inside the to_work window") {
+		t.Errorf("unexpected plan: %+v", routes)
+	}
+
+	// Once the boost lapses, only the scheduled route is due.
+	handler.Clock = clock.Fixed(tuesdayMorning.Add(3 * time.Hour))
+	if _, err := handler.HandleRequest(context.Background(), Request{}); err == nil {
+		t.Error("expected no routes due after the boost and window")
+	}
+	if len(dispatcher.requests) != 0 {
+		t.Errorf("dispatched %d routes, want 0", len(dispatcher.requests))
+	}
+}
+
+func TestHandleRequestCountsFailures(t *testing.T) {
+	handler, _, dispatcher := newHandler(t, 2)
+	dispatcher.err = errors.New("boom")
+
+	response, err := handler.HandleRequest(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if response.Data.Failed != 2 || response.Data.Suceeded != 0 {
+		t.Errorf("unexpected counts: %+v", response.Data)
+	}
+}
+
+func TestHandleRequestNoDueRoutes(t *testing.T) {
+	handler, memory, _ := newHandler(t, 1)
+	route := memory.Routes[1]
+	route.Active = false
+	memory.Routes[1] = route
+
+	if _, err := handler.HandleRequest(context.Background(), Request{}); err == nil {
+		t.Fatal("expected an error when no routes are due")
+	}
+}
+
+func TestHandleRequestStopsAtBudget(t *testing.T) {
+	handler, memory, dispatcher := newHandler(t, 2)
+	limit := 5
+	memory.Budgets[google.RoutesProvider] = domain.Budget{Provider: google.RoutesProvider, DailyLimit: &limit}
+	handler.Store.Usage.Record(context.Background(), google.RoutesProvider, tuesdayMorning, domain.Usage{Requests: 4, Units: 4})
+
+	response, err := handler.HandleRequest(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if len(dispatcher.requests) != 1 || response.Data.Processed != 1 || response.Data.Skipped != 1 {
+		t.Errorf("dispatched %d, data %+v; want 1 dispatched and 1 skipped", len(dispatcher.requests), response.Data)
+	}
+	status := response.Data.Budget
+	if status.DailyLimit == nil || *status.DailyLimit != 5 || status.DailyRemaining == nil || *status.DailyRemaining != 0 {
+		t.Errorf("unexpected budget: %+v", status)
+	}
+}
+
+func TestHandleRequestPausesWhenCircuitOpens(t *testing.T) {
+	handler, _, dispatcher := newHandler(t, 2)
+	handler.Store.Usage.Record(context.Background(), google.RoutesProvider, tuesdayMorning.Add(-time.Minute), domain.Usage{Requests: 10, Failures: 8})
+
+	response, err := handler.HandleRequest(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if len(dispatcher.requests) != 0 || response.Data.Skipped != 2 {
+		t.Errorf("dispatched %d, data %+v; want everything skipped", len(dispatcher.requests), response.Data)
+	}
+	if response.Data.Budget.Circuit != budget.Open {
+		t.Errorf("circuit = %q, want open", response.Data.Budget.Circuit)
+	}
+}
+
+func TestHandleRequestDryRun(t *testing.T) {
+	handler, memory, dispatcher := newHandler(t, 2)
+	limit := 1
+	memory.Budgets[google.RoutesProvider] = domain.Budget{Provider: google.RoutesProvider, DailyLimit: &limit}
+
+	response, err := handler.HandleRequest(context.Background(), Request{DryRun: true})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if len(dispatcher.requests) != 0 {
+		t.Errorf("dispatched %d routes during a dry run", len(dispatcher.requests))
+	}
+	data := response.Data
+	if !data.DryRun || data.Processed != 1 || data.Skipped != 1 || len(data.Routes) != 2 {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+	want := PlannedRoute{
+		RouteID:     1,
+		UserID:      3,
+		Direction:   "to_work",
+		TimeZone:    "America/Denver",
+		LocalTime:   "Tuesday 07:45:00",
+		WindowStart: "07:00:00",
+		WindowEnd:   "09:00:00",
+		Dispatch:    true,
+		Reason:      "Tuesday 07:45:00 is inside the to_work window 07:00:00-09:00:00",
+	}
+	if data.Routes[0] != want {
+		t.Errorf("got %+v, want %+v", data.Routes[0], want)
+	}
+	if skipped := data.Routes[1]; skipped.Dispatch || skipped.Reason != "skipped: google_routes budget exhausted" {
+		t.Errorf("unexpected skipped route: %+v", skipped)
+	}
+}
+
+func TestHandleRequestDryRunLeavesCircuitClosed(t *testing.T) {
+	handler, memory, _ := newHandler(t, 1)
+	handler.Store.Usage.Record(context.Background(), google.RoutesProvider, tuesdayMorning.Add(-time.Minute), domain.Usage{Requests: 10, Failures: 8})
+
+	response, err := handler.HandleRequest(context.Background(), Request{DryRun: true})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if response.Data.Budget.Circuit != budget.Open || response.Data.Routes[0].Reason != "skipped: google_routes circuit is open" {
+		t.Errorf("unexpected data: %+v", response.Data)
+	}
+	if _, ok := memory.Circuits[google.RoutesProvider]; ok {
+		t.Error("dry run opened the circuit")
+	}
+}
+
+func TestHandleRequestDryRunWithNoDueRoutes(t *testing.T) {
+	handler, _, _ := newHandler(t, 1)
+	handler.Clock = clock.Fixed(time.Date(2024, 9, 7, 12, 0, 0, 0, denver))
+
+	response, err := handler.HandleRequest(context.Background(), Request{DryRun: true})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if response.Data.Processed != 0 || len(response.Data.Routes) != 0 {
+		t.Errorf("unexpected data: %+v", response.Data)
+	}
+}
+
+func TestHandleRequestEmitsMetrics(t *testing.T) {
+	handler, memory, dispatcher := newHandler(t, 2)
+	sink := metrics.NewPrometheus()
+	handler.Metrics = sink
+	dispatcher.err = fmt.Errorf("invoking optimizeRoute: %w", google.ErrQuota)
+	memory.Commutes = append(memory.Commutes,
+		domain.Commute{Route: 1, QueryTime: tuesdayMorning.Add(-time.Hour)},
+		domain.Commute{Route: 1, QueryTime: tuesdayMorning.Add(-time.Minute), AdHoc: true})
+
+	if _, err := handler.HandleRequest(context.Background(), Request{}); err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+
+	var buf bytes.Buffer
+	sink.WriteTo(&buf)
+	for _, line := range []string{
+		`optimize_route_routes_due_total{direction="to_work"} 2`,
+		`optimize_route_routes_due_total{direction="from_work"} 0`,
+		`optimize_route_routes_dispatched_total 2`,
+		`optimize_route_routes_failed_total{error_class="quota"} 2`,
+		`optimize_route_routes_never_recorded_total 1`,
+		`optimize_route_commute_age_seconds_sum 3600`,
+		`optimize_route_commute_age_seconds_count 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, buf.String())
+		}
+	}
+}
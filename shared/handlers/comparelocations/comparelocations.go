@@ -0,0 +1,276 @@
+package comparelocations
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/whatif"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+const (
+	defaultSamples = 3
+	maxSamples     = 6
+	// MaxCandidates is how many addresses may be given on either side, on top
+	// of the route's own start or end.
+	MaxCandidates = 8
+)
+
+// Request compares the route's current home and work with candidate
+// OriginAddresses and DestinationAddresses, over its schedule. Every pair
+// stops at the route's saved waypoints on the way, as the route does. Samples
+// is the number of departures spread over each window, 3 by default.
+type Request struct {
+	RouteID      *int     `json:"route_id"`
+	Origins      []string `json:"origin_addresses"`
+	Destinations []string `json:"destination_addresses"`
+	Samples      int      `json:"samples"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+// Data lists the candidate homes and workplaces, the route's own first, the
+// stops every pair makes on the way to work, the departures sampled and every
+// pair, fastest round trip first. Nothing is saved.
+type Data struct {
+	RouteID            int           `json:"route_id"`
+	Origins            []Place       `json:"origins"`
+	Destinations       []Place       `json:"destinations"`
+	Stops              []Place       `json:"stops,omitempty"`
+	ToWorkDepartures   []time.Time   `json:"to_work_departures"`
+	FromWorkDepartures []time.Time   `json:"from_work_departures"`
+	Budget             budget.Status `json:"budget"`
+	Pairs              []whatif.Pair `json:"pairs"`
+}
+
+type Place struct {
+	Address  string        `json:"address"`
+	Location domain.LatLng `json:"location"`
+	Current  bool          `json:"current"`
+}
+
+// Handler answers "what would my commute be like from there" for a route's
+// schedule, using the routing provider's route matrix.
+type Handler struct {
+	Store  *store.Store
+	Routes *google.Client
+	// Clock picks the day sampled and defaults to the system clock.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+// places geocodes addresses after the route's current place.
+func (h *Handler) places(ctx context.Context, current Place, addresses []string) ([]Place, error) {
+	places := []Place{current}
+	for _, address := range addresses {
+		if address == "" {
+			return nil, fmt.Errorf("%w: candidate addresses must not be empty", domain.ErrInvalidRequest)
+		}
+		results, err := h.Routes.Geocode(ctx, address)
+		if err != nil {
+			slog.ErrorContext(ctx, "error geocoding address", "error_class", google.ClassName(err), "error", err)
+			return nil, fmt.Errorf("error geocoding address (%s): %w", google.ClassName(err), err)
+		}
+		if len(results) != 1 {
+			slog.WarnContext(ctx, "expected 1 geocode result", "results", len(results))
+			return nil, fmt.Errorf("%w: expected 1 result for %q, got: %d", domain.ErrInvalidRequest, address, len(results))
+		}
+		location := results[0].Geometry.Location
+		places = append(places, Place{
+			Address:  results[0].FormattedAddress,
+			Location: domain.LatLng{Latitude: location.Lat, Longitude: location.Lng},
+		})
+	}
+	return places, nil
+}
+
+// matrixElements is the size of each sample's route matrix: from every origin and
+// stop to every stop and destination.
+func matrixElements(origins, destinations, stops int) int {
+	return (origins + stops) * (stops + destinations)
+}
+
+// sample asks for the drive from every origin to every destination through
+// stops, in driving order, at each departure. Each departure is one matrix
+// from the origins and stops to the stops and destinations, and a pair's
+// drive is the sum of its legs.
+func (h *Handler) sample(ctx context.Context, origins, destinations []Place, stops []domain.LatLng, departures []time.Time) ([]whatif.Matrix, error) {
+	from := make([]domain.LatLng, 0, len(origins)+len(stops))
+	for _, place := range origins {
+		from = append(from, place.Location)
+	}
+	from = append(from, stops...)
+	to := append([]domain.LatLng{}, stops...)
+	for _, place := range destinations {
+		to = append(to, place.Location)
+	}
+
+	matrices := make([]whatif.Matrix, len(departures))
+	for k, departure := range departures {
+		elements, err := h.Routes.ComputeRouteMatrix(ctx, google.NewRouteMatrixRequest(from, to, departure.UTC()))
+		if err != nil {
+			slog.ErrorContext(ctx, "error computing route matrix", "departure", departure, "error_class", google.ClassName(err), "error", err)
+			return nil, fmt.Errorf("error computing route matrix (%s): %w", google.ClassName(err), err)
+		}
+		legs := make(whatif.Matrix, len(from))
+		for i := range legs {
+			legs[i] = make([]*whatif.Drive, len(to))
+		}
+		for _, element := range elements {
+			if !element.Exists() || element.OriginIndex < 0 || element.OriginIndex >= len(from) || element.DestinationIndex < 0 || element.DestinationIndex >= len(to) {
+				continue
+			}
+			seconds, err := element.DurationSeconds()
+			if err != nil {
+				return nil, fmt.Errorf("route matrix element %d to %d: %v", element.OriginIndex, element.DestinationIndex, err)
+			}
+			legs[element.OriginIndex][element.DestinationIndex] = &whatif.Drive{Duration: seconds, Distance: element.DistanceMeters}
+		}
+
+		matrix := make(whatif.Matrix, len(origins))
+		for i := range matrix {
+			matrix[i] = make([]*whatif.Drive, len(destinations))
+			for j := range matrix[i] {
+				matrix[i][j] = chain(legs, i, j, len(origins), len(stops))
+			}
+		}
+		matrices[k] = matrix
+	}
+	return matrices, nil
+}
+
+// chain adds up the legs from origin i through every stop to destination j,
+// or returns nil when one of them has no route.
+func chain(legs whatif.Matrix, i, j, origins, stops int) *whatif.Drive {
+	drive := &whatif.Drive{}
+	at := i
+	for stop := 0; stop <= stops; stop++ {
+		leg := legs[at][stop]
+		if stop == stops {
+			leg = legs[at][stops+j]
+		}
+		if leg == nil {
+			return nil
+		}
+		drive.Duration += leg.Duration
+		drive.Distance += leg.Distance
+		at = origins + stop
+	}
+	return drive
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	ctx = logging.WithRunID(ctx, logging.NewRunID())
+	if h.Routes.APIKey == "" {
+		return Response{}, fmt.Errorf("error loading google maps API key from environment variables")
+	}
+	if request.RouteID == nil {
+		return Response{}, fmt.Errorf("%w. Missing route ID", domain.ErrInvalidRequest)
+	}
+	samples := defaultSamples
+	if request.Samples != 0 {
+		samples = request.Samples
+	}
+	if samples < 1 || samples > maxSamples {
+		return Response{}, fmt.Errorf("%w: samples must be between 1 and %d, got %d", domain.ErrInvalidRequest, maxSamples, samples)
+	}
+	if len(request.Origins)+len(request.Destinations) == 0 {
+		return Response{}, fmt.Errorf("%w: give at least one origin or destination address to compare", domain.ErrInvalidRequest)
+	}
+	if len(request.Origins) > MaxCandidates || len(request.Destinations) > MaxCandidates {
+		return Response{}, fmt.Errorf("%w: at most %d origin and %d destination addresses", domain.ErrInvalidRequest, MaxCandidates, MaxCandidates)
+	}
+
+	route, err := h.Store.Routes.Get(ctx, *request.RouteID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading route", "route_id", *request.RouteID, "error", err)
+		return Response{}, fmt.Errorf("error loading route: %w", err)
+	}
+	schedule, err := h.Store.Schedules.GetByRoute(ctx, route.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading schedule", "route_id", route.ID, "error", err)
+		return Response{}, fmt.Errorf("error loading schedule: %w", err)
+	}
+	loc, err := time.LoadLocation(route.TimeZone)
+	if err != nil {
+		return Response{}, fmt.Errorf("route %d has an invalid time zone %q: %v", route.ID, route.TimeZone, err)
+	}
+	waypoints, err := h.Store.Waypoints.List(ctx, route.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading waypoints", "route_id", route.ID, "error", err)
+		return Response{}, fmt.Errorf("error loading waypoints: %v", err)
+	}
+	trip, err := route.Direction(true)
+	if err == nil {
+		trip, err = trip.Via(waypoints)
+	}
+	if err != nil {
+		return Response{}, fmt.Errorf("error loading route: %v", err)
+	}
+	toWork, fromWork, err := whatif.Departures(schedule, loc, clock.Now(h.Clock), samples)
+	if err != nil {
+		return Response{}, fmt.Errorf("%w: route %d: %v", domain.ErrInvalidRequest, route.ID, err)
+	}
+
+	// Check the budget before geocoding, so a refusal costs nothing. Google
+	// bills every element of every matrix.
+	calls := len(toWork) + len(fromWork)
+	size := matrixElements(1+len(request.Origins), 1+len(request.Destinations), len(waypoints))
+	guard := budget.NewGuard(h.Store.Usage, google.RoutesProvider)
+	guard.Clock = h.Clock
+	status, err := guard.Require(ctx, calls, size)
+	if err != nil {
+		slog.WarnContext(ctx, "refusing to compare locations", "matrices", calls, "elements", size, "circuit", status.Circuit, "error", err)
+		return Response{}, err
+	}
+
+	origins, err := h.places(ctx, Place{Address: route.StartAddress, Location: trip.Origin, Current: true}, request.Origins)
+	if err != nil {
+		return Response{}, err
+	}
+	destinations, err := h.places(ctx, Place{Address: route.EndAddress, Location: trip.Destination, Current: true}, request.Destinations)
+	if err != nil {
+		return Response{}, err
+	}
+	stops := make([]Place, len(waypoints))
+	for i, waypoint := range waypoints {
+		stops[i] = Place{Address: waypoint.Address, Location: trip.Waypoints[i], Current: true}
+	}
+	homeward := slices.Clone(trip.Waypoints)
+	slices.Reverse(homeward)
+	toWorkMatrices, err := h.sample(ctx, origins, destinations, trip.Waypoints, toWork)
+	if err != nil {
+		return Response{}, err
+	}
+	fromWorkMatrices, err := h.sample(ctx, destinations, origins, homeward, fromWork)
+	if err != nil {
+		return Response{}, err
+	}
+
+	data := Data{
+		RouteID:            route.ID,
+		Origins:            origins,
+		Destinations:       destinations,
+		Stops:              stops,
+		ToWorkDepartures:   toWork,
+		FromWorkDepartures: fromWork,
+		Budget:             status,
+		Pairs:              whatif.Compare(len(origins), len(destinations), toWorkMatrices, fromWorkMatrices, whatif.Days(schedule)),
+	}
+	sink := metrics.Or(h.Metrics)
+	sink.Count("location_pairs_compared", float64(len(data.Pairs)))
+	slog.InfoContext(ctx, "compared locations", "route_id", route.ID, "origins", len(origins), "destinations", len(destinations), "samples", samples)
+	return Response{fmt.Sprintf("Compared %d home and work pairs over %d departures each way.", len(data.Pairs), samples), data}, nil
+}
@@ -0,0 +1,168 @@
+package comparelocations
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google/fakegoogle"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+// tuesdayNight is after every window, so Wednesday's are sampled.
+var tuesdayNight = time.Date(2024, 9, 3, 21, 0, 0, 0, denver)
+
+// newHandler seeds route 7 from Boulder to downtown Denver on weekdays.
+func newHandler(t *testing.T) (*Handler, *store.Memory, *fakegoogle.Server) {
+	t.Helper()
+	fake := fakegoogle.NewDefault()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := google.NewClient("test-key")
+	client.SetBaseURL(server.URL)
+	client.Retry = google.RetryPolicy{MaxAttempts: 1}
+
+	db, memory := store.NewMemory()
+	memory.Routes[7] = domain.Route{
+		ID: 7, UserID: 3, Active: true, TimeZone: "America/Denver",
+		StartAddress: "1777 Broadway, Boulder, CO 80302, USA", StartLatitude: "40.01499", StartLongitude: "-105.27055",
+		EndAddress: "1437 Bannock St, Denver, CO 80202, USA", EndLatitude: "39.73915", EndLongitude: "-104.9847",
+	}
+	memory.Schedules[7] = domain.Schedule{
+		RouteID:            7,
+		MorningStartTime:   "07:00:00",
+		MorningEndTime:     "08:30:00",
+		AfternoonStartTime: "16:30:00",
+		AfternoonEndTime:   "18:00:00",
+		Monday:             true, Tuesday: true, Wednesday: true, Thursday: true, Friday: true,
+	}
+	return &Handler{Store: db, Routes: client, Clock: clock.Fixed(tuesdayNight)}, memory, fake
+}
+
+func TestHandleRequestComparesCandidates(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	routeID := 7
+
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, Origins: []string{"3901 W 112th Ave, Westminster, CO"}})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if len(data.Origins) != 2 || !data.Origins[0].Current || data.Origins[1].Address != "3901 W 112th Ave, Westminster, CO 80031, USA" || len(data.Destinations) != 1 {
+		t.Fatalf("unexpected places: %+v %+v", data.Origins, data.Destinations)
+	}
+	if len(data.ToWorkDepartures) != 3 || data.ToWorkDepartures[1].In(denver).Format("Mon 15:04") != "Wed 07:45" {
+		t.Errorf("to work departures = %v", data.ToWorkDepartures)
+	}
+	if len(data.Pairs) != 2 {
+		t.Fatalf("got %d pairs", len(data.Pairs))
+	}
+	// Westminster is closer to downtown than Boulder.
+	best, current := data.Pairs[0], data.Pairs[1]
+	if best.Origin != 1 || !best.Complete() || best.ToWork.Samples != 3 || best.Change == nil || *best.Change >= 0 || best.Weekly != 5*best.Daily {
+		t.Errorf("unexpected best pair: %+v", best)
+	}
+	if current.Origin != 0 || current.Change != nil || *best.Change != best.Daily-current.Daily {
+		t.Errorf("unexpected current pair: %+v", current)
+	}
+
+	requests := fake.MatrixRequests()
+	if len(requests) != 6 {
+		t.Fatalf("made %d matrix requests, want 6", len(requests))
+	}
+	if home := requests[3]; len(home.Origins) != 1 || len(home.Destinations) != 2 {
+		t.Errorf("unexpected from work request: %+v", home)
+	}
+	if len(memory.Routes) != 1 || len(fake.RouteRequests()) != 0 {
+		t.Error("comparing locations should not save routes")
+	}
+	if response.Message != "Compared 2 home and work pairs over 3 departures each way." {
+		t.Errorf("message = %q", response.Message)
+	}
+}
+
+func TestHandleRequestVisitsRouteStops(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	routeID := 7
+	request := Request{RouteID: &routeID, Origins: []string{"3901 W 112th Ave, Westminster, CO"}, Samples: 1}
+	direct, err := handler.HandleRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+
+	// A stop well east of both homes makes every commute longer.
+	memory.Waypoints[7] = []domain.Waypoint{{RouteID: 7, Position: 1, Address: "Daycare", Latitude: "39.9", Longitude: "-104.8"}}
+	response, err := handler.HandleRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if len(data.Stops) != 1 || data.Stops[0].Address != "Daycare" {
+		t.Errorf("stops = %+v", data.Stops)
+	}
+	before := map[int]int{}
+	for _, pair := range direct.Data.Pairs {
+		before[pair.Origin] = pair.Daily
+	}
+	for _, pair := range data.Pairs {
+		if pair.Daily <= before[pair.Origin] {
+			t.Errorf("pair %+v is not longer than %d s without the stop", pair, before[pair.Origin])
+		}
+	}
+	// Origins and the stop to the stop and the destination.
+	requests := fake.MatrixRequests()
+	if last := requests[len(requests)-1]; len(last.Origins) != 2 || len(last.Destinations) != 3 {
+		t.Errorf("unexpected from work request: %d by %d", len(last.Origins), len(last.Destinations))
+	}
+	if toWork := requests[len(requests)-2]; len(toWork.Origins) != 3 || len(toWork.Destinations) != 2 {
+		t.Errorf("unexpected to work request: %d by %d", len(toWork.Origins), len(toWork.Destinations))
+	}
+}
+
+func TestHandleRequestRespectsBudget(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	// Six 2 by 1 matrices are billed as 12 requests.
+	limit := 11
+	memory.Budgets[google.RoutesProvider] = domain.Budget{Provider: google.RoutesProvider, DailyLimit: &limit}
+	routeID := 7
+
+	_, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, Origins: []string{"3901 W 112th Ave, Westminster, CO"}})
+	if !errors.Is(err, google.ErrQuota) {
+		t.Fatalf("got %v, want ErrQuota", err)
+	}
+	if fake.GeocodeCalls() != 0 || len(fake.MatrixRequests()) != 0 {
+		t.Error("called Google without the budget")
+	}
+}
+
+func TestHandleRequestValidation(t *testing.T) {
+	handler, _, _ := newHandler(t)
+	routeID, missingRoute := 7, 99
+	westminster := []string{"3901 W 112th Ave, Westminster, CO"}
+	tooMany := make([]string, MaxCandidates+1)
+	for i := range tooMany {
+		tooMany[i] = westminster[0]
+	}
+
+	for name, request := range map[string]Request{
+		"no route":        {Origins: westminster},
+		"no candidates":   {RouteID: &routeID},
+		"too many":        {RouteID: &routeID, Destinations: tooMany},
+		"samples":         {RouteID: &routeID, Origins: westminster, Samples: maxSamples + 1},
+		"ambiguous":       {RouteID: &routeID, Origins: []string{"Springfield"}},
+		"empty candidate": {RouteID: &routeID, Destinations: []string{""}},
+	} {
+		if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, domain.ErrInvalidRequest) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &missingRoute, Origins: westminster}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("unknown route: got %v", err)
+	}
+}
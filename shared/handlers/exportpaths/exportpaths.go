@@ -0,0 +1,213 @@
+package exportpaths
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/geoexport"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/pathhistory"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/polyline"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	// MaxTracks keeps the export well inside Lambda's 6 MB response limit. It
+	// is shared between the directions exported.
+	MaxTracks = 100
+	// maxContent is the most the rendered file may take, leaving room for
+	// the JSON escaping around it.
+	maxContent = 4 << 20
+)
+
+// Request exports the scheduled commutes one route recorded over the last
+// Days days (30 by default), in one direction or, when ToWork is unset, both.
+// From and To ("HH:MM" or "HH:MM:SS" in the route's time zone) keep only the
+// commutes that left in that slot, From included and To not. Fastest keeps
+// the fastest N commutes of each direction, fastest first; otherwise commutes
+// are in the order they were recorded. At most MaxTracks commutes are
+// exported, split between the directions: the latest ones, or the fastest
+// with Fastest. Format is "geojson" (the default), "gpx" or "kml".
+type Request struct {
+	RouteID *int   `json:"route_id"`
+	ToWork  *bool  `json:"to_work"`
+	Days    *int   `json:"days"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Fastest int    `json:"fastest"`
+	Format  string `json:"format"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+// Data is the rendered file. Matched is how many commutes were in the period
+// and slot, and Truncated is true when the older or slower ones past
+// MaxTracks were left out.
+type Data struct {
+	RouteID   int       `json:"route_id"`
+	Format    string    `json:"format"`
+	Since     time.Time `json:"since"`
+	Commutes  int       `json:"commutes"`
+	Matched   int       `json:"matched"`
+	Truncated bool      `json:"truncated"`
+	Content   string    `json:"content"`
+}
+
+// Handler exports the paths a route's commutes took for viewing in a map.
+type Handler struct {
+	Store *store.Store
+	// Clock defaults to the system clock when nil.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+// timeOfDay is how far t's clock reading is past midnight.
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse(time.TimeOnly, value)
+	if err != nil {
+		if t, err = time.Parse("15:04", value); err != nil {
+			return 0, err
+		}
+	}
+	return timeOfDay(t), nil
+}
+
+// slot parses the request's From and To. A missing From is midnight and a
+// missing To the end of the day.
+func slot(request Request) (time.Duration, time.Duration, error) {
+	from, to := time.Duration(0), 24*time.Hour
+	var err error
+	if request.From != "" {
+		if from, err = parseTimeOfDay(request.From); err != nil {
+			return 0, 0, fmt.Errorf("%w: from must be HH:MM or HH:MM:SS, got %q", domain.ErrInvalidRequest, request.From)
+		}
+	}
+	if request.To != "" {
+		if to, err = parseTimeOfDay(request.To); err != nil {
+			return 0, 0, fmt.Errorf("%w: to must be HH:MM or HH:MM:SS, got %q", domain.ErrInvalidRequest, request.To)
+		}
+	}
+	if from >= to {
+		return 0, 0, fmt.Errorf("%w: from %s is not before to %s", domain.ErrInvalidRequest, request.From, request.To)
+	}
+	return from, to, nil
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	ctx = logging.WithRunID(ctx, logging.NewRunID())
+	format := request.Format
+	if format == "" {
+		format = "geojson"
+	}
+	if !slices.Contains(geoexport.Formats, format) {
+		return Response{}, fmt.Errorf("%w: format must be one of %v, got %q", domain.ErrInvalidRequest, geoexport.Formats, format)
+	}
+	if request.Fastest < 0 {
+		return Response{}, fmt.Errorf("%w: fastest must not be negative, got %d", domain.ErrInvalidRequest, request.Fastest)
+	}
+	from, to, err := slot(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	period, err := pathhistory.Open(ctx, h.Store, pathhistory.Query{RouteID: request.RouteID, ToWork: request.ToWork, Days: request.Days}, clock.Now(h.Clock))
+	if err != nil {
+		return Response{}, err
+	}
+	route := period.Route
+	loc, err := time.LoadLocation(route.TimeZone)
+	if err != nil {
+		return Response{}, fmt.Errorf("route %d has an invalid time zone %q: %v", route.ID, route.TimeZone, err)
+	}
+
+	limit := MaxTracks / len(period.Directions)
+	matched, truncated := 0, false
+	var tracks []geoexport.Track
+	for _, toWork := range period.Directions {
+		commutes, err := period.Commutes(ctx, h.Store, toWork)
+		if err != nil {
+			return Response{}, err
+		}
+		_, labels, err := period.Paths(ctx, h.Store, toWork)
+		if err != nil {
+			return Response{}, err
+		}
+
+		var selected []geoexport.Track
+		for _, commute := range commutes {
+			start := commute.QueryTime.In(loc)
+			if offset := timeOfDay(start); offset < from || offset >= to || commute.RouteHash == "" {
+				continue
+			}
+			points, err := polyline.Decode(commute.RouteHash)
+			if err != nil {
+				slog.WarnContext(ctx, "skipping commute with an invalid polyline", "commute_id", commute.ID, "error", err)
+				continue
+			}
+			track := geoexport.Track{
+				CommuteID: commute.ID,
+				RouteID:   route.ID,
+				ToWork:    toWork,
+				Start:     start,
+				Duration:  commute.Duration,
+				Distance:  commute.Distance,
+				Points:    points,
+			}
+			if commute.PathID != nil {
+				track.Path = labels[*commute.PathID]
+			}
+			selected = append(selected, track)
+		}
+		matched += len(selected)
+		wanted := len(selected)
+		if request.Fastest > 0 {
+			sort.SliceStable(selected, func(i, j int) bool { return selected[i].Duration < selected[j].Duration })
+			wanted = min(request.Fastest, wanted)
+		}
+		if wanted > limit {
+			wanted, truncated = limit, true
+		}
+		if request.Fastest > 0 {
+			selected = selected[:wanted]
+		} else {
+			selected = selected[len(selected)-wanted:]
+		}
+		tracks = append(tracks, selected...)
+	}
+
+	content, err := geoexport.Render(fmt.Sprintf("Route %d commutes since %s", route.ID, period.Since.In(loc).Format(time.DateOnly)), tracks, format)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(content) > maxContent {
+		slog.WarnContext(ctx, "export is too large", "route_id", route.ID, "commutes", len(tracks), "bytes", len(content))
+		return Response{}, fmt.Errorf("%w: %d commutes take %d bytes as %s, over the %d byte limit; ask for fewer days, a narrower slot or the fastest few",
+			domain.ErrInvalidRequest, len(tracks), len(content), format, maxContent)
+	}
+	metrics.Or(h.Metrics).Count("commutes_exported", float64(len(tracks)), "format", format)
+	slog.InfoContext(ctx, "exported commutes", "route_id", route.ID, "format", format, "commutes", len(tracks), "matched", matched)
+	data := Data{RouteID: route.ID, Format: format, Since: period.Since, Commutes: len(tracks), Matched: matched, Truncated: truncated, Content: content}
+	message := fmt.Sprintf("Exported %d commutes of route %d as %s.", len(tracks), route.ID, format)
+	if truncated {
+		kept := "latest"
+		if request.Fastest > 0 {
+			kept = "fastest"
+		}
+		message = fmt.Sprintf("Exported the %s %d of %d commutes of route %d as %s.", kept, len(tracks), matched, route.ID, format)
+	}
+	return Response{message, data}, nil
+}
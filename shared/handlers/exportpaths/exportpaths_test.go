@@ -0,0 +1,198 @@
+package exportpaths
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/polyline"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+var (
+	origin      = domain.LatLng{Latitude: 40.01499, Longitude: -105.27055}
+	destination = domain.LatLng{Latitude: 39.73915, Longitude: -104.9847}
+	direct      = polyline.Encode([]domain.LatLng{origin, destination})
+)
+
+type properties struct {
+	CommuteID int    `json:"commute_id"`
+	Direction string `json:"direction"`
+	Path      string `json:"path"`
+	LocalTime string `json:"local_time"`
+	Duration  int    `json:"duration"`
+}
+
+func newHandler(t *testing.T) (*Handler, *store.Memory) {
+	t.Helper()
+	db, memory := store.NewMemory()
+	memory.Routes[7] = domain.Route{ID: 7, UserID: 3, Active: true, TimeZone: "America/Denver"}
+	memory.Paths = []domain.RoutePath{{ID: 1, RouteID: 7, ToWork: true, Fingerprint: "aaaa", Label: "path 1"}}
+	pathID := 1
+	// Mornings at 07:30, 08:00 and 08:30 on Monday and Tuesday, one evening,
+	// an ad hoc check and a commute without a polyline.
+	id := 100
+	add := func(commute domain.Commute) {
+		id++
+		commute.ID, commute.Route, commute.UserID = id, 7, 3
+		if commute.RouteHash == "" && !commute.AdHoc {
+			commute.RouteHash = direct
+		}
+		memory.Commutes = append(memory.Commutes, commute)
+	}
+	for _, day := range []int{2, 3} {
+		for i, minute := range []int{30, 60, 90} {
+			add(domain.Commute{ToWork: true, QueryTime: time.Date(2024, 9, day, 7, minute, 0, 0, denver), Duration: 2000 + 100*i - 10*day, PathID: &pathID})
+		}
+	}
+	add(domain.Commute{ToWork: false, QueryTime: time.Date(2024, 9, 2, 17, 0, 0, 0, denver), Duration: 1900})
+	add(domain.Commute{ToWork: true, AdHoc: true, RouteHash: direct, QueryTime: time.Date(2024, 9, 3, 7, 45, 0, 0, denver), Duration: 1000})
+	add(domain.Commute{ToWork: true, QueryTime: time.Date(2024, 9, 3, 7, 50, 0, 0, denver), Duration: 1000, RouteHash: "!"})
+	return &Handler{Store: db, Clock: clock.Fixed(time.Date(2024, 9, 4, 12, 0, 0, 0, denver))}, memory
+}
+
+func features(t *testing.T, content string) []properties {
+	t.Helper()
+	var collection struct {
+		Features []struct {
+			Properties properties `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal([]byte(content), &collection); err != nil {
+		t.Fatalf("decoding %s: %v", content, err)
+	}
+	var found []properties
+	for _, feature := range collection.Features {
+		found = append(found, feature.Properties)
+	}
+	return found
+}
+
+func TestHandleRequestFiltersBySlot(t *testing.T) {
+	handler, _ := newHandler(t)
+	routeID, toWork := 7, true
+
+	// 08:00 to 08:30 keeps only the 08:00 commutes; the commute at 07:50
+	// has an invalid polyline and is skipped.
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, ToWork: &toWork, From: "07:50", To: "08:30"})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	found := features(t, response.Data.Content)
+	if response.Data.Commutes != 2 || len(found) != 2 {
+		t.Fatalf("exported %+v", found)
+	}
+	for _, feature := range found {
+		if feature.LocalTime != "08:00" || feature.Direction != "to_work" || feature.Path != "path 1" {
+			t.Errorf("unexpected feature: %+v", feature)
+		}
+	}
+	if response.Message != "Exported 2 commutes of route 7 as geojson." {
+		t.Errorf("message = %q", response.Message)
+	}
+}
+
+func TestHandleRequestFastest(t *testing.T) {
+	handler, _ := newHandler(t)
+	routeID := 7
+
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, Fastest: 2})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	found := features(t, response.Data.Content)
+	// The two fastest mornings, both at 07:30, then the only evening.
+	if len(found) != 3 || found[0].Duration != 1970 || found[1].Duration != 1980 || found[2].Direction != "from_work" || found[2].Path != "" {
+		t.Errorf("exported %+v", found)
+	}
+}
+
+func TestHandleRequestCapsTracks(t *testing.T) {
+	handler, memory := newHandler(t)
+	routeID, toWork := 7, true
+	for i := range MaxTracks {
+		memory.Commutes = append(memory.Commutes, domain.Commute{
+			ID: 1000 + i, Route: 7, UserID: 3, ToWork: true, RouteHash: direct,
+			QueryTime: time.Date(2024, 9, 4, 7, 0, i, 0, denver), Duration: 1500 + i,
+		})
+	}
+
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, ToWork: &toWork})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if data.Commutes != MaxTracks || data.Matched != MaxTracks+6 || !data.Truncated {
+		t.Errorf("exported %d of %d, truncated %v", data.Commutes, data.Matched, data.Truncated)
+	}
+	if found := features(t, data.Content); found[0].CommuteID != 1000 {
+		t.Errorf("kept %+v first, want the latest commutes", found[0])
+	}
+
+	// Both directions share the cap; the evening's one commute is kept.
+	response, err = handler.HandleRequest(context.Background(), Request{RouteID: &routeID, Fastest: MaxTracks})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if data := response.Data; data.Commutes != MaxTracks/2+1 || !data.Truncated || !strings.HasPrefix(response.Message, "Exported the fastest 51 of") {
+		t.Errorf("exported %d, truncated %v: %q", data.Commutes, data.Truncated, response.Message)
+	}
+}
+
+func TestHandleRequestRefusesLargeExports(t *testing.T) {
+	handler, memory := newHandler(t)
+	routeID, toWork := 7, false
+	// Zigzagging every few meters makes a polyline of about 200,000 points.
+	points := make([]domain.LatLng, 200000)
+	for i := range points {
+		points[i] = domain.LatLng{Latitude: 39.7 + float64(i%2)/10000, Longitude: -105 + float64(i)/100000}
+	}
+	memory.Commutes = append(memory.Commutes, domain.Commute{
+		ID: 1000, Route: 7, UserID: 3, RouteHash: polyline.Encode(points), QueryTime: time.Date(2024, 9, 3, 17, 0, 0, 0, denver), Duration: 1800,
+	})
+
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, ToWork: &toWork}); !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("got %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestHandleRequestFormats(t *testing.T) {
+	handler, _ := newHandler(t)
+	routeID, toWork := 7, false
+	for format, want := range map[string]string{"gpx": "<gpx ", "kml": "<kml "} {
+		response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, ToWork: &toWork, Format: format})
+		if err != nil {
+			t.Fatalf("HandleRequest(%s): %v", format, err)
+		}
+		if !strings.Contains(response.Data.Content, want) || !strings.Contains(response.Data.Content, "Route 7 from work, Mon 2 Sep 17:00") {
+			t.Errorf("unexpected %s:\n%s", format, response.Data.Content)
+		}
+	}
+}
+
+func TestHandleRequestValidation(t *testing.T) {
+	handler, _ := newHandler(t)
+	routeID, missingID, days := 7, 8, 400
+	tests := map[string]Request{
+		"missing route":  {},
+		"too many days":  {RouteID: &routeID, Days: &days},
+		"unknown format": {RouteID: &routeID, Format: "shp"},
+		"negative count": {RouteID: &routeID, Fastest: -1},
+		"bad slot":       {RouteID: &routeID, From: "7am"},
+		"empty slot":     {RouteID: &routeID, From: "09:00", To: "08:00"},
+	}
+	for name, request := range tests {
+		if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, domain.ErrInvalidRequest) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &missingID}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("unknown route: got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"math"
+	"time"
+)
+
+const (
+	defaultDays        = 7
+	maxDays            = 90
+	defaultMinCoverage = 0.5
+)
+
+// Request looks back Days days (7 by default) from AsOf (now by default). A
+// schedule window that recorded fewer than MinCoverage (0.5 by default) of
+// its expected commutes is a gap.
+type Request struct {
+	Days        *int       `json:"days"`
+	MinCoverage *float64   `json:"min_coverage"`
+	AsOf        *time.Time `json:"as_of"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+type Data struct {
+	AsOf    time.Time     `json:"as_of"`
+	Since   time.Time     `json:"since"`
+	Checked int           `json:"routes_checked"`
+	Stale   int           `json:"routes_stale"`
+	Routes  []RouteHealth `json:"routes"`
+}
+
+// RouteHealth compares the commutes a route's schedule should have recorded
+// over the period with the ones it did. Only windows that closed inside the
+// period count.
+type RouteHealth struct {
+	RouteID      int        `json:"route_id"`
+	UserID       int        `json:"user_id"`
+	Expected     int        `json:"expected"`
+	Recorded     int        `json:"recorded"`
+	Coverage     float64    `json:"coverage"`
+	LastRecorded *time.Time `json:"last_recorded"`
+	Stale        bool       `json:"stale"`
+	// Problem explains a route that could not be checked at all.
+	Problem string `json:"problem,omitempty"`
+	Gaps    []Gap  `json:"gaps,omitempty"`
+}
+
+// Gap is one schedule window that recorded too few commutes.
+type Gap struct {
+	Date        string `json:"date"`
+	Direction   string `json:"direction"`
+	WindowStart string `json:"window_start"`
+	WindowEnd   string `json:"window_end"`
+	Expected    int    `json:"expected"`
+	Recorded    int    `json:"recorded"`
+}
+
+// Handler finds active routes whose schedule windows went without commutes.
+type Handler struct {
+	Store *store.Store
+	// Interval is how often commutesQueue runs, and so how many commutes a
+	// window should record. It defaults to a minute, the CloudWatch schedule.
+	Interval time.Duration
+	// Clock defaults to the system clock when nil.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+func directionName(toWork bool) string {
+	if toWork {
+		return "to_work"
+	}
+	return "from_work"
+}
+
+type countKey struct {
+	toWork bool
+	date   string
+}
+
+// checkRoute walks every scheduled window of route between since and asOf.
+func checkRoute(scheduled domain.ScheduledRoute, counts map[countKey]int, since, asOf time.Time, interval time.Duration, minCoverage float64) RouteHealth {
+	route, schedule := scheduled.Route, scheduled.Schedule
+	health := RouteHealth{RouteID: route.ID, UserID: route.UserID, Coverage: 1}
+
+	// LoadLocation reads "" as UTC, but a route without a time zone has no
+	// local windows to check.
+	if route.TimeZone == "" {
+		health.Problem = "missing time zone"
+		return health
+	}
+	loc, err := time.LoadLocation(route.TimeZone)
+	if err != nil {
+		health.Problem = fmt.Sprintf("invalid time zone %q", route.TimeZone)
+		return health
+	}
+	windows := map[bool][2]string{
+		true:  {schedule.MorningStartTime, schedule.MorningEndTime},
+		false: {schedule.AfternoonStartTime, schedule.AfternoonEndTime},
+	}
+	parsed := map[bool][2]time.Time{}
+	for _, toWork := range []bool{true, false} {
+		window := windows[toWork]
+		start, startErr := time.Parse(time.TimeOnly, window[0])
+		end, endErr := time.Parse(time.TimeOnly, window[1])
+		if startErr != nil || endErr != nil {
+			health.Problem = fmt.Sprintf("invalid %s window %s-%s", directionName(toWork), window[0], window[1])
+			return health
+		}
+		parsed[toWork] = [2]time.Time{start, end}
+	}
+
+	first := since.In(loc)
+	last := asOf.In(loc)
+	for day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc); !day.After(last); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		if !schedule.ActiveOn(day.Weekday()) || date < route.StartDate || (route.EndDate != nil && date > *route.EndDate) {
+			continue
+		}
+		for _, toWork := range []bool{true, false} {
+			window := parsed[toWork]
+			start := time.Date(day.Year(), day.Month(), day.Day(), window[0].Hour(), window[0].Minute(), window[0].Second(), 0, loc)
+			end := time.Date(day.Year(), day.Month(), day.Day(), window[1].Hour(), window[1].Minute(), window[1].Second(), 0, loc)
+			if !end.After(start) || start.Before(since) || end.After(asOf) {
+				continue
+			}
+			// Commutes are taken at both ends of the window and every interval
+			// in between.
+			expected := int(end.Sub(start)/interval) + 1
+			recorded := counts[countKey{toWork, date}]
+			health.Expected += expected
+			health.Recorded += min(recorded, expected)
+			if float64(recorded) < math.Ceil(minCoverage*float64(expected)) {
+				health.Gaps = append(health.Gaps, Gap{
+					Date:        date,
+					Direction:   directionName(toWork),
+					WindowStart: windows[toWork][0],
+					WindowEnd:   windows[toWork][1],
+					Expected:    expected,
+					Recorded:    recorded,
+				})
+			}
+		}
+	}
+	if health.Expected > 0 {
+		health.Coverage = float64(health.Recorded) / float64(health.Expected)
+	}
+	return health
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	ctx = logging.WithRunID(ctx, logging.NewRunID())
+
+	days := defaultDays
+	if request.Days != nil {
+		days = *request.Days
+	}
+	if days < 1 || days > maxDays {
+		return Response{}, fmt.Errorf("%w: days must be between 1 and %d, got %d", domain.ErrInvalidRequest, maxDays, days)
+	}
+	minCoverage := defaultMinCoverage
+	if request.MinCoverage != nil {
+		minCoverage = *request.MinCoverage
+	}
+	if minCoverage < 0 || minCoverage > 1 {
+		return Response{}, fmt.Errorf("%w: min_coverage must be between 0 and 1, got %v", domain.ErrInvalidRequest, minCoverage)
+	}
+	interval := h.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	asOf := clock.Now(h.Clock)
+	if request.AsOf != nil {
+		asOf = *request.AsOf
+	}
+	since := asOf.AddDate(0, 0, -days)
+
+	scheduled, err := h.Store.Schedules.Active(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading active routes", "error", err)
+		return Response{}, fmt.Errorf("error loading active routes: %v", err)
+	}
+	ids := make([]int, len(scheduled))
+	for i, route := range scheduled {
+		ids[i] = route.Route.ID
+	}
+	dailyCounts, err := h.Store.Commutes.DailyCounts(ctx, ids, since)
+	if err != nil {
+		slog.ErrorContext(ctx, "error counting commutes", "error", err)
+		return Response{}, fmt.Errorf("error counting commutes: %v", err)
+	}
+	last, err := h.Store.Commutes.LastRecorded(ctx, ids)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading last recorded commutes", "error", err)
+		return Response{}, fmt.Errorf("error loading last recorded commutes: %v", err)
+	}
+
+	counts := map[int]map[countKey]int{}
+	for _, count := range dailyCounts {
+		if counts[count.RouteID] == nil {
+			counts[count.RouteID] = map[countKey]int{}
+		}
+		counts[count.RouteID][countKey{count.ToWork, count.Date}] += count.Count
+	}
+
+	data := Data{AsOf: asOf, Since: since, Checked: len(scheduled), Routes: make([]RouteHealth, 0, len(scheduled))}
+	for _, route := range scheduled {
+		health := checkRoute(route, counts[route.Route.ID], since, asOf, interval, minCoverage)
+		if recorded, ok := last[route.Route.ID]; ok {
+			health.LastRecorded = &recorded
+		}
+		health.Stale = health.Problem != "" || len(health.Gaps) > 0
+		if health.Stale {
+			data.Stale++
+			slog.WarnContext(ctx, "stale route", "route_id", health.RouteID, "user_id", health.UserID, "gaps", len(health.Gaps),
+				"expected", health.Expected, "recorded", health.Recorded, "last_recorded", health.LastRecorded, "problem", health.Problem)
+		}
+		data.Routes = append(data.Routes, health)
+	}
+
+	sink := metrics.Or(h.Metrics)
+	sink.Count("routes_checked", float64(data.Checked))
+	sink.Count("routes_stale", float64(data.Stale))
+
+	slog.InfoContext(ctx, "health check complete", "since", since, "as_of", asOf, "checked", data.Checked, "stale", data.Stale)
+	if data.Stale > 0 {
+		return Response{fmt.Sprintf("%d of %d routes have gaps.", data.Stale, data.Checked), data}, nil
+	}
+	return Response{"All routes are recording commutes.", data}, nil
+}
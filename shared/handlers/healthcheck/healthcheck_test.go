@@ -0,0 +1,177 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+// wednesdayNoon looks back over Monday afternoon, both Tuesday windows and
+// Wednesday morning when days is 2.
+var wednesdayNoon = time.Date(2024, 9, 4, 12, 0, 0, 0, denver)
+
+func weekdays(routeID int) domain.Schedule {
+	return domain.Schedule{
+		RouteID:            routeID,
+		MorningStartTime:   "07:00:00",
+		MorningEndTime:     "07:10:00",
+		AfternoonStartTime: "17:00:00",
+		AfternoonEndTime:   "17:10:00",
+		Monday:             true,
+		Tuesday:            true,
+		Wednesday:          true,
+		Thursday:           true,
+		Friday:             true,
+	}
+}
+
+// record adds n scheduled commutes a minute apart from start.
+func record(memory *store.Memory, routeID int, toWork bool, start time.Time, n int) {
+	for i := 0; i < n; i++ {
+		memory.Commutes = append(memory.Commutes, domain.Commute{
+			Route:     routeID,
+			ToWork:    toWork,
+			QueryTime: start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+}
+
+func at(day, hour int) time.Time {
+	return time.Date(2024, 9, day, hour, 0, 0, 0, denver)
+}
+
+func TestHandleRequestFindsGaps(t *testing.T) {
+	db, memory := store.NewMemory()
+	for _, id := range []int{7, 8, 9, 10} {
+		memory.Routes[id] = domain.Route{ID: id, UserID: 3, Active: true, TimeZone: "America/Denver", StartDate: "2024-01-01"}
+		memory.Schedules[id] = weekdays(id)
+	}
+	memory.Routes[9] = domain.Route{ID: 9, UserID: 3, Active: true, TimeZone: "Mars/Olympus_Mons"}
+	memory.Routes[10] = domain.Route{ID: 10, UserID: 3, Active: false, TimeZone: "America/Denver"}
+
+	// Route 7 records every window in full.
+	record(memory, 7, false, at(2, 17), 11)
+	record(memory, 7, true, at(3, 7), 11)
+	record(memory, 7, false, at(3, 17), 11)
+	record(memory, 7, true, at(4, 7), 11)
+	// Route 8 stops recording on Tuesday afternoon. Half of Wednesday
+	// morning is still enough, and ad hoc checks do not count.
+	record(memory, 8, false, at(2, 17), 11)
+	record(memory, 8, true, at(3, 7), 11)
+	record(memory, 8, false, at(3, 17), 2)
+	record(memory, 8, true, at(4, 7), 6)
+	memory.Commutes = append(memory.Commutes, domain.Commute{Route: 8, ToWork: false, QueryTime: at(3, 17), AdHoc: true})
+
+	sink := metrics.NewPrometheus()
+	handler := &Handler{Store: db, Clock: clock.Fixed(wednesdayNoon), Metrics: sink}
+	days := 2
+	response, err := handler.HandleRequest(context.Background(), Request{Days: &days})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+
+	data := response.Data
+	if data.Checked != 3 || data.Stale != 2 || len(data.Routes) != 3 {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+	healthy := data.Routes[0]
+	if healthy.RouteID != 7 || healthy.Stale || healthy.Expected != 44 || healthy.Recorded != 44 || healthy.Coverage != 1 {
+		t.Errorf("unexpected health for route 7: %+v", healthy)
+	}
+	if healthy.LastRecorded == nil || !healthy.LastRecorded.Equal(at(4, 7).Add(10*time.Minute)) {
+		t.Errorf("route 7 last_recorded = %v", healthy.LastRecorded)
+	}
+
+	gappy := data.Routes[1]
+	if gappy.RouteID != 8 || !gappy.Stale || gappy.Recorded != 30 || len(gappy.Gaps) != 1 {
+		t.Fatalf("unexpected health for route 8: %+v", gappy)
+	}
+	want := Gap{Date: "2024-09-03", Direction: "from_work", WindowStart: "17:00:00", WindowEnd: "17:10:00", Expected: 11, Recorded: 2}
+	if gappy.Gaps[0] != want {
+		t.Errorf("gap = %+v, want %+v", gappy.Gaps[0], want)
+	}
+
+	broken := data.Routes[2]
+	if broken.RouteID != 9 || !broken.Stale || broken.Problem == "" {
+		t.Errorf("unexpected health for route 9: %+v", broken)
+	}
+	if response.Message != "2 of 3 routes have gaps." {
+		t.Errorf("message = %q", response.Message)
+	}
+	var scrape strings.Builder
+	sink.WriteTo(&scrape)
+	if !strings.Contains(scrape.String(), "optimize_route_routes_stale_total 2\n") {
+		t.Errorf("routes_stale was not counted:\n%s", scrape.String())
+	}
+}
+
+func TestHandleRequestSkipsOpenAndFutureWindows(t *testing.T) {
+	db, memory := store.NewMemory()
+	memory.Routes[7] = domain.Route{ID: 7, UserID: 3, Active: true, TimeZone: "America/Denver"}
+	memory.Schedules[7] = weekdays(7)
+
+	// At 07:05 on Wednesday the morning window is still open, and Tuesday
+	// morning began before the one day period.
+	handler := &Handler{Store: db, Clock: clock.Fixed(at(4, 7).Add(5 * time.Minute))}
+	days := 1
+	response, err := handler.HandleRequest(context.Background(), Request{Days: &days})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	health := response.Data.Routes[0]
+	if health.Expected != 11 || len(health.Gaps) != 1 || health.Gaps[0].Date != "2024-09-03" || health.Gaps[0].Direction != "from_work" {
+		t.Errorf("unexpected health: %+v", health)
+	}
+}
+
+func TestHandleRequestReportsRoutesWithoutTimeZone(t *testing.T) {
+	db, memory := store.NewMemory()
+	for _, id := range []int{7, 8, 9} {
+		memory.Routes[id] = domain.Route{ID: id, UserID: 3, Active: true, TimeZone: "America/Denver", StartDate: "2024-01-01"}
+		memory.Schedules[id] = weekdays(id)
+		record(memory, id, true, at(4, 7), 11)
+	}
+	memory.Routes[8] = domain.Route{ID: 8, UserID: 3, Active: true, StartDate: "2024-01-01"}
+	memory.Routes[9] = domain.Route{ID: 9, UserID: 3, Active: true, TimeZone: "Mars/Olympus_Mons", StartDate: "2024-01-01"}
+
+	handler := &Handler{Store: db, Clock: clock.Fixed(wednesdayNoon)}
+	days := 1
+	response, err := handler.HandleRequest(context.Background(), Request{Days: &days})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	routes := response.Data.Routes
+	if len(routes) != 3 || routes[0].Problem != "" || routes[0].Recorded != 11 {
+		t.Fatalf("unexpected health: %+v", routes)
+	}
+	if routes[1].Problem != "missing time zone" || !routes[1].Stale || routes[2].Problem == "" || !routes[2].Stale {
+		t.Errorf("unexpected health for routes without a valid time zone: %+v", routes[1:])
+	}
+}
+
+func TestHandleRequestRejectsBadInput(t *testing.T) {
+	db, _ := store.NewMemory()
+	handler := &Handler{Store: db, Clock: clock.Fixed(wednesdayNoon)}
+	days, coverage := 0, 0.5
+	tooHigh := 1.5
+
+	for _, request := range []Request{
+		{Days: &days},
+		{MinCoverage: &tooHigh},
+	} {
+		if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, domain.ErrInvalidRequest) {
+			t.Errorf("HandleRequest(%+v) = %v, want ErrInvalidRequest", request, err)
+		}
+	}
+	if _, err := handler.HandleRequest(context.Background(), Request{MinCoverage: &coverage}); err != nil {
+		t.Errorf("HandleRequest: %v", err)
+	}
+}
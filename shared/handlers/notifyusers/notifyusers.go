@@ -0,0 +1,219 @@
+package notifyusers
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/notify"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"time"
+)
+
+// Request optionally overrides the time used to pick routes whose window is
+// open, like commutesQueue's as_of.
+type Request struct {
+	AsOf *time.Time `json:"as_of"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+type Data struct {
+	Checked int      `json:"routes_checked"`
+	Sent    int      `json:"notifications_sent"`
+	Failed  int      `json:"notifications_failed"`
+	RunID   string   `json:"run_id"`
+	Routes  []Result `json:"routes"`
+}
+
+// Result is what happened for one route in an open window. Channels and
+// Failed list the channels the message was and was not delivered to; Reason
+// explains a route that was not notified.
+type Result struct {
+	RouteID   int            `json:"route_id"`
+	UserID    int            `json:"user_id"`
+	Direction string         `json:"direction"`
+	Advice    *notify.Advice `json:"advice,omitempty"`
+	Channels  []string       `json:"channels,omitempty"`
+	Failed    []string       `json:"failed,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+}
+
+// Handler advises users whose schedule window is open whether to leave now,
+// based on the latest commute optimizeRoute recorded for their route.
+type Handler struct {
+	Store    *store.Store
+	Channels notify.Channels
+	// Policy defaults to notify.DefaultPolicy when its Wait is zero.
+	Policy notify.Policy
+	// Clock defaults to the system clock when nil.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+func directionName(toWork bool) string {
+	if toWork {
+		return "to_work"
+	}
+	return "from_work"
+}
+
+// compose writes the message for advice.
+func compose(route domain.DueRoute, advice notify.Advice, wait time.Duration) notify.Message {
+	destination := "home"
+	if route.ToWork {
+		destination = "work"
+	}
+	minutes := func(seconds int) int { return (seconds + 30) / 60 }
+	title := fmt.Sprintf("Leave now for %s", destination)
+	body := fmt.Sprintf("Route %d takes %d min right now; it usually takes %d min around %s.",
+		route.ID, minutes(advice.Current), minutes(advice.Usual), advice.At.Format("15:04"))
+	if advice.Decision == notify.Wait {
+		title = fmt.Sprintf("Wait %d minutes before leaving for %s", int(wait.Minutes()), destination)
+		body += fmt.Sprintf(" Leaving at %s usually takes %d min.", advice.At.Add(wait).Format("15:04"), minutes(advice.Later))
+	}
+	return notify.Message{
+		Title:    title,
+		Body:     body,
+		UserID:   route.UserID,
+		RouteID:  route.ID,
+		ToWork:   &route.ToWork,
+		Decision: advice.Decision,
+		Advice:   &advice,
+	}
+}
+
+// notifyRoute advises one route's user, unless they were already told to
+// leave in this window or were told to wait less than Policy.Wait ago.
+func (h *Handler) notifyRoute(ctx context.Context, route domain.DueRoute, policy notify.Policy, now time.Time, sink metrics.Sink) Result {
+	result := Result{RouteID: route.ID, UserID: route.UserID, Direction: directionName(route.ToWork)}
+
+	subscriptions, err := h.Store.Notifications.Subscriptions(ctx, route.UserID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading subscriptions", "user_id", route.UserID, "error", err)
+		result.Reason = "error loading subscriptions"
+		return result
+	}
+	if len(subscriptions) == 0 {
+		result.Reason = "no subscriptions"
+		return result
+	}
+
+	loc, err := time.LoadLocation(route.TimeZone)
+	if err != nil {
+		result.Reason = fmt.Sprintf("invalid time zone %q", route.TimeZone)
+		return result
+	}
+	local := now.In(loc)
+	windowStart, err := time.ParseInLocation(time.DateOnly+" "+time.TimeOnly, local.Format(time.DateOnly)+" "+route.WindowStart, loc)
+	if err != nil {
+		result.Reason = fmt.Sprintf("invalid window start %q", route.WindowStart)
+		return result
+	}
+	last, err := h.Store.Notifications.Last(ctx, route.ID, route.ToWork, windowStart)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading last notification", "route_id", route.ID, "error", err)
+		result.Reason = "error loading last notification"
+		return result
+	}
+	switch {
+	case last != nil && last.Decision == string(notify.LeaveNow):
+		result.Reason = "already told to leave in this window"
+		return result
+	case last != nil && now.Sub(last.SentAt) < policy.Wait:
+		result.Reason = fmt.Sprintf("told to wait at %s", last.SentAt.In(loc).Format("15:04"))
+		return result
+	}
+
+	commutes, err := h.Store.Commutes.Recent(ctx, route.ID, route.ToWork, now.Add(-policy.History))
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading recent commutes", "route_id", route.ID, "error", err)
+		result.Reason = "error loading recent commutes"
+		return result
+	}
+	if len(commutes) == 0 || now.Sub(commutes[len(commutes)-1].QueryTime) > policy.Freshness {
+		result.Reason = "no recent commute"
+		return result
+	}
+	latest := commutes[len(commutes)-1]
+	advice, ok := policy.Advise(latest, commutes[:len(commutes)-1], loc)
+	if !ok {
+		result.Reason = "not enough history"
+		return result
+	}
+	result.Advice = &advice
+
+	message := compose(route, advice, policy.Wait)
+	for _, subscription := range subscriptions {
+		if err := h.Channels.Send(ctx, subscription, message); err != nil {
+			slog.ErrorContext(ctx, "error sending notification", "route_id", route.ID, "channel", subscription.Channel, "error", err)
+			sink.Count("notifications_failed", 1, "channel", subscription.Channel)
+			result.Failed = append(result.Failed, subscription.Channel)
+			continue
+		}
+		sink.Count("notifications_sent", 1, "channel", subscription.Channel, "decision", string(advice.Decision))
+		result.Channels = append(result.Channels, subscription.Channel)
+	}
+	if len(result.Channels) == 0 {
+		result.Reason = "every channel failed"
+		return result
+	}
+
+	_, err = h.Store.Notifications.Insert(ctx, domain.Notification{
+		UserID:   route.UserID,
+		Route:    route.ID,
+		ToWork:   route.ToWork,
+		Decision: string(advice.Decision),
+		Message:  message.Title,
+		SentAt:   now,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "error logging notification", "route_id", route.ID, "error", err)
+	}
+	slog.InfoContext(ctx, "notified user", "route_id", route.ID, "user_id", route.UserID, "decision", advice.Decision,
+		"current_s", advice.Current, "usual_s", advice.Usual, "later_s", advice.Later, "channels", result.Channels)
+	return result
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	runID := logging.NewRunID()
+	ctx = logging.WithRunID(ctx, runID)
+
+	now := clock.Now(h.Clock)
+	if request.AsOf != nil {
+		now = *request.AsOf
+	}
+	policy := h.Policy
+	if policy.Wait == 0 {
+		policy = notify.DefaultPolicy
+	}
+	sink := metrics.Or(h.Metrics)
+
+	var routes []domain.DueRoute
+	for _, toWork := range []bool{true, false} {
+		due, err := h.Store.Schedules.Due(ctx, toWork, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "error selecting due routes", "to_work", toWork, "error", err)
+			return Response{}, fmt.Errorf("error selecting due routes: %v", err)
+		}
+		routes = append(routes, due...)
+	}
+
+	data := Data{Checked: len(routes), RunID: runID, Routes: make([]Result, 0, len(routes))}
+	for _, route := range routes {
+		result := h.notifyRoute(ctx, route, policy, now, sink)
+		data.Sent += len(result.Channels)
+		data.Failed += len(result.Failed)
+		data.Routes = append(data.Routes, result)
+	}
+
+	slog.InfoContext(ctx, "notifications complete", "checked", data.Checked, "sent", data.Sent, "failed", data.Failed)
+	return Response{"Notifications complete.", data}, nil
+}
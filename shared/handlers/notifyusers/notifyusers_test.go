@@ -0,0 +1,152 @@
+package notifyusers
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/notify"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 9, day, hour, minute, 0, 0, denver)
+}
+
+type failingChannel struct{}
+
+func (failingChannel) Send(ctx context.Context, target string, message notify.Message) error {
+	return errors.New("connection refused")
+}
+
+// newHandler seeds route 7 for user 3, who subscribes on two channels, and
+// route 8 for user 4, who does not subscribe. On Monday to Wednesday route 7
+// took 30 minutes at 07:45 and 24 at 08:00.
+func newHandler(t *testing.T) (*Handler, *store.Memory, *notify.Capture) {
+	t.Helper()
+	db, memory := store.NewMemory()
+	for id, userID := range map[int]int{7: 3, 8: 4} {
+		memory.Routes[id] = domain.Route{
+			ID:             id,
+			UserID:         userID,
+			StartLatitude:  "40.01499",
+			StartLongitude: "-105.27055",
+			EndLatitude:    "39.73915",
+			EndLongitude:   "-104.9847",
+			Active:         true,
+			TimeZone:       "America/Denver",
+		}
+		memory.Schedules[id] = domain.Schedule{
+			RouteID:            id,
+			MorningStartTime:   "07:00:00",
+			MorningEndTime:     "09:00:00",
+			AfternoonStartTime: "16:00:00",
+			AfternoonEndTime:   "18:00:00",
+			Monday:             true,
+			Tuesday:            true,
+			Wednesday:          true,
+			Thursday:           true,
+			Friday:             true,
+		}
+	}
+	for _, day := range []int{2, 3, 4} {
+		memory.Commutes = append(memory.Commutes,
+			domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: at(day, 7, 45), Duration: 1800},
+			domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: at(day, 8, 0), Duration: 1440},
+		)
+	}
+	memory.Subscriptions = []domain.Subscription{
+		{UserID: 3, Channel: "capture", Target: "phone", Active: true},
+		{UserID: 3, Channel: "broken", Target: "pager", Active: true},
+		{UserID: 3, Channel: "capture", Target: "old phone", Active: false},
+	}
+
+	capture := &notify.Capture{}
+	handler := &Handler{Store: db, Channels: notify.Channels{"capture": capture, "broken": failingChannel{}}}
+	return handler, memory, capture
+}
+
+func run(t *testing.T, handler *Handler, now time.Time) Data {
+	t.Helper()
+	handler.Clock = clock.Fixed(now)
+	response, err := handler.HandleRequest(context.Background(), Request{})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	return response.Data
+}
+
+func TestHandleRequestAdvisesThroughTheWindow(t *testing.T) {
+	handler, memory, capture := newHandler(t)
+
+	// Thursday at 07:45 takes the usual 30 minutes, but 08:00 is usually six
+	// minutes faster.
+	memory.Commutes = append(memory.Commutes, domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: at(5, 7, 44), Duration: 1800})
+	data := run(t, handler, at(5, 7, 45))
+	if data.Checked != 2 || data.Sent != 1 || data.Failed != 1 {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+	result := data.Routes[0]
+	if result.RouteID != 7 || result.Advice == nil || result.Advice.Decision != notify.Wait || len(result.Channels) != 1 {
+		t.Errorf("unexpected result for route 7: %+v", result)
+	}
+	if data.Routes[1].Reason != "no subscriptions" {
+		t.Errorf("unexpected result for route 8: %+v", data.Routes[1])
+	}
+	sent := capture.Sent()
+	if len(sent) != 1 || sent[0].Target != "phone" {
+		t.Fatalf("captured %+v", sent)
+	}
+	message := sent[0].Message
+	if message.Title != "Wait 15 minutes before leaving for work" ||
+		message.Body != "Route 7 takes 30 min right now; it usually takes 30 min around 07:44. Leaving at 07:59 usually takes 24 min." {
+		t.Errorf("unexpected message: %+v", message)
+	}
+
+	// Five minutes later the wait advice still stands.
+	memory.Commutes = append(memory.Commutes, domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: at(5, 7, 49), Duration: 1800})
+	if data := run(t, handler, at(5, 7, 50)); data.Sent != 0 || data.Routes[0].Reason != "told to wait at 07:45" {
+		t.Errorf("unexpected data at 07:50: %+v", data)
+	}
+
+	// At 08:01 the drive is as fast as it gets.
+	memory.Commutes = append(memory.Commutes, domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: at(5, 8, 0), Duration: 1440})
+	data = run(t, handler, at(5, 8, 1))
+	if data.Sent != 1 || data.Routes[0].Advice.Decision != notify.LeaveNow {
+		t.Fatalf("unexpected data at 08:01: %+v", data)
+	}
+	if sent := capture.Sent(); sent[len(sent)-1].Message.Title != "Leave now for work" {
+		t.Errorf("unexpected message: %+v", sent[len(sent)-1].Message)
+	}
+
+	// Once told to leave, the user hears nothing more this window.
+	memory.Commutes = append(memory.Commutes, domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: at(5, 8, 19), Duration: 1440})
+	if data := run(t, handler, at(5, 8, 20)); data.Sent != 0 || data.Routes[0].Reason != "already told to leave in this window" {
+		t.Errorf("unexpected data at 08:20: %+v", data)
+	}
+	if len(memory.Notifications) != 2 {
+		t.Errorf("logged %d notifications, want 2", len(memory.Notifications))
+	}
+}
+
+func TestHandleRequestNeedsFreshCommuteAndHistory(t *testing.T) {
+	handler, memory, capture := newHandler(t)
+
+	// The last commute is Wednesday's.
+	if data := run(t, handler, at(5, 7, 45)); data.Routes[0].Reason != "no recent commute" {
+		t.Errorf("unexpected data: %+v", data)
+	}
+
+	// Nothing was recorded around 07:15 before.
+	memory.Commutes = append(memory.Commutes, domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: at(5, 7, 14), Duration: 1800})
+	if data := run(t, handler, at(5, 7, 15)); data.Routes[0].Reason != "not enough history" {
+		t.Errorf("unexpected data: %+v", data)
+	}
+	if sent := capture.Sent(); len(sent) != 0 {
+		t.Errorf("captured %+v", sent)
+	}
+}
@@ -0,0 +1,323 @@
+package optimizeroute
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/anomaly"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/polyline"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+type Data struct {
+	Routes []google.Route `json:"routes"`
+}
+
+// Handler asks Google for the current drive time of one route and records it
+// as a commute.
+type Handler struct {
+	Store  *store.Store
+	Routes *google.Client
+	// Clock sets the departure time and defaults to the system clock.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+	// Anomalies scores recorded scheduled commutes. Scoring is skipped when
+	// nil.
+	Anomalies *anomaly.Monitor
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request domain.OptimizeRouteRequest) (Response, error) {
+	// Keep the dispatching commutesQueue run's ID, or start a new run for
+	// direct invocations.
+	runID := request.RunID
+	if runID == "" {
+		runID = logging.RunID(ctx)
+	}
+	if runID == "" {
+		runID = logging.NewRunID()
+	}
+	ctx = logging.WithRunID(ctx, runID)
+
+	if request.Route == nil {
+		return Response{}, fmt.Errorf("%w. Missing route ID", domain.ErrInvalidRequest)
+	}
+	if request.ToWork == nil {
+		return Response{}, fmt.Errorf("%w. Missing to_work", domain.ErrInvalidRequest)
+	}
+
+	// Only the route ID and direction are trusted; everything else comes from
+	// the routes row.
+	route, err := h.Store.Routes.Get(ctx, *request.Route)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading route", "route_id", *request.Route, "error", err)
+		return Response{}, fmt.Errorf("error loading route: %w", err)
+	}
+	if !route.Active {
+		return Response{}, fmt.Errorf("%w: route %d is inactive", domain.ErrInvalidRequest, route.ID)
+	}
+	if request.UserID != nil && *request.UserID != route.UserID {
+		return Response{}, fmt.Errorf("%w: route %d does not belong to user %d", domain.ErrInvalidRequest, route.ID, *request.UserID)
+	}
+
+	loc, err := time.LoadLocation(route.TimeZone)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading location", "route_id", route.ID, "error", err)
+		return Response{}, fmt.Errorf("error laoding location for route %d: %v", route.ID, err)
+	}
+	direction, err := h.Store.Direction(ctx, route, *request.ToWork)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading route direction", "route_id", route.ID, "error", err)
+		return Response{}, err
+	}
+	now := clock.Now(h.Clock).UTC()
+	boosted, err := h.boosted(ctx, direction, now)
+	if err != nil {
+		return Response{}, err
+	}
+	trip := Trip{
+		Route:     direction,
+		Departure: now.In(loc).Add(1 * time.Minute),
+		Record:    true,
+		Boosted:   boosted,
+	}
+	responseData, commute, err := h.Check(ctx, trip)
+	if err != nil {
+		return Response{}, err
+	}
+	if commute == nil {
+		return Response{Message: "No routes found in the response", Data: responseData}, nil
+	}
+	return Response{Message: "Request successful", Data: responseData}, nil
+}
+
+// boosted reports whether the route is only due in its direction because its
+// sampling is boosted after an anomaly: a boost is in force at at and the
+// schedule window is closed.
+func (h *Handler) boosted(ctx context.Context, route domain.DueRoute, at time.Time) (bool, error) {
+	matches := func(other domain.DueRoute) bool { return other.ID == route.ID && other.ToWork == route.ToWork }
+	boosts, err := h.Store.Boosts.Active(ctx, at)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading boosted routes", "route_id", route.ID, "error", err)
+		return false, fmt.Errorf("error loading boosted routes: %v", err)
+	}
+	if !slices.ContainsFunc(boosts, matches) {
+		return false, nil
+	}
+	due, err := h.Store.Schedules.Due(ctx, route.ToWork, at)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading due routes", "route_id", route.ID, "error", err)
+		return false, fmt.Errorf("error loading due routes: %v", err)
+	}
+	return !slices.ContainsFunc(due, matches), nil
+}
+
+// Trip is one drive to ask Google about, already in its direction of travel.
+type Trip struct {
+	Route     domain.DueRoute
+	Departure time.Time
+	// Record saves the result as a commute. AdHoc marks it as requested
+	// outside the route's schedule, and Boosted as sampled outside it while
+	// the route is boosted.
+	Record  bool
+	AdHoc   bool
+	Boosted bool
+}
+
+// Check asks Google for the drive time of a trip. The returned commute is nil
+// when Google found no route; it has no ID unless the trip was recorded.
+func (h *Handler) Check(ctx context.Context, trip Trip) (Data, *domain.Commute, error) {
+	googleRequest := google.NewRouteRequest(trip.Route.Origin, trip.Route.Destination, trip.Departure, trip.Route.Waypoints...)
+
+	sink := metrics.Or(h.Metrics)
+	routesResponse, err := h.Routes.ComputeRoutes(ctx, googleRequest)
+	if err != nil {
+		sink.Count("commute_checks", 1, "outcome", metrics.ErrorClass(err))
+		slog.ErrorContext(ctx, "error computing routes", "route_id", trip.Route.ID, "error_class", google.ClassName(err), "error", err)
+		return Data{}, nil, fmt.Errorf("error computing routes (%s): %w", google.ClassName(err), err)
+	}
+	responseData := Data{Routes: routesResponse.Routes}
+
+	if len(responseData.Routes) == 0 {
+		slog.WarnContext(ctx, "no routes found in the response", "route_id", trip.Route.ID)
+		sink.Count("commute_checks", 1, "outcome", "no_route")
+		return responseData, nil, nil
+	}
+	distanceMeters := responseData.Routes[0].DistanceMeters
+	encodedPolyline := responseData.Routes[0].Polyline.EncodedPolyline
+
+	durationInt, err := responseData.Routes[0].DurationSeconds()
+	if err != nil {
+		slog.ErrorContext(ctx, "error converting duration", "route_id", trip.Route.ID, "error", err)
+		return Data{}, nil, fmt.Errorf("error converting duration: %v", err)
+	}
+
+	legs, err := recordedLegs(trip.Route, responseData.Routes[0])
+	if err != nil {
+		slog.ErrorContext(ctx, "error converting leg durations", "route_id", trip.Route.ID, "error", err)
+		return Data{}, nil, fmt.Errorf("error converting leg durations: %v", err)
+	}
+
+	record := domain.Commute{
+		UserID:    trip.Route.UserID,
+		QueryTime: trip.Departure,
+		Duration:  durationInt,
+		Distance:  distanceMeters,
+		Route:     trip.Route.ID,
+		RouteHash: encodedPolyline,
+		ToWork:    trip.Route.ToWork,
+		DayOfWeek: trip.Departure.Weekday().String(),
+		AdHoc:     trip.AdHoc,
+		Boosted:   trip.Boosted,
+		Legs:      legs,
+	}
+	sink.Count("commute_checks", 1, "outcome", "ok")
+	if !trip.Record {
+		return responseData, &record, nil
+	}
+	inserted, err := h.Store.Commutes.Insert(ctx, record)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to insert commute", "route_id", trip.Route.ID, "error", err)
+		return Data{}, nil, fmt.Errorf("failed to insert data: %v", err)
+	}
+	h.recordPath(ctx, sink, &inserted)
+	sink.Count("commutes_inserted", 1, "ad_hoc", strconv.FormatBool(inserted.AdHoc))
+	slog.InfoContext(ctx, "recorded commute",
+		"commute_id", inserted.ID,
+		"route_id", inserted.Route,
+		"user_id", inserted.UserID,
+		"to_work", inserted.ToWork,
+		"ad_hoc", inserted.AdHoc,
+		"boosted", inserted.Boosted,
+		"duration_s", inserted.Duration,
+		"distance_m", inserted.Distance,
+		"query_time", inserted.QueryTime)
+
+	if h.Anomalies != nil && !inserted.AdHoc {
+		// Boosted commutes are scored too, so an incident that goes on keeps
+		// the route boosted, but stay out of the baseline. A failed score
+		// does not fail the commute, which is already saved.
+		score, err := h.Anomalies.Inspect(ctx, inserted, trip.Departure.Location())
+		if err != nil {
+			slog.WarnContext(ctx, "error scoring commute", "commute_id", inserted.ID, "route_id", inserted.Route, "error", err)
+		}
+		if score != nil {
+			inserted.AnomalyScore = &score.Score
+		}
+	}
+	return responseData, &inserted, nil
+}
+
+// recordedLegs returns the legs of a route with waypoints, one more than
+// there are waypoints, or nil for a route without any.
+func recordedLegs(trip domain.DueRoute, route google.Route) ([]domain.Leg, error) {
+	if len(trip.Waypoints) == 0 {
+		return nil, nil
+	}
+	if len(route.Legs) != len(trip.Waypoints)+1 {
+		return nil, fmt.Errorf("got %d legs for %d waypoints", len(route.Legs), len(trip.Waypoints))
+	}
+	legs := make([]domain.Leg, len(route.Legs))
+	for i, leg := range route.Legs {
+		seconds, err := leg.DurationSeconds()
+		if err != nil {
+			return nil, fmt.Errorf("leg %d: %w", i+1, err)
+		}
+		legs[i] = domain.Leg{Duration: seconds, Distance: leg.DistanceMeters}
+	}
+	return legs, nil
+}
+
+func directionName(toWork bool) string {
+	if toWork {
+		return "to_work"
+	}
+	return "from_work"
+}
+
+// recordPath links the saved commute to the path its polyline follows, and
+// notes when Google has moved a scheduled commute onto a different path than
+// the last one. An ad hoc commute, which may depart at any time, is only
+// linked to a path already recorded and leaves its first and last seen
+// alone. Errors are only logged and leave the commute unlinked.
+func (h *Handler) recordPath(ctx context.Context, sink metrics.Sink, commute *domain.Commute) {
+	if commute.RouteHash == "" {
+		return
+	}
+	fingerprint, err := polyline.Fingerprint(commute.RouteHash)
+	if err != nil {
+		slog.WarnContext(ctx, "error fingerprinting route path", "route_id", commute.Route, "error", err)
+		return
+	}
+	if commute.AdHoc {
+		path, err := h.Store.Paths.Find(ctx, commute.Route, commute.ToWork, fingerprint)
+		if err != nil {
+			slog.WarnContext(ctx, "error finding route path", "route_id", commute.Route, "error", err)
+			return
+		}
+		if path != nil {
+			h.linkPath(ctx, commute, *path)
+		}
+		return
+	}
+	var last *domain.RoutePath
+	if commute.Scheduled() {
+		if last, err = h.Store.Paths.Last(ctx, commute.Route, commute.ToWork); err != nil {
+			slog.WarnContext(ctx, "error loading last route path", "route_id", commute.Route, "error", err)
+		}
+	}
+	path, added, err := h.Store.Paths.Record(ctx, domain.RoutePath{
+		RouteID:     commute.Route,
+		ToWork:      commute.ToWork,
+		Fingerprint: fingerprint,
+		Polyline:    commute.RouteHash,
+		Distance:    commute.Distance,
+		FirstSeen:   commute.QueryTime,
+		LastSeen:    commute.QueryTime,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "error recording route path", "route_id", commute.Route, "error", err)
+		return
+	}
+	if !h.linkPath(ctx, commute, path) {
+		return
+	}
+
+	direction := directionName(commute.ToWork)
+	if added {
+		sink.Count("route_paths_added", 1, "direction", direction)
+		slog.InfoContext(ctx, "found new route path", "route_id", commute.Route, "to_work", commute.ToWork, "path_id", path.ID, "label", path.Label)
+	}
+	if last != nil && last.ID != path.ID {
+		sink.Count("route_path_changes", 1, "direction", direction)
+		slog.InfoContext(ctx, "route path changed",
+			"route_id", commute.Route,
+			"to_work", commute.ToWork,
+			"from", last.Label,
+			"to", path.Label,
+			"distance_change_m", commute.Distance-last.Distance)
+	}
+}
+
+// linkPath saves the commute's path and reports whether it did.
+func (h *Handler) linkPath(ctx context.Context, commute *domain.Commute, path domain.RoutePath) bool {
+	if err := h.Store.Commutes.SetPath(ctx, commute.ID, path.ID); err != nil {
+		slog.WarnContext(ctx, "error linking route path", "commute_id", commute.ID, "route_id", commute.Route, "error", err)
+		return false
+	}
+	commute.PathID = &path.ID
+	return true
+}
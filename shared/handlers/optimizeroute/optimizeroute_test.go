@@ -0,0 +1,421 @@
+package optimizeroute
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/anomaly"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google/fakegoogle"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/polyline"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newHandler(t *testing.T) (*Handler, *store.Memory, *fakegoogle.Server) {
+	t.Helper()
+	fake := fakegoogle.NewDefault()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := google.NewClient("test-key")
+	client.SetBaseURL(server.URL)
+	client.Retry = google.RetryPolicy{MaxAttempts: 1}
+
+	db, memory := store.NewMemory()
+	return &Handler{Store: db, Routes: client}, memory, fake
+}
+
+// requestFor stores route 7 between the endpoints of a fakegoogle fixture and
+// returns a request for it in the to work direction.
+func requestFor(t *testing.T, memory *store.Memory, fake *fakegoogle.Server, fixture string) domain.OptimizeRouteRequest {
+	t.Helper()
+	route, ok := fake.Fixtures.Route(fixture)
+	if !ok {
+		t.Fatalf("no route fixture %q", fixture)
+	}
+	memory.Routes[7] = domain.Route{
+		ID:             7,
+		UserID:         3,
+		StartLatitude:  domain.FormatCoordinate(route.Origin.Latitude),
+		StartLongitude: domain.FormatCoordinate(route.Origin.Longitude),
+		EndLatitude:    domain.FormatCoordinate(route.Destination.Latitude),
+		EndLongitude:   domain.FormatCoordinate(route.Destination.Longitude),
+		Active:         true,
+		TimeZone:       "America/Denver",
+	}
+	routeID, toWork, userID := 7, true, 3
+	return domain.OptimizeRouteRequest{Route: &routeID, ToWork: &toWork, UserID: &userID}
+}
+
+func TestHandleRequestRecordsCommute(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+
+	response, err := handler.HandleRequest(context.Background(), requestFor(t, memory, fake, "boulder_to_denver"))
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if response.Message != "Request successful" {
+		t.Errorf("message = %q", response.Message)
+	}
+
+	commutes := memory.AllCommutes()
+	if len(commutes) != 1 {
+		t.Fatalf("recorded %d commutes, want 1", len(commutes))
+	}
+	commute := commutes[0]
+	if commute.Route != 7 || commute.UserID != 3 || !commute.ToWork {
+		t.Errorf("unexpected commute: %+v", commute)
+	}
+	if commute.Duration != 2104 || commute.Distance != 45872 {
+		t.Errorf("got %d s and %d m, want 2104 s and 45872 m", commute.Duration, commute.Distance)
+	}
+	if commute.DayOfWeek != commute.QueryTime.Weekday().String() {
+		t.Errorf("day_of_week %q does not match query time %v", commute.DayOfWeek, commute.QueryTime)
+	}
+}
+
+func TestHandleRequestRecordsLegs(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	request := requestFor(t, memory, fake, "boulder_to_denver")
+	daycare := domain.LatLng{Latitude: 39.90083, Longitude: -105.03561}
+	gym := domain.LatLng{Latitude: 39.7508, Longitude: -105.0}
+	memory.Waypoints[7] = []domain.Waypoint{
+		{ID: 2, RouteID: 7, Position: 2, Latitude: "39.7508", Longitude: "-105"},
+		{ID: 1, RouteID: 7, Position: 1, Latitude: "39.90083", Longitude: "-105.03561"},
+	}
+
+	for _, toWork := range []bool{true, false} {
+		request.ToWork = &toWork
+		if _, err := handler.HandleRequest(context.Background(), request); err != nil {
+			t.Fatalf("HandleRequest: %v", err)
+		}
+	}
+
+	requests := fake.RouteRequests()
+	for i, want := range [][]domain.LatLng{{daycare, gym}, {gym, daycare}} {
+		var stops []domain.LatLng
+		for _, stop := range requests[i].Intermediates {
+			stops = append(stops, stop.Location.LatLng)
+		}
+		if len(stops) != 2 || stops[0] != want[0] || stops[1] != want[1] {
+			t.Errorf("request %d stops at %v, want %v", i, stops, want)
+		}
+	}
+	for _, commute := range memory.AllCommutes() {
+		total := 0
+		for _, leg := range commute.Legs {
+			total += leg.Duration
+		}
+		if len(commute.Legs) != 3 || total != commute.Duration {
+			t.Errorf("commute of %d s has legs %+v", commute.Duration, commute.Legs)
+		}
+	}
+}
+
+func TestHandleRequestScoresAnomalies(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	request := requestFor(t, memory, fake, "boulder_to_denver")
+	denver, _ := time.LoadLocation("America/Denver")
+	for day := 2; day <= 13; day++ {
+		memory.Commutes = append(memory.Commutes, domain.Commute{
+			ID: 100 + day, Route: 7, UserID: 3, ToWork: true, QueryTime: time.Date(2024, 9, day, 8, 0, 0, 0, denver), Duration: 1500 + day,
+		})
+	}
+	var alerts []anomaly.Anomaly
+	handler.Clock = clock.Fixed(time.Date(2024, 9, 16, 8, 0, 0, 0, denver))
+	handler.Anomalies = &anomaly.Monitor{Store: handler.Store, Alerter: anomaly.AlerterFunc(func(ctx context.Context, found anomaly.Anomaly) error {
+		alerts = append(alerts, found)
+		return nil
+	})}
+
+	// The fixture's 2104 s is ten minutes slower than usual.
+	if _, err := handler.HandleRequest(context.Background(), request); err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	commutes := memory.AllCommutes()
+	if recorded := commutes[len(commutes)-1]; recorded.AnomalyScore == nil || *recorded.AnomalyScore < 3.5 {
+		t.Errorf("unexpected score for %+v", recorded)
+	}
+	if len(alerts) != 1 || len(memory.Boosts) != 1 || !memory.Boosts[0].ToWork {
+		t.Errorf("alerts = %+v, boosts = %+v", alerts, memory.Boosts)
+	}
+}
+
+func TestHandleRequestTagsBoostedCommutes(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	request := requestFor(t, memory, fake, "boulder_to_denver")
+	denver, _ := time.LoadLocation("America/Denver")
+	for day := 2; day <= 13; day++ {
+		memory.Commutes = append(memory.Commutes, domain.Commute{
+			ID: 100 + day, Route: 7, UserID: 3, ToWork: true, QueryTime: time.Date(2024, 9, day, 21, 0, 0, 0, denver), Duration: 1500 + day,
+		})
+	}
+	// The route is boosted in the evening, after its morning window.
+	memory.Schedules[7] = domain.Schedule{RouteID: 7, MorningStartTime: "07:00:00", MorningEndTime: "08:30:00", AfternoonStartTime: "16:30:00", AfternoonEndTime: "18:00:00", Monday: true}
+	now := time.Date(2024, 9, 16, 21, 0, 0, 0, denver)
+	memory.Boosts = []domain.Boost{{RouteID: 7, ToWork: true, Until: now.Add(time.Hour)}}
+	handler.Clock = clock.Fixed(now)
+	handler.Anomalies = &anomaly.Monitor{Store: handler.Store, Alerter: anomaly.AlerterFunc(func(ctx context.Context, found anomaly.Anomaly) error { return nil })}
+
+	if _, err := handler.HandleRequest(context.Background(), request); err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	commutes := memory.AllCommutes()
+	recorded := commutes[len(commutes)-1]
+	if !recorded.Boosted || recorded.AdHoc || recorded.AnomalyScore == nil {
+		t.Errorf("unexpected boosted commute: %+v", recorded)
+	}
+	// Boosted samples are scored but kept out of the scheduled history.
+	recent, err := handler.Store.Commutes.Recent(context.Background(), 7, true, time.Date(2024, 9, 1, 0, 0, 0, 0, denver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 12 {
+		t.Errorf("got %d recent commutes, want the 12 scheduled ones", len(recent))
+	}
+}
+
+func TestHandleRequestIgnoresForgedBoost(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	requestFor(t, memory, fake, "boulder_to_denver")
+	memory.Schedules[7] = domain.Schedule{RouteID: 7, MorningStartTime: "07:00:00", MorningEndTime: "08:30:00", AfternoonStartTime: "16:30:00", AfternoonEndTime: "18:00:00", Monday: true}
+	denver, _ := time.LoadLocation("America/Denver")
+	now := time.Date(2024, 9, 16, 8, 0, 0, 0, denver)
+	handler.Clock = clock.Fixed(now)
+	var request domain.OptimizeRouteRequest
+	if err := json.Unmarshal([]byte(`{"route": 7, "to_work": true, "boosted": true}`), &request); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the payload nor a boost inside the schedule window makes the
+	// commute a boosted sample.
+	for _, boosts := range [][]domain.Boost{nil, {{RouteID: 7, ToWork: true, Until: now.Add(time.Hour)}}} {
+		memory.Boosts = boosts
+		if _, err := handler.HandleRequest(context.Background(), request); err != nil {
+			t.Fatalf("HandleRequest: %v", err)
+		}
+		commutes := memory.AllCommutes()
+		if recorded := commutes[len(commutes)-1]; recorded.Boosted {
+			t.Errorf("commute tagged as boosted with boosts %+v", boosts)
+		}
+	}
+}
+
+func TestHandleRequestLinksPaths(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	origin := domain.LatLng{Latitude: 40.01499, Longitude: -105.27055}
+	destination := domain.LatLng{Latitude: 40.01499, Longitude: -105.17055}
+	detour := domain.LatLng{Latitude: 40.04, Longitude: -105.22}
+	response := func(distance int, points ...domain.LatLng) fakegoogle.Response {
+		body, _ := json.Marshal(google.RoutesResponse{Routes: []google.Route{{
+			DistanceMeters: distance,
+			Duration:       "900s",
+			Polyline:       google.Polyline{EncodedPolyline: polyline.Encode(points)},
+		}}})
+		return fakegoogle.Response{Status: 200, Body: body}
+	}
+	// Google takes the direct road once, then diverts for the rest.
+	fake.Fixtures.Routes = append(fake.Fixtures.Routes, fakegoogle.RouteFixture{
+		Name:        "diverted",
+		Origin:      origin,
+		Destination: destination,
+		Responses:   []fakegoogle.Response{response(8500, origin, destination), response(11200, origin, detour, destination)},
+	})
+	request := requestFor(t, memory, fake, "diverted")
+	sink := metrics.NewPrometheus()
+	handler.Metrics = sink
+
+	start := time.Date(2024, 9, 16, 14, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		handler.Clock = clock.Fixed(start.Add(time.Duration(i) * 10 * time.Minute))
+		if _, err := handler.HandleRequest(context.Background(), request); err != nil {
+			t.Fatalf("HandleRequest: %v", err)
+		}
+	}
+
+	if len(memory.Paths) != 2 || memory.Paths[0].Label != "path 1" || memory.Paths[1].Label != "path 2" {
+		t.Fatalf("paths = %+v", memory.Paths)
+	}
+	var labels []string
+	for _, commute := range memory.AllCommutes() {
+		if commute.PathID == nil {
+			t.Fatalf("commute %d has no path", commute.ID)
+		}
+		for _, path := range memory.Paths {
+			if path.ID == *commute.PathID {
+				labels = append(labels, path.Label)
+			}
+		}
+	}
+	if strings.Join(labels, ",") != "path 1,path 2,path 2" {
+		t.Errorf("commutes took %v", labels)
+	}
+	if diverted := memory.Paths[1]; diverted.Distance != 11200 || !diverted.LastSeen.Equal(start.Add(21*time.Minute)) {
+		t.Errorf("unexpected diverted path: %+v", diverted)
+	}
+	var scrape strings.Builder
+	sink.WriteTo(&scrape)
+	if !strings.Contains(scrape.String(), `optimize_route_route_path_changes_total{direction="to_work"} 1`) {
+		t.Errorf("path change was not counted once:\n%s", scrape.String())
+	}
+}
+
+func TestCheckLinksAdHocCommutesToKnownPaths(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	request := requestFor(t, memory, fake, "boulder_to_denver")
+	now := time.Date(2024, 9, 16, 14, 0, 0, 0, time.UTC)
+	handler.Clock = clock.Fixed(now)
+	direction, err := handler.Store.Direction(context.Background(), memory.Routes[7], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adHoc := Trip{Route: direction, Departure: now.AddDate(0, 0, 2), Record: true, AdHoc: true}
+
+	// A path only seen on demand is not recorded.
+	if _, commute, err := handler.Check(context.Background(), adHoc); err != nil || commute.PathID != nil || len(memory.Paths) != 0 {
+		t.Fatalf("got %+v, %v with paths %+v", commute, err, memory.Paths)
+	}
+	if _, err := handler.HandleRequest(context.Background(), request); err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	seen := memory.Paths[0]
+	_, commute, err := handler.Check(context.Background(), adHoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commute.PathID == nil || *commute.PathID != seen.ID {
+		t.Errorf("ad hoc commute was not linked to %+v", seen)
+	}
+	if len(memory.Paths) != 1 || memory.Paths[0] != seen {
+		t.Errorf("ad hoc commute changed paths to %+v", memory.Paths)
+	}
+}
+
+func TestHandleRequestLogsRunID(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(logging.NewHandler(&buf)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	handler, memory, fake := newHandler(t)
+	request := requestFor(t, memory, fake, "boulder_to_denver")
+	request.RunID = "tick-1"
+	if _, err := handler.HandleRequest(context.Background(), request); err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var record map[string]any
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record["msg"] == "recorded commute" {
+			if record["run_id"] != "tick-1" || record["route_id"] != float64(7) {
+				t.Errorf("unexpected record: %v", record)
+			}
+			return
+		}
+	}
+	t.Error("no recorded commute log line")
+}
+
+func TestHandleRequestNoRoute(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+
+	response, err := handler.HandleRequest(context.Background(), requestFor(t, memory, fake, "no_route"))
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if response.Message != "No routes found in the response" {
+		t.Errorf("message = %q", response.Message)
+	}
+	if len(memory.AllCommutes()) != 0 {
+		t.Error("recorded a commute without a route")
+	}
+}
+
+func TestHandleRequestProviderError(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+
+	_, err := handler.HandleRequest(context.Background(), requestFor(t, memory, fake, "quota_exceeded"))
+	if !errors.Is(err, google.ErrQuota) {
+		t.Fatalf("got %v, want ErrQuota", err)
+	}
+	if len(memory.AllCommutes()) != 0 {
+		t.Error("recorded a commute after a failed request")
+	}
+}
+
+func TestHandleRequestFromWork(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	request := requestFor(t, memory, fake, "boulder_to_denver")
+	toWork := false
+	request.ToWork = &toWork
+
+	if _, err := handler.HandleRequest(context.Background(), request); err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	// The stored route runs Boulder to Denver, so home from work is the
+	// denver_to_boulder fixture.
+	commutes := memory.AllCommutes()
+	if len(commutes) != 1 || commutes[0].ToWork || commutes[0].Duration != 2388 {
+		t.Errorf("unexpected commutes: %+v", commutes)
+	}
+}
+
+func TestHandleRequestValidation(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	valid := requestFor(t, memory, fake, "boulder_to_denver")
+	otherUser, unknownRoute := 4, 99
+
+	tests := map[string]func(r *domain.OptimizeRouteRequest){
+		"missing to_work": func(r *domain.OptimizeRouteRequest) { r.ToWork = nil },
+		"missing route":   func(r *domain.OptimizeRouteRequest) { r.Route = nil },
+		"wrong user":      func(r *domain.OptimizeRouteRequest) { r.UserID = &otherUser },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			request := valid
+			mutate(&request)
+			_, err := handler.HandleRequest(context.Background(), request)
+			if !errors.Is(err, domain.ErrInvalidRequest) {
+				t.Fatalf("got %v, want ErrInvalidRequest", err)
+			}
+		})
+	}
+
+	t.Run("inactive route", func(t *testing.T) {
+		route := memory.Routes[7]
+		route.Active = false
+		memory.Routes[7] = route
+		defer func() { route.Active = true; memory.Routes[7] = route }()
+		if _, err := handler.HandleRequest(context.Background(), valid); !errors.Is(err, domain.ErrInvalidRequest) {
+			t.Fatalf("got %v, want ErrInvalidRequest", err)
+		}
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		request := valid
+		request.Route = &unknownRoute
+		if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("got %v, want ErrNotFound", err)
+		}
+	})
+
+	if len(fake.RouteRequests()) != 0 {
+		t.Errorf("sent %d requests for invalid input", len(fake.RouteRequests()))
+	}
+	if len(memory.AllCommutes()) != 0 {
+		t.Error("recorded a commute for invalid input")
+	}
+}
@@ -0,0 +1,203 @@
+package orderstops
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/tour"
+	"log/slog"
+	"time"
+)
+
+// MaxStops keeps the (stops+1) by (stops+1) matrix within one
+// computeRouteMatrix call.
+const MaxStops = 23
+
+// Request orders Stops between Origin and Destination. With RouteID, the
+// route's ends in the ToWork direction (to work by default) stand in for a
+// missing Origin or Destination, and its saved waypoints for missing Stops.
+// DepartureTime defaults to a minute from now.
+type Request struct {
+	RouteID       *int            `json:"route_id"`
+	ToWork        *bool           `json:"to_work"`
+	Origin        *domain.LatLng  `json:"origin"`
+	Destination   *domain.LatLng  `json:"destination"`
+	Stops         []domain.LatLng `json:"stops"`
+	DepartureTime *time.Time      `json:"departure_time"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+// Data is the fastest order found. Order lists indexes into the stops as
+// given, and Stops the stops in that order. Durations are in seconds and
+// Distance in meters. GivenDuration is the drive in the given order, nil
+// when it has a leg with no route, and Saved the difference. Exact is false
+// when the order was found heuristically and may not be the best.
+type Data struct {
+	RouteID       *int            `json:"route_id,omitempty"`
+	DepartureTime time.Time       `json:"departure_time"`
+	Order         []int           `json:"order"`
+	Stops         []domain.LatLng `json:"stops"`
+	Duration      int             `json:"duration"`
+	Distance      int             `json:"distance"`
+	GivenDuration *int            `json:"given_duration"`
+	Saved         *int            `json:"saved"`
+	Exact         bool            `json:"exact"`
+	Budget        budget.Status   `json:"budget"`
+}
+
+// Handler finds the fastest order to drive through a set of stops, asking
+// the routing provider for every drive between them in one route matrix.
+type Handler struct {
+	Store  *store.Store
+	Routes *google.Client
+	// Clock sets the default departure time and defaults to the system clock.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+// trip fills in the request's ends and stops from its route.
+func (h *Handler) trip(ctx context.Context, request Request) (domain.DueRoute, error) {
+	var trip domain.DueRoute
+	if request.RouteID != nil {
+		toWork := true
+		if request.ToWork != nil {
+			toWork = *request.ToWork
+		}
+		route, err := h.Store.Routes.Get(ctx, *request.RouteID)
+		if err != nil {
+			slog.ErrorContext(ctx, "error loading route", "route_id", *request.RouteID, "error", err)
+			return domain.DueRoute{}, fmt.Errorf("error loading route: %w", err)
+		}
+		if trip, err = h.Store.Direction(ctx, route, toWork); err != nil {
+			slog.ErrorContext(ctx, "error loading route direction", "route_id", route.ID, "error", err)
+			return domain.DueRoute{}, fmt.Errorf("error loading route: %v", err)
+		}
+	} else if request.Origin == nil || request.Destination == nil {
+		return domain.DueRoute{}, fmt.Errorf("%w: origin and destination are required without a route_id", domain.ErrInvalidRequest)
+	}
+	if request.Origin != nil {
+		trip.Origin = *request.Origin
+	}
+	if request.Destination != nil {
+		trip.Destination = *request.Destination
+	}
+	if request.Stops != nil {
+		trip.Waypoints = request.Stops
+	}
+	return trip, nil
+}
+
+// costs arranges the matrix elements from origin and stops to stops and
+// destination into tour costs of seconds and meters.
+func costs(elements []google.RouteMatrixElement, stops int) (tour.Costs, tour.Costs, error) {
+	points := stops + 2
+	durations, distances := make(tour.Costs, points), make(tour.Costs, points)
+	for i := range durations {
+		durations[i], distances[i] = make([]int, points), make([]int, points)
+		for j := range durations[i] {
+			if i == j {
+				continue
+			}
+			durations[i][j], distances[i][j] = -1, -1
+		}
+	}
+	for _, element := range elements {
+		if !element.Exists() {
+			continue
+		}
+		// Origins are points 0 to stops and destinations points 1 to stops+1.
+		from, to := element.OriginIndex, element.DestinationIndex+1
+		if from < 0 || from > stops || to < 1 || to >= points {
+			return nil, nil, fmt.Errorf("route matrix element %d to %d is out of range", element.OriginIndex, element.DestinationIndex)
+		}
+		seconds, err := element.DurationSeconds()
+		if err != nil {
+			return nil, nil, fmt.Errorf("route matrix element %d to %d: %v", element.OriginIndex, element.DestinationIndex, err)
+		}
+		if from != to {
+			durations[from][to], distances[from][to] = seconds, element.DistanceMeters
+		}
+	}
+	return durations, distances, nil
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	ctx = logging.WithRunID(ctx, logging.NewRunID())
+	if h.Routes.APIKey == "" {
+		return Response{}, fmt.Errorf("error loading google maps API key from environment variables")
+	}
+	trip, err := h.trip(ctx, request)
+	if err != nil {
+		return Response{}, err
+	}
+	stops := trip.Waypoints
+	if len(stops) == 0 || len(stops) > MaxStops {
+		return Response{}, fmt.Errorf("%w: need between 1 and %d stops, got %d", domain.ErrInvalidRequest, MaxStops, len(stops))
+	}
+	now := clock.Now(h.Clock)
+	departure := now.Add(1 * time.Minute)
+	if request.DepartureTime != nil {
+		departure = *request.DepartureTime
+	}
+	if departure.Before(now) {
+		return Response{}, fmt.Errorf("%w: departure_time must not be in the past", domain.ErrInvalidRequest)
+	}
+
+	origins := append([]domain.LatLng{trip.Origin}, stops...)
+	destinations := append(append([]domain.LatLng{}, stops...), trip.Destination)
+	matrixRequest := google.NewRouteMatrixRequest(origins, destinations, departure.UTC())
+
+	// Google bills the matrix per element, so the budget must cover them all.
+	guard := budget.NewGuard(h.Store.Usage, google.RoutesProvider)
+	guard.Clock = h.Clock
+	status, err := guard.Require(ctx, 1, matrixRequest.Elements())
+	if err != nil {
+		slog.WarnContext(ctx, "refusing to order stops", "elements", matrixRequest.Elements(), "circuit", status.Circuit, "error", err)
+		return Response{}, err
+	}
+	elements, err := h.Routes.ComputeRouteMatrix(ctx, matrixRequest)
+	if err != nil {
+		slog.ErrorContext(ctx, "error computing route matrix", "stops", len(stops), "error_class", google.ClassName(err), "error", err)
+		return Response{}, fmt.Errorf("error computing route matrix (%s): %w", google.ClassName(err), err)
+	}
+	durations, distances, err := costs(elements, len(stops))
+	if err != nil {
+		slog.ErrorContext(ctx, "error reading route matrix", "error", err)
+		return Response{}, err
+	}
+	solution, err := tour.Solve(durations)
+	if err != nil {
+		slog.WarnContext(ctx, "no order reaches every stop", "stops", len(stops), "error", err)
+		return Response{}, fmt.Errorf("%w: %v", domain.ErrInvalidRequest, err)
+	}
+
+	data := Data{RouteID: request.RouteID, DepartureTime: departure, Order: solution.Order, Duration: solution.Cost, Exact: solution.Exact, Budget: status}
+	data.Distance, _ = distances.Cost(solution.Order)
+	for _, stop := range solution.Order {
+		data.Stops = append(data.Stops, stops[stop])
+	}
+	given := make([]int, len(stops))
+	for i := range given {
+		given[i] = i
+	}
+	if duration, ok := durations.Cost(given); ok {
+		saved := duration - solution.Cost
+		data.GivenDuration, data.Saved = &duration, &saved
+	}
+
+	sink := metrics.Or(h.Metrics)
+	sink.Count("stops_ordered", float64(len(stops)))
+	slog.InfoContext(ctx, "ordered stops", "stops", len(stops), "duration", data.Duration, "exact", data.Exact)
+	return Response{fmt.Sprintf("Ordered %d stops for a %d minute drive.", len(stops), (data.Duration+30)/60), data}, nil
+}
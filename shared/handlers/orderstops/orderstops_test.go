@@ -0,0 +1,141 @@
+package orderstops
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google/fakegoogle"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 9, 4, 16, 0, 0, 0, time.UTC)
+
+func newHandler(t *testing.T) (*Handler, *store.Memory, *fakegoogle.Server) {
+	t.Helper()
+	fake := fakegoogle.NewDefault()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := google.NewClient("test-key")
+	client.SetBaseURL(server.URL)
+	client.Retry = google.RetryPolicy{MaxAttempts: 1}
+
+	db, memory := store.NewMemory()
+	return &Handler{Store: db, Routes: client, Clock: clock.Fixed(now)}, memory, fake
+}
+
+// north is a point due north of Denver's Civic Center.
+func north(latitude float64) domain.LatLng {
+	return domain.LatLng{Latitude: latitude, Longitude: -104.9847}
+}
+
+func TestHandleRequestOrdersStops(t *testing.T) {
+	handler, _, fake := newHandler(t)
+	origin, destination := north(39.70), north(39.80)
+	request := Request{Origin: &origin, Destination: &destination, Stops: []domain.LatLng{north(39.78), north(39.72), north(39.75)}}
+
+	response, err := handler.HandleRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if !slices.Equal(data.Order, []int{1, 2, 0}) || !data.Exact {
+		t.Fatalf("unexpected order: %+v", data)
+	}
+	if !slices.Equal(data.Stops, []domain.LatLng{north(39.72), north(39.75), north(39.78)}) {
+		t.Errorf("stops = %v", data.Stops)
+	}
+	if data.GivenDuration == nil || data.Saved == nil || *data.Saved <= 0 || *data.GivenDuration-*data.Saved != data.Duration {
+		t.Errorf("unexpected saving: %+v", data)
+	}
+	if data.Distance < 11000 || data.Distance > 11200 {
+		t.Errorf("distance = %d", data.Distance)
+	}
+	if !data.DepartureTime.Equal(now.Add(time.Minute)) {
+		t.Errorf("departure = %v", data.DepartureTime)
+	}
+
+	requests := fake.MatrixRequests()
+	if len(requests) != 1 || len(requests[0].Origins) != 4 || len(requests[0].Destinations) != 4 {
+		t.Fatalf("unexpected matrix requests: %+v", requests)
+	}
+	if got := requests[0].Destinations[3].Waypoint.Location.LatLng; got != destination {
+		t.Errorf("last destination = %v", got)
+	}
+}
+
+func TestHandleRequestUsesRouteWaypoints(t *testing.T) {
+	handler, memory, _ := newHandler(t)
+	memory.Routes[7] = domain.Route{
+		ID: 7, UserID: 3, Active: true, TimeZone: "America/Denver",
+		StartLatitude: "39.7", StartLongitude: "-104.9847", EndLatitude: "39.8", EndLongitude: "-104.9847",
+	}
+	memory.Waypoints[7] = []domain.Waypoint{
+		{RouteID: 7, Position: 1, Latitude: "39.72", Longitude: "-104.9847"},
+		{RouteID: 7, Position: 2, Latitude: "39.78", Longitude: "-104.9847"},
+		{RouteID: 7, Position: 3, Latitude: "39.75", Longitude: "-104.9847"},
+	}
+	routeID, toWork := 7, false
+
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, ToWork: &toWork})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	// Driving home visits the saved stops in reverse: 39.75, 39.78, 39.72.
+	if data := response.Data; !slices.Equal(data.Order, []int{1, 0, 2}) || *data.RouteID != 7 {
+		t.Errorf("unexpected data: %+v", data)
+	}
+}
+
+func TestHandleRequestRespectsBudget(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	// Three stops need a 4 by 4 matrix, billed as 16 requests.
+	limit := 15
+	memory.Budgets[google.RoutesProvider] = domain.Budget{Provider: google.RoutesProvider, DailyLimit: &limit}
+	origin, destination := north(39.70), north(39.80)
+	request := Request{Origin: &origin, Destination: &destination, Stops: []domain.LatLng{north(39.78), north(39.72), north(39.75)}}
+
+	if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, google.ErrQuota) {
+		t.Fatalf("got %v, want ErrQuota", err)
+	}
+	if len(fake.MatrixRequests()) != 0 {
+		t.Error("called Google without the budget")
+	}
+
+	limit = 16
+	if _, err := handler.HandleRequest(context.Background(), request); err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+}
+
+func TestHandleRequestValidation(t *testing.T) {
+	handler, _, fake := newHandler(t)
+	origin, destination := north(39.70), north(39.80)
+	missingRoute, past := 99, now.Add(-time.Hour)
+	tooMany := make([]domain.LatLng, MaxStops+1)
+	for i := range tooMany {
+		tooMany[i] = north(39.71 + float64(i)/1000)
+	}
+
+	for name, request := range map[string]Request{
+		"no origin":      {Destination: &destination, Stops: []domain.LatLng{north(39.75)}},
+		"no stops":       {Origin: &origin, Destination: &destination},
+		"too many stops": {Origin: &origin, Destination: &destination, Stops: tooMany},
+		"past departure": {Origin: &origin, Destination: &destination, Stops: []domain.LatLng{north(39.75)}, DepartureTime: &past},
+	} {
+		if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, domain.ErrInvalidRequest) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &missingRoute}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("unknown route: got %v", err)
+	}
+	if requests := fake.MatrixRequests(); len(requests) != 0 {
+		t.Errorf("made %d matrix requests", len(requests))
+	}
+}
@@ -0,0 +1,180 @@
+package plandeparture
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/budget"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/planner"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultConfidence = 0.9
+	minConfidence     = 0.5
+	maxConfidence     = 0.99
+	// history is how far back past commutes are used to size the buffer.
+	history = 8 * 7 * 24 * time.Hour
+)
+
+// Request plans one route's departure. ToWork defaults to the morning
+// direction. ArriveBy ("HH:MM" or "HH:MM:SS") defaults to the schedule's
+// target for that direction, and Date ("YYYY-MM-DD") to the next day that
+// target is still ahead. Confidence is the chance of arriving on time, 0.9 by
+// default.
+type Request struct {
+	RouteID    *int     `json:"route_id"`
+	ToWork     *bool    `json:"to_work"`
+	Date       string   `json:"date"`
+	ArriveBy   string   `json:"arrive_by"`
+	Confidence *float64 `json:"confidence"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+type Data struct {
+	RouteID int  `json:"route_id"`
+	ToWork  bool `json:"to_work"`
+	planner.Plan
+}
+
+// Handler answers "when do I have to leave to get there on time" for a
+// single route.
+type Handler struct {
+	Store  *store.Store
+	Routes *google.Client
+	// Predict defaults to asking Routes when nil.
+	Predict planner.Predictor
+	// Clock defaults to the system clock when nil.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+func directionName(toWork bool) string {
+	if toWork {
+		return "to_work"
+	}
+	return "from_work"
+}
+
+func parseTimeOfDay(value string) (time.Time, error) {
+	if t, err := time.Parse(time.TimeOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("15:04", value)
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	ctx = logging.WithRunID(ctx, logging.NewRunID())
+	if request.RouteID == nil {
+		return Response{}, fmt.Errorf("%w. Missing route ID", domain.ErrInvalidRequest)
+	}
+	toWork := true
+	if request.ToWork != nil {
+		toWork = *request.ToWork
+	}
+	confidence := defaultConfidence
+	if request.Confidence != nil {
+		confidence = *request.Confidence
+	}
+	if confidence < minConfidence || confidence > maxConfidence {
+		return Response{}, fmt.Errorf("%w: confidence must be between %v and %v, got %v", domain.ErrInvalidRequest, minConfidence, maxConfidence, confidence)
+	}
+
+	route, err := h.Store.Routes.Get(ctx, *request.RouteID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading route", "route_id", *request.RouteID, "error", err)
+		return Response{}, fmt.Errorf("error loading route: %w", err)
+	}
+	if !route.Active {
+		return Response{}, fmt.Errorf("%w: route %d is inactive", domain.ErrInvalidRequest, route.ID)
+	}
+	loc, err := time.LoadLocation(route.TimeZone)
+	if err != nil {
+		return Response{}, fmt.Errorf("route %d has an invalid time zone %q: %v", route.ID, route.TimeZone, err)
+	}
+
+	target := request.ArriveBy
+	if target == "" {
+		schedule, err := h.Store.Schedules.GetByRoute(ctx, route.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "error loading schedule", "route_id", route.ID, "error", err)
+			return Response{}, fmt.Errorf("error loading schedule: %w", err)
+		}
+		target = schedule.ArriveBy(toWork)
+	}
+	if target == "" {
+		return Response{}, fmt.Errorf("%w: route %d has no %s arrive_by time and none was given", domain.ErrInvalidRequest, route.ID, directionName(toWork))
+	}
+	arriveAt, err := parseTimeOfDay(target)
+	if err != nil {
+		return Response{}, fmt.Errorf("%w: arrive_by must be HH:MM or HH:MM:SS, got %q", domain.ErrInvalidRequest, target)
+	}
+
+	now := clock.Now(h.Clock)
+	local := now.In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	if request.Date != "" {
+		day, err = time.ParseInLocation(time.DateOnly, request.Date, loc)
+		if err != nil {
+			return Response{}, fmt.Errorf("%w: date must be YYYY-MM-DD, got %q", domain.ErrInvalidRequest, request.Date)
+		}
+	}
+	arriveBy := time.Date(day.Year(), day.Month(), day.Day(), arriveAt.Hour(), arriveAt.Minute(), arriveAt.Second(), 0, loc)
+	if !arriveBy.After(now) {
+		if request.Date != "" {
+			return Response{}, fmt.Errorf("%w: arrive_by %s is in the past", domain.ErrInvalidRequest, arriveBy.Format(time.RFC3339))
+		}
+		arriveBy = arriveBy.AddDate(0, 0, 1)
+	}
+
+	trip, err := h.Store.Direction(ctx, route, toWork)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading route direction", "route_id", route.ID, "error", err)
+		return Response{}, err
+	}
+	commutes, err := h.Store.Commutes.Recent(ctx, route.ID, toWork, now.Add(-history))
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading recent commutes", "route_id", route.ID, "error", err)
+		return Response{}, fmt.Errorf("error loading recent commutes: %v", err)
+	}
+
+	predict := h.Predict
+	if predict == nil {
+		predict = planner.GooglePredictor(h.Routes)
+	}
+	plans := planner.New(predict)
+	// A plan may probe the provider up to MaxProbes times; refuse up front
+	// rather than run out of budget halfway. The probes are reserved as one
+	// call, so a half-open circuit lets a whole plan through as its probe.
+	guard := budget.NewGuard(h.Store.Usage, google.RoutesProvider)
+	guard.Clock = h.Clock
+	if status, err := guard.Require(ctx, 1, plans.MaxProbes); err != nil {
+		slog.WarnContext(ctx, "refusing to plan departure", "route_id", route.ID, "circuit", status.Circuit, "error", err)
+		return Response{}, err
+	}
+	plan, err := plans.Plan(ctx, trip, arriveBy, confidence, commutes, now)
+	if err != nil {
+		slog.ErrorContext(ctx, "error planning departure", "route_id", route.ID, "error", err)
+		return Response{}, err
+	}
+	metrics.Or(h.Metrics).Count("departures_planned", 1, "late", fmt.Sprint(plan.Late))
+
+	slog.InfoContext(ctx, "planned departure", "route_id", route.ID, "to_work", toWork, "arrive_by", plan.ArriveBy,
+		"departure", plan.Departure, "predicted_s", plan.Predicted, "buffer_s", plan.Buffer, "samples", plan.Samples)
+	data := Data{RouteID: route.ID, ToWork: toWork, Plan: plan}
+	if plan.Late {
+		return Response{fmt.Sprintf("Leave now; the latest safe departure was %s.", plan.Departure.In(loc).Format("15:04")), data}, nil
+	}
+	return Response{fmt.Sprintf("Leave by %s to arrive by %s.", plan.Departure.In(loc).Format("15:04"), arriveBy.Format("15:04")), data}, nil
+}
@@ -0,0 +1,170 @@
+package plandeparture
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google/fakegoogle"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+// thursdayDawn is before the 09:00 target on Thursday.
+var thursdayDawn = time.Date(2024, 9, 5, 6, 0, 0, 0, denver)
+
+func newHandler(t *testing.T) (*Handler, *store.Memory, *fakegoogle.Server) {
+	t.Helper()
+	fake := fakegoogle.NewDefault()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := google.NewClient("test-key")
+	client.SetBaseURL(server.URL)
+	client.Retry = google.RetryPolicy{MaxAttempts: 1}
+
+	db, memory := store.NewMemory()
+	memory.Routes[7] = domain.Route{
+		ID:             7,
+		UserID:         3,
+		StartLatitude:  "40.01499",
+		StartLongitude: "-105.27055",
+		EndLatitude:    "39.73915",
+		EndLongitude:   "-104.9847",
+		TimeZone:       "America/Denver",
+		Active:         true,
+	}
+	memory.Schedules[7] = domain.Schedule{RouteID: 7, MorningStartTime: "07:00:00", MorningEndTime: "09:00:00", MorningArriveBy: "09:00:00"}
+	return &Handler{Store: db, Routes: client, Clock: clock.Fixed(thursdayDawn)}, memory, fake
+}
+
+func TestHandleRequestPlansFromSchedule(t *testing.T) {
+	handler, _, fake := newHandler(t)
+	routeID := 7
+
+	// The fake always predicts 2104 seconds to work, so the plan settles on
+	// the second probe.
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	want := time.Date(2024, 9, 5, 8, 24, 0, 0, denver)
+	if !data.ToWork || !data.Departure.Equal(want) || data.Predicted != 2104 || data.Buffer != 0 || data.Confidence != 0.9 || data.Late {
+		t.Errorf("unexpected data: %+v", data)
+	}
+	if requests := fake.RouteRequests(); len(requests) != 2 || !requests[1].DepartureTime.Equal(want) {
+		t.Errorf("unexpected route requests: %+v", requests)
+	}
+	if response.Message != "Leave by 08:24 to arrive by 09:00." {
+		t.Errorf("message = %q", response.Message)
+	}
+}
+
+func TestHandleRequestRespectsBudget(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	routeID := 7
+	// Enough for the two probes this plan takes, but not for the four it may.
+	limit := 3
+	memory.Budgets[google.RoutesProvider] = domain.Budget{Provider: google.RoutesProvider, DailyLimit: &limit}
+
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID}); !errors.Is(err, google.ErrQuota) {
+		t.Fatalf("got %v, want ErrQuota", err)
+	}
+	if requests := fake.RouteRequests(); len(requests) != 0 {
+		t.Errorf("sent %d route requests", len(requests))
+	}
+}
+
+func TestHandleRequestProbesHalfOpenCircuit(t *testing.T) {
+	handler, memory, fake := newHandler(t)
+	routeID := 7
+	memory.Circuits[google.RoutesProvider] = thursdayDawn.Add(-time.Hour)
+
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID}); err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if requests := fake.RouteRequests(); len(requests) != 2 {
+		t.Errorf("sent %d route requests, want the plan's 2", len(requests))
+	}
+}
+
+func TestHandleRequestBuffersFromHistory(t *testing.T) {
+	handler, memory, _ := newHandler(t)
+	routeID := 7
+	for i, duration := range []int{1700, 1750, 1800, 1800, 1800, 1850, 1900, 2000, 2100, 2400} {
+		memory.Commutes = append(memory.Commutes, domain.Commute{
+			Route:     7,
+			UserID:    3,
+			ToWork:    true,
+			QueryTime: time.Date(2024, 8, 20+i, 8, 25, 0, 0, denver),
+			Duration:  duration,
+		})
+	}
+	handler.Predict = func(ctx context.Context, trip domain.DueRoute, departure time.Time) (int, error) {
+		return 1800, nil
+	}
+
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if !data.Departure.Equal(time.Date(2024, 9, 5, 8, 25, 0, 0, denver)) || data.Buffer != 300 || data.Samples != 10 {
+		t.Errorf("unexpected data: %+v", data)
+	}
+}
+
+func TestHandleRequestRollsOverAndOverrides(t *testing.T) {
+	handler, _, _ := newHandler(t)
+	routeID, toWork := 7, false
+
+	// Past 09:00 the next target is tomorrow's.
+	handler.Clock = clock.Fixed(thursdayDawn.Add(4 * time.Hour))
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if want := time.Date(2024, 9, 6, 9, 0, 0, 0, denver); !response.Data.ArriveBy.Equal(want) {
+		t.Errorf("arrive_by = %v, want %v", response.Data.ArriveBy, want)
+	}
+
+	// The schedule has no afternoon target, so one must be given.
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, ToWork: &toWork}); !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("err = %v, want ErrInvalidRequest without an arrive_by time", err)
+	}
+	response, err = handler.HandleRequest(context.Background(), Request{RouteID: &routeID, ToWork: &toWork, ArriveBy: "17:30", Date: "2024-09-09"})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if want := time.Date(2024, 9, 9, 17, 30, 0, 0, denver); !response.Data.ArriveBy.Equal(want) || response.Data.Predicted != 2388 {
+		t.Errorf("unexpected data: %+v", response.Data)
+	}
+}
+
+func TestHandleRequestRejectsBadInput(t *testing.T) {
+	handler, memory, _ := newHandler(t)
+	routeID, inactive, unknown := 7, 8, 99
+	tooSure := 1.0
+	memory.Routes[8] = domain.Route{ID: 8, UserID: 3, TimeZone: "America/Denver"}
+
+	for _, request := range []Request{
+		{},
+		{RouteID: &routeID, Confidence: &tooSure},
+		{RouteID: &routeID, ArriveBy: "9am"},
+		{RouteID: &routeID, Date: "Thursday"},
+		{RouteID: &routeID, Date: "2024-09-04"},
+		{RouteID: &inactive, ArriveBy: "09:00"},
+	} {
+		if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, domain.ErrInvalidRequest) {
+			t.Errorf("HandleRequest(%+v) = %v, want ErrInvalidRequest", request, err)
+		}
+	}
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &unknown}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,169 @@
+package routepaths
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/pathhistory"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/polyline"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"time"
+)
+
+// Request reports the paths one route took over the last Days days (30 by
+// default), in one direction or, when ToWork is unset, both. Backfill first
+// links the period's commutes recorded before paths were tracked.
+type Request struct {
+	RouteID  *int  `json:"route_id"`
+	ToWork   *bool `json:"to_work"`
+	Days     *int  `json:"days"`
+	Backfill bool  `json:"backfill"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+type Data struct {
+	RouteID    int         `json:"route_id"`
+	Since      time.Time   `json:"since"`
+	Backfilled int         `json:"backfilled"`
+	Directions []Direction `json:"directions"`
+}
+
+// Direction lists every path the route has taken in one direction, with how
+// the period's scheduled commutes on each performed, and each time a commute
+// took a different path than the one before it. Current is the label of the
+// latest commute's path.
+type Direction struct {
+	Direction string             `json:"direction"`
+	ToWork    bool               `json:"to_work"`
+	Current   string             `json:"current,omitempty"`
+	Paths     []domain.PathStats `json:"paths"`
+	Changes   []Change           `json:"changes"`
+}
+
+type Change struct {
+	At        time.Time `json:"at"`
+	CommuteID int       `json:"commute_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+}
+
+// Handler shows when the provider moved a route onto a different path and
+// how each path performs.
+type Handler struct {
+	Store *store.Store
+	// Clock defaults to the system clock when nil.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+func directionName(toWork bool) string {
+	if toWork {
+		return "to_work"
+	}
+	return "from_work"
+}
+
+// backfill links the commutes without a path to the one their polyline
+// follows, oldest first so labels keep the order paths were first taken.
+func (h *Handler) backfill(ctx context.Context, commutes []domain.Commute) (int, error) {
+	linked := 0
+	for i, commute := range commutes {
+		if commute.PathID != nil || commute.RouteHash == "" {
+			continue
+		}
+		fingerprint, err := polyline.Fingerprint(commute.RouteHash)
+		if err != nil {
+			slog.WarnContext(ctx, "skipping commute with an invalid polyline", "commute_id", commute.ID, "error", err)
+			continue
+		}
+		path, _, err := h.Store.Paths.Record(ctx, domain.RoutePath{
+			RouteID:     commute.Route,
+			ToWork:      commute.ToWork,
+			Fingerprint: fingerprint,
+			Polyline:    commute.RouteHash,
+			Distance:    commute.Distance,
+			FirstSeen:   commute.QueryTime,
+			LastSeen:    commute.QueryTime,
+		})
+		if err != nil {
+			return linked, err
+		}
+		if err := h.Store.Commutes.SetPath(ctx, commute.ID, path.ID); err != nil {
+			return linked, err
+		}
+		commutes[i].PathID = &path.ID
+		linked++
+	}
+	return linked, nil
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	ctx = logging.WithRunID(ctx, logging.NewRunID())
+	period, err := pathhistory.Open(ctx, h.Store, pathhistory.Query{RouteID: request.RouteID, ToWork: request.ToWork, Days: request.Days}, clock.Now(h.Clock))
+	if err != nil {
+		return Response{}, err
+	}
+	route := period.Route
+
+	data := Data{RouteID: route.ID, Since: period.Since, Directions: []Direction{}}
+	changes := 0
+	for _, toWork := range period.Directions {
+		commutes, err := period.Commutes(ctx, h.Store, toWork)
+		if err != nil {
+			return Response{}, err
+		}
+		if request.Backfill {
+			linked, err := h.backfill(ctx, commutes)
+			data.Backfilled += linked
+			if err != nil {
+				slog.ErrorContext(ctx, "error backfilling paths", "route_id", route.ID, "to_work", toWork, "error", err)
+				return Response{}, fmt.Errorf("error backfilling paths: %v", err)
+			}
+		}
+
+		stats, labels, err := period.Paths(ctx, h.Store, toWork)
+		if err != nil {
+			return Response{}, err
+		}
+
+		direction := Direction{Direction: directionName(toWork), ToWork: toWork, Paths: stats, Changes: []Change{}}
+		if direction.Paths == nil {
+			direction.Paths = []domain.PathStats{}
+		}
+		var previous *int
+		for _, commute := range commutes {
+			if commute.PathID == nil {
+				continue
+			}
+			if previous != nil && *previous != *commute.PathID {
+				direction.Changes = append(direction.Changes, Change{
+					At:        commute.QueryTime,
+					CommuteID: commute.ID,
+					From:      labels[*previous],
+					To:        labels[*commute.PathID],
+				})
+			}
+			previous = commute.PathID
+		}
+		if previous != nil {
+			direction.Current = labels[*previous]
+		}
+		changes += len(direction.Changes)
+		data.Directions = append(data.Directions, direction)
+	}
+
+	if data.Backfilled > 0 {
+		metrics.Or(h.Metrics).Count("route_paths_backfilled", float64(data.Backfilled))
+	}
+	slog.InfoContext(ctx, "listed route paths", "route_id", route.ID, "days", period.Days, "changes", changes, "backfilled", data.Backfilled)
+	return Response{fmt.Sprintf("Route %d changed paths %d times in the last %d days.", route.ID, changes, period.Days), data}, nil
+}
@@ -0,0 +1,107 @@
+package routepaths
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/polyline"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+var (
+	origin      = domain.LatLng{Latitude: 40.01499, Longitude: -105.27055}
+	destination = domain.LatLng{Latitude: 40.01499, Longitude: -105.17055}
+	direct      = polyline.Encode([]domain.LatLng{origin, destination})
+	detour      = polyline.Encode([]domain.LatLng{origin, {Latitude: 40.04, Longitude: -105.22}, destination})
+)
+
+func TestHandleRequestBackfillsAndReportsChanges(t *testing.T) {
+	db, memory := store.NewMemory()
+	memory.Routes[7] = domain.Route{ID: 7, UserID: 3, Active: true, TimeZone: "America/Denver"}
+	// Route 7 was diverted on Wednesday morning only. The ad hoc check and
+	// the commute from before the period are left alone.
+	for i, commute := range []struct {
+		day      int
+		duration int
+		polyline string
+		adHoc    bool
+	}{
+		{1, 1500, direct, false},
+		{9, 1500, direct, false},
+		{10, 1600, direct, false},
+		{11, 2400, detour, false},
+		{11, 2500, detour, true},
+		{12, 1700, direct, false},
+	} {
+		memory.Commutes = append(memory.Commutes, domain.Commute{
+			ID: 100 + i, Route: 7, UserID: 3, ToWork: true, AdHoc: commute.adHoc, RouteHash: commute.polyline,
+			QueryTime: time.Date(2024, 9, commute.day, 8, i, 0, 0, denver), Duration: commute.duration,
+		})
+	}
+
+	handler := &Handler{Store: db, Clock: clock.Fixed(time.Date(2024, 9, 13, 12, 0, 0, 0, denver))}
+	routeID, toWork, days := 7, true, 7
+	response, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, ToWork: &toWork, Days: &days, Backfill: true})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if data.Backfilled != 4 || len(data.Directions) != 1 {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+	direction := data.Directions[0]
+	if direction.Current != "path 1" || len(direction.Paths) != 2 || len(direction.Changes) != 2 {
+		t.Fatalf("unexpected direction: %+v", direction)
+	}
+	usual, diverted := direction.Paths[0], direction.Paths[1]
+	if usual.Label != "path 1" || usual.Commutes != 3 || usual.AverageDuration != 1600 || usual.MinDuration != 1500 || usual.MaxDuration != 1700 {
+		t.Errorf("unexpected usual path: %+v", usual)
+	}
+	if diverted.Label != "path 2" || diverted.Commutes != 1 || diverted.AverageDuration != 2400 {
+		t.Errorf("unexpected diverted path: %+v", diverted)
+	}
+	want := Change{At: time.Date(2024, 9, 11, 8, 3, 0, 0, denver), CommuteID: 103, From: "path 1", To: "path 2"}
+	if direction.Changes[0] != want || direction.Changes[1].From != "path 2" || direction.Changes[1].To != "path 1" {
+		t.Errorf("changes = %+v", direction.Changes)
+	}
+	if response.Message != "Route 7 changed paths 2 times in the last 7 days." {
+		t.Errorf("message = %q", response.Message)
+	}
+
+	for _, commute := range memory.AllCommutes() {
+		if linked := commute.PathID != nil; linked != (commute.ID != 100 && !commute.AdHoc) {
+			t.Errorf("commute %d linked = %v", commute.ID, linked)
+		}
+	}
+
+	// A second run has nothing left to link.
+	response, err = handler.HandleRequest(context.Background(), Request{RouteID: &routeID, Days: &days, Backfill: true})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	if response.Data.Backfilled != 0 || len(response.Data.Directions) != 2 || len(response.Data.Directions[1].Paths) != 0 {
+		t.Errorf("unexpected second run: %+v", response.Data)
+	}
+}
+
+func TestHandleRequestValidation(t *testing.T) {
+	db, memory := store.NewMemory()
+	memory.Routes[7] = domain.Route{ID: 7, UserID: 3, Active: true, TimeZone: "America/Denver"}
+	handler := &Handler{Store: db}
+	routeID, missingID, days := 7, 8, 0
+
+	if _, err := handler.HandleRequest(context.Background(), Request{}); !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("missing route: got %v", err)
+	}
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &routeID, Days: &days}); !errors.Is(err, domain.ErrInvalidRequest) {
+		t.Errorf("zero days: got %v", err)
+	}
+	if _, err := handler.HandleRequest(context.Background(), Request{RouteID: &missingID}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("unknown route: got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package weeklyreport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/report"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Request builds the reports for the week containing WeekOf ("YYYY-MM-DD"),
+// by default the last full week, for every user with active routes or just
+// UserID. Format picks how each report is returned: "json" (the default)
+// returns only the report, "markdown" and "html" also render it. Send
+// delivers each report through the handler's Sender.
+type Request struct {
+	WeekOf string `json:"week_of"`
+	UserID *int   `json:"user_id"`
+	Format string `json:"format"`
+	Send   bool   `json:"send"`
+}
+
+type Response struct {
+	Message string `json:"message"`
+	Data    Data   `json:"data"`
+}
+
+type Data struct {
+	WeekStart string   `json:"week_start"`
+	WeekEnd   string   `json:"week_end"`
+	Sent      int      `json:"reports_sent"`
+	Failed    int      `json:"reports_failed"`
+	RunID     string   `json:"run_id"`
+	Reports   []Result `json:"reports"`
+}
+
+// Result is one user's report. Content is the rendered report for the
+// markdown and html formats; Reason explains a report that was not sent.
+type Result struct {
+	Report  report.Report `json:"report"`
+	Content string        `json:"content,omitempty"`
+	Sent    bool          `json:"sent"`
+	Reason  string        `json:"reason,omitempty"`
+}
+
+// Handler summarises each user's week of commutes.
+type Handler struct {
+	Store *store.Store
+	// Sender delivers reports when a request asks to send them.
+	Sender report.Sender
+	// Clock defaults to the system clock when nil.
+	Clock clock.Clock
+	// Metrics defaults to discarding metrics when nil.
+	Metrics metrics.Sink
+}
+
+// commutes loads both directions of route from since onward.
+func (h *Handler) commutes(ctx context.Context, route domain.Route, since time.Time) ([]domain.Commute, error) {
+	var commutes []domain.Commute
+	for _, toWork := range []bool{true, false} {
+		recent, err := h.Store.Commutes.Recent(ctx, route.ID, toWork, since)
+		if err != nil {
+			return nil, err
+		}
+		commutes = append(commutes, recent...)
+	}
+	return commutes, nil
+}
+
+func hasCommutes(built report.Report) bool {
+	for _, route := range built.Routes {
+		if len(route.Directions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, request Request) (Response, error) {
+	runID := logging.NewRunID()
+	ctx = logging.WithRunID(ctx, runID)
+
+	now := clock.Now(h.Clock)
+	monday := report.WeekStart(now).AddDate(0, 0, -7)
+	if request.WeekOf != "" {
+		day, err := time.Parse(time.DateOnly, request.WeekOf)
+		if err != nil {
+			return Response{}, fmt.Errorf("%w: week_of must be YYYY-MM-DD, got %q", domain.ErrInvalidRequest, request.WeekOf)
+		}
+		monday = report.WeekStart(day)
+		if monday.After(now) {
+			return Response{}, fmt.Errorf("%w: the week of %s has not started", domain.ErrInvalidRequest, request.WeekOf)
+		}
+	}
+	format := request.Format
+	if format == "" {
+		format = "json"
+	}
+	if !slices.Contains(report.Formats, format) {
+		return Response{}, fmt.Errorf("%w: format must be one of %v, got %q", domain.ErrInvalidRequest, report.Formats, format)
+	}
+	if request.Send && h.Sender == nil {
+		return Response{}, errors.New("no report sender is configured")
+	}
+
+	scheduled, err := h.Store.Schedules.Active(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading active routes", "error", err)
+		return Response{}, fmt.Errorf("error loading active routes: %v", err)
+	}
+	// Start a day early so the week before begins on its Monday in every time
+	// zone.
+	since := monday.AddDate(0, 0, -8)
+	byUser := map[int][]report.RouteCommutes{}
+	for _, route := range scheduled {
+		if request.UserID != nil && route.Route.UserID != *request.UserID {
+			continue
+		}
+		commutes, err := h.commutes(ctx, route.Route, since)
+		if err != nil {
+			slog.ErrorContext(ctx, "error loading commutes", "route_id", route.Route.ID, "error", err)
+			return Response{}, fmt.Errorf("error loading commutes for route %d: %v", route.Route.ID, err)
+		}
+		waypoints, err := h.Store.Waypoints.List(ctx, route.Route.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "error loading waypoints", "route_id", route.Route.ID, "error", err)
+			return Response{}, fmt.Errorf("error loading waypoints for route %d: %v", route.Route.ID, err)
+		}
+		byUser[route.Route.UserID] = append(byUser[route.Route.UserID], report.RouteCommutes{Route: route.Route, Waypoints: waypoints, Commutes: commutes})
+	}
+	if request.UserID != nil && len(byUser) == 0 {
+		return Response{}, fmt.Errorf("active routes for user %d: %w", *request.UserID, store.ErrNotFound)
+	}
+	users := make([]int, 0, len(byUser))
+	for userID := range byUser {
+		users = append(users, userID)
+	}
+	sort.Ints(users)
+
+	sink := metrics.Or(h.Metrics)
+	data := Data{
+		WeekStart: monday.Format(time.DateOnly),
+		WeekEnd:   monday.AddDate(0, 0, 6).Format(time.DateOnly),
+		RunID:     runID,
+		Reports:   make([]Result, 0, len(users)),
+	}
+	for _, userID := range users {
+		result := Result{Report: report.Build(userID, monday, byUser[userID])}
+		if format != "json" {
+			if result.Content, err = report.Render(result.Report, format); err != nil {
+				return Response{}, err
+			}
+		}
+		sink.Count("reports_built", 1)
+
+		switch {
+		case !request.Send:
+		case !hasCommutes(result.Report):
+			result.Reason = "nothing recorded this week"
+		default:
+			err := h.Sender.Send(ctx, result.Report)
+			if errors.Is(err, report.ErrNoSubscriptions) {
+				result.Reason = err.Error()
+				break
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "error sending report", "user_id", userID, "error", err)
+				sink.Count("reports_failed", 1)
+				result.Reason = err.Error()
+				data.Failed++
+				break
+			}
+			sink.Count("reports_sent", 1)
+			result.Sent = true
+			data.Sent++
+		}
+		data.Reports = append(data.Reports, result)
+	}
+
+	slog.InfoContext(ctx, "weekly reports complete", "week_start", data.WeekStart, "users", len(users), "sent", data.Sent, "failed", data.Failed)
+	return Response{fmt.Sprintf("Built %d weekly reports.", len(data.Reports)), data}, nil
+}
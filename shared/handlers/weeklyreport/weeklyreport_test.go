@@ -0,0 +1,128 @@
+package weeklyreport
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/clock"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/notify"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/report"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 9, day, hour, minute, 0, 0, denver)
+}
+
+// newHandler seeds route 7 for user 3, who subscribes and commuted in the
+// week of 2024-09-09, and route 8 for user 4, who recorded nothing. It is
+// Wednesday of the week after.
+func newHandler(t *testing.T) (*Handler, *store.Memory, *notify.Capture) {
+	t.Helper()
+	db, memory := store.NewMemory()
+	for id, userID := range map[int]int{7: 3, 8: 4} {
+		memory.Routes[id] = domain.Route{ID: id, UserID: userID, Active: true, TimeZone: "America/Denver"}
+		memory.Schedules[id] = domain.Schedule{RouteID: id, MorningStartTime: "07:00:00", MorningEndTime: "09:00:00"}
+	}
+	memory.Commutes = append(memory.Commutes,
+		domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: at(9, 8, 0), Duration: 1800},
+		domain.Commute{Route: 7, UserID: 3, ToWork: true, QueryTime: at(10, 8, 0), Duration: 1500},
+		domain.Commute{Route: 7, UserID: 3, ToWork: false, QueryTime: at(10, 17, 0), Duration: 2100},
+	)
+	memory.Subscriptions = []domain.Subscription{{UserID: 3, Channel: "capture", Target: "phone", Active: true}}
+
+	capture := &notify.Capture{}
+	handler := &Handler{
+		Store:  db,
+		Sender: &report.Subscribers{Notifications: db.Notifications, Channels: notify.Channels{"capture": capture}},
+		Clock:  clock.Fixed(at(18, 12, 0)),
+	}
+	return handler, memory, capture
+}
+
+func TestHandleRequestBuildsLastWeek(t *testing.T) {
+	handler, _, capture := newHandler(t)
+
+	response, err := handler.HandleRequest(context.Background(), Request{Send: true})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if data.WeekStart != "2024-09-09" || data.Sent != 1 || data.Failed != 0 || len(data.Reports) != 2 {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+	first := data.Reports[0]
+	if first.Report.UserID != 3 || !first.Sent || first.Content != "" || first.Report.Total != 5400 {
+		t.Errorf("unexpected report for user 3: %+v", first)
+	}
+	if second := data.Reports[1]; second.Report.UserID != 4 || second.Sent || second.Reason != "nothing recorded this week" {
+		t.Errorf("unexpected report for user 4: %+v", second)
+	}
+	if sent := capture.Sent(); len(sent) != 1 || sent[0].Message.UserID != 3 {
+		t.Errorf("captured %+v", sent)
+	}
+}
+
+func TestHandleRequestRendersOneUser(t *testing.T) {
+	handler, _, capture := newHandler(t)
+	userID := 3
+
+	response, err := handler.HandleRequest(context.Background(), Request{WeekOf: "2024-09-11", UserID: &userID, Format: "markdown"})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	reports := response.Data.Reports
+	if len(reports) != 1 || reports[0].Sent || !strings.HasPrefix(reports[0].Content, "# Commutes from 2024-09-09 to 2024-09-15") {
+		t.Errorf("unexpected reports: %+v", reports)
+	}
+	if sent := capture.Sent(); len(sent) != 0 {
+		t.Errorf("captured %+v without send", sent)
+	}
+}
+
+func TestHandleRequestSendFailures(t *testing.T) {
+	handler, memory, _ := newHandler(t)
+	memory.Commutes = append(memory.Commutes, domain.Commute{Route: 8, UserID: 4, ToWork: true, QueryTime: at(9, 8, 0), Duration: 1800})
+	handler.Sender = report.SenderFunc(func(ctx context.Context, built report.Report) error {
+		if built.UserID == 4 {
+			return report.ErrNoSubscriptions
+		}
+		return errors.New("connection refused")
+	})
+
+	response, err := handler.HandleRequest(context.Background(), Request{Send: true})
+	if err != nil {
+		t.Fatalf("HandleRequest: %v", err)
+	}
+	data := response.Data
+	if data.Sent != 0 || data.Failed != 1 || data.Reports[0].Reason != "connection refused" || data.Reports[1].Reason != "no subscriptions" {
+		t.Errorf("unexpected data: %+v", data)
+	}
+}
+
+func TestHandleRequestRejectsBadInput(t *testing.T) {
+	handler, _, _ := newHandler(t)
+	unknown := 99
+
+	for _, request := range []Request{
+		{WeekOf: "last week"},
+		{WeekOf: "2024-09-30"},
+		{Format: "pdf"},
+	} {
+		if _, err := handler.HandleRequest(context.Background(), request); !errors.Is(err, domain.ErrInvalidRequest) {
+			t.Errorf("HandleRequest(%+v) = %v, want ErrInvalidRequest", request, err)
+		}
+	}
+	if _, err := handler.HandleRequest(context.Background(), Request{UserID: &unknown}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+	handler.Sender = nil
+	if _, err := handler.HandleRequest(context.Background(), Request{Send: true}); err == nil {
+		t.Error("expected an error sending without a sender")
+	}
+}
@@ -0,0 +1,184 @@
+// Package pgtest gives tests a throwaway Postgres database migrated to the
+// current schema.
+//
+// It uses the server at TEST_DATABASE_URL when set; the user there needs
+// permission to create databases. Otherwise it starts a temporary server
+// with initdb and pg_ctl from PATH. Tests are skipped when neither is
+// available.
+package pgtest
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/migrations"
+	_ "github.com/lib/pq"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	startOnce sync.Once
+	serverURL string
+	skipMsg   string
+	startErr  error
+	stop      func()
+)
+
+// New creates an empty database, applies every migration and returns a
+// connection to it. The database is dropped when the test ends.
+func New(t testing.TB) *sql.DB {
+	t.Helper()
+	startOnce.Do(start)
+	if skipMsg != "" {
+		t.Skip(skipMsg)
+	}
+	if startErr != nil {
+		t.Fatalf("starting Postgres: %v", startErr)
+	}
+
+	ctx := context.Background()
+	admin, err := sql.Open("postgres", serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	name := "optimize_route_test_" + randomSuffix()
+	if _, err := admin.ExecContext(ctx, "CREATE DATABASE "+name); err != nil {
+		t.Fatalf("creating test database: %v", err)
+	}
+	// adjusted_query_time depends on the session time zone, so pin it.
+	if _, err := admin.ExecContext(ctx, "ALTER DATABASE "+name+" SET timezone TO 'UTC'"); err != nil {
+		t.Fatalf("setting test database time zone: %v", err)
+	}
+
+	databaseURL, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	databaseURL.Path = "/" + name
+	db, err := sql.Open("postgres", databaseURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		admin, err := sql.Open("postgres", serverURL)
+		if err != nil {
+			t.Errorf("dropping test database: %v", err)
+			return
+		}
+		defer admin.Close()
+		if _, err := admin.ExecContext(context.Background(), "DROP DATABASE IF EXISTS "+name); err != nil {
+			t.Errorf("dropping test database: %v", err)
+		}
+	})
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("applying migrations: %v", err)
+	}
+	return db
+}
+
+// Stop shuts down the temporary server, if one was started. Call it from
+// TestMain after m.Run.
+func Stop() {
+	if stop != nil {
+		stop()
+	}
+}
+
+func start() {
+	if serverURL = os.Getenv("TEST_DATABASE_URL"); serverURL != "" {
+		return
+	}
+
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		skipMsg = "no Postgres available: set TEST_DATABASE_URL or put initdb and pg_ctl on PATH"
+		return
+	}
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		skipMsg = "no Postgres available: set TEST_DATABASE_URL or put initdb and pg_ctl on PATH"
+		return
+	}
+	if os.Geteuid() == 0 {
+		skipMsg = "initdb cannot run as root: set TEST_DATABASE_URL instead"
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "pgtest")
+	if err != nil {
+		startErr = err
+		return
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		startErr = fmt.Errorf("initdb: %v: %s", err, out)
+		return
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		startErr = err
+		return
+	}
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses=localhost -F", port, dir)
+	if out, err := exec.Command(pgCtl, "-D", data, "-o", options, "-l", filepath.Join(dir, "postgres.log"), "-w", "-t", "30", "start").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		startErr = fmt.Errorf("pg_ctl start: %v: %s", err, out)
+		return
+	}
+	stop = func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+		os.RemoveAll(dir)
+	}
+	serverURL = fmt.Sprintf("postgres://postgres@localhost:%d/postgres?sslmode=disable", port)
+
+	// pg_ctl -w returns once the server accepts connections, but give slow
+	// machines a moment before the first test fails on a refused connection.
+	admin, err := sql.Open("postgres", serverURL)
+	if err != nil {
+		startErr = err
+		return
+	}
+	defer admin.Close()
+	for attempt := 0; ; attempt++ {
+		if err = admin.Ping(); err == nil || attempt == 20 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	startErr = err
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func randomSuffix() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
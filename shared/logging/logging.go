@@ -0,0 +1,64 @@
+// Package logging sets up structured JSON logs and carries the run ID that
+// ties one commutesQueue tick to every optimizeRoute invocation it fans out.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Setup makes JSON on stdout the default slog output. Lambdas call it first
+// thing in main so CloudWatch receives one JSON object per line.
+func Setup() {
+	slog.SetDefault(slog.New(NewHandler(os.Stdout)))
+}
+
+// NewHandler returns a JSON handler that adds the context's run ID, if any,
+// to every record logged with a *Context method.
+func NewHandler(w io.Writer) slog.Handler {
+	return contextHandler{slog.NewJSONHandler(w, nil)}
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if runID := RunID(ctx); runID != "" {
+		record.AddAttrs(slog.String("run_id", runID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type runIDKey struct{}
+
+func WithRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// RunID returns the run ID stored in ctx, or "" if there is none.
+func RunID(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	return runID
+}
+
+// NewRunID returns a random 16 character hex ID.
+func NewRunID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
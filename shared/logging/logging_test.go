@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestHandlerAddsRunID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(&buf)).With("component", "test")
+
+	logger.InfoContext(WithRunID(context.Background(), "abc123"), "dispatched", "route_id", 7)
+	logger.InfoContext(context.Background(), "no run")
+
+	decoder := json.NewDecoder(&buf)
+	var first, second map[string]any
+	if err := decoder.Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoder.Decode(&second); err != nil {
+		t.Fatal(err)
+	}
+	if first["run_id"] != "abc123" || first["route_id"] != float64(7) || first["component"] != "test" {
+		t.Errorf("unexpected record: %v", first)
+	}
+	if _, ok := second["run_id"]; ok {
+		t.Errorf("record without a run ID has one: %v", second)
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	a, b := NewRunID(), NewRunID()
+	if len(a) != 16 || a == b {
+		t.Errorf("got %q and %q, want two different 16 character IDs", a, b)
+	}
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxEMFValues is the most values CloudWatch accepts for one metric in one
+// document.
+const maxEMFValues = 100
+
+// EMF buffers metrics and writes them as CloudWatch Embedded Metric Format
+// documents on Flush, one per label set. CloudWatch extracts them from the
+// Lambda's log stream without any API calls.
+type EMF struct {
+	Namespace string
+
+	w   io.Writer
+	now func() time.Time
+
+	mu     sync.Mutex
+	groups map[string]*emfGroup
+}
+
+type emfGroup struct {
+	labels   []label
+	units    map[string]Unit
+	counters map[string]float64
+	samples  map[string][]float64
+}
+
+func NewEMF(w io.Writer) *EMF {
+	return &EMF{Namespace: Namespace, w: w, now: time.Now, groups: map[string]*emfGroup{}}
+}
+
+func (e *EMF) group(pairs []string) *emfGroup {
+	labels := parseLabels(pairs)
+	key := labelKey(labels)
+	g, ok := e.groups[key]
+	if !ok {
+		g = &emfGroup{
+			labels:   labels,
+			units:    map[string]Unit{},
+			counters: map[string]float64{},
+			samples:  map[string][]float64{},
+		}
+		e.groups[key] = g
+	}
+	return g
+}
+
+func (e *EMF) Count(name string, value float64, labels ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g := e.group(labels)
+	g.units[name] = Count
+	g.counters[name] += value
+}
+
+func (e *EMF) Observe(name string, value float64, unit Unit, labels ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g := e.group(labels)
+	g.units[name] = unit
+	g.samples[name] = append(g.samples[name], value)
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit Unit   `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+func (e *EMF) Flush() error {
+	e.mu.Lock()
+	groups := e.groups
+	e.groups = map[string]*emfGroup{}
+	e.mu.Unlock()
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	timestamp := e.now().UnixMilli()
+	encoder := json.NewEncoder(e.w)
+	for _, key := range keys {
+		for _, document := range groups[key].documents(e.Namespace, timestamp) {
+			if err := encoder.Encode(document); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// documents splits a group into as many documents as it takes to keep every
+// metric under maxEMFValues values. Counters go in the first one.
+func (g *emfGroup) documents(namespace string, timestamp int64) []map[string]any {
+	dimensions := make([]string, 0, len(g.labels))
+	for _, l := range g.labels {
+		dimensions = append(dimensions, l.key)
+	}
+	names := make([]string, 0, len(g.units))
+	for name := range g.units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var documents []map[string]any
+	for chunk := 0; ; chunk++ {
+		document := map[string]any{}
+		var metrics []emfMetric
+		for _, name := range names {
+			if value, ok := g.counters[name]; ok && chunk == 0 {
+				document[name] = value
+				metrics = append(metrics, emfMetric{name, g.units[name]})
+			}
+			samples := g.samples[name]
+			if start := chunk * maxEMFValues; start < len(samples) {
+				document[name] = samples[start:min(start+maxEMFValues, len(samples))]
+				metrics = append(metrics, emfMetric{name, g.units[name]})
+			}
+		}
+		if len(metrics) == 0 {
+			return documents
+		}
+		for _, l := range g.labels {
+			document[l.key] = l.value
+		}
+		document["_aws"] = emfMetadata{
+			Timestamp: timestamp,
+			CloudWatchMetrics: []emfDirective{{
+				Namespace:  namespace,
+				Dimensions: [][]string{dimensions},
+				Metrics:    metrics,
+			}},
+		}
+		documents = append(documents, document)
+	}
+}
@@ -0,0 +1,146 @@
+// Package metrics counts what the handlers do and hands the numbers to a
+// pluggable sink: CloudWatch Embedded Metric Format for the Lambdas,
+// Prometheus text for the local server, or plain lines on stdout.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Namespace is the CloudWatch namespace EMF documents are filed under.
+const Namespace = "OptimizeRouteApp"
+
+type Unit string
+
+const (
+	Count        Unit = "Count"
+	Milliseconds Unit = "Milliseconds"
+	Seconds      Unit = "Seconds"
+)
+
+// Sink receives metrics. Labels are key, value pairs, as with slog.
+// Implementations are safe for concurrent use.
+type Sink interface {
+	// Count adds value to a counter.
+	Count(name string, value float64, labels ...string)
+	// Observe records one sample of a distribution, such as a latency.
+	Observe(name string, value float64, unit Unit, labels ...string)
+	// Flush writes out anything buffered since the last flush.
+	Flush() error
+}
+
+type discard struct{}
+
+func (discard) Count(name string, value float64, labels ...string)              {}
+func (discard) Observe(name string, value float64, unit Unit, labels ...string) {}
+func (discard) Flush() error                                                    { return nil }
+
+// Discard drops every metric.
+var Discard Sink = discard{}
+
+// Or returns sink, or Discard when sink is nil, so handlers can leave their
+// Metrics field unset.
+func Or(sink Sink) Sink {
+	if sink == nil {
+		return Discard
+	}
+	return sink
+}
+
+// New returns the sink named by kind: "emf", "prometheus", "stdout", or
+// "none"/"" for Discard.
+func New(kind string) (Sink, error) {
+	switch kind {
+	case "", "none":
+		return Discard, nil
+	case "emf":
+		return NewEMF(os.Stdout), nil
+	case "prometheus":
+		return NewPrometheus(), nil
+	case "stdout":
+		return NewStdout(os.Stdout), nil
+	}
+	return nil, fmt.Errorf("unknown metrics sink %q", kind)
+}
+
+// FromEnv returns the sink named by METRICS_SINK.
+func FromEnv() (Sink, error) {
+	return New(os.Getenv("METRICS_SINK"))
+}
+
+// Wrap flushes sink after every call to handle, so each Lambda invocation
+// writes out its own metrics.
+func Wrap[Req, Resp any](sink Sink, handle func(context.Context, Req) (Resp, error)) func(context.Context, Req) (Resp, error) {
+	return func(ctx context.Context, request Req) (Resp, error) {
+		response, err := handle(ctx, request)
+		if flushErr := Or(sink).Flush(); flushErr != nil {
+			slog.ErrorContext(ctx, "error flushing metrics", "error", flushErr)
+		}
+		return response, err
+	}
+}
+
+// ErrorClass is a short label for err: "ok" for nil, the Google error class,
+// "invalid_input", "not_found" or "unknown".
+func ErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case google.Class(err) != nil:
+		return google.ClassName(err)
+	case errors.Is(err, domain.ErrInvalidRequest):
+		return "invalid_input"
+	case errors.Is(err, store.ErrNotFound):
+		return "not_found"
+	}
+	return "unknown"
+}
+
+// ProviderHook returns a google.Client OnAttempt hook that counts requests
+// by provider and outcome and records their latency.
+func ProviderHook(sink Sink) google.AttemptHook {
+	return func(ctx context.Context, attempt google.Attempt) {
+		sink := Or(sink)
+		sink.Count("provider_requests", 1, "provider", attempt.Provider, "outcome", ErrorClass(attempt.Err))
+		sink.Observe("provider_latency", float64(attempt.Elapsed.Milliseconds()), Milliseconds, "provider", attempt.Provider)
+	}
+}
+
+type label struct {
+	key, value string
+}
+
+// parseLabels turns key, value pairs into labels sorted by key. A trailing
+// key without a value gets "".
+func parseLabels(pairs []string) []label {
+	labels := make([]label, 0, (len(pairs)+1)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		l := label{key: pairs[i]}
+		if i+1 < len(pairs) {
+			l.value = pairs[i+1]
+		}
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].key < labels[j].key })
+	return labels
+}
+
+func labelKey(labels []label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.key)
+		b.WriteByte('=')
+		b.WriteString(l.value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
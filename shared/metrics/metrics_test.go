@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEMF(t *testing.T) {
+	var buf bytes.Buffer
+	emf := NewEMF(&buf)
+	emf.now = func() time.Time { return time.UnixMilli(1725371100000) }
+
+	emf.Count("routes_due", 2, "direction", "to_work")
+	emf.Count("routes_due", 1, "direction", "to_work")
+	emf.Count("routes_dispatched", 3)
+	for i := 0; i < maxEMFValues+1; i++ {
+		emf.Observe("commute_age", float64(i), Seconds)
+	}
+	if err := emf.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var documents []map[string]any
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var document map[string]any
+		if err := decoder.Decode(&document); err != nil {
+			t.Fatal(err)
+		}
+		documents = append(documents, document)
+	}
+	// No labels: counters plus the first 100 ages, then the last age. Then
+	// the direction label set.
+	if len(documents) != 3 {
+		t.Fatalf("got %d documents, want 3: %v", len(documents), documents)
+	}
+	if documents[0]["routes_dispatched"] != float64(3) || len(documents[0]["commute_age"].([]any)) != maxEMFValues {
+		t.Errorf("unexpected first document: %v", documents[0])
+	}
+	if _, ok := documents[1]["routes_dispatched"]; ok || len(documents[1]["commute_age"].([]any)) != 1 {
+		t.Errorf("unexpected second document: %v", documents[1])
+	}
+	due := documents[2]
+	if due["routes_due"] != float64(3) || due["direction"] != "to_work" {
+		t.Errorf("unexpected labelled document: %v", due)
+	}
+	metadata := due["_aws"].(map[string]any)
+	directive := metadata["CloudWatchMetrics"].([]any)[0].(map[string]any)
+	if metadata["Timestamp"] != float64(1725371100000) || directive["Namespace"] != Namespace ||
+		fmt.Sprint(directive["Dimensions"]) != "[[direction]]" {
+		t.Errorf("unexpected metadata: %v", metadata)
+	}
+
+	buf.Reset()
+	if err := emf.Flush(); err != nil || buf.Len() != 0 {
+		t.Errorf("second flush wrote %q, %v", buf.String(), err)
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus()
+	p.Count("routes_failed", 1, "error_class", "quota")
+	p.Count("routes_failed", 2, "error_class", "quota")
+	p.Observe("provider_latency", 120, Milliseconds, "provider", "google_routes")
+	p.Observe("provider_latency", 20000, Milliseconds, "provider", "google_routes")
+
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE optimize_route_routes_failed_total counter",
+		`optimize_route_routes_failed_total{error_class="quota"} 3`,
+		"# TYPE optimize_route_provider_latency_milliseconds histogram",
+		`optimize_route_provider_latency_milliseconds_bucket{provider="google_routes",le="100"} 0`,
+		`optimize_route_provider_latency_milliseconds_bucket{provider="google_routes",le="250"} 1`,
+		`optimize_route_provider_latency_milliseconds_bucket{provider="google_routes",le="+Inf"} 2`,
+		`optimize_route_provider_latency_milliseconds_sum{provider="google_routes"} 20120`,
+		`optimize_route_provider_latency_milliseconds_count{provider="google_routes"} 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestStdout(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStdout(&buf)
+	s.Count("routes_due", 2, "direction", "to_work")
+	s.Observe("provider_latency", 85, Milliseconds, "provider", "google_routes")
+
+	want := "metric routes_due=2 Count direction=to_work\nmetric provider_latency=85 Milliseconds provider=google_routes\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, "ok"},
+		{fmt.Errorf("computing: %w", google.ErrQuota), "quota"},
+		{fmt.Errorf("%w: route 3 is inactive", domain.ErrInvalidRequest), "invalid_input"},
+		{fmt.Errorf("route 3: %w", store.ErrNotFound), "not_found"},
+		{errors.New("boom"), "unknown"},
+	}
+	for _, test := range tests {
+		if got := ErrorClass(test.err); got != test.want {
+			t.Errorf("ErrorClass(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+}
+
+func TestWrapFlushes(t *testing.T) {
+	var buf bytes.Buffer
+	emf := NewEMF(&buf)
+	handle := Wrap(emf, func(ctx context.Context, n int) (int, error) {
+		emf.Count("calls", 1)
+		return n * 2, nil
+	})
+
+	if got, err := handle(context.Background(), 21); got != 42 || err != nil {
+		t.Fatalf("got %d, %v", got, err)
+	}
+	if !strings.Contains(buf.String(), `"calls":1`) {
+		t.Errorf("metrics were not flushed: %q", buf.String())
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, kind := range []string{"", "none", "emf", "prometheus", "stdout"} {
+		if _, err := New(kind); err != nil {
+			t.Errorf("New(%q): %v", kind, err)
+		}
+	}
+	if _, err := New("statsd"); err == nil {
+		t.Error("expected an error for an unknown sink")
+	}
+}
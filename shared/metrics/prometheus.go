@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buckets are the histogram upper bounds Prometheus uses for each unit.
+var Buckets = map[Unit][]float64{
+	Count:        {1, 5, 10, 50, 100, 500},
+	Milliseconds: {50, 100, 250, 500, 1000, 2500, 5000, 10000},
+	Seconds:      {60, 300, 900, 1800, 3600, 21600, 86400, 604800},
+}
+
+// Prometheus keeps counters and histograms for the life of the process and
+// serves them in the Prometheus text format. Flush does nothing.
+type Prometheus struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]*promCounter
+	histograms map[string]map[string]*promHistogram
+}
+
+type promCounter struct {
+	labels []label
+	value  float64
+}
+
+type promHistogram struct {
+	labels  []label
+	bounds  []float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		counters:   map[string]map[string]*promCounter{},
+		histograms: map[string]map[string]*promHistogram{},
+	}
+}
+
+// prometheusPrefix namespaces every metric name.
+const prometheusPrefix = "optimize_route_"
+
+func metricName(name string) string {
+	return prometheusPrefix + name
+}
+
+func (p *Prometheus) Count(name string, value float64, labels ...string) {
+	name = metricName(name) + "_total"
+	parsed := parseLabels(labels)
+	key := labelKey(parsed)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.counters[name] == nil {
+		p.counters[name] = map[string]*promCounter{}
+	}
+	c, ok := p.counters[name][key]
+	if !ok {
+		c = &promCounter{labels: parsed}
+		p.counters[name][key] = c
+	}
+	c.value += value
+}
+
+func (p *Prometheus) Observe(name string, value float64, unit Unit, labels ...string) {
+	name = metricName(name)
+	if unit != Count {
+		name += "_" + strings.ToLower(string(unit))
+	}
+	parsed := parseLabels(labels)
+	key := labelKey(parsed)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.histograms[name] == nil {
+		p.histograms[name] = map[string]*promHistogram{}
+	}
+	h, ok := p.histograms[name][key]
+	if !ok {
+		bounds := Buckets[unit]
+		h = &promHistogram{labels: parsed, bounds: bounds, buckets: make([]uint64, len(bounds))}
+		p.histograms[name][key] = h
+	}
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (p *Prometheus) Flush() error {
+	return nil
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+	for _, name := range sortedKeys(p.counters) {
+		fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+		for _, key := range sortedKeys(p.counters[name]) {
+			c := p.counters[name][key]
+			fmt.Fprintf(&b, "%s%s %s\n", name, formatLabels(c.labels, ""), formatValue(c.value))
+		}
+	}
+	for _, name := range sortedKeys(p.histograms) {
+		fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+		for _, key := range sortedKeys(p.histograms[name]) {
+			h := p.histograms[name][key]
+			for i, bound := range h.bounds {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(h.labels, formatValue(bound)), h.buckets[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(h.labels, "+Inf"), h.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", name, formatLabels(h.labels, ""), formatValue(h.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, formatLabels(h.labels, ""), h.count)
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics for a Prometheus scrape.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p.WriteTo(w)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels renders labels as {k="v",...}, adding le when it is set.
+func formatLabels(labels []label, le string) string {
+	parts := make([]string, 0, len(labels)+1)
+	for _, l := range labels {
+		parts = append(parts, fmt.Sprintf("%s=%s", l.key, strconv.Quote(l.value)))
+	}
+	if le != "" {
+		parts = append(parts, fmt.Sprintf("le=%q", le))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
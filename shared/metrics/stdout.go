@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Stdout writes each metric as a line as soon as it is recorded, for reading
+// along during local development.
+type Stdout struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdout(w io.Writer) *Stdout {
+	return &Stdout{w: w}
+}
+
+func (s *Stdout) Count(name string, value float64, labels ...string) {
+	s.write(name, value, Count, labels)
+}
+
+func (s *Stdout) Observe(name string, value float64, unit Unit, labels ...string) {
+	s.write(name, value, unit, labels)
+}
+
+func (s *Stdout) write(name string, value float64, unit Unit, pairs []string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "metric %s=%s %s", name, formatValue(value), unit)
+	for _, l := range parseLabels(pairs) {
+		fmt.Fprintf(&b, " %s=%s", l.key, l.value)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintln(s.w, b.String())
+}
+
+func (s *Stdout) Flush() error {
+	return nil
+}
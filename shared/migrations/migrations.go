@@ -0,0 +1,290 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// advisoryLockID keeps two migrate runs from applying the same version at once.
+const advisoryLockID = 7355608
+
+var ErrVersionMismatch = errors.New("database schema version mismatch")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// Load reads the embedded migrations, sorted by version. Files are named
+// NNNN_name.up.sql and NNNN_name.down.sql.
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		filename := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(filename, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(filename, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file: %s", filename)
+		}
+		base := strings.TrimSuffix(filename, "."+direction+".sql")
+		versionPart, name, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("migration file missing name: %s", filename)
+		}
+		version, err := strconv.Atoi(versionPart)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", filename)
+		}
+		contents, err := files.ReadFile(path.Join("sql", filename))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", filename, err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names: %s and %s", version, migration.Name, name)
+		}
+		if direction == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d is missing an up or down file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be sequential, expected %d got %d", i+1, migration.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Expected is the schema version this build of the code was written against.
+func Expected() int {
+	migrations, err := Load()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// CheckVersion compares a version read from the database with Expected.
+func CheckVersion(version int) error {
+	expected := Expected()
+	if version != expected {
+		return fmt.Errorf("%w: database is at version %d, expected %d", ErrVersionMismatch, version, expected)
+	}
+	return nil
+}
+
+// Check asserts that the database schema is at the expected version. Each
+// Lambda calls this before touching any tables.
+func Check(ctx context.Context, db *sql.DB) error {
+	migrator, err := New(db)
+	if err != nil {
+		return err
+	}
+	return migrator.Check(ctx)
+}
+
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Version returns the current schema version, or 0 if no migrations have run.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	return currentVersion(ctx, m.db)
+}
+
+func (m *Migrator) Check(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	return CheckVersion(version)
+}
+
+// Up applies every pending migration, each in its own transaction, and
+// returns the migrations that were applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, migration := range m.migrations {
+		ran, err := m.apply(ctx, migration, true)
+		if err != nil {
+			return applied, err
+		}
+		if ran {
+			applied = append(applied, migration)
+		}
+	}
+	return applied, nil
+}
+
+// Down rolls back the latest steps migrations and returns them in the order
+// they were rolled back. It stops early when another migrate run has moved
+// the version in the meantime.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("steps must be positive, got %d", steps)
+	}
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := 0; i < steps; i++ {
+		version, err := m.Version(ctx)
+		if err != nil {
+			return reverted, err
+		}
+		if version == 0 {
+			break
+		}
+		if version > len(m.migrations) {
+			return reverted, fmt.Errorf("database is at version %d, which this build does not know about", version)
+		}
+		migration := m.migrations[version-1]
+		ran, err := m.apply(ctx, migration, false)
+		if err != nil {
+			return reverted, err
+		}
+		if !ran {
+			break
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+func (m *Migrator) ensureVersionTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS public.schema_migrations (
+    version integer PRIMARY KEY,
+    name text NOT NULL,
+    applied_at timestamp with time zone NOT NULL DEFAULT NOW()
+)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// apply runs a single migration in the given direction. It reports false
+// when the database was already past (or before) the migration.
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool) (bool, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", advisoryLockID); err != nil {
+		return false, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	version, err := currentVersion(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+
+	if up {
+		if version >= migration.Version {
+			return false, nil
+		}
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return false, fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO public.schema_migrations (version, name) VALUES ($1, $2)",
+			migration.Version, migration.Name); err != nil {
+			return false, fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+		}
+	} else {
+		if version != migration.Version {
+			return false, nil
+		}
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return false, fmt.Errorf("failed to revert migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM public.schema_migrations WHERE version = $1",
+			migration.Version); err != nil {
+			return false, fmt.Errorf("failed to remove migration %d: %w", migration.Version, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
+	}
+	return true, nil
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func currentVersion(ctx context.Context, db querier) (int, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx,
+		"SELECT to_regclass('public.schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return 0, fmt.Errorf("failed to look up schema_migrations table: %w", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	if err := db.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(version), 0) FROM public.schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,122 @@
+package migrations_test
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/internal/pgtest"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/migrations"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	pgtest.Stop()
+	os.Exit(code)
+}
+
+func TestCheckVersion(t *testing.T) {
+	expected := migrations.Expected()
+	if expected == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	if err := migrations.CheckVersion(expected); err != nil {
+		t.Errorf("CheckVersion(%d): %v", expected, err)
+	}
+	for _, version := range []int{0, expected - 1, expected + 1} {
+		if err := migrations.CheckVersion(version); !errors.Is(err, migrations.ErrVersionMismatch) {
+			t.Errorf("CheckVersion(%d) = %v, want ErrVersionMismatch", version, err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	db := pgtest.New(t)
+	ctx := context.Background()
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := migrations.Expected()
+	if err := migrations.Check(ctx, db); err != nil {
+		t.Fatalf("Check after up: %v", err)
+	}
+
+	// Everything but the baseline rolls back, newest first.
+	reverted, err := migrator.Down(ctx, expected-1)
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if len(reverted) != expected-1 || reverted[0].Version != expected || reverted[len(reverted)-1].Version != 2 {
+		t.Fatalf("reverted %+v", reverted)
+	}
+	if err := migrations.Check(ctx, db); !errors.Is(err, migrations.ErrVersionMismatch) {
+		t.Errorf("Check after down = %v, want ErrVersionMismatch", err)
+	}
+
+	// The baseline refuses to roll back and leaves the version alone.
+	if reverted, err := migrator.Down(ctx, 1); err == nil || len(reverted) != 0 {
+		t.Errorf("Down past the baseline reverted %+v, err %v", reverted, err)
+	}
+	if version, err := migrator.Version(ctx); err != nil || version != 1 {
+		t.Errorf("version = %d, %v; want 1", version, err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if len(applied) != expected-1 {
+		t.Errorf("applied %d migrations, want %d", len(applied), expected-1)
+	}
+	if err := migrator.Check(ctx); err != nil {
+		t.Errorf("Check after up again: %v", err)
+	}
+}
+
+func TestConcurrentDownReportsOnlyItsOwnRollbacks(t *testing.T) {
+	db := pgtest.New(t)
+	ctx := context.Background()
+	expected := migrations.Expected()
+
+	var wg sync.WaitGroup
+	results := make([][]migrations.Migration, 2)
+	errs := make([]error, 2)
+	for i := range results {
+		migrator, err := migrations.New(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = migrator.Down(ctx, 1)
+		}()
+	}
+	wg.Wait()
+
+	seen := map[int]bool{}
+	for i, reverted := range results {
+		if errs[i] != nil {
+			t.Fatalf("Down: %v", errs[i])
+		}
+		for _, migration := range reverted {
+			if seen[migration.Version] {
+				t.Errorf("migration %d reported as rolled back twice", migration.Version)
+			}
+			seen[migration.Version] = true
+		}
+	}
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != expected-version {
+		t.Errorf("reported %d rollbacks, but the database went from %d to %d", len(seen), expected, version)
+	}
+}
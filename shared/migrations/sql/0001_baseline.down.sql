@@ -0,0 +1,8 @@
+-- The baseline is the schema the app ran on before migrations were tracked,
+-- so rolling it back would drop every user, route and commute. Refuse instead;
+-- drop the tables by hand to start over.
+DO $$
+BEGIN
+    RAISE EXCEPTION 'the baseline migration cannot be rolled back';
+END
+$$;
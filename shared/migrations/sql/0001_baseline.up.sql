@@ -0,0 +1,100 @@
+-- Baseline schema. Matches supabase/database_setup.sql, so databases that were
+-- set up from the dump are adopted without changes.
+
+CREATE TABLE IF NOT EXISTS public.users (
+    id serial PRIMARY KEY,
+    username text NOT NULL,
+    date_joined date NOT NULL,
+    name text
+);
+
+CREATE TABLE IF NOT EXISTS public.routes (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT fk_user REFERENCES public.users(id),
+    start_address text,
+    end_address text,
+    start_latitude text NOT NULL,
+    end_latitude text NOT NULL,
+    active boolean NOT NULL,
+    start_date date NOT NULL,
+    end_date date,
+    start_longitude text NOT NULL,
+    end_longitude text NOT NULL,
+    time_zone text
+);
+
+CREATE TABLE IF NOT EXISTS public.route_schedule (
+    id bigint GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    morning_start_time time without time zone NOT NULL,
+    morning_end_time time without time zone NOT NULL,
+    afternoon_start_time time without time zone NOT NULL,
+    afternoon_end_time time without time zone NOT NULL,
+    route_id integer NOT NULL CONSTRAINT route_schedule_route_id_fkey REFERENCES public.routes(id),
+    monday boolean NOT NULL,
+    tuesday boolean NOT NULL,
+    wednesday boolean NOT NULL,
+    thursday boolean NOT NULL,
+    friday boolean NOT NULL,
+    saturday boolean NOT NULL,
+    sunday boolean NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.commutes (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT fk_user REFERENCES public.users(id),
+    query_time timestamp with time zone NOT NULL,
+    duration bigint NOT NULL,
+    route_hash text,
+    route integer NOT NULL CONSTRAINT fk_route REFERENCES public.routes(id),
+    distance bigint NOT NULL,
+    to_work boolean NOT NULL,
+    day_of_week character varying,
+    adjusted_query_time timestamp with time zone
+);
+
+CREATE OR REPLACE FUNCTION public.convert_to_timezone(query_time timestamp with time zone, target_timezone text) RETURNS timestamp with time zone
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    RETURN query_time AT TIME ZONE target_timezone;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION public.update_adjusted_query_time() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    NEW.adjusted_query_time := convert_to_timezone(
+        NEW.query_time,
+        (SELECT time_zone FROM routes WHERE id = NEW.route)
+    );
+    RETURN NEW;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION public.update_related_commutes() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    -- Only proceed if time_zone has changed
+    IF OLD.time_zone IS DISTINCT FROM NEW.time_zone THEN
+        UPDATE commutes
+        SET adjusted_query_time = convert_to_timezone(query_time, NEW.time_zone)
+        WHERE route = NEW.id;
+    END IF;
+    RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS update_commute_timezone ON public.commutes;
+CREATE TRIGGER update_commute_timezone BEFORE INSERT OR UPDATE ON public.commutes
+    FOR EACH ROW EXECUTE FUNCTION public.update_adjusted_query_time();
+
+DROP TRIGGER IF EXISTS update_route_timezone ON public.routes;
+CREATE TRIGGER update_route_timezone AFTER UPDATE ON public.routes
+    FOR EACH ROW EXECUTE FUNCTION public.update_related_commutes();
+
+ALTER TABLE public.commutes ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.route_schedule ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.routes ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.users ENABLE ROW LEVEL SECURITY;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.provider_circuits;
+DROP TABLE IF EXISTS public.provider_budgets;
+DROP TABLE IF EXISTS public.provider_usage;
@@ -0,0 +1,27 @@
+-- Routing API spend tracking. optimizeRoute records every provider request
+-- into per-minute buckets; commutesQueue reads them to enforce budgets and
+-- trip the circuit breaker.
+
+CREATE TABLE public.provider_usage (
+    provider text NOT NULL,
+    bucket timestamp with time zone NOT NULL,
+    requests integer NOT NULL DEFAULT 0,
+    failures integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (provider, bucket)
+);
+
+-- A NULL limit means unlimited. Periods are UTC days and months.
+CREATE TABLE public.provider_budgets (
+    provider text PRIMARY KEY,
+    daily_limit integer CHECK (daily_limit >= 0),
+    monthly_limit integer CHECK (monthly_limit >= 0)
+);
+
+CREATE TABLE public.provider_circuits (
+    provider text PRIMARY KEY,
+    opened_at timestamp with time zone
+);
+
+ALTER TABLE public.provider_usage ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.provider_budgets ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.provider_circuits ENABLE ROW LEVEL SECURITY;
@@ -0,0 +1 @@
+ALTER TABLE public.commutes DROP COLUMN IF EXISTS ad_hoc;
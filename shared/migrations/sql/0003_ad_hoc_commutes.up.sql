@@ -0,0 +1,3 @@
+-- Commutes checked on demand through checkCommute are flagged so dashboards
+-- can keep them out of the scheduled history.
+ALTER TABLE public.commutes ADD COLUMN ad_hoc boolean NOT NULL DEFAULT false;
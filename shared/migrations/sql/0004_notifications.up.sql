@@ -0,0 +1,25 @@
+-- Leave-now notifications. A subscription sends a user's messages to one
+-- channel; notifications logs what was sent so a window is not spammed.
+
+CREATE TABLE public.notification_subscriptions (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT fk_user REFERENCES public.users(id),
+    channel text NOT NULL,
+    target text NOT NULL,
+    active boolean NOT NULL DEFAULT true
+);
+
+CREATE TABLE public.notifications (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT fk_user REFERENCES public.users(id),
+    route integer NOT NULL CONSTRAINT fk_route REFERENCES public.routes(id),
+    to_work boolean NOT NULL,
+    decision text NOT NULL,
+    message text NOT NULL,
+    sent_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX notifications_route_sent_at ON public.notifications (route, to_work, sent_at);
+
+ALTER TABLE public.notification_subscriptions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.notifications ENABLE ROW LEVEL SECURITY;
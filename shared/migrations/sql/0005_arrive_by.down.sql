@@ -0,0 +1,2 @@
+ALTER TABLE public.route_schedule DROP COLUMN IF EXISTS afternoon_arrive_by;
+ALTER TABLE public.route_schedule DROP COLUMN IF EXISTS morning_arrive_by;
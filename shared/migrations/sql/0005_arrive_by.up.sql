@@ -0,0 +1,5 @@
+-- Optional target arrival times, in the route's time zone: at work for the
+-- morning trip and at home for the afternoon one. planDeparture works back
+-- from them to the latest safe departure.
+ALTER TABLE public.route_schedule ADD COLUMN morning_arrive_by time without time zone;
+ALTER TABLE public.route_schedule ADD COLUMN afternoon_arrive_by time without time zone;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.sampling_boosts;
+ALTER TABLE public.commutes DROP COLUMN IF EXISTS anomaly_score;
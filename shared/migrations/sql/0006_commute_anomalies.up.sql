@@ -0,0 +1,15 @@
+-- optimizeRoute scores each scheduled commute against the route's usual
+-- duration around that time of day. While a route is running far slower than
+-- usual, a sampling boost keeps commutesQueue checking it past the end of its
+-- schedule window.
+ALTER TABLE public.commutes ADD COLUMN anomaly_score double precision;
+
+CREATE TABLE public.sampling_boosts (
+    route integer NOT NULL CONSTRAINT fk_route REFERENCES public.routes(id),
+    to_work boolean NOT NULL,
+    until timestamp with time zone NOT NULL,
+    reason text NOT NULL,
+    PRIMARY KEY (route, to_work)
+);
+
+ALTER TABLE public.sampling_boosts ENABLE ROW LEVEL SECURITY;
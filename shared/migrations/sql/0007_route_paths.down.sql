@@ -0,0 +1,2 @@
+ALTER TABLE public.commutes DROP COLUMN IF EXISTS path_id;
+DROP TABLE IF EXISTS public.route_paths;
@@ -0,0 +1,19 @@
+-- Each distinct path Google has given for a route in one direction, keyed by
+-- the fingerprint of its polyline. Commutes link to the path they took, so a
+-- change of path and how each path performs can be read back.
+CREATE TABLE public.route_paths (
+    id serial PRIMARY KEY,
+    route integer NOT NULL CONSTRAINT fk_route REFERENCES public.routes(id),
+    to_work boolean NOT NULL,
+    fingerprint text NOT NULL,
+    label text NOT NULL,
+    polyline text NOT NULL,
+    distance integer NOT NULL,
+    first_seen timestamp with time zone NOT NULL,
+    last_seen timestamp with time zone NOT NULL,
+    UNIQUE (route, to_work, fingerprint)
+);
+
+ALTER TABLE public.route_paths ENABLE ROW LEVEL SECURITY;
+
+ALTER TABLE public.commutes ADD COLUMN path_id integer CONSTRAINT fk_path REFERENCES public.route_paths(id);
@@ -0,0 +1,3 @@
+ALTER TABLE public.commutes DROP COLUMN IF EXISTS leg_distances;
+ALTER TABLE public.commutes DROP COLUMN IF EXISTS leg_durations;
+DROP TABLE IF EXISTS public.route_waypoints;
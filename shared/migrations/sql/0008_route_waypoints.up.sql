@@ -0,0 +1,18 @@
+-- Stops a route makes between home and work, such as daycare, in position
+-- order on the way to work. Driving home visits them in reverse.
+CREATE TABLE public.route_waypoints (
+    id serial PRIMARY KEY,
+    route integer NOT NULL CONSTRAINT fk_route REFERENCES public.routes(id),
+    position integer NOT NULL,
+    address text NOT NULL,
+    latitude text NOT NULL,
+    longitude text NOT NULL,
+    UNIQUE (route, position)
+);
+
+ALTER TABLE public.route_waypoints ENABLE ROW LEVEL SECURITY;
+
+-- The duration and distance of each leg between stops, in driving order, for
+-- commutes of routes with waypoints.
+ALTER TABLE public.commutes ADD COLUMN leg_durations integer[];
+ALTER TABLE public.commutes ADD COLUMN leg_distances integer[];
@@ -0,0 +1,4 @@
+-- Commutes sampled only because the route was boosted after an anomaly,
+-- outside its schedule window, are flagged so counts and baselines can keep
+-- them out of the scheduled history.
+ALTER TABLE public.commutes ADD COLUMN boosted boolean NOT NULL DEFAULT false;
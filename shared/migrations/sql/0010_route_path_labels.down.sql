@@ -0,0 +1 @@
+ALTER TABLE public.route_paths DROP CONSTRAINT IF EXISTS route_paths_label_key;
@@ -0,0 +1,13 @@
+-- Renumber the paths of any route and direction where concurrent records gave
+-- two paths the same label, in the order they were first seen.
+UPDATE public.route_paths SET label = numbered.label
+FROM (
+    SELECT id, 'path ' || ROW_NUMBER() OVER (PARTITION BY route, to_work ORDER BY first_seen, id) AS label
+    FROM public.route_paths
+    WHERE (route, to_work) IN (
+        SELECT route, to_work FROM public.route_paths GROUP BY route, to_work, label HAVING COUNT(*) > 1
+    )
+) AS numbered
+WHERE route_paths.id = numbered.id;
+
+ALTER TABLE public.route_paths ADD CONSTRAINT route_paths_label_key UNIQUE (route, to_work, label);
@@ -0,0 +1 @@
+ALTER TABLE public.provider_usage DROP COLUMN IF EXISTS units;
@@ -0,0 +1,5 @@
+-- requests and failures count calls, which the circuit breaker judges; units
+-- counts what the calls are billed as, such as one per route matrix element,
+-- which the budgets limit. Every call so far was billed as one unit.
+ALTER TABLE public.provider_usage ADD COLUMN units integer NOT NULL DEFAULT 0;
+UPDATE public.provider_usage SET units = requests;
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"sort"
+	"time"
+)
+
+type Decision string
+
+const (
+	LeaveNow Decision = "leave_now"
+	Wait     Decision = "wait"
+)
+
+// Policy decides between leaving now and waiting.
+type Policy struct {
+	// Wait is how much later the alternative departure is.
+	Wait time.Duration
+	// MinSaving is how much faster the later departure must usually be to
+	// advise waiting.
+	MinSaving time.Duration
+	// Tolerance is how far from a time of day a past commute may be and still
+	// count toward its baseline.
+	Tolerance time.Duration
+	// MinSamples is the fewest past commutes a baseline needs.
+	MinSamples int
+	// History is how far back baselines look.
+	History time.Duration
+	// Freshness is how old the latest commute may be.
+	Freshness time.Duration
+}
+
+var DefaultPolicy = Policy{
+	Wait:       15 * time.Minute,
+	MinSaving:  5 * time.Minute,
+	Tolerance:  10 * time.Minute,
+	MinSamples: 3,
+	History:    28 * 24 * time.Hour,
+	Freshness:  10 * time.Minute,
+}
+
+// Advice compares the latest duration with the median of past commutes at
+// the same time of day, and at Wait later. Durations are in seconds; Later is
+// 0 without enough history.
+type Advice struct {
+	Decision Decision  `json:"decision"`
+	At       time.Time `json:"at"`
+	Current  int       `json:"current"`
+	Usual    int       `json:"usual"`
+	Later    int       `json:"later"`
+	Samples  int       `json:"samples"`
+}
+
+// Advise returns false when history holds too few commutes near the latest
+// one's time of day to say what is usual. Commutes from the latest one's day
+// are left out of the baseline.
+func (p Policy) Advise(latest domain.Commute, history []domain.Commute, loc *time.Location) (Advice, bool) {
+	at := latest.QueryTime.In(loc)
+	today := at.Format(time.DateOnly)
+	var now, later []int
+	for _, commute := range history {
+		local := commute.QueryTime.In(loc)
+		if local.Format(time.DateOnly) == today {
+			continue
+		}
+		offset := timeOfDay(local) - timeOfDay(at)
+		if offset.Abs() <= p.Tolerance {
+			now = append(now, commute.Duration)
+		}
+		if (offset - p.Wait).Abs() <= p.Tolerance {
+			later = append(later, commute.Duration)
+		}
+	}
+	if len(now) < p.MinSamples {
+		return Advice{}, false
+	}
+
+	advice := Advice{Decision: LeaveNow, At: at, Current: latest.Duration, Usual: median(now), Samples: len(now)}
+	if len(later) >= p.MinSamples {
+		advice.Later = median(later)
+		if advice.Current-advice.Later >= int(p.MinSaving.Seconds()) {
+			advice.Decision = Wait
+		}
+	}
+	return advice, true
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+func median(values []int) int {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,233 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTP sends plain text email, with an HTML alternative when the message has
+// one.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+
+	// send defaults to sendMail.
+	send func(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func (s *SMTP) Send(ctx context.Context, target string, message Message) error {
+	// A line break would let the address or title add headers of its own.
+	if strings.ContainsAny(target, "\r\n") {
+		return fmt.Errorf("invalid email address %q: contains a line break", target)
+	}
+	if strings.ContainsAny(message.Title, "\r\n") {
+		return fmt.Errorf("invalid email subject %q: contains a line break", message.Title)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("error sending email to %s: %w", target, err)
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", target)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if message.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		b.WriteString(message.Body)
+		b.WriteString("\r\n")
+	} else {
+		parts := multipart.NewWriter(&b)
+		fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain; charset=UTF-8", message.Body},
+			{"text/html; charset=UTF-8", message.HTML},
+		} {
+			w, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+			if err != nil {
+				return fmt.Errorf("error writing email: %v", err)
+			}
+			io.WriteString(w, part.body)
+		}
+		parts.Close()
+	}
+
+	send := s.send
+	if send == nil {
+		send = sendMail
+	}
+	addr := s.Host + ":" + strconv.Itoa(s.Port)
+	if err := send(ctx, addr, auth, s.From, []string{target}, []byte(b.String())); err != nil {
+		return fmt.Errorf("error sending email to %s: %w", target, err)
+	}
+	return nil
+}
+
+// sendMail is smtp.SendMail over a connection that is closed once ctx is
+// done, so a slow server cannot outlive the invocation.
+func sendMail(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		if !stop() && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	host, _, _ := net.SplitHostPort(addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("server does not support AUTH")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Webhook posts the message as JSON.
+type Webhook struct {
+	Client *http.Client
+}
+
+func (w *Webhook) Send(ctx context.Context, target string, message Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error encoding message: %v", err)
+	}
+	return post(ctx, w.Client, target, "application/json", body, nil)
+}
+
+// Slack posts the message as {"text": ...}, which Slack incoming webhooks
+// and compatible chat services accept.
+type Slack struct {
+	Client *http.Client
+}
+
+func (s *Slack) Send(ctx context.Context, target string, message Message) error {
+	body, err := json.Marshal(map[string]string{"text": "*" + message.Title + "*\n" + message.Body})
+	if err != nil {
+		return fmt.Errorf("error encoding message: %v", err)
+	}
+	return post(ctx, s.Client, target, "application/json", body, nil)
+}
+
+// Ntfy publishes the message to an ntfy topic URL such as
+// https://ntfy.sh/my-commute.
+type Ntfy struct {
+	Client *http.Client
+}
+
+func (n *Ntfy) Send(ctx context.Context, target string, message Message) error {
+	headers := map[string]string{"Title": message.Title, "Tags": "car"}
+	if message.Decision == LeaveNow {
+		headers["Priority"] = "high"
+	}
+	return post(ctx, n.Client, target, "text/plain; charset=utf-8", []byte(message.Body), headers)
+}
+
+func post(ctx context.Context, client *http.Client, url, contentType string, body []byte, headers map[string]string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// Sent is a message delivered to a Capture channel.
+type Sent struct {
+	Target  string
+	Message Message
+}
+
+// Capture keeps messages in memory instead of sending them, for tests and
+// local runs.
+type Capture struct {
+	mu   sync.Mutex
+	sent []Sent
+}
+
+func (c *Capture) Send(ctx context.Context, target string, message Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, Sent{Target: target, Message: message})
+	return nil
+}
+
+// Sent returns a copy of every captured message.
+func (c *Capture) Sent() []Sent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Sent(nil), c.sent...)
+}
@@ -0,0 +1,79 @@
+// Package notify tells users when to leave. Advice compares a route's latest
+// commute with its history, and Channels deliver the resulting Message by
+// email, webhook, Slack-compatible webhook or ntfy.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Message is one leave-now notification, anomaly alert or weekly report.
+// Webhooks receive it as JSON. RouteID and ToWork are only set for messages
+// about one route and direction, and Decision and Advice only for leave-now
+// messages. HTML, when set, is sent to email as an alternative to Body, and
+// Report carries the weekly report for webhooks.
+type Message struct {
+	Title    string   `json:"title"`
+	Body     string   `json:"body"`
+	UserID   int      `json:"user_id"`
+	RouteID  int      `json:"route_id,omitempty"`
+	ToWork   *bool    `json:"to_work,omitempty"`
+	Decision Decision `json:"decision,omitempty"`
+	Advice   *Advice  `json:"advice,omitempty"`
+	HTML     string   `json:"html,omitempty"`
+	Report   any      `json:"report,omitempty"`
+}
+
+// Channel delivers a message to target, whose meaning depends on the channel:
+// an email address for SMTP, a URL for the others.
+type Channel interface {
+	Send(ctx context.Context, target string, message Message) error
+}
+
+// Channels maps the channel names used in notification_subscriptions to
+// their implementations.
+type Channels map[string]Channel
+
+// Send delivers message to the subscription's channel.
+func (c Channels) Send(ctx context.Context, subscription domain.Subscription, message Message) error {
+	channel, ok := c[subscription.Channel]
+	if !ok {
+		return fmt.Errorf("unknown notification channel %q", subscription.Channel)
+	}
+	return channel.Send(ctx, subscription.Target, message)
+}
+
+// ChannelsFromEnv returns the webhook, slack and ntfy channels, plus email when
+// SMTP_HOST is set. SMTP_PORT defaults to 587; SMTP_USERNAME and
+// SMTP_PASSWORD are optional and SMTP_FROM is the sender.
+func ChannelsFromEnv() (Channels, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	channels := Channels{
+		"webhook": &Webhook{Client: client},
+		"slack":   &Slack{Client: client},
+		"ntfy":    &Ntfy{Client: client},
+	}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := 587
+		if value := os.Getenv("SMTP_PORT"); value != "" {
+			var err error
+			if port, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid SMTP_PORT %q: %v", value, err)
+			}
+		}
+		channels["email"] = &SMTP{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+	}
+	return channels, nil
+}
@@ -0,0 +1,224 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+// history has three past weekdays where 07:45 took 30 minutes and 08:00 took
+// 24, plus a commute from the latest one's own day, which is ignored.
+func history() []domain.Commute {
+	var commutes []domain.Commute
+	for _, day := range []int{2, 3, 4} {
+		commutes = append(commutes,
+			domain.Commute{QueryTime: time.Date(2024, 9, day, 7, 45, 0, 0, denver), Duration: 1800},
+			domain.Commute{QueryTime: time.Date(2024, 9, day, 8, 0, 0, 0, denver), Duration: 1440},
+		)
+	}
+	return append(commutes, domain.Commute{QueryTime: time.Date(2024, 9, 5, 7, 40, 0, 0, denver), Duration: 9999})
+}
+
+func TestAdvise(t *testing.T) {
+	at := time.Date(2024, 9, 5, 7, 45, 0, 0, denver)
+	tests := []struct {
+		name     string
+		current  int
+		decision Decision
+	}{
+		{"usual traffic", 1800, Wait},
+		{"light traffic", 1500, LeaveNow},
+		{"saving just under the minimum", 1440 + 299, LeaveNow},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			advice, ok := DefaultPolicy.Advise(domain.Commute{QueryTime: at, Duration: test.current}, history(), denver)
+			if !ok {
+				t.Fatal("expected advice")
+			}
+			if advice.Decision != test.decision || advice.Usual != 1800 || advice.Later != 1440 || advice.Samples != 3 {
+				t.Errorf("unexpected advice: %+v", advice)
+			}
+		})
+	}
+
+	// 12:00 has no history nearby.
+	if _, ok := DefaultPolicy.Advise(domain.Commute{QueryTime: at.Add(4 * time.Hour), Duration: 1800}, history(), denver); ok {
+		t.Error("expected no advice without a baseline")
+	}
+}
+
+func TestHTTPChannels(t *testing.T) {
+	var got *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		raw, _ := io.ReadAll(r.Body)
+		body = string(raw)
+	}))
+	defer server.Close()
+	message := Message{Title: "Leave now for work", Body: "Route 7 takes 25 min right now.", RouteID: 7, Decision: LeaveNow}
+
+	if err := (&Webhook{}).Send(context.Background(), server.URL, message); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Message
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil || decoded.RouteID != 7 || decoded.Decision != LeaveNow {
+		t.Errorf("webhook body %q: %v", body, err)
+	}
+
+	if err := (&Slack{}).Send(context.Background(), server.URL, message); err != nil {
+		t.Fatal(err)
+	}
+	if body != `{"text":"*Leave now for work*\nRoute 7 takes 25 min right now."}` {
+		t.Errorf("slack body = %q", body)
+	}
+
+	if err := (&Ntfy{}).Send(context.Background(), server.URL+"/commute", message); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/commute" || got.Header.Get("Title") != message.Title || got.Header.Get("Priority") != "high" || body != message.Body {
+		t.Errorf("unexpected ntfy request %s %v: %q", got.URL.Path, got.Header, body)
+	}
+}
+
+func TestMessageOmitsRouteFields(t *testing.T) {
+	body, err := json.Marshal(Message{Title: "Your week", Body: "# Week", UserID: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"title":"Your week","body":"# Week","user_id":3}` {
+		t.Errorf("report payload = %s", body)
+	}
+}
+
+func TestHTTPChannelRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer server.Close()
+	err := (&Webhook{}).Send(context.Background(), server.URL, Message{})
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got %v, want a 404 error", err)
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	var addr, from string
+	var to []string
+	var msg []byte
+	channel := &SMTP{Host: "smtp.example.com", Port: 587, Username: "user", Password: "secret", From: "commutes@example.com"}
+	channel.send = func(ctx context.Context, a string, auth smtp.Auth, f string, t []string, m []byte) error {
+		addr, from, to, msg = a, f, t, m
+		return nil
+	}
+
+	err := channel.Send(context.Background(), "me@example.com", Message{Title: "Leave now for work", Body: "Go."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "smtp.example.com:587" || from != "commutes@example.com" || len(to) != 1 || to[0] != "me@example.com" {
+		t.Errorf("sent from %s to %v via %s", from, to, addr)
+	}
+	if !strings.Contains(string(msg), "Subject: Leave now for work\r\n") || !strings.HasSuffix(string(msg), "\r\n\r\nGo.\r\n") {
+		t.Errorf("unexpected message:\n%s", msg)
+	}
+
+	// With HTML the body becomes the plain text alternative.
+	err = channel.Send(context.Background(), "me@example.com", Message{Title: "Your week", Body: "# Week", HTML: "<h1>Week</h1>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Content-Type: multipart/alternative; boundary=", "Content-Type: text/plain; charset=UTF-8\r\n\r\n# Week", "Content-Type: text/html; charset=UTF-8\r\n\r\n<h1>Week</h1>"} {
+		if !strings.Contains(string(msg), want) {
+			t.Errorf("message is missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestSMTPHeaders(t *testing.T) {
+	var msg []byte
+	channel := &SMTP{Host: "smtp.example.com", Port: 587, From: "commutes@example.com"}
+	channel.send = func(ctx context.Context, a string, auth smtp.Auth, f string, t []string, m []byte) error {
+		msg = m
+		return nil
+	}
+
+	if err := channel.Send(context.Background(), "me@example.com", Message{Title: "Your week – 3 h 20 min", Body: "Go."}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg), "Subject: =?utf-8?q?Your_week_=E2=80=93_3_h_20_min?=\r\n") {
+		t.Errorf("subject is not encoded:\n%s", msg)
+	}
+
+	msg = nil
+	for name, send := range map[string]func() error{
+		"target": func() error {
+			return channel.Send(context.Background(), "me@example.com\r\nBcc: them@example.com", Message{Title: "Hi"})
+		},
+		"title": func() error {
+			return channel.Send(context.Background(), "me@example.com", Message{Title: "Hi\nBcc: them@example.com"})
+		},
+	} {
+		if err := send(); err == nil {
+			t.Errorf("%s with a line break was accepted", name)
+		}
+	}
+	if msg != nil {
+		t.Errorf("sent a message with a line break:\n%s", msg)
+	}
+}
+
+func TestSMTPRespectsContext(t *testing.T) {
+	// The server accepts the connection but never greets.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	channel := &SMTP{Host: host, Port: portNumber, From: "commutes@example.com"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := channel.Send(ctx, "me@example.com", Message{Title: "Hi", Body: "Go."}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline", err)
+	}
+}
+
+func TestChannelsSend(t *testing.T) {
+	capture := &Capture{}
+	channels := Channels{"capture": capture}
+	subscription := domain.Subscription{UserID: 3, Channel: "capture", Target: "phone"}
+
+	if err := channels.Send(context.Background(), subscription, Message{Title: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if sent := capture.Sent(); len(sent) != 1 || sent[0].Target != "phone" || sent[0].Message.Title != "hi" {
+		t.Errorf("captured %+v", sent)
+	}
+	subscription.Channel = "pager"
+	if err := channels.Send(context.Background(), subscription, Message{}); err == nil {
+		t.Error("expected an error for an unknown channel")
+	}
+}
@@ -0,0 +1,84 @@
+// Package pathhistory loads the scheduled commutes a route recorded over the
+// last few days and the paths they took, for routePaths and exportPaths.
+package pathhistory
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"log/slog"
+	"time"
+)
+
+const (
+	DefaultDays = 30
+	MaxDays     = 365
+)
+
+// Query picks a route, one direction or, when ToWork is unset, both, and the
+// last Days days, DefaultDays when unset.
+type Query struct {
+	RouteID *int
+	ToWork  *bool
+	Days    *int
+}
+
+// Period is a checked Query with its route loaded. Directions lists to work
+// before from work.
+type Period struct {
+	Route      domain.Route
+	Days       int
+	Since      time.Time
+	Directions []bool
+}
+
+// Open checks the query and loads its route as of now.
+func Open(ctx context.Context, s *store.Store, query Query, now time.Time) (Period, error) {
+	if query.RouteID == nil {
+		return Period{}, fmt.Errorf("%w. Missing route ID", domain.ErrInvalidRequest)
+	}
+	days := DefaultDays
+	if query.Days != nil {
+		days = *query.Days
+	}
+	if days < 1 || days > MaxDays {
+		return Period{}, fmt.Errorf("%w: days must be between 1 and %d, got %d", domain.ErrInvalidRequest, MaxDays, days)
+	}
+	route, err := s.Routes.Get(ctx, *query.RouteID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading route", "route_id", *query.RouteID, "error", err)
+		return Period{}, fmt.Errorf("error loading route: %w", err)
+	}
+	directions := []bool{true, false}
+	if query.ToWork != nil {
+		directions = []bool{*query.ToWork}
+	}
+	return Period{Route: route, Days: days, Since: now.AddDate(0, 0, -days), Directions: directions}, nil
+}
+
+// Commutes returns the route's scheduled commutes in the direction over the
+// period, oldest first.
+func (p Period) Commutes(ctx context.Context, s *store.Store, toWork bool) ([]domain.Commute, error) {
+	commutes, err := s.Commutes.Recent(ctx, p.Route.ID, toWork, p.Since)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading commutes", "route_id", p.Route.ID, "to_work", toWork, "error", err)
+		return nil, fmt.Errorf("error loading commutes: %v", err)
+	}
+	return commutes, nil
+}
+
+// Paths returns every path of the route in the direction, with the period's
+// scheduled commutes on each, and their labels by ID.
+func (p Period) Paths(ctx context.Context, s *store.Store, toWork bool) ([]domain.PathStats, map[int]string, error) {
+	paths, err := s.Paths.Stats(ctx, p.Route.ID, toWork, p.Since)
+	if err != nil {
+		slog.ErrorContext(ctx, "error loading paths", "route_id", p.Route.ID, "to_work", toWork, "error", err)
+		return nil, nil, fmt.Errorf("error loading paths: %v", err)
+	}
+	labels := map[int]string{}
+	for _, path := range paths {
+		labels[path.ID] = path.Label
+	}
+	return paths, labels, nil
+}
@@ -0,0 +1,151 @@
+// Package planner works back from a target arrival time to the latest
+// departure that arrives on time at a chosen confidence. The provider's
+// traffic prediction for the day sets the expected drive, and the spread of
+// past commutes around that time of day sets how much buffer to add.
+package planner
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/google"
+	"math"
+	"sort"
+	"time"
+)
+
+// Predictor returns the predicted drive in seconds for a departure time.
+type Predictor func(ctx context.Context, trip domain.DueRoute, departure time.Time) (int, error)
+
+// GooglePredictor asks the Routes API for a traffic aware prediction. The API
+// only honours arrivalTime for transit, so driving plans probe departure times
+// instead.
+func GooglePredictor(client *google.Client) Predictor {
+	return func(ctx context.Context, trip domain.DueRoute, departure time.Time) (int, error) {
+		response, err := client.ComputeRoutes(ctx, google.NewRouteRequest(trip.Origin, trip.Destination, departure, trip.Waypoints...))
+		if err != nil {
+			return 0, fmt.Errorf("error computing route (%s): %w", google.ClassName(err), err)
+		}
+		if len(response.Routes) == 0 {
+			return 0, fmt.Errorf("no route found for route %d", trip.ID)
+		}
+		return response.Routes[0].DurationSeconds()
+	}
+}
+
+type Planner struct {
+	Predict Predictor
+	// Tolerance is how far from a departure's time of day a past commute
+	// may be and still count toward its spread.
+	Tolerance time.Duration
+	// MinSamples is the fewest past commutes needed to add a buffer.
+	MinSamples int
+	// MaxProbes caps the provider calls one plan makes.
+	MaxProbes int
+}
+
+func New(predict Predictor) *Planner {
+	return &Planner{Predict: predict, Tolerance: 15 * time.Minute, MinSamples: 5, MaxProbes: 4}
+}
+
+// Probe is one provider prediction made while planning.
+type Probe struct {
+	Departure time.Time `json:"departure"`
+	Duration  int       `json:"duration"`
+}
+
+// Plan is the latest departure that arrives by ArriveBy with probability
+// Confidence. Durations are in seconds. Predicted is the provider's drive
+// for that departure and Buffer the margin added for variation seen in past
+// commutes; HistoricalOnTime is the share of past commutes near that time of
+// day that would have made it. Late is set when the departure has passed.
+type Plan struct {
+	ArriveBy         time.Time `json:"arrive_by"`
+	Confidence       float64   `json:"confidence"`
+	Departure        time.Time `json:"departure"`
+	Predicted        int       `json:"predicted"`
+	Buffer           int       `json:"buffer"`
+	Samples          int       `json:"samples"`
+	HistoricalOnTime *float64  `json:"historical_on_time"`
+	Late             bool      `json:"late"`
+	Probes           []Probe   `json:"probes"`
+}
+
+// Plan probes departures, starting from arriveBy and stepping back by the
+// buffered prediction until the departure settles within a minute. Probes
+// are never made in the past; now is when the provider is asked.
+func (p *Planner) Plan(ctx context.Context, trip domain.DueRoute, arriveBy time.Time, confidence float64, history []domain.Commute, now time.Time) (Plan, error) {
+	plan := Plan{ArriveBy: arriveBy, Confidence: confidence}
+	loc := arriveBy.Location()
+
+	departure := arriveBy
+	for probe := 0; probe < p.MaxProbes; probe++ {
+		asked := departure
+		if asked.Before(now) {
+			asked = now
+		}
+		predicted, err := p.Predict(ctx, trip, asked)
+		if err != nil {
+			return Plan{}, err
+		}
+		plan.Probes = append(plan.Probes, Probe{Departure: asked, Duration: predicted})
+
+		durations := p.nearby(history, asked, loc)
+		buffer := 0
+		if len(durations) >= p.MinSamples {
+			// Scale the prediction by how much slower than typical the
+			// confidence quantile of past commutes was.
+			typical, slow := quantile(durations, 0.5), quantile(durations, confidence)
+			if slow > typical && typical > 0 {
+				buffer = (predicted*(slow-typical) + typical - 1) / typical
+			}
+		}
+		next := arriveBy.Add(-time.Duration(predicted+buffer) * time.Second).Truncate(time.Minute)
+
+		plan.Departure, plan.Predicted, plan.Buffer, plan.Samples = next, predicted, buffer, len(durations)
+		if next.Sub(departure).Abs() < time.Minute {
+			break
+		}
+		departure = next
+	}
+
+	durations := p.nearby(history, plan.Departure, loc)
+	if len(durations) > 0 {
+		onTime := 0
+		allowed := int(arriveBy.Sub(plan.Departure).Seconds())
+		for _, duration := range durations {
+			if duration <= allowed {
+				onTime++
+			}
+		}
+		share := float64(onTime) / float64(len(durations))
+		plan.HistoricalOnTime = &share
+	}
+	plan.Late = plan.Departure.Before(now)
+	return plan, nil
+}
+
+// nearby returns the durations of past commutes within Tolerance of the time
+// of day of at.
+func (p *Planner) nearby(history []domain.Commute, at time.Time, loc *time.Location) []int {
+	target := timeOfDay(at.In(loc))
+	var durations []int
+	for _, commute := range history {
+		if (timeOfDay(commute.QueryTime.In(loc)) - target).Abs() <= p.Tolerance {
+			durations = append(durations, commute.Duration)
+		}
+	}
+	return durations
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// quantile is the nearest-rank q quantile of values.
+func quantile(values []int, q float64) int {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
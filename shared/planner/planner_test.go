@@ -0,0 +1,91 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+// history is ten past commutes at 08:25 whose median is 30 minutes and whose
+// 90th percentile is 35.
+func history() []domain.Commute {
+	durations := []int{1700, 1750, 1800, 1800, 1800, 1850, 1900, 2000, 2100, 2400}
+	commutes := make([]domain.Commute, len(durations))
+	for i, duration := range durations {
+		commutes[i] = domain.Commute{QueryTime: time.Date(2024, 8, 20+i, 8, 25, 0, 0, denver), Duration: duration}
+	}
+	return commutes
+}
+
+func constant(seconds int) Predictor {
+	return func(ctx context.Context, trip domain.DueRoute, departure time.Time) (int, error) {
+		return seconds, nil
+	}
+}
+
+func TestPlan(t *testing.T) {
+	arriveBy := time.Date(2024, 9, 5, 9, 0, 0, 0, denver)
+	now := time.Date(2024, 9, 5, 6, 0, 0, 0, denver)
+	plan, err := New(constant(1800)).Plan(context.Background(), domain.DueRoute{ID: 7}, arriveBy, 0.9, history(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing was recorded near 09:00, so the first probe adds no buffer. At
+	// 08:30 the spread of past commutes adds five minutes.
+	want := time.Date(2024, 9, 5, 8, 25, 0, 0, denver)
+	if !plan.Departure.Equal(want) || plan.Predicted != 1800 || plan.Buffer != 300 || plan.Samples != 10 || plan.Late {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+	if len(plan.Probes) != 3 || !plan.Probes[1].Departure.Equal(want.Add(5*time.Minute)) {
+		t.Errorf("unexpected probes: %+v", plan.Probes)
+	}
+	if plan.HistoricalOnTime == nil || *plan.HistoricalOnTime != 0.9 {
+		t.Errorf("historical_on_time = %v, want 0.9", plan.HistoricalOnTime)
+	}
+
+	// At 50% confidence the median needs no buffer.
+	plan, err = New(constant(1800)).Plan(context.Background(), domain.DueRoute{ID: 7}, arriveBy, 0.5, history(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Departure.Equal(want.Add(5*time.Minute)) || plan.Buffer != 0 {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestPlanLateAndProbesNotInThePast(t *testing.T) {
+	arriveBy := time.Date(2024, 9, 5, 9, 0, 0, 0, denver)
+	now := time.Date(2024, 9, 5, 8, 45, 0, 0, denver)
+	var asked []time.Time
+	predict := func(ctx context.Context, trip domain.DueRoute, departure time.Time) (int, error) {
+		asked = append(asked, departure)
+		return 1800, nil
+	}
+	plan, err := New(predict).Plan(context.Background(), domain.DueRoute{ID: 7}, arriveBy, 0.9, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Late || plan.HistoricalOnTime != nil {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+	for _, departure := range asked {
+		if departure.Before(now) {
+			t.Errorf("probed %v, before now", departure)
+		}
+	}
+}
+
+func TestPlanPredictorError(t *testing.T) {
+	failing := func(ctx context.Context, trip domain.DueRoute, departure time.Time) (int, error) {
+		return 0, errors.New("quota exceeded")
+	}
+	_, err := New(failing).Plan(context.Background(), domain.DueRoute{ID: 7}, time.Now(), 0.9, nil, time.Now())
+	if err == nil {
+		t.Error("expected the predictor error")
+	}
+}
@@ -0,0 +1,198 @@
+// Package polyline decodes the encoded polylines the Routes API returns and
+// reduces each one to a fingerprint, so commutes that followed the same roads
+// can be grouped into one path even when the raw polylines differ slightly.
+package polyline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"math"
+	"strings"
+)
+
+const (
+	// Tolerance is how far in meters Simplify may move the line when
+	// fingerprinting.
+	Tolerance = 50.0
+	// CellDegrees is the size of the grid cells a fingerprint is made of,
+	// roughly 550 by 425 meters around Denver.
+	CellDegrees = 0.005
+	// step is how far apart in meters the line is sampled for cells.
+	step = 100.0
+)
+
+var ErrInvalid = errors.New("invalid polyline")
+
+// Encode encodes points with Google's polyline algorithm at 1e5 precision.
+func Encode(points []domain.LatLng) string {
+	var b strings.Builder
+	var prevLat, prevLng int
+	for _, point := range points {
+		lat := int(math.Round(point.Latitude * 1e5))
+		lng := int(math.Round(point.Longitude * 1e5))
+		encodeValue(&b, lat-prevLat)
+		encodeValue(&b, lng-prevLng)
+		prevLat, prevLng = lat, lng
+	}
+	return b.String()
+}
+
+func encodeValue(b *strings.Builder, value int) {
+	v := value << 1
+	if value < 0 {
+		v = ^v
+	}
+	for v >= 0x20 {
+		b.WriteByte(byte((0x20 | (v & 0x1f)) + 63))
+		v >>= 5
+	}
+	b.WriteByte(byte(v + 63))
+}
+
+// Decode reverses Encode.
+func Decode(encoded string) ([]domain.LatLng, error) {
+	var points []domain.LatLng
+	var lat, lng int
+	for i := 0; i < len(encoded); {
+		dLat, next, err := decodeValue(encoded, i)
+		if err != nil {
+			return nil, err
+		}
+		dLng, next, err := decodeValue(encoded, next)
+		if err != nil {
+			return nil, err
+		}
+		i = next
+		lat += dLat
+		lng += dLng
+		points = append(points, domain.LatLng{Latitude: float64(lat) / 1e5, Longitude: float64(lng) / 1e5})
+	}
+	return points, nil
+}
+
+func decodeValue(encoded string, i int) (int, int, error) {
+	var result, shift int
+	for {
+		if i >= len(encoded) {
+			return 0, i, fmt.Errorf("%w: truncated at byte %d", ErrInvalid, i)
+		}
+		c := int(encoded[i]) - 63
+		if c < 0 || c > 0x3f || shift > 30 {
+			return 0, i, fmt.Errorf("%w: unexpected %q at byte %d", ErrInvalid, encoded[i], i)
+		}
+		i++
+		result |= (c & 0x1f) << shift
+		shift += 5
+		if c < 0x20 {
+			break
+		}
+	}
+	if result&1 != 0 {
+		return ^(result >> 1), i, nil
+	}
+	return result >> 1, i, nil
+}
+
+// Simplify drops the points that lie within tolerance meters of the line
+// through their neighbours, using the Douglas-Peucker algorithm. The first
+// and last points are always kept.
+func Simplify(points []domain.LatLng, tolerance float64) []domain.LatLng {
+	if len(points) < 3 {
+		return points
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	project := projection(points[0])
+	var simplify func(first, last int)
+	simplify = func(first, last int) {
+		a, b := project(points[first]), project(points[last])
+		farthest, distance := 0, tolerance
+		for i := first + 1; i < last; i++ {
+			if d := segmentDistance(project(points[i]), a, b); d > distance {
+				farthest, distance = i, d
+			}
+		}
+		if farthest == 0 {
+			return
+		}
+		keep[farthest] = true
+		simplify(first, farthest)
+		simplify(farthest, last)
+	}
+	simplify(0, len(points)-1)
+
+	var simplified []domain.LatLng
+	for i, point := range points {
+		if keep[i] {
+			simplified = append(simplified, point)
+		}
+	}
+	return simplified
+}
+
+// Fingerprint identifies the roads an encoded polyline follows. The line is
+// simplified, walked in steps of 100 meters and reduced to the grid cells it
+// passes through, so small shifts in the polyline keep the same fingerprint
+// while a detour onto other roads changes it. An empty polyline has no
+// fingerprint.
+func Fingerprint(encoded string) (string, error) {
+	points, err := Decode(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(points) == 0 {
+		return "", fmt.Errorf("%w: no points", ErrInvalid)
+	}
+	points = Simplify(points, Tolerance)
+
+	var cells [][2]int
+	visit := func(point domain.LatLng) {
+		cell := [2]int{int(math.Floor(point.Latitude / CellDegrees)), int(math.Floor(point.Longitude / CellDegrees))}
+		if len(cells) == 0 || cells[len(cells)-1] != cell {
+			cells = append(cells, cell)
+		}
+	}
+	project := projection(points[0])
+	visit(points[0])
+	for i := 1; i < len(points); i++ {
+		from, to := points[i-1], points[i]
+		a, b := project(from), project(to)
+		steps := int(math.Ceil(math.Hypot(b[0]-a[0], b[1]-a[1]) / step))
+		for s := 1; s <= steps; s++ {
+			f := float64(s) / float64(steps)
+			visit(domain.LatLng{
+				Latitude:  from.Latitude + (to.Latitude-from.Latitude)*f,
+				Longitude: from.Longitude + (to.Longitude-from.Longitude)*f,
+			})
+		}
+	}
+
+	hash := sha256.New()
+	for _, cell := range cells {
+		fmt.Fprintf(hash, "%d,%d;", cell[0], cell[1])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// projection returns an equirectangular projection to meters around origin,
+// which is accurate enough over the length of a commute.
+func projection(origin domain.LatLng) func(domain.LatLng) [2]float64 {
+	const metersPerDegree = 111320.0
+	scale := math.Cos(origin.Latitude * math.Pi / 180)
+	return func(point domain.LatLng) [2]float64 {
+		return [2]float64{point.Longitude * metersPerDegree * scale, point.Latitude * metersPerDegree}
+	}
+}
+
+func segmentDistance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	if dx == 0 && dy == 0 {
+		return math.Hypot(p[0]-a[0], p[1]-a[1])
+	}
+	t := ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / (dx*dx + dy*dy)
+	t = max(0, min(1, t))
+	return math.Hypot(p[0]-a[0]-t*dx, p[1]-a[1]-t*dy)
+}
@@ -0,0 +1,85 @@
+package polyline
+
+import (
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"testing"
+)
+
+// example is the one from Google's polyline algorithm documentation.
+const example = "_p~iF~ps|U_ulLnnqC_mqNvxq`@"
+
+func TestDecode(t *testing.T) {
+	points, err := Decode(example)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []domain.LatLng{{Latitude: 38.5, Longitude: -120.2}, {Latitude: 40.7, Longitude: -120.95}, {Latitude: 43.252, Longitude: -126.453}}
+	if len(points) != len(want) {
+		t.Fatalf("decoded %v, want %v", points, want)
+	}
+	for i := range want {
+		if points[i] != want[i] {
+			t.Errorf("point %d = %v, want %v", i, points[i], want[i])
+		}
+	}
+	if got := Encode(points); got != example {
+		t.Errorf("Encode(Decode) = %q", got)
+	}
+
+	for _, invalid := range []string{"_p~iF~ps|", "_p~iF~ps|U_", "_p~iF ps|U"} {
+		if _, err := Decode(invalid); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Decode(%q) = %v, want ErrInvalid", invalid, err)
+		}
+	}
+}
+
+// line runs east from Boulder for about 4 km in n points.
+// offset degrees.
+func line(n int) []domain.LatLng {
+	var points []domain.LatLng
+	for i := 0; i < n; i++ {
+		points = append(points, domain.LatLng{Latitude: 40.0123, Longitude: -105.27 + float64(i)*0.05/float64(n-1)})
+	}
+	return points
+}
+
+func TestSimplify(t *testing.T) {
+	// A 20 m wobble is dropped, and so is every point on a straight stretch,
+	// but the corner where the line turns north is kept.
+	points := line(4)
+	points[1].Latitude += 0.0002
+	corner := points[3]
+	points = append(points, domain.LatLng{Latitude: corner.Latitude + 0.01, Longitude: corner.Longitude}, domain.LatLng{Latitude: corner.Latitude + 0.02, Longitude: corner.Longitude})
+	simplified := Simplify(points, Tolerance)
+	if len(simplified) != 3 || simplified[0] != points[0] || simplified[1] != corner || simplified[2] != points[5] {
+		t.Errorf("Simplify kept %v", simplified)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	base, err := Fingerprint(Encode(line(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More points and a few meters of noise follow the same roads.
+	noisy := line(40)
+	for i := range noisy {
+		noisy[i].Latitude += float64(i%3-1) * 0.00005
+	}
+	if got, _ := Fingerprint(Encode(noisy)); got != base {
+		t.Errorf("noisy fingerprint %s, want %s", got, base)
+	}
+
+	// A detour two kilometers north does not.
+	detour := line(5)
+	detour[2].Latitude += 0.02
+	if got, _ := Fingerprint(Encode(detour)); got == base {
+		t.Error("detour kept the same fingerprint")
+	}
+
+	if _, err := Fingerprint(""); !errors.Is(err, ErrInvalid) {
+		t.Errorf("empty polyline: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// Formats lists the formats Render accepts.
+var Formats = []string{"markdown", "html", "json"}
+
+// Render renders report as "markdown", "html" or "json".
+func Render(report Report, format string) (string, error) {
+	switch format {
+	case "markdown":
+		return Markdown(report), nil
+	case "html":
+		return HTML(report)
+	case "json":
+		body, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("error encoding report: %v", err)
+		}
+		return string(body), nil
+	}
+	return "", fmt.Errorf("unknown report format %q", format)
+}
+
+// Title is the subject line of a report.
+func Title(report Report) string {
+	return fmt.Sprintf("Your commutes for the week of %s", report.WeekStart)
+}
+
+// Minutes formats seconds as "31 min" or "2 h 05 min".
+func Minutes(seconds int) string {
+	minutes := (seconds + 30) / 60
+	if minutes < 60 {
+		return fmt.Sprintf("%d min", minutes)
+	}
+	return fmt.Sprintf("%d h %02d min", minutes/60, minutes%60)
+}
+
+// Compare describes current against previous, e.g. "5 min less than the week
+// before".
+func Compare(current, previous int) string {
+	if previous == 0 {
+		return "nothing recorded the week before"
+	}
+	difference := current - previous
+	if math.Abs(float64(difference)) < 30 {
+		return "about the same as the week before"
+	}
+	if difference > 0 {
+		return Minutes(difference) + " more than the week before"
+	}
+	return Minutes(-difference) + " less than the week before"
+}
+
+func heading(route Route) string {
+	if route.StartAddress == "" || route.EndAddress == "" {
+		return fmt.Sprintf("Route %d", route.RouteID)
+	}
+	return fmt.Sprintf("Route %d: %s to %s", route.RouteID, route.StartAddress, route.EndAddress)
+}
+
+func directionTitle(direction string) string {
+	if direction == "to_work" {
+		return "To work"
+	}
+	return "From work"
+}
+
+func average(direction Direction) string {
+	text := fmt.Sprintf("Average %s (best %s, worst %s) over %d checks", Minutes(direction.Average), Minutes(direction.Best), Minutes(direction.Worst), direction.Samples)
+	if direction.Previous != nil {
+		text += ", " + Compare(direction.Average, *direction.Previous)
+	}
+	return text + "."
+}
+
+func bestDeparture(direction Direction) string {
+	return fmt.Sprintf("Best time to leave: %s (%s on average).", direction.BestDeparture, Minutes(direction.BestDepartureAverage))
+}
+
+// byLeg is empty for a direction without legs.
+func byLeg(direction Direction) string {
+	if len(direction.Legs) == 0 {
+		return ""
+	}
+	parts := make([]string, len(direction.Legs))
+	for i, leg := range direction.Legs {
+		parts[i] = fmt.Sprintf("%s to %s %s", leg.From, leg.To, Minutes(leg.Average))
+	}
+	return fmt.Sprintf(" By leg: %s.", strings.Join(parts, ", "))
+}
+
+func summary(report Report) string {
+	return fmt.Sprintf("You spent about %s commuting, %s.", Minutes(report.Total), Compare(report.Total, report.PreviousTotal))
+}
+
+// Markdown renders report as Markdown.
+func Markdown(report Report) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Commutes from %s to %s\n\n%s\n", report.WeekStart, report.WeekEnd, summary(report))
+	for _, route := range report.Routes {
+		fmt.Fprintf(&b, "\n## %s\n", heading(route))
+		if route.Problem != "" {
+			fmt.Fprintf(&b, "\n%s\n", route.Problem)
+			continue
+		}
+		if len(route.Directions) == 0 {
+			b.WriteString("\nNo commutes recorded this week.\n")
+		}
+		for _, direction := range route.Directions {
+			fmt.Fprintf(&b, "\n### %s\n\n%s %s%s\n\n", directionTitle(direction.Direction), average(direction), bestDeparture(direction), byLeg(direction))
+			b.WriteString("| Day | Average | Best | Worst | Best at |\n| --- | ---: | ---: | ---: | ---: |\n")
+			for _, day := range direction.Days {
+				fmt.Fprintf(&b, "| %s %s | %s | %s | %s | %s |\n", day.Weekday[:3], day.Date, Minutes(day.Average), Minutes(day.Best), Minutes(day.Worst), day.BestAt)
+			}
+		}
+	}
+	return b.String()
+}
+
+var page = template.Must(template.New("report").Funcs(template.FuncMap{
+	"minutes":        Minutes,
+	"heading":        heading,
+	"directionTitle": directionTitle,
+	"average":        average,
+	"bestDeparture":  bestDeparture,
+	"byLeg":          byLeg,
+	"summary":        summary,
+	"short":          func(weekday string) string { return weekday[:3] },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Commutes from {{.WeekStart}} to {{.WeekEnd}}</title></head>
+<body>
+<h1>Commutes from {{.WeekStart}} to {{.WeekEnd}}</h1>
+<p>{{summary .}}</p>
+{{- range .Routes}}
+<h2>{{heading .}}</h2>
+{{- if .Problem}}
+<p>{{.Problem}}</p>
+{{- else if not .Directions}}
+<p>No commutes recorded this week.</p>
+{{- end}}
+{{- range .Directions}}
+<h3>{{directionTitle .Direction}}</h3>
+<p>{{average .}} {{bestDeparture .}}{{byLeg .}}</p>
+<table>
+<tr><th>Day</th><th>Average</th><th>Best</th><th>Worst</th><th>Best at</th></tr>
+{{- range .Days}}
+<tr><td>{{short .Weekday}} {{.Date}}</td><td>{{minutes .Average}}</td><td>{{minutes .Best}}</td><td>{{minutes .Worst}}</td><td>{{.BestAt}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// HTML renders report as an HTML page.
+func HTML(report Report) (string, error) {
+	var b bytes.Buffer
+	if err := page.Execute(&b, report); err != nil {
+		return "", fmt.Errorf("error rendering report: %v", err)
+	}
+	return b.String(), nil
+}
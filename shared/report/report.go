@@ -0,0 +1,266 @@
+// Package report summarises a user's week of commutes per route and renders
+// the summary as Markdown, HTML or JSON for a Sender to deliver.
+package report
+
+import (
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"slices"
+	"sort"
+	"time"
+)
+
+// slot is the width of the departure times compared for the best departure.
+const slot = 15 * time.Minute
+
+// Report is one user's week, Monday to Sunday in each route's time zone.
+// Durations are in seconds. Total estimates the time spent commuting as one
+// trip of the day's average duration per day and direction recorded;
+// PreviousTotal is the same for the week before.
+type Report struct {
+	UserID        int      `json:"user_id"`
+	WeekStart     string   `json:"week_start"`
+	WeekEnd       string   `json:"week_end"`
+	Total         int      `json:"total"`
+	PreviousTotal int      `json:"previous_total"`
+	Change        *float64 `json:"change"`
+	Routes        []Route  `json:"routes"`
+}
+
+type Route struct {
+	RouteID       int         `json:"route_id"`
+	StartAddress  string      `json:"start_address"`
+	EndAddress    string      `json:"end_address"`
+	Total         int         `json:"total"`
+	PreviousTotal int         `json:"previous_total"`
+	Directions    []Direction `json:"directions"`
+	// Problem explains a route that could not be summarised.
+	Problem string `json:"problem,omitempty"`
+}
+
+// Direction summarises one direction of a route. BestDeparture is the
+// 15-minute slot, "HH:MM" local time, with the lowest average duration.
+// Previous and Change compare the average with the week before, when it
+// recorded any commutes.
+type Direction struct {
+	Direction            string   `json:"direction"`
+	Samples              int      `json:"samples"`
+	Average              int      `json:"average"`
+	Best                 int      `json:"best"`
+	Worst                int      `json:"worst"`
+	BestDeparture        string   `json:"best_departure"`
+	BestDepartureAverage int      `json:"best_departure_average"`
+	Total                int      `json:"total"`
+	Previous             *int     `json:"previous"`
+	PreviousTotal        int      `json:"previous_total"`
+	Change               *float64 `json:"change"`
+	Days                 []Day    `json:"days"`
+	// Legs break the average down by stop for a route with waypoints.
+	Legs []Leg `json:"legs,omitempty"`
+}
+
+// Leg is the average drive between two stops over the week's commutes that
+// recorded their legs. From and To are the stops' addresses, or "home" and
+// "work".
+type Leg struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Samples int    `json:"samples"`
+	Average int    `json:"average"`
+}
+
+// Day summarises one direction of one day. BestAt is when the best duration
+// was recorded.
+type Day struct {
+	Date    string `json:"date"`
+	Weekday string `json:"weekday"`
+	Samples int    `json:"samples"`
+	Average int    `json:"average"`
+	Best    int    `json:"best"`
+	Worst   int    `json:"worst"`
+	BestAt  string `json:"best_at"`
+}
+
+// RouteCommutes is a route with its waypoints by position and its scheduled
+// commutes from the week before the report's week onward.
+type RouteCommutes struct {
+	Route     domain.Route
+	Waypoints []domain.Waypoint
+	Commutes  []domain.Commute
+}
+
+// WeekStart returns the Monday on or before t's date, at midnight UTC.
+func WeekStart(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// Build summarises routes for the week starting on monday's date.
+func Build(userID int, monday time.Time, routes []RouteCommutes) Report {
+	report := Report{
+		UserID:    userID,
+		WeekStart: monday.Format(time.DateOnly),
+		WeekEnd:   monday.AddDate(0, 0, 6).Format(time.DateOnly),
+		Routes:    make([]Route, 0, len(routes)),
+	}
+	for _, route := range routes {
+		summary := buildRoute(route, monday)
+		report.Total += summary.Total
+		report.PreviousTotal += summary.PreviousTotal
+		report.Routes = append(report.Routes, summary)
+	}
+	sort.Slice(report.Routes, func(i, j int) bool { return report.Routes[i].RouteID < report.Routes[j].RouteID })
+	report.Change = change(report.Total, report.PreviousTotal)
+	return report
+}
+
+func buildRoute(route RouteCommutes, monday time.Time) Route {
+	summary := Route{RouteID: route.Route.ID, StartAddress: route.Route.StartAddress, EndAddress: route.Route.EndAddress}
+	loc, err := time.LoadLocation(route.Route.TimeZone)
+	if err != nil {
+		summary.Problem = fmt.Sprintf("invalid time zone %q", route.Route.TimeZone)
+		return summary
+	}
+	start := time.Date(monday.Year(), monday.Month(), monday.Day(), 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 0, 7)
+	previousStart := start.AddDate(0, 0, -7)
+
+	for _, toWork := range []bool{true, false} {
+		var week, previous []domain.Commute
+		for _, commute := range route.Commutes {
+			switch {
+			case commute.ToWork != toWork || !commute.Scheduled():
+			case !commute.QueryTime.Before(start) && commute.QueryTime.Before(end):
+				week = append(week, commute)
+			case !commute.QueryTime.Before(previousStart) && commute.QueryTime.Before(start):
+				previous = append(previous, commute)
+			}
+		}
+		if len(week) == 0 {
+			continue
+		}
+		direction := buildDirection(week, loc)
+		direction.Direction = directionName(toWork)
+		direction.Legs = buildLegs(route.Waypoints, toWork, week)
+		if len(previous) > 0 {
+			average := mean(previous)
+			direction.Previous = &average
+			for _, day := range days(previous, loc) {
+				direction.PreviousTotal += day.Average
+			}
+			direction.Change = change(direction.Average, average)
+		}
+		summary.Total += direction.Total
+		summary.PreviousTotal += direction.PreviousTotal
+		summary.Directions = append(summary.Directions, direction)
+	}
+	return summary
+}
+
+func buildDirection(commutes []domain.Commute, loc *time.Location) Direction {
+	direction := Direction{Samples: len(commutes), Average: mean(commutes), Best: commutes[0].Duration, Worst: commutes[0].Duration}
+	slots := map[time.Duration][]domain.Commute{}
+	for _, commute := range commutes {
+		direction.Best = min(direction.Best, commute.Duration)
+		direction.Worst = max(direction.Worst, commute.Duration)
+		local := commute.QueryTime.In(loc)
+		at := (time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute).Truncate(slot)
+		slots[at] = append(slots[at], commute)
+	}
+	best := time.Duration(-1)
+	for at, commutes := range slots {
+		average := mean(commutes)
+		if best < 0 || average < direction.BestDepartureAverage || (average == direction.BestDepartureAverage && at < best) {
+			best, direction.BestDepartureAverage = at, average
+		}
+	}
+	direction.BestDeparture = fmt.Sprintf("%02d:%02d", int(best.Hours()), int(best.Minutes())%60)
+	direction.Days = days(commutes, loc)
+	for _, day := range direction.Days {
+		direction.Total += day.Average
+	}
+	return direction
+}
+
+// buildLegs averages each leg between stops in the direction of travel, or
+// returns nil when no commute recorded them.
+func buildLegs(waypoints []domain.Waypoint, toWork bool, commutes []domain.Commute) []Leg {
+	if len(waypoints) == 0 {
+		return nil
+	}
+	stops := []string{"home"}
+	for _, waypoint := range waypoints {
+		stops = append(stops, waypoint.Address)
+	}
+	stops = append(stops, "work")
+	if !toWork {
+		slices.Reverse(stops)
+	}
+	legs := make([]Leg, len(stops)-1)
+	for i := range legs {
+		legs[i].From, legs[i].To = stops[i], stops[i+1]
+	}
+	for _, commute := range commutes {
+		if len(commute.Legs) != len(legs) {
+			continue
+		}
+		for i, leg := range commute.Legs {
+			legs[i].Samples++
+			legs[i].Average += leg.Duration
+		}
+	}
+	if legs[0].Samples == 0 {
+		return nil
+	}
+	for i := range legs {
+		legs[i].Average = (legs[i].Average + legs[i].Samples/2) / legs[i].Samples
+	}
+	return legs
+}
+
+// days summarises commutes by local date, oldest first.
+func days(commutes []domain.Commute, loc *time.Location) []Day {
+	byDate := map[string][]domain.Commute{}
+	for _, commute := range commutes {
+		date := commute.QueryTime.In(loc).Format(time.DateOnly)
+		byDate[date] = append(byDate[date], commute)
+	}
+	summaries := make([]Day, 0, len(byDate))
+	for date, commutes := range byDate {
+		day := Day{Date: date, Weekday: commutes[0].QueryTime.In(loc).Weekday().String(), Samples: len(commutes), Average: mean(commutes)}
+		for i, commute := range commutes {
+			if i == 0 || commute.Duration < day.Best {
+				day.Best, day.BestAt = commute.Duration, commute.QueryTime.In(loc).Format("15:04")
+			}
+			day.Worst = max(day.Worst, commute.Duration)
+		}
+		summaries = append(summaries, day)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Date < summaries[j].Date })
+	return summaries
+}
+
+func mean(commutes []domain.Commute) int {
+	total := 0
+	for _, commute := range commutes {
+		total += commute.Duration
+	}
+	return (total + len(commutes)/2) / len(commutes)
+}
+
+// change is the fractional change from previous to current, or nil when
+// there is nothing to compare with.
+func change(current, previous int) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	value := float64(current-previous) / float64(previous)
+	return &value
+}
+
+func directionName(toWork bool) string {
+	if toWork {
+		return "to_work"
+	}
+	return "from_work"
+}
@@ -0,0 +1,184 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/notify"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 9, day, hour, minute, 0, 0, denver)
+}
+
+var monday = time.Date(2024, 9, 9, 0, 0, 0, 0, time.UTC)
+
+// week is route 7 for the week of 2024-09-09 and the week before, with a
+// commute either side that does not count.
+func week() []RouteCommutes {
+	commute := func(toWork bool, when time.Time, duration int) domain.Commute {
+		return domain.Commute{Route: 7, UserID: 3, ToWork: toWork, QueryTime: when, Duration: duration}
+	}
+	return []RouteCommutes{{
+		Route: domain.Route{ID: 7, UserID: 3, StartAddress: "Pearl & 9th", EndAddress: "2 Work Ave", TimeZone: "America/Denver"},
+		Commutes: []domain.Commute{
+			commute(true, at(2, 7, 45), 1800),
+			commute(true, at(2, 8, 0), 2000),
+			commute(true, at(9, 7, 45), 1800),
+			commute(true, at(9, 8, 0), 1500),
+			commute(true, at(9, 8, 15), 1600),
+			commute(true, at(10, 7, 45), 2000),
+			commute(true, at(10, 8, 0), 1400),
+			commute(false, at(11, 17, 0), 2400),
+			// Late on Sunday in Denver is Monday in UTC.
+			commute(false, at(15, 23, 30), 1200),
+			commute(true, at(16, 7, 45), 900),
+			{Route: 7, UserID: 3, ToWork: true, QueryTime: at(9, 9, 0), Duration: 60, AdHoc: true},
+		},
+	}}
+}
+
+func TestWeekStart(t *testing.T) {
+	for _, day := range []time.Time{at(9, 0, 0), at(12, 8, 0), time.Date(2024, 9, 15, 12, 0, 0, 0, time.UTC)} {
+		if got := WeekStart(day); !got.Equal(monday) {
+			t.Errorf("WeekStart(%v) = %v, want %v", day, got, monday)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	report := Build(3, monday, week())
+	if report.WeekStart != "2024-09-09" || report.WeekEnd != "2024-09-15" || report.Total != 6933 || report.PreviousTotal != 1900 || len(report.Routes) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	directions := report.Routes[0].Directions
+	if len(directions) != 2 {
+		t.Fatalf("unexpected directions: %+v", directions)
+	}
+
+	toWork := directions[0]
+	if toWork.Direction != "to_work" || toWork.Samples != 5 || toWork.Average != 1660 || toWork.Best != 1400 || toWork.Worst != 2000 ||
+		toWork.BestDeparture != "08:00" || toWork.BestDepartureAverage != 1450 || toWork.Total != 3333 {
+		t.Errorf("unexpected to_work: %+v", toWork)
+	}
+	if toWork.Previous == nil || *toWork.Previous != 1900 || toWork.Change == nil || *toWork.Change > -0.12 {
+		t.Errorf("unexpected comparison: previous %v, change %v", toWork.Previous, toWork.Change)
+	}
+	want := Day{Date: "2024-09-09", Weekday: "Monday", Samples: 3, Average: 1633, Best: 1500, Worst: 1800, BestAt: "08:00"}
+	if len(toWork.Days) != 2 || toWork.Days[0] != want {
+		t.Errorf("unexpected days: %+v", toWork.Days)
+	}
+
+	fromWork := directions[1]
+	if fromWork.Samples != 2 || fromWork.BestDeparture != "23:30" || fromWork.Previous != nil || fromWork.Change != nil || fromWork.Days[1].Date != "2024-09-15" {
+		t.Errorf("unexpected from_work: %+v", fromWork)
+	}
+}
+
+func TestBuildLegs(t *testing.T) {
+	routes := week()
+	routes[0].Waypoints = []domain.Waypoint{{RouteID: 7, Position: 1, Address: "Daycare"}}
+	for i, commute := range routes[0].Commutes {
+		// The morning of the 10th predates the stop.
+		if commute.QueryTime.Day() != 10 {
+			routes[0].Commutes[i].Legs = []domain.Leg{{Duration: 600}, {Duration: commute.Duration - 600}}
+		}
+	}
+	report := Build(3, monday, routes)
+
+	toWork, fromWork := report.Routes[0].Directions[0], report.Routes[0].Directions[1]
+	want := []Leg{{From: "home", To: "Daycare", Samples: 3, Average: 600}, {From: "Daycare", To: "work", Samples: 3, Average: 1033}}
+	if len(toWork.Legs) != 2 || toWork.Legs[0] != want[0] || toWork.Legs[1] != want[1] {
+		t.Errorf("to_work legs = %+v", toWork.Legs)
+	}
+	if len(fromWork.Legs) != 2 || fromWork.Legs[0].From != "work" || fromWork.Legs[1].To != "home" {
+		t.Errorf("from_work legs = %+v", fromWork.Legs)
+	}
+	if markdown := Markdown(report); !strings.Contains(markdown, "Best time to leave: 08:00 (24 min on average). By leg: home to Daycare 10 min, Daycare to work 17 min.\n") {
+		t.Errorf("legs are not rendered:\n%s", markdown)
+	}
+	if Build(3, monday, week()).Routes[0].Directions[0].Legs != nil {
+		t.Error("legs for a route without waypoints")
+	}
+}
+
+func TestBuildInvalidTimeZone(t *testing.T) {
+	routes := week()
+	routes[0].Route.TimeZone = "Mars/Olympus_Mons"
+	report := Build(3, monday, routes)
+	if report.Routes[0].Problem == "" || report.Total != 0 || report.Change != nil {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if !strings.Contains(Markdown(report), "invalid time zone") {
+		t.Errorf("the problem is not rendered:\n%s", Markdown(report))
+	}
+}
+
+func TestRender(t *testing.T) {
+	report := Build(3, monday, week())
+
+	markdown, err := Render(report, "markdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# Commutes from 2024-09-09 to 2024-09-15\n\nYou spent about 1 h 56 min commuting, 1 h 24 min more than the week before.\n",
+		"## Route 7: Pearl & 9th to 2 Work Ave\n",
+		"Average 28 min (best 23 min, worst 33 min) over 5 checks, 4 min less than the week before. Best time to leave: 08:00 (24 min on average).",
+		"| Mon 2024-09-09 | 27 min | 25 min | 30 min | 08:00 |\n",
+	} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("markdown is missing %q:\n%s", want, markdown)
+		}
+	}
+
+	html, err := Render(report, "html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html, "<h2>Route 7: Pearl &amp; 9th to 2 Work Ave</h2>") || !strings.Contains(html, "<td>Mon 2024-09-09</td><td>27 min</td>") {
+		t.Errorf("unexpected html:\n%s", html)
+	}
+
+	body, err := Render(report, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil || decoded.Total != report.Total || len(decoded.Routes[0].Directions) != 2 {
+		t.Errorf("decoded %+v, %v", decoded, err)
+	}
+
+	if _, err := Render(report, "pdf"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestSubscribers(t *testing.T) {
+	db, memory := store.NewMemory()
+	memory.Subscriptions = []domain.Subscription{{UserID: 3, Channel: "capture", Target: "me@example.com", Active: true}}
+	capture := &notify.Capture{}
+	sender := &Subscribers{Notifications: db.Notifications, Channels: notify.Channels{"capture": capture}}
+
+	report := Build(3, monday, week())
+	if err := sender.Send(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+	sent := capture.Sent()
+	if len(sent) != 1 || sent[0].Message.Title != "Your commutes for the week of 2024-09-09" ||
+		!strings.HasPrefix(sent[0].Message.Body, "# Commutes") || !strings.HasPrefix(sent[0].Message.HTML, "<!DOCTYPE html>") {
+		t.Errorf("captured %+v", sent)
+	}
+
+	report.UserID = 4
+	if err := sender.Send(context.Background(), report); !errors.Is(err, ErrNoSubscriptions) {
+		t.Errorf("err = %v, want ErrNoSubscriptions", err)
+	}
+}
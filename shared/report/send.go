@@ -0,0 +1,61 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/notify"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+)
+
+// ErrNoSubscriptions is returned by Subscribers for a user with nowhere to
+// send a report.
+var ErrNoSubscriptions = errors.New("no subscriptions")
+
+// Sender delivers a report to its user.
+type Sender interface {
+	Send(ctx context.Context, report Report) error
+}
+
+// SenderFunc adapts a function to a Sender.
+type SenderFunc func(ctx context.Context, report Report) error
+
+func (f SenderFunc) Send(ctx context.Context, report Report) error {
+	return f(ctx, report)
+}
+
+// Subscribers sends reports through the user's notification subscriptions.
+// The message body is the Markdown report; email also gets the HTML and
+// webhooks the report itself.
+type Subscribers struct {
+	Notifications store.NotificationRepository
+	Channels      notify.Channels
+}
+
+func (s *Subscribers) Send(ctx context.Context, report Report) error {
+	subscriptions, err := s.Notifications.Subscriptions(ctx, report.UserID)
+	if err != nil {
+		return fmt.Errorf("error loading subscriptions: %v", err)
+	}
+	if len(subscriptions) == 0 {
+		return ErrNoSubscriptions
+	}
+	html, err := HTML(report)
+	if err != nil {
+		return err
+	}
+	message := notify.Message{
+		Title:  Title(report),
+		Body:   Markdown(report),
+		UserID: report.UserID,
+		HTML:   html,
+		Report: report,
+	}
+	var errs []error
+	for _, subscription := range subscriptions {
+		if err := s.Channels.Send(ctx, subscription, message); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", subscription.Channel, err))
+		}
+	}
+	return errors.Join(errs...)
+}
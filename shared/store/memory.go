@@ -0,0 +1,581 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/migrations"
+	"maps"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is an in-memory Store backend for tests and local runs. Its fields
+// are exported so tests can seed and inspect data directly. Schedules is
+// keyed by route ID, as is Waypoints; Usage is keyed by provider, then minute bucket.
+type Memory struct {
+	mu        sync.Mutex
+	Users     map[int]domain.User
+	Routes    map[int]domain.Route
+	Waypoints map[int][]domain.Waypoint
+	Schedules map[int]domain.Schedule
+	Commutes  []domain.Commute
+	Usage     map[string]map[time.Time]domain.Usage
+	Budgets   map[string]domain.Budget
+	Circuits  map[string]time.Time
+	// Subscriptions and Notifications mirror the notification tables.
+	Subscriptions []domain.Subscription
+	Notifications []domain.Notification
+	Boosts        []domain.Boost
+	Paths         []domain.RoutePath
+	nextID        int
+}
+
+// NewMemory returns a Store backed by m, and m itself for seeding.
+func NewMemory() (*Store, *Memory) {
+	m := &Memory{
+		Users:     map[int]domain.User{},
+		Routes:    map[int]domain.Route{},
+		Waypoints: map[int][]domain.Waypoint{},
+		Schedules: map[int]domain.Schedule{},
+		Usage:     map[string]map[time.Time]domain.Usage{},
+		Budgets:   map[string]domain.Budget{},
+		Circuits:  map[string]time.Time{},
+	}
+	return &Store{
+		Users:         memoryUsers{m},
+		Routes:        memoryRoutes{m},
+		Waypoints:     memoryWaypoints{m},
+		Schedules:     memorySchedules{m},
+		Commutes:      memoryCommutes{m},
+		Usage:         memoryUsage{m},
+		Notifications: memoryNotifications{m},
+		Boosts:        memoryBoosts{m},
+		Paths:         memoryPaths{m},
+		schemaVersion: func(ctx context.Context) (int, error) {
+			return migrations.Expected(), nil
+		},
+		transact: m.transact,
+	}, m
+}
+
+// transact runs fn against the memory store and puts the routes, waypoints
+// and schedules back as they were when it fails.
+func (m *Memory) transact(ctx context.Context, fn func(tx *Store) error) error {
+	m.mu.Lock()
+	routes, waypoints, schedules := maps.Clone(m.Routes), maps.Clone(m.Waypoints), maps.Clone(m.Schedules)
+	m.mu.Unlock()
+	err := fn(&Store{Routes: memoryRoutes{m}, Waypoints: memoryWaypoints{m}, Schedules: memorySchedules{m}})
+	if err != nil {
+		m.mu.Lock()
+		m.Routes, m.Waypoints, m.Schedules = routes, waypoints, schedules
+		m.mu.Unlock()
+	}
+	return err
+}
+
+func (m *Memory) id() int {
+	m.nextID++
+	return m.nextID
+}
+
+// AllCommutes returns a copy of every recorded commute.
+func (m *Memory) AllCommutes() []domain.Commute {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]domain.Commute(nil), m.Commutes...)
+}
+
+type memoryUsers struct{ m *Memory }
+
+func (r memoryUsers) Get(ctx context.Context, id int) (domain.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	user, ok := r.m.Users[id]
+	if !ok {
+		return domain.User{}, fmt.Errorf("user %d: %w", id, ErrNotFound)
+	}
+	return user, nil
+}
+
+type memoryRoutes struct{ m *Memory }
+
+func (r memoryRoutes) Get(ctx context.Context, id int) (domain.Route, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	route, ok := r.m.Routes[id]
+	if !ok {
+		return domain.Route{}, fmt.Errorf("route %d: %w", id, ErrNotFound)
+	}
+	return route, nil
+}
+
+func (r memoryRoutes) Create(ctx context.Context, route domain.Route) (domain.Route, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	route.ID = r.m.id()
+	r.m.Routes[route.ID] = route
+	return route, nil
+}
+
+type memoryWaypoints struct{ m *Memory }
+
+func (r memoryWaypoints) List(ctx context.Context, routeID int) ([]domain.Waypoint, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	waypoints := append([]domain.Waypoint{}, r.m.Waypoints[routeID]...)
+	sort.Slice(waypoints, func(i, j int) bool { return waypoints[i].Position < waypoints[j].Position })
+	return waypoints, nil
+}
+
+func (r memoryWaypoints) Create(ctx context.Context, routeID int, waypoints []domain.Waypoint) ([]domain.Waypoint, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.Routes[routeID]; !ok {
+		return nil, fmt.Errorf("route %d: %w", routeID, ErrNotFound)
+	}
+	created := make([]domain.Waypoint, len(waypoints))
+	for i, waypoint := range waypoints {
+		waypoint.ID, waypoint.RouteID, waypoint.Position = r.m.id(), routeID, i+1
+		created[i] = waypoint
+	}
+	r.m.Waypoints[routeID] = append(r.m.Waypoints[routeID], created...)
+	return created, nil
+}
+
+type memorySchedules struct{ m *Memory }
+
+func (r memorySchedules) GetByRoute(ctx context.Context, routeID int) (domain.Schedule, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	schedule, ok := r.m.Schedules[routeID]
+	if !ok {
+		return domain.Schedule{}, fmt.Errorf("schedule for route %d: %w", routeID, ErrNotFound)
+	}
+	return schedule, nil
+}
+
+func (r memorySchedules) Create(ctx context.Context, schedule domain.Schedule) (domain.Schedule, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	schedule.ID = r.m.id()
+	r.m.Schedules[schedule.RouteID] = schedule
+	return schedule, nil
+}
+
+// Due mirrors the window logic in queries/to_work_valid_rows.sql and
+// queries/from_work_valid_rows.sql.
+func (r memorySchedules) Due(ctx context.Context, toWork bool, at time.Time) ([]domain.DueRoute, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var due []domain.DueRoute
+	for routeID, schedule := range r.m.Schedules {
+		route, ok := r.m.Routes[routeID]
+		if !ok || !route.Active {
+			continue
+		}
+		loc, err := time.LoadLocation(route.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", routeID, err)
+		}
+		now := at.In(loc)
+		if !schedule.ActiveOn(now.Weekday()) {
+			continue
+		}
+		windowStart, windowEnd := schedule.AfternoonStartTime, schedule.AfternoonEndTime
+		if toWork {
+			windowStart, windowEnd = schedule.MorningStartTime, schedule.MorningEndTime
+		}
+		clock := now.Format("15:04:05")
+		if clock < windowStart || clock > windowEnd {
+			continue
+		}
+
+		dueRoute, err := route.Direction(toWork)
+		if err != nil {
+			return nil, err
+		}
+		dueRoute.WindowStart, dueRoute.WindowEnd = windowStart, windowEnd
+		due = append(due, dueRoute)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	return due, nil
+}
+
+func (r memorySchedules) Active(ctx context.Context) ([]domain.ScheduledRoute, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var scheduled []domain.ScheduledRoute
+	for routeID, schedule := range r.m.Schedules {
+		route, ok := r.m.Routes[routeID]
+		if !ok || !route.Active {
+			continue
+		}
+		scheduled = append(scheduled, domain.ScheduledRoute{Route: route, Schedule: schedule})
+	}
+	sort.Slice(scheduled, func(i, j int) bool { return scheduled[i].Route.ID < scheduled[j].Route.ID })
+	return scheduled, nil
+}
+
+type memoryCommutes struct{ m *Memory }
+
+func (r memoryCommutes) Insert(ctx context.Context, commute domain.Commute) (domain.Commute, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	route, ok := r.m.Routes[commute.Route]
+	if !ok {
+		return domain.Commute{}, fmt.Errorf("route %d: %w", commute.Route, ErrNotFound)
+	}
+	commute.ID = r.m.id()
+	if loc, err := time.LoadLocation(route.TimeZone); err == nil {
+		adjusted := commute.QueryTime.In(loc)
+		commute.AdjustedQueryTime = &adjusted
+	}
+	r.m.Commutes = append(r.m.Commutes, commute)
+	return commute, nil
+}
+
+func (r memoryCommutes) LastRecorded(ctx context.Context, routeIDs []int) (map[int]time.Time, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	wanted := map[int]bool{}
+	for _, id := range routeIDs {
+		wanted[id] = true
+	}
+	last := map[int]time.Time{}
+	for _, commute := range r.m.Commutes {
+		if !wanted[commute.Route] || !commute.Scheduled() {
+			continue
+		}
+		if previous, ok := last[commute.Route]; !ok || commute.QueryTime.After(previous) {
+			last[commute.Route] = commute.QueryTime
+		}
+	}
+	return last, nil
+}
+
+func (r memoryCommutes) DailyCounts(ctx context.Context, routeIDs []int, since time.Time) ([]domain.DailyCount, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	wanted := map[int]bool{}
+	for _, id := range routeIDs {
+		wanted[id] = true
+	}
+	byKey := map[domain.DailyCount]int{}
+	for _, commute := range r.m.Commutes {
+		if !wanted[commute.Route] || !commute.Scheduled() || commute.QueryTime.Before(since) {
+			continue
+		}
+		timeZone := r.m.Routes[commute.Route].TimeZone
+		loc, err := time.LoadLocation(timeZone)
+		if timeZone == "" || err != nil {
+			continue
+		}
+		key := domain.DailyCount{RouteID: commute.Route, ToWork: commute.ToWork, Date: commute.QueryTime.In(loc).Format("2006-01-02")}
+		byKey[key]++
+	}
+
+	counts := make([]domain.DailyCount, 0, len(byKey))
+	for key, count := range byKey {
+		key.Count = count
+		counts = append(counts, key)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.RouteID != b.RouteID {
+			return a.RouteID < b.RouteID
+		}
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		return a.ToWork && !b.ToWork
+	})
+	return counts, nil
+}
+
+func (r memoryCommutes) Recent(ctx context.Context, routeID int, toWork bool, since time.Time) ([]domain.Commute, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	var commutes []domain.Commute
+	for _, commute := range r.m.Commutes {
+		if commute.Route == routeID && commute.ToWork == toWork && commute.Scheduled() && !commute.QueryTime.Before(since) {
+			commutes = append(commutes, commute)
+		}
+	}
+	sort.SliceStable(commutes, func(i, j int) bool { return commutes[i].QueryTime.Before(commutes[j].QueryTime) })
+	return commutes, nil
+}
+
+func (r memoryCommutes) SetAnomalyScore(ctx context.Context, id int, score float64) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for i := range r.m.Commutes {
+		if r.m.Commutes[i].ID == id {
+			r.m.Commutes[i].AnomalyScore = &score
+			return nil
+		}
+	}
+	return fmt.Errorf("commute %d: %w", id, ErrNotFound)
+}
+
+func (r memoryCommutes) SetPath(ctx context.Context, id int, pathID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for i := range r.m.Commutes {
+		if r.m.Commutes[i].ID == id {
+			r.m.Commutes[i].PathID = &pathID
+			return nil
+		}
+	}
+	return fmt.Errorf("commute %d: %w", id, ErrNotFound)
+}
+
+type memoryUsage struct{ m *Memory }
+
+func (r memoryUsage) Record(ctx context.Context, provider string, at time.Time, usage domain.Usage) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	buckets, ok := r.m.Usage[provider]
+	if !ok {
+		buckets = map[time.Time]domain.Usage{}
+		r.m.Usage[provider] = buckets
+	}
+	bucket := at.UTC().Truncate(time.Minute)
+	total := buckets[bucket]
+	total.Requests += usage.Requests
+	total.Failures += usage.Failures
+	total.Units += usage.Units
+	buckets[bucket] = total
+	return nil
+}
+
+func (r memoryUsage) Since(ctx context.Context, provider string, since time.Time) (domain.Usage, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	since = since.UTC().Truncate(time.Minute)
+	var total domain.Usage
+	for bucket, usage := range r.m.Usage[provider] {
+		if bucket.Before(since) {
+			continue
+		}
+		total.Requests += usage.Requests
+		total.Failures += usage.Failures
+		total.Units += usage.Units
+	}
+	return total, nil
+}
+
+func (r memoryUsage) Budget(ctx context.Context, provider string) (domain.Budget, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	budget, ok := r.m.Budgets[provider]
+	if !ok {
+		return domain.Budget{Provider: provider}, nil
+	}
+	return budget, nil
+}
+
+func (r memoryUsage) CircuitOpenedAt(ctx context.Context, provider string) (*time.Time, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	openedAt, ok := r.m.Circuits[provider]
+	if !ok {
+		return nil, nil
+	}
+	return &openedAt, nil
+}
+
+func (r memoryUsage) SetCircuitOpenedAt(ctx context.Context, provider string, openedAt *time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if openedAt == nil {
+		delete(r.m.Circuits, provider)
+		return nil
+	}
+	r.m.Circuits[provider] = *openedAt
+	return nil
+}
+
+type memoryNotifications struct{ m *Memory }
+
+func (r memoryNotifications) Subscriptions(ctx context.Context, userID int) ([]domain.Subscription, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	var subscriptions []domain.Subscription
+	for _, subscription := range r.m.Subscriptions {
+		if subscription.UserID == userID && subscription.Active {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions, nil
+}
+
+func (r memoryNotifications) Last(ctx context.Context, routeID int, toWork bool, since time.Time) (*domain.Notification, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	var last *domain.Notification
+	for i, notification := range r.m.Notifications {
+		if notification.Route != routeID || notification.ToWork != toWork || notification.SentAt.Before(since) {
+			continue
+		}
+		if last == nil || !notification.SentAt.Before(last.SentAt) {
+			last = &r.m.Notifications[i]
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+	notification := *last
+	return &notification, nil
+}
+
+func (r memoryNotifications) Insert(ctx context.Context, notification domain.Notification) (domain.Notification, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	notification.ID = r.m.id()
+	r.m.Notifications = append(r.m.Notifications, notification)
+	return notification, nil
+}
+
+type memoryBoosts struct{ m *Memory }
+
+func (r memoryBoosts) Extend(ctx context.Context, boost domain.Boost, at time.Time) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for i, existing := range r.m.Boosts {
+		if existing.RouteID == boost.RouteID && existing.ToWork == boost.ToWork {
+			if existing.Until.After(boost.Until) {
+				boost.Until = existing.Until
+			}
+			r.m.Boosts[i] = boost
+			return !existing.Until.After(at), nil
+		}
+	}
+	r.m.Boosts = append(r.m.Boosts, boost)
+	return true, nil
+}
+
+func (r memoryBoosts) Active(ctx context.Context, at time.Time) ([]domain.DueRoute, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	var routes []domain.DueRoute
+	for _, boost := range r.m.Boosts {
+		row, ok := r.m.Routes[boost.RouteID]
+		if !ok || !row.Active || !boost.Until.After(at) {
+			continue
+		}
+		route, err := row.Direction(boost.ToWork)
+		if err != nil {
+			return nil, err
+		}
+		until := boost.Until
+		route.BoostedUntil = &until
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].ID != routes[j].ID {
+			return routes[i].ID < routes[j].ID
+		}
+		return routes[i].ToWork
+	})
+	return routes, nil
+}
+
+type memoryPaths struct{ m *Memory }
+
+func (r memoryPaths) Record(ctx context.Context, path domain.RoutePath) (domain.RoutePath, bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	count := 0
+	for i, existing := range r.m.Paths {
+		if existing.RouteID != path.RouteID || existing.ToWork != path.ToWork {
+			continue
+		}
+		count++
+		if existing.Fingerprint == path.Fingerprint {
+			if path.FirstSeen.Before(existing.FirstSeen) {
+				existing.FirstSeen = path.FirstSeen
+			}
+			if path.LastSeen.After(existing.LastSeen) {
+				existing.LastSeen = path.LastSeen
+			}
+			r.m.Paths[i] = existing
+			return existing, false, nil
+		}
+	}
+	if _, ok := r.m.Routes[path.RouteID]; !ok {
+		return domain.RoutePath{}, false, fmt.Errorf("route %d: %w", path.RouteID, ErrNotFound)
+	}
+	path.ID = r.m.id()
+	path.Label = fmt.Sprintf("path %d", count+1)
+	r.m.Paths = append(r.m.Paths, path)
+	return path, true, nil
+}
+
+func (r memoryPaths) Find(ctx context.Context, routeID int, toWork bool, fingerprint string) (*domain.RoutePath, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, path := range r.m.Paths {
+		if path.RouteID == routeID && path.ToWork == toWork && path.Fingerprint == fingerprint {
+			return &path, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r memoryPaths) Last(ctx context.Context, routeID int, toWork bool) (*domain.RoutePath, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	var last *domain.Commute
+	for i, commute := range r.m.Commutes {
+		if commute.Route != routeID || commute.ToWork != toWork || !commute.Scheduled() || commute.PathID == nil {
+			continue
+		}
+		if last == nil || !commute.QueryTime.Before(last.QueryTime) {
+			last = &r.m.Commutes[i]
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+	for _, path := range r.m.Paths {
+		if path.ID == *last.PathID {
+			return &path, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r memoryPaths) Stats(ctx context.Context, routeID int, toWork bool, since time.Time) ([]domain.PathStats, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	var stats []domain.PathStats
+	for _, path := range r.m.Paths {
+		if path.RouteID != routeID || path.ToWork != toWork {
+			continue
+		}
+		stat := domain.PathStats{RoutePath: path}
+		total := 0
+		for _, commute := range r.m.Commutes {
+			if commute.PathID == nil || *commute.PathID != path.ID || !commute.Scheduled() || commute.QueryTime.Before(since) {
+				continue
+			}
+			if stat.Commutes == 0 || commute.Duration < stat.MinDuration {
+				stat.MinDuration = commute.Duration
+			}
+			stat.MaxDuration = max(stat.MaxDuration, commute.Duration)
+			stat.Commutes++
+			total += commute.Duration
+		}
+		if stat.Commutes > 0 {
+			stat.AverageDuration = int(math.Round(float64(total) / float64(stat.Commutes)))
+		}
+		stats = append(stats, stat)
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].FirstSeen.Before(stats[j].FirstSeen) })
+	return stats, nil
+}
@@ -0,0 +1,905 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/migrations"
+	"github.com/lib/pq"
+	"time"
+)
+
+//go:embed queries/*.sql
+var queries embed.FS
+
+var toWorkQueryFilePath = "queries/to_work_valid_rows.sql"
+var fromWorkQueryFilePath = "queries/from_work_valid_rows.sql"
+
+// NewPostgres builds a Store on top of a database/sql connection using the
+// lib/pq driver.
+func NewPostgres(db *sql.DB) *Store {
+	return &Store{
+		Users:         &postgresUsers{db: db},
+		Routes:        &postgresRoutes{db: db},
+		Waypoints:     &postgresWaypoints{db: db},
+		Schedules:     &postgresSchedules{db: db},
+		Commutes:      &postgresCommutes{db: db},
+		Usage:         &postgresUsage{db: db},
+		Notifications: &postgresNotifications{db: db},
+		Boosts:        &postgresBoosts{db: db},
+		Paths:         &postgresPaths{db: db},
+		schemaVersion: func(ctx context.Context) (int, error) {
+			migrator, err := migrations.New(db)
+			if err != nil {
+				return 0, err
+			}
+			return migrator.Version(ctx)
+		},
+		transact: func(ctx context.Context, fn func(tx *Store) error) error {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return fmt.Errorf("failed to begin transaction: %w", err)
+			}
+			defer tx.Rollback()
+			if err := fn(&Store{
+				Routes:    &postgresRoutes{db: tx},
+				Waypoints: &postgresWaypoints{db: tx},
+				Schedules: &postgresSchedules{db: tx},
+			}); err != nil {
+				return err
+			}
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("failed to commit transaction: %w", err)
+			}
+			return nil
+		},
+		close: db.Close,
+	}
+}
+
+// queryer is what the repositories that can take part in a transaction need
+// from a *sql.DB or *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type postgresUsers struct {
+	db *sql.DB
+}
+
+func (r *postgresUsers) Get(ctx context.Context, id int) (domain.User, error) {
+	var user domain.User
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, username, date_joined::text, name FROM public.users WHERE id = $1", id).
+		Scan(&user.ID, &user.Username, &user.DateJoined, &user.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.User{}, fmt.Errorf("user %d: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return domain.User{}, fmt.Errorf("failed to query user %d: %w", id, err)
+	}
+	return user, nil
+}
+
+type postgresRoutes struct {
+	db queryer
+}
+
+const routeColumns = `id, user_id, COALESCE(start_address, ''), COALESCE(end_address, ''),
+  start_latitude, start_longitude, end_latitude, end_longitude, active,
+  start_date::text, end_date::text, COALESCE(time_zone, '')`
+
+func scanRoute(row interface{ Scan(...any) error }) (domain.Route, error) {
+	var route domain.Route
+	err := row.Scan(
+		&route.ID,
+		&route.UserID,
+		&route.StartAddress,
+		&route.EndAddress,
+		&route.StartLatitude,
+		&route.StartLongitude,
+		&route.EndLatitude,
+		&route.EndLongitude,
+		&route.Active,
+		&route.StartDate,
+		&route.EndDate,
+		&route.TimeZone)
+	return route, err
+}
+
+func (r *postgresRoutes) Get(ctx context.Context, id int) (domain.Route, error) {
+	route, err := scanRoute(r.db.QueryRowContext(ctx,
+		"SELECT "+routeColumns+" FROM public.routes WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Route{}, fmt.Errorf("route %d: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return domain.Route{}, fmt.Errorf("failed to query route %d: %w", id, err)
+	}
+	return route, nil
+}
+
+func (r *postgresRoutes) Create(ctx context.Context, route domain.Route) (domain.Route, error) {
+	created, err := scanRoute(r.db.QueryRowContext(ctx, `INSERT INTO public.routes (
+  user_id, start_address, end_address, start_latitude, start_longitude,
+  end_latitude, end_longitude, active, start_date, end_date, time_zone
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+RETURNING `+routeColumns,
+		route.UserID,
+		route.StartAddress,
+		route.EndAddress,
+		route.StartLatitude,
+		route.StartLongitude,
+		route.EndLatitude,
+		route.EndLongitude,
+		route.Active,
+		route.StartDate,
+		route.EndDate,
+		route.TimeZone))
+	if err != nil {
+		return domain.Route{}, fmt.Errorf("failed to insert route: %w", err)
+	}
+	return created, nil
+}
+
+type postgresWaypoints struct {
+	db queryer
+}
+
+func (r *postgresWaypoints) List(ctx context.Context, routeID int) ([]domain.Waypoint, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, route, position, address, latitude, longitude
+FROM public.route_waypoints WHERE route = $1 ORDER BY position`, routeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query waypoints of route %d: %w", routeID, err)
+	}
+	return scanWaypoints(rows)
+}
+
+func (r *postgresWaypoints) Create(ctx context.Context, routeID int, waypoints []domain.Waypoint) ([]domain.Waypoint, error) {
+	addresses := make([]string, len(waypoints))
+	latitudes := make([]string, len(waypoints))
+	longitudes := make([]string, len(waypoints))
+	for i, waypoint := range waypoints {
+		addresses[i], latitudes[i], longitudes[i] = waypoint.Address, waypoint.Latitude, waypoint.Longitude
+	}
+	// One statement, so a route never ends up with only some of its stops.
+	rows, err := r.db.QueryContext(ctx, `WITH inserted AS (
+  INSERT INTO public.route_waypoints (route, position, address, latitude, longitude)
+  SELECT $1, stop.position, stop.address, stop.latitude, stop.longitude
+  FROM unnest($2::text[], $3::text[], $4::text[]) WITH ORDINALITY AS stop(address, latitude, longitude, position)
+  RETURNING id, route, position, address, latitude, longitude
+)
+SELECT id, route, position, address, latitude, longitude FROM inserted ORDER BY position`,
+		routeID, pq.Array(addresses), pq.Array(latitudes), pq.Array(longitudes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert waypoints of route %d: %w", routeID, err)
+	}
+	return scanWaypoints(rows)
+}
+
+func scanWaypoints(rows *sql.Rows) ([]domain.Waypoint, error) {
+	defer rows.Close()
+	waypoints := []domain.Waypoint{}
+	for rows.Next() {
+		var waypoint domain.Waypoint
+		if err := rows.Scan(
+			&waypoint.ID,
+			&waypoint.RouteID,
+			&waypoint.Position,
+			&waypoint.Address,
+			&waypoint.Latitude,
+			&waypoint.Longitude); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		waypoints = append(waypoints, waypoint)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return waypoints, nil
+}
+
+type postgresSchedules struct {
+	db queryer
+}
+
+func (r *postgresSchedules) GetByRoute(ctx context.Context, routeID int) (domain.Schedule, error) {
+	var schedule domain.Schedule
+	err := r.db.QueryRowContext(ctx, `SELECT id, route_id,
+  morning_start_time::text, morning_end_time::text,
+  afternoon_start_time::text, afternoon_end_time::text,
+  COALESCE(morning_arrive_by::text, ''), COALESCE(afternoon_arrive_by::text, ''),
+  monday, tuesday, wednesday, thursday, friday, saturday, sunday
+FROM public.route_schedule WHERE route_id = $1 ORDER BY id LIMIT 1`, routeID).Scan(
+		&schedule.ID,
+		&schedule.RouteID,
+		&schedule.MorningStartTime,
+		&schedule.MorningEndTime,
+		&schedule.AfternoonStartTime,
+		&schedule.AfternoonEndTime,
+		&schedule.MorningArriveBy,
+		&schedule.AfternoonArriveBy,
+		&schedule.Monday,
+		&schedule.Tuesday,
+		&schedule.Wednesday,
+		&schedule.Thursday,
+		&schedule.Friday,
+		&schedule.Saturday,
+		&schedule.Sunday)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Schedule{}, fmt.Errorf("schedule for route %d: %w", routeID, ErrNotFound)
+	}
+	if err != nil {
+		return domain.Schedule{}, fmt.Errorf("failed to query schedule for route %d: %w", routeID, err)
+	}
+	return schedule, nil
+}
+
+func (r *postgresSchedules) Create(ctx context.Context, schedule domain.Schedule) (domain.Schedule, error) {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO public.route_schedule (
+  route_id, morning_start_time, morning_end_time, afternoon_start_time, afternoon_end_time,
+  monday, tuesday, wednesday, thursday, friday, saturday, sunday,
+  morning_arrive_by, afternoon_arrive_by
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, '')::time, NULLIF($14, '')::time)
+RETURNING id`,
+		schedule.RouteID,
+		schedule.MorningStartTime,
+		schedule.MorningEndTime,
+		schedule.AfternoonStartTime,
+		schedule.AfternoonEndTime,
+		schedule.Monday,
+		schedule.Tuesday,
+		schedule.Wednesday,
+		schedule.Thursday,
+		schedule.Friday,
+		schedule.Saturday,
+		schedule.Sunday,
+		schedule.MorningArriveBy,
+		schedule.AfternoonArriveBy).Scan(&schedule.ID)
+	if err != nil {
+		return domain.Schedule{}, fmt.Errorf("failed to insert into route_schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+func (r *postgresSchedules) Due(ctx context.Context, toWork bool, at time.Time) ([]domain.DueRoute, error) {
+	queryFilePath := fromWorkQueryFilePath
+	if toWork {
+		queryFilePath = toWorkQueryFilePath
+	}
+	query, err := queries.ReadFile(queryFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load query: %w, at filepath: %v", err, queryFilePath)
+	}
+
+	rows, err := r.db.QueryContext(ctx, string(query), at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query data: %w", err)
+	}
+	defer rows.Close()
+
+	var routes []domain.DueRoute
+	for rows.Next() {
+		var active bool
+		var startLatitude, startLongitude, stopLatitude, stopLongitude string
+		route := domain.DueRoute{ToWork: toWork}
+		if err := rows.Scan(
+			&route.ID,
+			&route.UserID,
+			&active,
+			&startLatitude,
+			&startLongitude,
+			&stopLatitude,
+			&stopLongitude,
+			&route.TimeZone,
+			&route.WindowStart,
+			&route.WindowEnd); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		row := domain.Route{
+			StartLatitude:  startLatitude,
+			StartLongitude: startLongitude,
+			EndLatitude:    stopLatitude,
+			EndLongitude:   stopLongitude,
+		}
+		if route.Origin, err = row.Start(); err != nil {
+			return nil, fmt.Errorf("route %d origin: %w", route.ID, err)
+		}
+		if route.Destination, err = row.End(); err != nil {
+			return nil, fmt.Errorf("route %d destination: %w", route.ID, err)
+		}
+		routes = append(routes, route)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return routes, nil
+}
+
+func (r *postgresSchedules) Active(ctx context.Context) ([]domain.ScheduledRoute, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT routes.id, routes.user_id,
+  COALESCE(routes.start_address, ''), COALESCE(routes.end_address, ''),
+  routes.start_latitude, routes.start_longitude, routes.end_latitude, routes.end_longitude,
+  routes.active, routes.start_date::text, routes.end_date::text, COALESCE(routes.time_zone, ''),
+  route_schedule.id,
+  route_schedule.morning_start_time::text, route_schedule.morning_end_time::text,
+  route_schedule.afternoon_start_time::text, route_schedule.afternoon_end_time::text,
+  COALESCE(route_schedule.morning_arrive_by::text, ''), COALESCE(route_schedule.afternoon_arrive_by::text, ''),
+  route_schedule.monday, route_schedule.tuesday, route_schedule.wednesday, route_schedule.thursday,
+  route_schedule.friday, route_schedule.saturday, route_schedule.sunday
+FROM public.routes
+  INNER JOIN public.route_schedule ON routes.id = route_schedule.route_id
+WHERE routes.active = true
+ORDER BY routes.id, route_schedule.id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active routes: %w", err)
+	}
+	defer rows.Close()
+
+	var scheduled []domain.ScheduledRoute
+	for rows.Next() {
+		var route domain.Route
+		var schedule domain.Schedule
+		if err := rows.Scan(
+			&route.ID,
+			&route.UserID,
+			&route.StartAddress,
+			&route.EndAddress,
+			&route.StartLatitude,
+			&route.StartLongitude,
+			&route.EndLatitude,
+			&route.EndLongitude,
+			&route.Active,
+			&route.StartDate,
+			&route.EndDate,
+			&route.TimeZone,
+			&schedule.ID,
+			&schedule.MorningStartTime,
+			&schedule.MorningEndTime,
+			&schedule.AfternoonStartTime,
+			&schedule.AfternoonEndTime,
+			&schedule.MorningArriveBy,
+			&schedule.AfternoonArriveBy,
+			&schedule.Monday,
+			&schedule.Tuesday,
+			&schedule.Wednesday,
+			&schedule.Thursday,
+			&schedule.Friday,
+			&schedule.Saturday,
+			&schedule.Sunday); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		// Like GetByRoute, only the first schedule of a route counts.
+		if len(scheduled) > 0 && scheduled[len(scheduled)-1].Route.ID == route.ID {
+			continue
+		}
+		schedule.RouteID = route.ID
+		scheduled = append(scheduled, domain.ScheduledRoute{Route: route, Schedule: schedule})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return scheduled, nil
+}
+
+type postgresCommutes struct {
+	db *sql.DB
+}
+
+func (r *postgresCommutes) Insert(ctx context.Context, commute domain.Commute) (domain.Commute, error) {
+	var legDurations, legDistances []int64
+	for _, leg := range commute.Legs {
+		legDurations = append(legDurations, int64(leg.Duration))
+		legDistances = append(legDistances, int64(leg.Distance))
+	}
+	err := r.db.QueryRowContext(ctx, `INSERT INTO public.commutes (
+  user_id, query_time, duration, distance, route, route_hash, to_work, day_of_week, ad_hoc, boosted, path_id,
+  leg_durations, leg_distances
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+RETURNING id, adjusted_query_time`,
+		commute.UserID,
+		commute.QueryTime,
+		commute.Duration,
+		commute.Distance,
+		commute.Route,
+		commute.RouteHash,
+		commute.ToWork,
+		commute.DayOfWeek,
+		commute.AdHoc,
+		commute.Boosted,
+		commute.PathID,
+		pq.Array(legDurations),
+		pq.Array(legDistances)).Scan(&commute.ID, &commute.AdjustedQueryTime)
+	if err != nil {
+		return domain.Commute{}, fmt.Errorf("failed to insert data: %w", err)
+	}
+	return commute, nil
+}
+
+func (r *postgresCommutes) LastRecorded(ctx context.Context, routeIDs []int) (map[int]time.Time, error) {
+	ids := make([]int64, len(routeIDs))
+	for i, id := range routeIDs {
+		ids[i] = int64(id)
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT route, MAX(query_time) FROM public.commutes
+WHERE route = ANY($1) AND NOT ad_hoc AND NOT boosted GROUP BY route`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query last commutes: %w", err)
+	}
+	defer rows.Close()
+
+	last := map[int]time.Time{}
+	for rows.Next() {
+		var routeID int
+		var queryTime time.Time
+		if err := rows.Scan(&routeID, &queryTime); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		last[routeID] = queryTime
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return last, nil
+}
+
+func (r *postgresCommutes) DailyCounts(ctx context.Context, routeIDs []int, since time.Time) ([]domain.DailyCount, error) {
+	ids := make([]int64, len(routeIDs))
+	for i, id := range routeIDs {
+		ids[i] = int64(id)
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT commutes.route, commutes.to_work,
+  (commutes.query_time AT TIME ZONE routes.time_zone)::date::text AS local_date, COUNT(*)
+FROM public.commutes
+  INNER JOIN public.routes ON routes.id = commutes.route
+WHERE commutes.route = ANY($1) AND NOT commutes.ad_hoc AND NOT commutes.boosted AND commutes.query_time >= $2::timestamptz
+  AND routes.time_zone IN (SELECT name FROM pg_timezone_names)
+GROUP BY commutes.route, commutes.to_work, local_date
+ORDER BY commutes.route, local_date, commutes.to_work DESC`, pq.Array(ids), since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query daily commute counts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []domain.DailyCount
+	for rows.Next() {
+		var count domain.DailyCount
+		if err := rows.Scan(&count.RouteID, &count.ToWork, &count.Date, &count.Count); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return counts, nil
+}
+
+func (r *postgresCommutes) Recent(ctx context.Context, routeID int, toWork bool, since time.Time) ([]domain.Commute, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, user_id, query_time, duration, distance, route,
+  COALESCE(route_hash, ''), to_work, COALESCE(day_of_week, ''), adjusted_query_time, ad_hoc, boosted, anomaly_score,
+  path_id, leg_durations, leg_distances
+FROM public.commutes
+WHERE route = $1 AND to_work = $2 AND NOT ad_hoc AND NOT boosted AND query_time >= $3::timestamptz
+ORDER BY query_time, id`, routeID, toWork, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recent commutes: %w", err)
+	}
+	defer rows.Close()
+
+	var commutes []domain.Commute
+	for rows.Next() {
+		var commute domain.Commute
+		var legDurations, legDistances []int64
+		if err := rows.Scan(
+			&commute.ID,
+			&commute.UserID,
+			&commute.QueryTime,
+			&commute.Duration,
+			&commute.Distance,
+			&commute.Route,
+			&commute.RouteHash,
+			&commute.ToWork,
+			&commute.DayOfWeek,
+			&commute.AdjustedQueryTime,
+			&commute.AdHoc,
+			&commute.Boosted,
+			&commute.AnomalyScore,
+			&commute.PathID,
+			pq.Array(&legDurations),
+			pq.Array(&legDistances)); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		for i := range min(len(legDurations), len(legDistances)) {
+			commute.Legs = append(commute.Legs, domain.Leg{Duration: int(legDurations[i]), Distance: int(legDistances[i])})
+		}
+		commutes = append(commutes, commute)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return commutes, nil
+}
+
+func (r *postgresCommutes) SetAnomalyScore(ctx context.Context, id int, score float64) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE public.commutes SET anomaly_score = $2 WHERE id = $1", id, score)
+	if err != nil {
+		return fmt.Errorf("failed to update commute %d: %w", id, err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return fmt.Errorf("commute %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *postgresCommutes) SetPath(ctx context.Context, id int, pathID int) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE public.commutes SET path_id = $2 WHERE id = $1", id, pathID)
+	if err != nil {
+		return fmt.Errorf("failed to update commute %d: %w", id, err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return fmt.Errorf("commute %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+type postgresUsage struct {
+	db *sql.DB
+}
+
+func (r *postgresUsage) Record(ctx context.Context, provider string, at time.Time, usage domain.Usage) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO public.provider_usage (provider, bucket, requests, failures, units)
+VALUES ($1, date_trunc('minute', $2::timestamptz), $3, $4, $5)
+ON CONFLICT (provider, bucket) DO UPDATE SET
+  requests = provider_usage.requests + EXCLUDED.requests,
+  failures = provider_usage.failures + EXCLUDED.failures,
+  units = provider_usage.units + EXCLUDED.units`,
+		provider, at, usage.Requests, usage.Failures, usage.Units)
+	if err != nil {
+		return fmt.Errorf("failed to record %s usage: %w", provider, err)
+	}
+	return nil
+}
+
+func (r *postgresUsage) Since(ctx context.Context, provider string, since time.Time) (domain.Usage, error) {
+	var usage domain.Usage
+	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(requests), 0), COALESCE(SUM(failures), 0), COALESCE(SUM(units), 0)
+FROM public.provider_usage
+WHERE provider = $1 AND bucket >= date_trunc('minute', $2::timestamptz)`, provider, since).
+		Scan(&usage.Requests, &usage.Failures, &usage.Units)
+	if err != nil {
+		return domain.Usage{}, fmt.Errorf("failed to query %s usage: %w", provider, err)
+	}
+	return usage, nil
+}
+
+func (r *postgresUsage) Budget(ctx context.Context, provider string) (domain.Budget, error) {
+	budget := domain.Budget{Provider: provider}
+	var daily, monthly sql.NullInt64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT daily_limit, monthly_limit FROM public.provider_budgets WHERE provider = $1", provider).
+		Scan(&daily, &monthly)
+	if errors.Is(err, sql.ErrNoRows) {
+		return budget, nil
+	}
+	if err != nil {
+		return domain.Budget{}, fmt.Errorf("failed to query %s budget: %w", provider, err)
+	}
+	if daily.Valid {
+		limit := int(daily.Int64)
+		budget.DailyLimit = &limit
+	}
+	if monthly.Valid {
+		limit := int(monthly.Int64)
+		budget.MonthlyLimit = &limit
+	}
+	return budget, nil
+}
+
+func (r *postgresUsage) CircuitOpenedAt(ctx context.Context, provider string) (*time.Time, error) {
+	var openedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx,
+		"SELECT opened_at FROM public.provider_circuits WHERE provider = $1", provider).
+		Scan(&openedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s circuit: %w", provider, err)
+	}
+	if !openedAt.Valid {
+		return nil, nil
+	}
+	return &openedAt.Time, nil
+}
+
+func (r *postgresUsage) SetCircuitOpenedAt(ctx context.Context, provider string, openedAt *time.Time) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO public.provider_circuits (provider, opened_at)
+VALUES ($1, $2)
+ON CONFLICT (provider) DO UPDATE SET opened_at = EXCLUDED.opened_at`, provider, openedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update %s circuit: %w", provider, err)
+	}
+	return nil
+}
+
+type postgresNotifications struct {
+	db *sql.DB
+}
+
+func (r *postgresNotifications) Subscriptions(ctx context.Context, userID int) ([]domain.Subscription, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, user_id, channel, target, active
+FROM public.notification_subscriptions WHERE user_id = $1 AND active ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions for user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var subscriptions []domain.Subscription
+	for rows.Next() {
+		var subscription domain.Subscription
+		if err := rows.Scan(
+			&subscription.ID,
+			&subscription.UserID,
+			&subscription.Channel,
+			&subscription.Target,
+			&subscription.Active); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return subscriptions, nil
+}
+
+func (r *postgresNotifications) Last(ctx context.Context, routeID int, toWork bool, since time.Time) (*domain.Notification, error) {
+	var notification domain.Notification
+	err := r.db.QueryRowContext(ctx, `SELECT id, user_id, route, to_work, decision, message, sent_at
+FROM public.notifications
+WHERE route = $1 AND to_work = $2 AND sent_at >= $3::timestamptz
+ORDER BY sent_at DESC, id DESC LIMIT 1`, routeID, toWork, since).Scan(
+		&notification.ID,
+		&notification.UserID,
+		&notification.Route,
+		&notification.ToWork,
+		&notification.Decision,
+		&notification.Message,
+		&notification.SentAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query last notification for route %d: %w", routeID, err)
+	}
+	return &notification, nil
+}
+
+func (r *postgresNotifications) Insert(ctx context.Context, notification domain.Notification) (domain.Notification, error) {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO public.notifications (
+  user_id, route, to_work, decision, message, sent_at
+) VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id`,
+		notification.UserID,
+		notification.Route,
+		notification.ToWork,
+		notification.Decision,
+		notification.Message,
+		notification.SentAt).Scan(&notification.ID)
+	if err != nil {
+		return domain.Notification{}, fmt.Errorf("failed to insert notification: %w", err)
+	}
+	return notification, nil
+}
+
+type postgresBoosts struct {
+	db *sql.DB
+}
+
+func (r *postgresBoosts) Extend(ctx context.Context, boost domain.Boost, at time.Time) (bool, error) {
+	// The CTE reads the row as it was before the upsert.
+	var previous *time.Time
+	err := r.db.QueryRowContext(ctx, `WITH previous AS (
+  SELECT until FROM public.sampling_boosts WHERE route = $1 AND to_work = $2
+)
+INSERT INTO public.sampling_boosts (route, to_work, until, reason) VALUES ($1, $2, $3, $4)
+ON CONFLICT (route, to_work) DO UPDATE
+  SET until = GREATEST(sampling_boosts.until, EXCLUDED.until), reason = EXCLUDED.reason
+RETURNING (SELECT until FROM previous)`,
+		boost.RouteID,
+		boost.ToWork,
+		boost.Until,
+		boost.Reason).Scan(&previous)
+	if err != nil {
+		return false, fmt.Errorf("failed to boost route %d: %w", boost.RouteID, err)
+	}
+	return previous == nil || !previous.After(at), nil
+}
+
+func (r *postgresBoosts) Active(ctx context.Context, at time.Time) ([]domain.DueRoute, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT routes.id, routes.user_id,
+  routes.start_latitude, routes.start_longitude, routes.end_latitude, routes.end_longitude,
+  COALESCE(routes.time_zone, ''), sampling_boosts.to_work, sampling_boosts.until
+FROM public.sampling_boosts
+  INNER JOIN public.routes ON routes.id = sampling_boosts.route
+WHERE routes.active = true AND sampling_boosts.until > $1::timestamptz
+ORDER BY routes.id, sampling_boosts.to_work DESC`, at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query boosted routes: %w", err)
+	}
+	defer rows.Close()
+
+	var routes []domain.DueRoute
+	for rows.Next() {
+		var row domain.Route
+		var toWork bool
+		var until time.Time
+		if err := rows.Scan(
+			&row.ID,
+			&row.UserID,
+			&row.StartLatitude,
+			&row.StartLongitude,
+			&row.EndLatitude,
+			&row.EndLongitude,
+			&row.TimeZone,
+			&toWork,
+			&until); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		route, err := row.Direction(toWork)
+		if err != nil {
+			return nil, err
+		}
+		route.BoostedUntil = &until
+		routes = append(routes, route)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return routes, nil
+}
+
+type postgresPaths struct {
+	db *sql.DB
+}
+
+func (r *postgresPaths) Record(ctx context.Context, path domain.RoutePath) (domain.RoutePath, bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.RoutePath{}, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Labels are numbered from the paths already recorded, so concurrent
+	// records for the same route and direction take turns.
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, $2::boolean::integer)", path.RouteID, path.ToWork); err != nil {
+		return domain.RoutePath{}, false, fmt.Errorf("failed to lock paths of route %d: %w", path.RouteID, err)
+	}
+	// xmax is only zero on a row the statement inserted.
+	var added bool
+	err = tx.QueryRowContext(ctx, `INSERT INTO public.route_paths (
+  route, to_work, fingerprint, label, polyline, distance, first_seen, last_seen
+)
+SELECT $1, $2, $3, 'path ' || (COUNT(*) + 1), $4, $5, $6, $7
+FROM public.route_paths WHERE route = $1 AND to_work = $2
+ON CONFLICT (route, to_work, fingerprint) DO UPDATE
+  SET first_seen = LEAST(route_paths.first_seen, EXCLUDED.first_seen),
+    last_seen = GREATEST(route_paths.last_seen, EXCLUDED.last_seen)
+RETURNING id, label, polyline, distance, first_seen, last_seen, xmax = 0`,
+		path.RouteID,
+		path.ToWork,
+		path.Fingerprint,
+		path.Polyline,
+		path.Distance,
+		path.FirstSeen,
+		path.LastSeen).Scan(&path.ID, &path.Label, &path.Polyline, &path.Distance, &path.FirstSeen, &path.LastSeen, &added)
+	if err != nil {
+		return domain.RoutePath{}, false, fmt.Errorf("failed to record path of route %d: %w", path.RouteID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.RoutePath{}, false, fmt.Errorf("failed to commit path of route %d: %w", path.RouteID, err)
+	}
+	return path, added, nil
+}
+
+func (r *postgresPaths) Find(ctx context.Context, routeID int, toWork bool, fingerprint string) (*domain.RoutePath, error) {
+	var path domain.RoutePath
+	err := r.db.QueryRowContext(ctx, `SELECT id, route, to_work, fingerprint, label, polyline, distance, first_seen, last_seen
+FROM public.route_paths
+WHERE route = $1 AND to_work = $2 AND fingerprint = $3`, routeID, toWork, fingerprint).Scan(
+		&path.ID,
+		&path.RouteID,
+		&path.ToWork,
+		&path.Fingerprint,
+		&path.Label,
+		&path.Polyline,
+		&path.Distance,
+		&path.FirstSeen,
+		&path.LastSeen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query path of route %d: %w", routeID, err)
+	}
+	return &path, nil
+}
+
+func (r *postgresPaths) Last(ctx context.Context, routeID int, toWork bool) (*domain.RoutePath, error) {
+	var path domain.RoutePath
+	err := r.db.QueryRowContext(ctx, `SELECT route_paths.id, route_paths.route, route_paths.to_work,
+  route_paths.fingerprint, route_paths.label, route_paths.polyline, route_paths.distance,
+  route_paths.first_seen, route_paths.last_seen
+FROM public.commutes
+  INNER JOIN public.route_paths ON route_paths.id = commutes.path_id
+WHERE commutes.route = $1 AND commutes.to_work = $2 AND NOT commutes.ad_hoc AND NOT commutes.boosted
+ORDER BY commutes.query_time DESC, commutes.id DESC
+LIMIT 1`, routeID, toWork).Scan(
+		&path.ID,
+		&path.RouteID,
+		&path.ToWork,
+		&path.Fingerprint,
+		&path.Label,
+		&path.Polyline,
+		&path.Distance,
+		&path.FirstSeen,
+		&path.LastSeen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query last path of route %d: %w", routeID, err)
+	}
+	return &path, nil
+}
+
+func (r *postgresPaths) Stats(ctx context.Context, routeID int, toWork bool, since time.Time) ([]domain.PathStats, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT route_paths.id, route_paths.route, route_paths.to_work,
+  route_paths.fingerprint, route_paths.label, route_paths.polyline, route_paths.distance,
+  route_paths.first_seen, route_paths.last_seen, COUNT(commutes.id),
+  COALESCE(ROUND(AVG(commutes.duration))::integer, 0),
+  COALESCE(MIN(commutes.duration), 0), COALESCE(MAX(commutes.duration), 0)
+FROM public.route_paths
+  LEFT JOIN public.commutes ON commutes.path_id = route_paths.id
+    AND NOT commutes.ad_hoc AND NOT commutes.boosted AND commutes.query_time >= $3::timestamptz
+WHERE route_paths.route = $1 AND route_paths.to_work = $2
+GROUP BY route_paths.id
+ORDER BY route_paths.first_seen, route_paths.id`, routeID, toWork, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query paths of route %d: %w", routeID, err)
+	}
+	defer rows.Close()
+
+	var stats []domain.PathStats
+	for rows.Next() {
+		var path domain.PathStats
+		if err := rows.Scan(
+			&path.ID,
+			&path.RouteID,
+			&path.ToWork,
+			&path.Fingerprint,
+			&path.Label,
+			&path.Polyline,
+			&path.Distance,
+			&path.FirstSeen,
+			&path.LastSeen,
+			&path.Commutes,
+			&path.AverageDuration,
+			&path.MinDuration,
+			&path.MaxDuration); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		stats = append(stats, path)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return stats, nil
+}
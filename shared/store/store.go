@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/database"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/migrations"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type UserRepository interface {
+	Get(ctx context.Context, id int) (domain.User, error)
+}
+
+type RouteRepository interface {
+	Get(ctx context.Context, id int) (domain.Route, error)
+	Create(ctx context.Context, route domain.Route) (domain.Route, error)
+}
+
+type WaypointRepository interface {
+	// List returns the route's waypoints by position, empty when it has none.
+	List(ctx context.Context, routeID int) ([]domain.Waypoint, error)
+	// Create saves the route's waypoints at positions 1, 2 and so on, in the
+	// order given.
+	Create(ctx context.Context, routeID int, waypoints []domain.Waypoint) ([]domain.Waypoint, error)
+}
+
+type ScheduleRepository interface {
+	GetByRoute(ctx context.Context, routeID int) (domain.Schedule, error)
+	Create(ctx context.Context, schedule domain.Schedule) (domain.Schedule, error)
+	// Due returns the active routes whose morning (toWork) or afternoon
+	// window is open at the given time.
+	Due(ctx context.Context, toWork bool, at time.Time) ([]domain.DueRoute, error)
+	// Active returns every active route that has a schedule.
+	Active(ctx context.Context) ([]domain.ScheduledRoute, error)
+}
+
+type CommuteRepository interface {
+	Insert(ctx context.Context, commute domain.Commute) (domain.Commute, error)
+	// LastRecorded returns the latest scheduled (not ad hoc or boosted)
+	// query_time of each route. Routes that have never recorded a commute are
+	// left out.
+	LastRecorded(ctx context.Context, routeIDs []int) (map[int]time.Time, error)
+	// DailyCounts counts the scheduled commutes of each route by direction
+	// and local date from since onwards. Days without commutes, and routes
+	// without a valid time zone, are left out.
+	DailyCounts(ctx context.Context, routeIDs []int, since time.Time) ([]domain.DailyCount, error)
+	// Recent returns the scheduled commutes of one route and direction from
+	// since onwards, oldest first.
+	Recent(ctx context.Context, routeID int, toWork bool, since time.Time) ([]domain.Commute, error)
+	SetAnomalyScore(ctx context.Context, id int, score float64) error
+	// SetPath links a commute to the route_paths row of the path it took.
+	SetPath(ctx context.Context, id int, pathID int) error
+}
+
+type PathRepository interface {
+	// Record returns the route's path in the direction with the same
+	// fingerprint, widening its first and last seen to cover path's, or adds
+	// path under the next label. It reports whether the path was added.
+	Record(ctx context.Context, path domain.RoutePath) (domain.RoutePath, bool, error)
+	// Find returns the route's path in the direction with the fingerprint, or
+	// nil if it has not been recorded.
+	Find(ctx context.Context, routeID int, toWork bool, fingerprint string) (*domain.RoutePath, error)
+	// Last returns the path of the route's latest scheduled commute in the
+	// direction that has one, or nil if there is none.
+	Last(ctx context.Context, routeID int, toWork bool) (*domain.RoutePath, error)
+	// Stats returns every path of the route and direction, first seen first,
+	// with the scheduled commutes from since onwards that took it.
+	Stats(ctx context.Context, routeID int, toWork bool, since time.Time) ([]domain.PathStats, error)
+}
+
+type BoostRepository interface {
+	// Extend boosts the route and direction until boost.Until, or keeps a
+	// later boost. It reports whether no boost was in force at at, so a
+	// caller can react once per incident.
+	Extend(ctx context.Context, boost domain.Boost, at time.Time) (bool, error)
+	// Active returns the active routes boosted at at.
+	Active(ctx context.Context, at time.Time) ([]domain.DueRoute, error)
+}
+
+type NotificationRepository interface {
+	// Subscriptions returns the user's active subscriptions.
+	Subscriptions(ctx context.Context, userID int) ([]domain.Subscription, error)
+	// Last returns the latest notification for the route and direction sent
+	// from since onwards, or nil if there is none.
+	Last(ctx context.Context, routeID int, toWork bool, since time.Time) (*domain.Notification, error)
+	Insert(ctx context.Context, notification domain.Notification) (domain.Notification, error)
+}
+
+// UsageRepository tracks requests made to paid providers such as the Google
+// Routes API, along with each provider's budget and circuit breaker state.
+type UsageRepository interface {
+	// Record adds usage to the minute bucket containing at.
+	Record(ctx context.Context, provider string, at time.Time, usage domain.Usage) error
+	// Since sums usage from the bucket containing since onwards.
+	Since(ctx context.Context, provider string, since time.Time) (domain.Usage, error)
+	// Budget returns the provider's limits. A provider without a row is
+	// unlimited.
+	Budget(ctx context.Context, provider string) (domain.Budget, error)
+	// CircuitOpenedAt returns when the provider's breaker was opened, or nil
+	// if it is closed.
+	CircuitOpenedAt(ctx context.Context, provider string) (*time.Time, error)
+	SetCircuitOpenedAt(ctx context.Context, provider string, openedAt *time.Time) error
+}
+
+// Store groups the repositories a Lambda needs. Use Open, NewPostgres or
+// NewMemory to build one.
+type Store struct {
+	Users         UserRepository
+	Routes        RouteRepository
+	Waypoints     WaypointRepository
+	Schedules     ScheduleRepository
+	Commutes      CommuteRepository
+	Usage         UsageRepository
+	Notifications NotificationRepository
+	Boosts        BoostRepository
+	Paths         PathRepository
+
+	schemaVersion func(ctx context.Context) (int, error)
+	// transact runs fn with a Store whose Routes, Waypoints and Schedules
+	// write in one transaction, committed when fn succeeds.
+	transact func(ctx context.Context, fn func(tx *Store) error) error
+	close    func() error
+}
+
+// Open connects to Postgres and checks the schema version. Lambdas call it
+// once per container from main and share the Store across invocations.
+func Open(ctx context.Context, config database.Config) (*Store, error) {
+	db, err := database.Open(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	s := NewPostgres(db)
+	if err := s.CheckSchema(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("schema check failed: %w", err)
+	}
+	return s, nil
+}
+
+// CheckSchema asserts that the backing database is at the schema version
+// this build expects.
+func (s *Store) CheckSchema(ctx context.Context) error {
+	version, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	return migrations.CheckVersion(version)
+}
+
+// Direction returns the route as driven in a direction, stopping at its
+// waypoints.
+func (s *Store) Direction(ctx context.Context, route domain.Route, toWork bool) (domain.DueRoute, error) {
+	trip, err := route.Direction(toWork)
+	if err != nil {
+		return domain.DueRoute{}, err
+	}
+	waypoints, err := s.Waypoints.List(ctx, route.ID)
+	if err != nil {
+		return domain.DueRoute{}, fmt.Errorf("error loading waypoints of route %d: %w", route.ID, err)
+	}
+	return trip.Via(waypoints)
+}
+
+// CreateRoute saves a new route with its waypoints, if any, and its
+// schedule, all or none of them.
+func (s *Store) CreateRoute(ctx context.Context, route domain.Route, waypoints []domain.Waypoint, schedule domain.Schedule) (domain.Route, []domain.Waypoint, domain.Schedule, error) {
+	err := s.transact(ctx, func(tx *Store) error {
+		var err error
+		if route, err = tx.Routes.Create(ctx, route); err != nil {
+			return fmt.Errorf("failed to insert route: %w", err)
+		}
+		if len(waypoints) > 0 {
+			if waypoints, err = tx.Waypoints.Create(ctx, route.ID, waypoints); err != nil {
+				return fmt.Errorf("failed to insert into route_waypoints: %w", err)
+			}
+		}
+		schedule.RouteID = route.ID
+		if schedule, err = tx.Schedules.Create(ctx, schedule); err != nil {
+			return fmt.Errorf("failed to insert into routes_schedule: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.Route{}, nil, domain.Schedule{}, err
+	}
+	return route, waypoints, schedule, nil
+}
+
+func (s *Store) Close() error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close()
+}
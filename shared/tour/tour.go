@@ -0,0 +1,214 @@
+// Package tour orders the stops of a trip so the drive from the origin
+// through every stop to the destination costs the least. Up to ExactLimit
+// stops are ordered exactly with the Held-Karp algorithm; longer trips start
+// from the nearest neighbour order and are improved with 2-opt and
+// relocation moves until neither helps.
+package tour
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExactLimit is the most stops Solve orders exactly.
+const ExactLimit = 10
+
+// unreachable is added for every missing route, so an order that needs one
+// always costs more than any that does not.
+const unreachable = 1 << 40
+
+var ErrNoRoute = errors.New("no order reaches every stop")
+
+// Costs[i][j] is the cost, such as seconds, of driving from point i to point
+// j. Point 0 is the origin, points 1 to n the stops and n+1 the destination.
+// A negative cost means there is no route.
+type Costs [][]int
+
+// Solution lists the stops in driving order by their index among the stops,
+// 0 being point 1.
+type Solution struct {
+	Order []int
+	Cost  int
+	Exact bool
+}
+
+// Stops is the number of stops between the origin and destination.
+func (c Costs) Stops() int {
+	return len(c) - 2
+}
+
+func (c Costs) validate() error {
+	if len(c) < 2 {
+		return fmt.Errorf("need an origin and a destination, got %d points", len(c))
+	}
+	for i, row := range c {
+		if len(row) != len(c) {
+			return fmt.Errorf("row %d has %d costs, want %d", i, len(row), len(c))
+		}
+	}
+	return nil
+}
+
+// edge is the cost from point i to point j.
+func (c Costs) edge(i, j int) int {
+	if c[i][j] < 0 {
+		return unreachable
+	}
+	return c[i][j]
+}
+
+// total is the cost of visiting the stops in order, with unreachable for
+// every missing route.
+func (c Costs) total(order []int) int {
+	cost, at := 0, 0
+	for _, stop := range order {
+		cost += c.edge(at, stop+1)
+		at = stop + 1
+	}
+	return cost + c.edge(at, len(c)-1)
+}
+
+// Cost returns the cost of visiting the stops in order, and false when a
+// leg has no route.
+func (c Costs) Cost(order []int) (int, bool) {
+	cost := c.total(order)
+	return cost, cost < unreachable
+}
+
+// Solve finds the cheapest order of the stops.
+func Solve(costs Costs) (Solution, error) {
+	if err := costs.validate(); err != nil {
+		return Solution{}, err
+	}
+	solution := Solution{Exact: costs.Stops() <= ExactLimit}
+	if solution.Exact {
+		solution.Order = exact(costs)
+	} else {
+		solution.Order = improve(costs, nearestNeighbour(costs))
+	}
+	cost, ok := costs.Cost(solution.Order)
+	if !ok {
+		return Solution{}, ErrNoRoute
+	}
+	solution.Cost = cost
+	return solution, nil
+}
+
+// exact is Held-Karp: best[set][last] is the cheapest drive from the origin
+// through the stops in set, ending at last.
+func exact(costs Costs) []int {
+	n := costs.Stops()
+	if n == 0 {
+		return []int{}
+	}
+	full := 1<<n - 1
+	best := make([][]int, full+1)
+	previous := make([][]int, full+1)
+	for set := range best {
+		best[set] = make([]int, n)
+		previous[set] = make([]int, n)
+		for last := range best[set] {
+			best[set][last] = -1
+		}
+	}
+	for stop := 0; stop < n; stop++ {
+		best[1<<stop][stop] = costs.edge(0, stop+1)
+		previous[1<<stop][stop] = -1
+	}
+	for set := 1; set <= full; set++ {
+		for last := 0; last < n; last++ {
+			if best[set][last] < 0 {
+				continue
+			}
+			for next := 0; next < n; next++ {
+				if set&(1<<next) != 0 {
+					continue
+				}
+				extended := set | 1<<next
+				cost := best[set][last] + costs.edge(last+1, next+1)
+				if best[extended][next] < 0 || cost < best[extended][next] {
+					best[extended][next] = cost
+					previous[extended][next] = last
+				}
+			}
+		}
+	}
+
+	last, lowest := 0, -1
+	for stop := 0; stop < n; stop++ {
+		if cost := best[full][stop] + costs.edge(stop+1, n+1); lowest < 0 || cost < lowest {
+			last, lowest = stop, cost
+		}
+	}
+	order := make([]int, n)
+	for i, set := n-1, full; i >= 0; i-- {
+		order[i] = last
+		set, last = set&^(1<<last), previous[set][last]
+	}
+	return order
+}
+
+// nearestNeighbour drives to the closest stop not yet visited each time.
+func nearestNeighbour(costs Costs) []int {
+	n := costs.Stops()
+	visited := make([]bool, n)
+	order := make([]int, 0, n)
+	at := 0
+	for len(order) < n {
+		next := -1
+		for stop := 0; stop < n; stop++ {
+			if !visited[stop] && (next < 0 || costs.edge(at, stop+1) < costs.edge(at, next+1)) {
+				next = stop
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+		at = next + 1
+	}
+	return order
+}
+
+// improve applies the first 2-opt reversal or single stop relocation that
+// lowers the cost until there is none. Costs need not be symmetric, so every
+// candidate is costed in full.
+func improve(costs Costs, order []int) []int {
+	best := costs.total(order)
+	candidate := make([]int, len(order))
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < len(order) && !improved; i++ {
+			for j := i + 1; j < len(order) && !improved; j++ {
+				reverse(candidate, order, i, j)
+				if cost := costs.total(candidate); cost < best {
+					best, improved = cost, true
+					copy(order, candidate)
+					continue
+				}
+				for _, move := range [][2]int{{i, j}, {j, i}} {
+					relocate(candidate, order, move[0], move[1])
+					if cost := costs.total(candidate); cost < best {
+						best, improved = cost, true
+						copy(order, candidate)
+						break
+					}
+				}
+			}
+		}
+	}
+	return order
+}
+
+// reverse copies order into dst with order[i:j+1] reversed.
+func reverse(dst, order []int, i, j int) {
+	copy(dst, order)
+	for ; i < j; i, j = i+1, j-1 {
+		dst[i], dst[j] = dst[j], dst[i]
+	}
+}
+
+// relocate copies order into dst with the stop at from moved to index to.
+func relocate(dst, order []int, from, to int) {
+	stop := order[from]
+	rest := append(append(make([]int, 0, len(order)), order[:from]...), order[from+1:]...)
+	copy(dst, append(append(rest[:to:to], stop), rest[to:]...))
+}
@@ -0,0 +1,120 @@
+package tour
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// plane is the straight line cost between points, the origin first and the
+// destination last.
+func plane(points [][2]float64) Costs {
+	costs := make(Costs, len(points))
+	for i, a := range points {
+		costs[i] = make([]int, len(points))
+		for j, b := range points {
+			costs[i][j] = int(math.Round(math.Hypot(a[0]-b[0], a[1]-b[1])))
+		}
+	}
+	return costs
+}
+
+// bruteForce tries every order.
+func bruteForce(costs Costs) int {
+	order := make([]int, costs.Stops())
+	for i := range order {
+		order[i] = i
+	}
+	best := -1
+	var permute func(k int)
+	permute = func(k int) {
+		if k == len(order) {
+			if cost, ok := costs.Cost(order); ok && (best < 0 || cost < best) {
+				best = cost
+			}
+			return
+		}
+		for i := k; i < len(order); i++ {
+			order[k], order[i] = order[i], order[k]
+			permute(k + 1)
+			order[k], order[i] = order[i], order[k]
+		}
+	}
+	permute(0)
+	return best
+}
+
+func TestSolveExact(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 20; trial++ {
+		n := 2 + trial%6
+		costs := make(Costs, n+2)
+		for i := range costs {
+			costs[i] = make([]int, n+2)
+			for j := range costs[i] {
+				// Asymmetric, as drives are.
+				costs[i][j] = 60 + random.Intn(1200)
+			}
+		}
+		solution, err := Solve(costs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := bruteForce(costs); !solution.Exact || solution.Cost != want {
+			t.Errorf("trial %d: cost %d, want %d", trial, solution.Cost, want)
+		}
+		if cost, _ := costs.Cost(solution.Order); cost != solution.Cost {
+			t.Errorf("trial %d: order %v costs %d, not %d", trial, solution.Order, cost, solution.Cost)
+		}
+	}
+}
+
+func TestSolveHeuristic(t *testing.T) {
+	// Stops along a line between the origin and the destination, shuffled.
+	random := rand.New(rand.NewSource(2))
+	positions := random.Perm(18)
+	points := [][2]float64{{-1000, 0}}
+	for _, x := range positions {
+		points = append(points, [2]float64{float64(x) * 1000, float64(x%3) * 10})
+	}
+	points = append(points, [2]float64{18000, 0})
+
+	solution, err := Solve(plane(points))
+	if err != nil {
+		t.Fatal(err)
+	}
+	visited := make([]int, len(solution.Order))
+	for i, stop := range solution.Order {
+		visited[i] = positions[stop]
+	}
+	if solution.Exact || !slices.IsSorted(visited) {
+		t.Errorf("visited %v", visited)
+	}
+}
+
+func TestSolveUnreachable(t *testing.T) {
+	costs := plane([][2]float64{{0, 0}, {10, 0}, {5, 0}, {20, 0}})
+	// Stop 1 has no route to the destination, so it must come first.
+	costs[2][3] = -1
+	solution, err := Solve(costs)
+	if err != nil || !slices.Equal(solution.Order, []int{1, 0}) || solution.Cost != 20 {
+		t.Errorf("got %+v, %v", solution, err)
+	}
+
+	costs[2][1] = -1
+	if _, err := Solve(costs); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("got %v, want ErrNoRoute", err)
+	}
+}
+
+func TestSolveNoStops(t *testing.T) {
+	solution, err := Solve(plane([][2]float64{{0, 0}, {30, 40}}))
+	if err != nil || len(solution.Order) != 0 || solution.Cost != 50 {
+		t.Errorf("got %+v, %v", solution, err)
+	}
+	if _, err := Solve(Costs{{0}}); err == nil {
+		t.Error("expected an error without a destination")
+	}
+}
@@ -0,0 +1,173 @@
+// Package whatif compares the commutes between candidate homes and
+// workplaces, sampled across a route's schedule windows, without saving any
+// of them as routes.
+package whatif
+
+import (
+	"fmt"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"sort"
+	"time"
+)
+
+// Drive is one sampled drive. Duration is in seconds and Distance in meters.
+type Drive struct {
+	Duration int
+	Distance int
+}
+
+// Matrix[i][j] is the drive from the i-th origin to the j-th destination at
+// one departure, nil when there is no route.
+type Matrix [][]*Drive
+
+// Summary describes the sampled drives of one pair in one direction. Average
+// and Distance are means; everything is zero without samples.
+type Summary struct {
+	Samples  int `json:"samples"`
+	Average  int `json:"average"`
+	Best     int `json:"best"`
+	Worst    int `json:"worst"`
+	Distance int `json:"distance"`
+}
+
+// Pair is the commute between Origin and Destination, indexes into the
+// candidate homes and workplaces. Daily is a round trip at the average
+// durations and Weekly that times the schedule's days. Change is Daily
+// against the first pair, the current home and work, nil for the current
+// pair itself or when either has a direction without samples.
+type Pair struct {
+	Origin      int     `json:"origin"`
+	Destination int     `json:"destination"`
+	ToWork      Summary `json:"to_work"`
+	FromWork    Summary `json:"from_work"`
+	Daily       int     `json:"daily"`
+	Weekly      int     `json:"weekly"`
+	Change      *int    `json:"change"`
+}
+
+// Complete reports whether both directions were sampled.
+func (p Pair) Complete() bool {
+	return p.ToWork.Samples > 0 && p.FromWork.Samples > 0
+}
+
+// Days is the number of weekdays the schedule commutes on.
+func Days(schedule domain.Schedule) int {
+	days := 0
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if schedule.ActiveOn(day) {
+			days++
+		}
+	}
+	return days
+}
+
+// Departures spreads samples departures evenly over each of the schedule's
+// windows on the next day it commutes whose morning window has not started
+// by now, in loc. A single sample is taken mid-window.
+func Departures(schedule domain.Schedule, loc *time.Location, now time.Time, samples int) ([]time.Time, []time.Time, error) {
+	if samples < 1 {
+		return nil, nil, fmt.Errorf("need at least one sample, got %d", samples)
+	}
+	windows := make([][2]time.Time, 2)
+	for i, bounds := range [][2]string{
+		{schedule.MorningStartTime, schedule.MorningEndTime},
+		{schedule.AfternoonStartTime, schedule.AfternoonEndTime},
+	} {
+		for j, value := range bounds {
+			t, err := time.Parse(time.TimeOnly, value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid schedule time %q: %v", value, err)
+			}
+			windows[i][j] = t
+		}
+		if windows[i][1].Before(windows[i][0]) {
+			return nil, nil, fmt.Errorf("schedule window %s-%s ends before it starts", bounds[0], bounds[1])
+		}
+	}
+
+	local := now.In(loc)
+	for offset := 0; offset <= 7; offset++ {
+		day := local.AddDate(0, 0, offset)
+		at := func(clock time.Time) time.Time {
+			return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, loc)
+		}
+		start := at(windows[0][0])
+		if !start.After(now) || !schedule.ActiveOn(start.Weekday()) {
+			continue
+		}
+		spread := func(window [2]time.Time) []time.Time {
+			from, to := at(window[0]), at(window[1])
+			if samples == 1 {
+				return []time.Time{from.Add(to.Sub(from) / 2)}
+			}
+			departures := make([]time.Time, samples)
+			for i := range departures {
+				departures[i] = from.Add(to.Sub(from) * time.Duration(i) / time.Duration(samples-1))
+			}
+			return departures
+		}
+		return spread(windows[0]), spread(windows[1]), nil
+	}
+	return nil, nil, fmt.Errorf("schedule has no commuting days")
+}
+
+// Compare summarises every pair of origins and destinations. toWork holds
+// matrices from origins to destinations and fromWork from destinations back
+// to origins. Complete pairs come first, fastest round trip first, then by
+// origin and destination.
+func Compare(origins, destinations int, toWork, fromWork []Matrix, days int) []Pair {
+	pairs := make([]Pair, 0, origins*destinations)
+	for i := 0; i < origins; i++ {
+		for j := 0; j < destinations; j++ {
+			pair := Pair{
+				Origin:      i,
+				Destination: j,
+				ToWork:      summarise(toWork, i, j),
+				FromWork:    summarise(fromWork, j, i),
+			}
+			if pair.Complete() {
+				pair.Daily = pair.ToWork.Average + pair.FromWork.Average
+				pair.Weekly = pair.Daily * days
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(pairs) > 0 && pairs[0].Complete() {
+		for i := 1; i < len(pairs); i++ {
+			if pairs[i].Complete() {
+				change := pairs[i].Daily - pairs[0].Daily
+				pairs[i].Change = &change
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(a, b int) bool {
+		if pairs[a].Complete() != pairs[b].Complete() {
+			return pairs[a].Complete()
+		}
+		return pairs[a].Complete() && pairs[a].Daily < pairs[b].Daily
+	})
+	return pairs
+}
+
+// summarise collects the drive from i to j in each matrix.
+func summarise(matrices []Matrix, i, j int) Summary {
+	var summary Summary
+	for _, matrix := range matrices {
+		if i >= len(matrix) || j >= len(matrix[i]) || matrix[i][j] == nil {
+			continue
+		}
+		drive := matrix[i][j]
+		if summary.Samples == 0 || drive.Duration < summary.Best {
+			summary.Best = drive.Duration
+		}
+		summary.Worst = max(summary.Worst, drive.Duration)
+		summary.Average += drive.Duration
+		summary.Distance += drive.Distance
+		summary.Samples++
+	}
+	if summary.Samples > 0 {
+		summary.Average = (summary.Average + summary.Samples/2) / summary.Samples
+		summary.Distance = (summary.Distance + summary.Samples/2) / summary.Samples
+	}
+	return summary
+}
@@ -0,0 +1,75 @@
+package whatif
+
+import (
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/domain"
+	"testing"
+	"time"
+)
+
+var denver, _ = time.LoadLocation("America/Denver")
+
+var schedule = domain.Schedule{
+	MorningStartTime:   "07:00:00",
+	MorningEndTime:     "08:30:00",
+	AfternoonStartTime: "16:30:00",
+	AfternoonEndTime:   "18:00:00",
+	Monday:             true, Tuesday: true, Wednesday: true, Thursday: true, Friday: true,
+}
+
+func TestDepartures(t *testing.T) {
+	// Friday at 07:30 is inside the window, so the next one is Monday's.
+	now := time.Date(2024, 9, 6, 7, 30, 0, 0, denver)
+	toWork, fromWork, err := Departures(schedule, denver, now, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2024-09-09 07:00", "2024-09-09 07:30", "2024-09-09 08:00", "2024-09-09 08:30"}
+	for i, departure := range toWork {
+		if got := departure.Format("2006-01-02 15:04"); got != want[i] {
+			t.Errorf("to work %d = %s, want %s", i, got, want[i])
+		}
+	}
+	if len(fromWork) != 4 || fromWork[0].Format("15:04") != "16:30" || fromWork[3].Format("15:04") != "18:00" {
+		t.Errorf("from work = %v", fromWork)
+	}
+
+	toWork, _, err = Departures(schedule, denver, now, 1)
+	if err != nil || len(toWork) != 1 || toWork[0].Format("15:04") != "07:45" {
+		t.Errorf("single sample = %v, %v", toWork, err)
+	}
+	if _, _, err := Departures(domain.Schedule{MorningStartTime: "07:00:00", MorningEndTime: "08:00:00", AfternoonStartTime: "16:00:00", AfternoonEndTime: "17:00:00"}, denver, now, 3); err == nil {
+		t.Error("expected an error for a schedule without days")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	drive := func(minutes int) *Drive { return &Drive{Duration: minutes * 60, Distance: minutes * 1000} }
+	// Two homes and two workplaces, sampled twice each way. The second home
+	// cannot reach the second workplace.
+	toWork := []Matrix{
+		{{drive(30), drive(40)}, {drive(20), nil}},
+		{{drive(34), drive(44)}, {drive(22), nil}},
+	}
+	fromWork := []Matrix{
+		{{drive(35), drive(25)}, {drive(45), nil}},
+		{{drive(37), drive(27)}, {drive(45), nil}},
+	}
+
+	pairs := Compare(2, 2, toWork, fromWork, 5)
+	if len(pairs) != 4 {
+		t.Fatalf("got %d pairs", len(pairs))
+	}
+	best := pairs[0]
+	if best.Origin != 1 || best.Destination != 0 || best.Daily != 47*60 || best.Weekly != 5*47*60 || *best.Change != -21*60 {
+		t.Errorf("unexpected best pair: %+v", best)
+	}
+	if best.ToWork != (Summary{Samples: 2, Average: 21 * 60, Best: 20 * 60, Worst: 22 * 60, Distance: 21000}) {
+		t.Errorf("to work = %+v", best.ToWork)
+	}
+	if current := pairs[1]; current.Origin != 0 || current.Destination != 0 || current.Change != nil || current.Daily != 68*60 {
+		t.Errorf("unexpected current pair: %+v", current)
+	}
+	if last := pairs[3]; last.Origin != 1 || last.Destination != 1 || last.Complete() || last.Change != nil {
+		t.Errorf("unreachable pair should come last: %+v", last)
+	}
+}
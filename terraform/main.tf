@@ -10,8 +10,18 @@ module "optimize_route_function" {
   filename      = "../dist/optimizeRoute/optimizeRoute.zip"
   environment_variables = {
     GOOGLE_API_KEY : var.GOOGLE_API_KEY
-    SUPABASE_URL : var.SUPABASE_URL
-    SUPABASE_KEY : var.SUPABASE_KEY
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+    SMTP_HOST : var.SMTP_HOST
+    SMTP_PORT : var.SMTP_PORT
+    SMTP_USERNAME : var.SMTP_USERNAME
+    SMTP_PASSWORD : var.SMTP_PASSWORD
+    SMTP_FROM : var.SMTP_FROM
   }
   lambda_timeout = 15
 }
@@ -28,11 +38,144 @@ module "commutes_queue_function" {
     SUPABASE_HOST : var.SUPABASE_HOST
     SUPABASE_PORT : var.SUPABASE_PORT
     SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
     OPTIMIZE_ROUTE_FUNCTION : module.optimize_route_function.function_arn
   }
   lambda_timeout = 180
 }
 
+module "check_commute_function" {
+  source        = "./modules/lambda"
+  function_name = "check_commute_function"
+  handler       = "handler1"
+  runtime       = "provided.al2023"
+  filename      = "../dist/checkCommute/checkCommute.zip"
+  environment_variables = {
+    GOOGLE_API_KEY : var.GOOGLE_API_KEY
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+  }
+  lambda_timeout = 15
+}
+
+module "plan_departure_function" {
+  source        = "./modules/lambda"
+  function_name = "plan_departure_function"
+  handler       = "handler1"
+  runtime       = "provided.al2023"
+  filename      = "../dist/planDeparture/planDeparture.zip"
+  environment_variables = {
+    GOOGLE_API_KEY : var.GOOGLE_API_KEY
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+  }
+  lambda_timeout = 30
+}
+
+module "route_paths_function" {
+  source        = "./modules/lambda"
+  function_name = "route_paths_function"
+  handler       = "handler1"
+  runtime       = "provided.al2023"
+  filename      = "../dist/routePaths/routePaths.zip"
+  environment_variables = {
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+  }
+  lambda_timeout = 60
+}
+
+module "export_paths_function" {
+  source        = "./modules/lambda"
+  function_name = "export_paths_function"
+  handler       = "handler1"
+  runtime       = "provided.al2023"
+  filename      = "../dist/exportPaths/exportPaths.zip"
+  environment_variables = {
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+  }
+  lambda_timeout = 30
+}
+
+module "carpool_match_function" {
+  source        = "./modules/lambda"
+  function_name = "carpool_match_function"
+  handler       = "handler1"
+  runtime       = "provided.al2023"
+  filename      = "../dist/carpoolMatch/carpoolMatch.zip"
+  environment_variables = {
+    GOOGLE_API_KEY : var.GOOGLE_API_KEY
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+  }
+  lambda_timeout = 120
+}
+
+module "order_stops_function" {
+  source        = "./modules/lambda"
+  function_name = "order_stops_function"
+  handler       = "handler1"
+  runtime       = "provided.al2023"
+  filename      = "../dist/orderStops/orderStops.zip"
+  environment_variables = {
+    GOOGLE_API_KEY : var.GOOGLE_API_KEY
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+  }
+  lambda_timeout = 30
+}
+
+module "compare_locations_function" {
+  source        = "./modules/lambda"
+  function_name = "compare_locations_function"
+  handler       = "handler1"
+  runtime       = "provided.al2023"
+  filename      = "../dist/compareLocations/compareLocations.zip"
+  environment_variables = {
+    GOOGLE_API_KEY : var.GOOGLE_API_KEY
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+  }
+  lambda_timeout = 60
+}
+
 module "cloudwatch_event" {
   source                = "./modules/cloudwatch_cron"
   rule_name             = "every_minute_rule_commutes_queue"
@@ -40,4 +183,110 @@ module "cloudwatch_event" {
   schedule_expression   = "rate(1 minute)"
   lambda_function_arn   = module.commutes_queue_function.function_arn
   lambda_function_name  = module.commutes_queue_function.function_name
+}
+
+module "health_check_function" {
+  source        = "./modules/lambda"
+  function_name = "health_check_function"
+  handler       = "handler1"
+  runtime       = "provided.al2023"
+  filename      = "../dist/healthCheck/healthCheck.zip"
+  environment_variables = {
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+  }
+  lambda_timeout = 60
+}
+
+module "health_check_event" {
+  source                = "./modules/cloudwatch_cron"
+  rule_name             = "daily_rule_health_check"
+  rule_description      = "Trigger HealthCheck Lambda function every day"
+  schedule_expression   = "cron(0 12 * * ? *)"
+  lambda_function_arn   = module.health_check_function.function_arn
+  lambda_function_name  = module.health_check_function.function_name
+}
+
+module "notify_users_function" {
+  source        = "./modules/lambda"
+  function_name = "notify_users_function"
+  handler       = "handler1"
+  runtime       = "provided.al2023"
+  filename      = "../dist/notifyUsers/notifyUsers.zip"
+  environment_variables = {
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+    SMTP_HOST : var.SMTP_HOST
+    SMTP_PORT : var.SMTP_PORT
+    SMTP_USERNAME : var.SMTP_USERNAME
+    SMTP_PASSWORD : var.SMTP_PASSWORD
+    SMTP_FROM : var.SMTP_FROM
+  }
+  lambda_timeout = 60
+}
+
+module "notify_users_event" {
+  source                = "./modules/cloudwatch_cron"
+  rule_name             = "every_five_minutes_rule_notify_users"
+  rule_description      = "Trigger NotifyUsers Lambda function every five minutes"
+  schedule_expression   = "rate(5 minutes)"
+  lambda_function_arn   = module.notify_users_function.function_arn
+  lambda_function_name  = module.notify_users_function.function_name
+}
+
+module "weekly_report_function" {
+  source        = "./modules/lambda"
+  function_name = "weekly_report_function"
+  handler       = "handler1"
+  runtime       = "provided.al2023"
+  filename      = "../dist/weeklyReport/weeklyReport.zip"
+  environment_variables = {
+    SUPABASE_USERNAME : var.SUPABASE_USERNAME
+    SUPABASE_PASSWORD : var.SUPABASE_PASSWORD
+    SUPABASE_HOST : var.SUPABASE_HOST
+    SUPABASE_PORT : var.SUPABASE_PORT
+    SUPABASE_DATABASE : var.SUPABASE_DATABASE
+    SUPABASE_SSLMODE : var.SUPABASE_SSLMODE
+    METRICS_SINK : "emf"
+    SMTP_HOST : var.SMTP_HOST
+    SMTP_PORT : var.SMTP_PORT
+    SMTP_USERNAME : var.SMTP_USERNAME
+    SMTP_PASSWORD : var.SMTP_PASSWORD
+    SMTP_FROM : var.SMTP_FROM
+  }
+  lambda_timeout = 300
+}
+
+module "weekly_report_event" {
+  source                = "./modules/cloudwatch_cron"
+  rule_name             = "weekly_rule_weekly_report"
+  rule_description      = "Trigger WeeklyReport Lambda function every Monday"
+  schedule_expression   = "cron(0 14 ? * MON *)"
+  lambda_function_arn   = module.weekly_report_function.function_arn
+  lambda_function_name  = module.weekly_report_function.function_name
+  input                 = jsonencode({ send = true })
+}
+
+resource "aws_cloudwatch_metric_alarm" "stale_routes" {
+  alarm_name          = "optimize_route_stale_routes"
+  alarm_description   = "Active routes have schedule windows without commutes"
+  namespace           = "OptimizeRouteApp"
+  metric_name         = "routes_stale"
+  statistic           = "Maximum"
+  period              = 86400
+  evaluation_periods  = 1
+  threshold           = 0
+  comparison_operator = "GreaterThanThreshold"
+  treat_missing_data  = "breaching"
+  alarm_actions       = var.HEALTH_CHECK_ALARM_ACTIONS
 }
\ No newline at end of file
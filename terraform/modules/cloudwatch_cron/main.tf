@@ -8,6 +8,7 @@ resource "aws_cloudwatch_event_target" "this" {
   rule      = aws_cloudwatch_event_rule.this.name
   arn       = var.lambda_function_arn
   target_id = "lambda_target"
+  input     = var.input
 }
 
 resource "aws_lambda_permission" "this" {
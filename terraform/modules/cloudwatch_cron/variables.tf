@@ -23,3 +23,9 @@ variable "lambda_function_name" {
   description = "The name of the Lambda function to be triggered"
   type        = string
 }
+
+variable "input" {
+  description = "JSON passed to the Lambda function instead of the scheduled event"
+  type        = string
+  default     = null
+}
@@ -3,16 +3,6 @@ variable "GOOGLE_API_KEY" {
   sensitive   = true
   type        = string
 }
-variable "SUPABASE_URL" {
-  description = "Supabase Database URL"
-  sensitive   = true
-  type        = string
-}
-variable "SUPABASE_KEY" {
-  description = "Supabase Database Key"
-  sensitive   = true
-  type        = string
-}
 variable "SUPABASE_USERNAME" {
   description = "Supabase Username"
   sensitive   = true
@@ -37,4 +27,41 @@ variable "SUPABASE_DATABASE" {
   description = "Supabase Database"
   sensitive   = true
   type        = string
+}
+variable "SUPABASE_SSLMODE" {
+  description = "Postgres sslmode used by the Lambdas"
+  type        = string
+  default     = "require"
+}
+variable "HEALTH_CHECK_ALARM_ACTIONS" {
+  description = "ARNs, such as an SNS topic, notified when the health check finds stale routes"
+  type        = list(string)
+  default     = []
+}
+variable "SMTP_HOST" {
+  description = "SMTP server for leave-now emails. Email is disabled when empty"
+  type        = string
+  default     = ""
+}
+variable "SMTP_PORT" {
+  description = "SMTP server port"
+  type        = string
+  default     = "587"
+}
+variable "SMTP_USERNAME" {
+  description = "SMTP Username"
+  sensitive   = true
+  type        = string
+  default     = ""
+}
+variable "SMTP_PASSWORD" {
+  description = "SMTP Password"
+  sensitive   = true
+  type        = string
+  default     = ""
+}
+variable "SMTP_FROM" {
+  description = "Sender address for leave-now emails"
+  type        = string
+  default     = ""
 }
\ No newline at end of file
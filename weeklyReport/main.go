@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/database"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/handlers/weeklyreport"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/logging"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/metrics"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/notify"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/report"
+	"github.com/Cole-T-Harris/OptimizeRouteApp/shared/store"
+	"github.com/aws/aws-lambda-go/lambda"
+	"log/slog"
+	"os"
+)
+
+func main() {
+	logging.Setup()
+	db, err := store.Open(context.Background(), database.ConfigFromEnv())
+	if err != nil {
+		slog.Error("error opening store", "error", err)
+		os.Exit(1)
+	}
+	sink, err := metrics.FromEnv()
+	if err != nil {
+		slog.Error("error configuring metrics", "error", err)
+		os.Exit(1)
+	}
+	channels, err := notify.ChannelsFromEnv()
+	if err != nil {
+		slog.Error("error configuring notification channels", "error", err)
+		os.Exit(1)
+	}
+
+	sender := &report.Subscribers{Notifications: db.Notifications, Channels: channels}
+	handler := &weeklyreport.Handler{Store: db, Sender: sender, Metrics: sink}
+	lambda.Start(metrics.Wrap(sink, handler.HandleRequest))
+}